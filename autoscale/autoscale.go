@@ -0,0 +1,372 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package autoscale sizes the nodes of an environment from its workload. A
+// Controller periodically reads the nodes and pods of the cluster, and decides
+// to add nodes while pods wait to be scheduled, or to remove one when the
+// remaining nodes would still be little used. Cooldowns keep it from scaling
+// again before the previous change settles.
+package autoscale
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/gerred/kube-cluster/kubeclient"
+)
+
+// Cluster gives access to the nodes and pods of the scaled cluster. It is
+// satisfied by *kubeclient.Client.
+type Cluster interface {
+	Nodes() ([]kubeclient.Node, error)
+	Pods() ([]kubeclient.Pod, error)
+}
+
+// Scaler changes the number of nodes of the scaled node group.
+type Scaler interface {
+	// Size returns the current number of nodes.
+	Size() (int, error)
+	// Scale changes the number of nodes.
+	Scale(nodes int) error
+}
+
+// Policy bounds and paces the scaling decisions.
+type Policy struct {
+	Min int
+	Max int
+
+	// ScaleDownUtilization is the requested share of the allocatable
+	// resources under which a node can be removed, as long as the share
+	// stays under it once the node is gone.
+	ScaleDownUtilization float64
+
+	// ScaleUpCooldown and ScaleDownCooldown are the delays after any
+	// scaling before scaling up, respectively down, again.
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+}
+
+// DefaultPolicy returns the default cooldowns and utilization threshold,
+// between min and max nodes.
+func DefaultPolicy(min, max int) Policy {
+	return Policy{
+		Min:                  min,
+		Max:                  max,
+		ScaleDownUtilization: 0.5,
+		ScaleUpCooldown:      3 * time.Minute,
+		ScaleDownCooldown:    10 * time.Minute,
+	}
+}
+
+// Validate checks the policy bounds.
+func (p *Policy) Validate() error {
+	switch {
+	case p.Min < 0:
+		return fmt.Errorf("minimum must not be negative, got %d", p.Min)
+	case p.Max < 1 || p.Max < p.Min:
+		return fmt.Errorf("maximum must be at least 1 and the minimum %d, got %d", p.Min, p.Max)
+	case p.ScaleDownUtilization < 0 || p.ScaleDownUtilization > 1:
+		return fmt.Errorf("scale down utilization must be between 0 and 1, got %g", p.ScaleDownUtilization)
+	}
+	return nil
+}
+
+// Decision is the outcome of a controller step.
+type Decision struct {
+	Time    time.Time
+	Current int
+	Target  int
+	Reason  string
+	// Deferred is set if the target was not applied because of a cooldown.
+	Deferred bool
+	// Err is the failure to apply the target, if any.
+	Err error
+}
+
+func (d Decision) String() string {
+	action := "keep"
+	switch {
+	case d.Deferred:
+		action = "defer"
+	case d.Target > d.Current:
+		action = "scale up"
+	case d.Target < d.Current:
+		action = "scale down"
+	}
+	s := fmt.Sprintf("%s %s %d -> %d nodes: %s", d.Time.UTC().Format(time.RFC3339), action, d.Current, d.Target, d.Reason)
+	if d.Err != nil {
+		s += fmt.Sprintf(", failed: %v", d.Err)
+	}
+	return s
+}
+
+// Decide computes the number of nodes the workload needs, current being the
+// number of nodes of the scaled group. Pods the scheduler found no node for
+// call for as many nodes as their requests fill, the size of the largest
+// node; otherwise one node is removed if the others can hold the requests
+// under the utilization threshold. The result is kept between the policy bounds.
+func Decide(p Policy, current int, nodes []kubeclient.Node, pods []kubeclient.Pod) (int, string) {
+	switch {
+	case current < p.Min:
+		return p.Min, fmt.Sprintf("below the minimum of %d nodes", p.Min)
+	case current > p.Max:
+		return p.Max, fmt.Sprintf("above the maximum of %d nodes", p.Max)
+	}
+
+	var allocCPU, allocMemory, nodeCPU, nodeMemory int64
+	ready := 0
+	for _, n := range nodes {
+		if !n.Ready || n.Unschedulable {
+			continue
+		}
+		ready++
+		allocCPU += n.CPU
+		allocMemory += n.Memory
+		if n.CPU > nodeCPU {
+			nodeCPU = n.CPU
+		}
+		if n.Memory > nodeMemory {
+			nodeMemory = n.Memory
+		}
+	}
+
+	var pending int
+	var pendingCPU, pendingMemory, usedCPU, usedMemory int64
+	for _, pod := range pods {
+		switch {
+		case pod.Pending():
+			pending++
+			pendingCPU += pod.CPU
+			pendingMemory += pod.Memory
+		case !pod.Terminated():
+			usedCPU += pod.CPU
+			usedMemory += pod.Memory
+		}
+	}
+
+	if pending > 0 {
+		needed := max(ceilDiv(pendingCPU, nodeCPU), ceilDiv(pendingMemory, nodeMemory), 1)
+		target := current + int(needed)
+		if target > p.Max {
+			return p.Max, fmt.Sprintf("%d pending pod(s) need %d more node(s), capped by the maximum of %d", pending, needed, p.Max)
+		}
+		return target, fmt.Sprintf("%d pending pod(s) need %d more node(s)", pending, needed)
+	}
+
+	if current <= p.Min || ready < 2 {
+		return current, "no pending pods"
+	}
+
+	// Utilization once the largest node is gone, which is the worst case.
+	cpu := utilization(usedCPU, allocCPU-nodeCPU)
+	memory := utilization(usedMemory, allocMemory-nodeMemory)
+	if cpu < p.ScaleDownUtilization && memory < p.ScaleDownUtilization {
+		return current - 1, fmt.Sprintf("%d node(s) would hold the requests at %.0f%% cpu, %.0f%% memory", ready-1, cpu*100, memory*100)
+	}
+	return current, fmt.Sprintf("no pending pods, requests at %.0f%% cpu, %.0f%% memory", utilization(usedCPU, allocCPU)*100, utilization(usedMemory, allocMemory)*100)
+}
+
+func utilization(used, allocatable int64) float64 {
+	if allocatable <= 0 {
+		if used > 0 {
+			return 1
+		}
+		return 0
+	}
+	return float64(used) / float64(allocatable)
+}
+
+func ceilDiv(a, b int64) int64 {
+	if b <= 0 {
+		return 0
+	}
+	return (a + b - 1) / b
+}
+
+func max(values ...int64) int64 {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+// history is the persisted record of the last scaling operations, so that
+// cooldowns hold across runs.
+type history struct {
+	LastScaleUp   time.Time `json:"lastScaleUp,omitempty"`
+	LastScaleDown time.Time `json:"lastScaleDown,omitempty"`
+}
+
+func (h *history) last() time.Time {
+	if h.LastScaleUp.After(h.LastScaleDown) {
+		return h.LastScaleUp
+	}
+	return h.LastScaleDown
+}
+
+// Controller drives the size of a node group from the workload of a cluster.
+type Controller struct {
+	Policy  Policy
+	Cluster Cluster
+	Scaler  Scaler
+
+	// StatePath, if set, is the file the last scaling times are persisted
+	// to, otherwise they are only kept in memory.
+	StatePath string
+	// Out receives a line per decision, kept and deferred ones included,
+	// and per failure.
+	Out io.Writer
+	// Log receives a line per decision carried out, or failing to be, and
+	// per failure. It is meant to be kept, so it is spared the decisions
+	// changing nothing.
+	Log io.Writer
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+
+	history *history
+}
+
+// Step takes one scaling decision, and applies it unless a cooldown is
+// running. The decision is written to Out, and to Log once applied, with the
+// failure to apply it if any. Failures to take one are written to both.
+func (c *Controller) Step() (Decision, error) {
+	d, err := c.step()
+	if !d.Time.IsZero() {
+		c.printf(c.Out, "%s", d)
+		if !d.Deferred && d.Target != d.Current {
+			c.printf(c.Log, "%s", d)
+		}
+	}
+	if err != nil && err != d.Err {
+		line := fmt.Sprintf("%s error: %v", c.now().UTC().Format(time.RFC3339), err)
+		c.printf(c.Out, "%s", line)
+		c.printf(c.Log, "%s", line)
+	}
+	return d, err
+}
+
+// step takes a decision and applies it, the Decision being empty if none was
+// taken.
+func (c *Controller) step() (Decision, error) {
+	h, err := c.loadHistory()
+	if err != nil {
+		return Decision{}, err
+	}
+	current, err := c.Scaler.Size()
+	if err != nil {
+		return Decision{}, err
+	}
+	nodes, err := c.Cluster.Nodes()
+	if err != nil {
+		return Decision{}, err
+	}
+	pods, err := c.Cluster.Pods()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	d := Decision{Time: c.now(), Current: current}
+	d.Target, d.Reason = Decide(c.Policy, current, nodes, pods)
+
+	inRange := current >= c.Policy.Min && current <= c.Policy.Max
+	switch {
+	case d.Target > current && inRange && d.Time.Before(h.last().Add(c.Policy.ScaleUpCooldown)):
+		d.Deferred = true
+		d.Reason += fmt.Sprintf(", scale up cooldown until %s", h.last().Add(c.Policy.ScaleUpCooldown).UTC().Format(time.RFC3339))
+	case d.Target < current && inRange && d.Time.Before(h.last().Add(c.Policy.ScaleDownCooldown)):
+		d.Deferred = true
+		d.Reason += fmt.Sprintf(", scale down cooldown until %s", h.last().Add(c.Policy.ScaleDownCooldown).UTC().Format(time.RFC3339))
+	}
+
+	if d.Deferred || d.Target == current {
+		return d, nil
+	}
+	if d.Err = c.Scaler.Scale(d.Target); d.Err != nil {
+		return d, d.Err
+	}
+	if d.Target > current {
+		h.LastScaleUp = d.Time
+	} else {
+		h.LastScaleDown = d.Time
+	}
+	return d, c.saveHistory(h)
+}
+
+// Run takes a decision every interval until stop is closed. Failed steps are
+// retried at the next interval.
+func (c *Controller) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.Step()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (c *Controller) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+func (c *Controller) printf(w io.Writer, format string, args ...interface{}) {
+	if w != nil {
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+}
+
+func (c *Controller) loadHistory() (*history, error) {
+	if c.StatePath == "" {
+		if c.history == nil {
+			c.history = &history{}
+		}
+		return c.history, nil
+	}
+
+	h := &history{}
+	data, err := ioutil.ReadFile(c.StatePath)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("%s: %v", c.StatePath, err)
+	}
+	return h, nil
+}
+
+func (c *Controller) saveHistory(h *history) error {
+	c.history = h
+	if c.StatePath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.StatePath, data, 0600)
+}
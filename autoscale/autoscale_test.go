@@ -0,0 +1,225 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package autoscale
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerred/kube-cluster/kubeclient"
+)
+
+const gib = 1 << 30
+
+// node returns a ready node of 2 cores and 4GiB.
+func node(name string) kubeclient.Node {
+	return kubeclient.Node{Name: name, Ready: true, CPU: 2000, Memory: 4 * gib}
+}
+
+func running(cpu, memory int64) kubeclient.Pod {
+	return kubeclient.Pod{Phase: "Running", NodeName: "node-1", CPU: cpu, Memory: memory}
+}
+
+// pending returns a pod the scheduler found no node for.
+func pending(cpu, memory int64) kubeclient.Pod {
+	return kubeclient.Pod{Phase: "Pending", Unschedulable: true, CPU: cpu, Memory: memory}
+}
+
+func TestDecide(t *testing.T) {
+	policy := DefaultPolicy(1, 5)
+	three := []kubeclient.Node{node("node-1"), node("node-2"), node("node-3")}
+	tests := []struct {
+		name    string
+		current int
+		nodes   []kubeclient.Node
+		pods    []kubeclient.Pod
+		want    int
+	}{
+		{"below minimum", 0, nil, nil, 1},
+		{"above maximum", 7, three, nil, 5},
+		{"pending cpu", 3, three, []kubeclient.Pod{pending(3000, gib)}, 5},
+		{"pending memory", 3, three, []kubeclient.Pod{pending(100, 5*gib)}, 5},
+		{"pending without requests", 3, three, []kubeclient.Pod{pending(0, 0)}, 4},
+		{"pending capped", 3, three, []kubeclient.Pod{pending(20000, gib)}, 5},
+		{"not yet scheduled", 3, three, []kubeclient.Pod{{Phase: "Pending", CPU: 3000, Memory: gib}}, 3},
+		{"idle", 3, three, []kubeclient.Pod{running(500, gib)}, 2},
+		{"busy", 3, three, []kubeclient.Pod{running(2500, gib)}, 3},
+		{"terminated pods are free", 3, three, []kubeclient.Pod{running(100, gib), {Phase: "Succeeded", CPU: 4000}}, 2},
+		{"at minimum", 1, three[:1], nil, 1},
+		{"single ready node", 2, []kubeclient.Node{node("node-1"), {Name: "node-2", CPU: 2000, Memory: 4 * gib}}, nil, 2},
+		{"cordoned nodes do not count", 3, []kubeclient.Node{node("node-1"), node("node-2"), {Name: "node-3", Ready: true, Unschedulable: true, CPU: 2000, Memory: 4 * gib}}, []kubeclient.Pod{running(1500, gib)}, 3},
+	}
+	for _, test := range tests {
+		got, reason := Decide(policy, test.current, test.nodes, test.pods)
+		if got != test.want {
+			t.Errorf("%s: got %d nodes (%s), want %d", test.name, got, reason, test.want)
+		}
+		if reason == "" {
+			t.Errorf("%s: no reason given", test.name)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy Policy
+		ok     bool
+	}{
+		{DefaultPolicy(1, 3), true},
+		{DefaultPolicy(0, 1), true},
+		{DefaultPolicy(-1, 3), false},
+		{DefaultPolicy(4, 3), false},
+		{DefaultPolicy(0, 0), false},
+		{Policy{Min: 1, Max: 3, ScaleDownUtilization: 1.5}, false},
+	}
+	for _, test := range tests {
+		if err := test.policy.Validate(); (err == nil) != test.ok {
+			t.Errorf("%+v: got %v, want ok %v", test.policy, err, test.ok)
+		}
+	}
+}
+
+type testCluster struct {
+	nodes []kubeclient.Node
+	pods  []kubeclient.Pod
+	err   error
+}
+
+func (c *testCluster) Nodes() ([]kubeclient.Node, error) { return c.nodes, c.err }
+func (c *testCluster) Pods() ([]kubeclient.Pod, error)   { return c.pods, c.err }
+
+type testScaler struct {
+	size  int
+	err   error
+	calls []int
+}
+
+func (s *testScaler) Size() (int, error) { return s.size, nil }
+
+func (s *testScaler) Scale(nodes int) error {
+	s.calls = append(s.calls, nodes)
+	if s.err != nil {
+		return s.err
+	}
+	s.size = nodes
+	return nil
+}
+
+func TestStep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autoscale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2015, 11, 20, 10, 0, 0, 0, time.UTC)
+	cluster := &testCluster{nodes: []kubeclient.Node{node("node-1"), node("node-2")}, pods: []kubeclient.Pod{pending(3000, gib)}}
+	scaler := &testScaler{size: 2}
+	var out, log bytes.Buffer
+	newController := func() *Controller {
+		return &Controller{
+			Policy:    DefaultPolicy(1, 5),
+			Cluster:   cluster,
+			Scaler:    scaler,
+			StatePath: filepath.Join(dir, "autoscale.json"),
+			Out:       &out,
+			Log:       &log,
+			Now:       func() time.Time { return now },
+		}
+	}
+
+	d, err := newController().Step()
+	if err != nil || d.Target != 4 || d.Deferred {
+		t.Fatalf("got %v, %v, want a scale up to 4", d, err)
+	}
+
+	// the cooldown holds across controllers, through the state file
+	now = now.Add(time.Minute)
+	cluster.nodes = append(cluster.nodes, node("node-3"), node("node-4"))
+	cluster.pods = []kubeclient.Pod{running(100, gib)}
+	d, err = newController().Step()
+	if err != nil || !d.Deferred {
+		t.Fatalf("got %v, %v, want a deferred scale down", d, err)
+	}
+
+	now = now.Add(10 * time.Minute)
+	d, err = newController().Step()
+	if err != nil || d.Deferred || d.Target != 3 {
+		t.Fatalf("got %v, %v, want a scale down to 3", d, err)
+	}
+
+	if want := []int{4, 3}; len(scaler.calls) != 2 || scaler.calls[0] != want[0] || scaler.calls[1] != want[1] {
+		t.Errorf("got scale calls %v, want %v", scaler.calls, want)
+	}
+	scaleUp := "2015-11-20T10:00:00Z scale up 2 -> 4 nodes: 1 pending pod(s) need 2 more node(s)"
+	deferred := "2015-11-20T10:01:00Z defer 4 -> 3 nodes: "
+	scaleDown := "2015-11-20T10:11:00Z scale down 4 -> 3 nodes: "
+	checkLines(t, "output", out.String(), scaleUp, deferred, scaleDown)
+	// the log only keeps the decisions carried out
+	checkLines(t, "log", log.String(), scaleUp, scaleDown)
+}
+
+// checkLines checks each line of got starts with the matching prefix.
+func checkLines(t *testing.T, name, got string, prefixes ...string) {
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if len(lines) != len(prefixes) {
+		t.Errorf("got %s:\n%s", name, got)
+		return
+	}
+	for i := range prefixes {
+		if !strings.HasPrefix(lines[i], prefixes[i]) {
+			t.Errorf("%s line %d: got %q, want %q...", name, i, lines[i], prefixes[i])
+		}
+	}
+}
+
+// TestStepLogsFailures checks the log tells what happened, failures included.
+func TestStepLogsFailures(t *testing.T) {
+	now := time.Date(2015, 11, 20, 10, 0, 0, 0, time.UTC)
+	cluster := &testCluster{nodes: []kubeclient.Node{node("node-1")}, pods: []kubeclient.Pod{pending(1000, gib)}}
+	scaler := &testScaler{size: 1, err: errors.New("quota exceeded")}
+	var log bytes.Buffer
+	c := &Controller{Policy: DefaultPolicy(1, 5), Cluster: cluster, Scaler: scaler, Log: &log, Now: func() time.Time { return now }}
+
+	d, err := c.Step()
+	if err == nil || d.Err != err {
+		t.Fatalf("got %v, %v, want the scaling failure", d, err)
+	}
+	if want := "2015-11-20T10:00:00Z scale up 1 -> 2 nodes: 1 pending pod(s) need 1 more node(s), failed: quota exceeded\n"; log.String() != want {
+		t.Errorf("got log %q, want %q", log.String(), want)
+	}
+
+	// no cooldown after a failure
+	log.Reset()
+	scaler.err = nil
+	if d, err := c.Step(); err != nil || d.Deferred || scaler.size != 2 {
+		t.Errorf("got %v, %v, want a scale up", d, err)
+	}
+
+	log.Reset()
+	cluster.err = errors.New("connection refused")
+	if _, err := c.Step(); err == nil {
+		t.Fatal("got no error")
+	}
+	if want := "2015-11-20T10:00:00Z error: connection refused\n"; log.String() != want {
+		t.Errorf("got log %q, want %q", log.String(), want)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/prompt"
+	"github.com/gerred/kube-cluster/spec"
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply a configuration to a resource",
+	Long:  "Apply a configuration to a resource. Use \"apply env\" to reconcile a kubernetes environment with its specification, anything else is handed to kubectl.",
+}
+
+var applyEnvCmd = &cobra.Command{
+	Use:   "env [NAME] -f FILENAME",
+	Short: "Reconcile a kubernetes environment with its specification",
+	Long: `Reconcile a kubernetes environment with its YAML or JSON specification, as
+accepted by "create env -f". The specification is compared with the stored
+environment and the driver status, and the resulting plan is printed: nodes to
+add or remove, version upgrades, addon changes. The plan is only carried out
+once confirmed, or with --yes. Environments that do not exist yet are created.`,
+	RunE: runApplyEnv,
+}
+
+var applyEnvFlags struct {
+	filename string
+	yes      bool
+	dryRun   bool
+}
+
+func init() {
+	applyEnvCmd.Flags().StringVarP(&applyEnvFlags.filename, "filename", "f", "", "YAML or JSON environment specification, - for stdin")
+	applyEnvCmd.Flags().BoolVarP(&applyEnvFlags.yes, "yes", "y", false, "apply the plan without asking for confirmation")
+	applyEnvCmd.Flags().BoolVar(&applyEnvFlags.dryRun, "dry-run", false, "only print the plan")
+	addParallelismFlag(applyEnvCmd)
+	addLockFlag(applyEnvCmd)
+	applyCmd.AddCommand(applyEnvCmd)
+}
+
+func runApplyEnv(cmd *cobra.Command, args []string) error {
+	if applyEnvFlags.filename == "" {
+		return usageErrorf("--filename is required")
+	}
+	if applyEnvFlags.filename == "-" && !applyEnvFlags.yes && !applyEnvFlags.dryRun {
+		// The confirmation could not be read, stdin holds the specification.
+		return fmt.Errorf("--yes is required when reading the specification from stdin")
+	}
+
+	s, err := loadSpec(applyEnvFlags.filename)
+	if err != nil {
+		return err
+	}
+	desired := s.Environment()
+	switch {
+	case len(args) > 1:
+		return usageErrorf("at most one environment name is accepted")
+	case len(args) == 1 && args[0] != desired.Name:
+		return usageErrorf("environment name %q does not match the specification one, %q", args[0], desired.Name)
+	}
+
+	store := environments()
+	lock, err := lockEnvironment(store, desired.Name)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	current, err := store.Get(desired.Name)
+	if envstore.IsNotFound(err) || err == nil && creationPending(store, desired.Name) {
+		// Interrupted creations are resumed.
+		current = nil
+	} else if err != nil {
+		return err
+	}
+
+	p, err := plan(store, current, desired)
+	if err != nil {
+		return err
+	}
+	p.Print(os.Stdout)
+	if p.Empty() || applyEnvFlags.dryRun {
+		return nil
+	}
+
+	if !applyEnvFlags.yes {
+		ok, err := prompt.New(stdin, os.Stdout).YesNo("Apply these changes?", false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("Nothing changed.")
+			return nil
+		}
+	}
+
+	if current == nil {
+		return createEnvironment(store, desired)
+	}
+	return reconcile(store, p)
+}
+
+// plan compares the stored environment current, nil if it does not exist, with
+// desired, checking that the driver supports the changes.
+func plan(store *envstore.Store, current, desired *envstore.Environment) (*spec.Plan, error) {
+	if current == nil {
+		return spec.Diff(nil, desired, false)
+	}
+
+	// Settings missing from the specification are kept.
+	desired.CreatedAt = current.CreatedAt
+	desired.Endpoint = current.Endpoint
+	desired.KeyAlgorithm = current.KeyAlgorithm
+	desired.KubeconfigMerges = current.KubeconfigMerges
+	if desired.KubectlVersion == current.KubectlVersion {
+		desired.KubectlChecksum = current.KubectlChecksum
+	}
+
+	d, err := newDriver(store, current)
+	if err != nil {
+		return nil, err
+	}
+	state, err := d.Status()
+	if err != nil {
+		return nil, fmt.Errorf("environment %q: cannot get status: %v", current.Name, err)
+	}
+	if state != driver.Running && state != driver.Stopped {
+		return nil, fmt.Errorf("environment %q is %s, retry once it is running or stopped", current.Name, state)
+	}
+
+	current, err = spec.Observed(current, d)
+	if err != nil {
+		return nil, err
+	}
+	p, err := spec.Diff(current, desired, state == driver.Running)
+	if err != nil {
+		return nil, err
+	}
+	if err := canResize(d, current, desired); err != nil {
+		return nil, err
+	}
+	if _, ok := d.(driver.Upgrader); p.Upgrade != "" && !ok {
+		return nil, fmt.Errorf("environment %q: the %s driver cannot upgrade kubernetes", current.Name, current.Driver)
+	}
+	if _, ok := d.(driver.AddonManager); len(p.AddonsToInstall)+len(p.AddonsToRemove) > 0 && !ok {
+		return nil, fmt.Errorf("environment %q: the %s driver cannot manage addons", current.Name, current.Driver)
+	}
+	return p, nil
+}
+
+// reconcile carries out the plan p of an existing environment.
+func reconcile(store *envstore.Store, p *spec.Plan) error {
+	e := p.Desired
+	d, err := newDriver(store, e)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Applying changes to environment %q.\n", e.Name)
+	if p.Start {
+		if err := d.Start(); err != nil {
+			return err
+		}
+	}
+	if err := resize(store, p.Current, e, d, true); err != nil {
+		return err
+	}
+	if p.Upgrade != "" {
+		fmt.Printf("Upgrading kubernetes to %s.\n", p.Upgrade)
+		if err := d.(driver.Upgrader).Upgrade(p.Upgrade); err != nil {
+			return err
+		}
+	}
+	for _, a := range p.AddonsToRemove {
+		fmt.Printf("Removing addon %s.\n", a.Name)
+		if err := d.(driver.AddonManager).RemoveAddon(a.Name); err != nil {
+			return err
+		}
+	}
+	for _, a := range p.AddonsToInstall {
+		fmt.Printf("Installing addon %s %s.\n", a.Name, a.Version)
+		if err := d.(driver.AddonManager).InstallAddon(a.Name, a.Version); err != nil {
+			return err
+		}
+	}
+
+	url, err := d.GetURL()
+	if err != nil {
+		return err
+	}
+	e.Endpoint = url
+	if err := store.Save(e); err != nil {
+		return err
+	}
+	kubeconfig, err := d.GetKubeconfig()
+	if err != nil {
+		return err
+	}
+	if err := store.SaveKubeconfig(e.Name, kubeconfig); err != nil {
+		return err
+	}
+	fmt.Println("Done.")
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+// TestPlanObservesDriver checks plans start from what the driver reports,
+// not from the stored environment only.
+func TestPlanObservesDriver(t *testing.T) {
+	store, srv, cleanup := fakeStore(t)
+	defer cleanup()
+
+	e := fakeEnvironment(srv, nil)
+	e.Nodes = 2
+	e.Addons = []envstore.Addon{{Name: "dns", Version: "v1.0"}}
+	if err := createEnvironment(store, e); err != nil {
+		t.Fatal(err)
+	}
+
+	// change the environment behind kube-cluster's back
+	e, err := store.Get("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDriver(store, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.(driver.AddonManager).RemoveAddon("dns"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Scale(1); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := fakeEnvironment(srv, nil)
+	desired.Nodes = 2
+	desired.Addons = e.Addons
+	p, err := plan(store, e, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range p.Changes {
+		got = append(got, c.String())
+	}
+	want := []string{"~ nodePools.default.nodes: 1 -> 2 (+1)", "+ addons.dns: v1.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %q, want %q", got, want)
+	}
+}
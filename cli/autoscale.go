@@ -0,0 +1,217 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/autoscale"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/kubeclient"
+)
+
+const (
+	autoscaleStateFileName = "autoscale.json"
+	autoscaleLogFileName   = "autoscale.log"
+)
+
+var autoscaleCmd = &cobra.Command{
+	Use:   "autoscale",
+	Short: "Automatically scale a resource",
+	Long:  "Automatically scale a resource. Use \"autoscale env\" to size a kubernetes environment from its workload, anything else is handed to kubectl.",
+}
+
+var autoscaleEnvCmd = &cobra.Command{
+	Use:   "env [NAME] --max N",
+	Short: "Scale the nodes of a kubernetes environment from its workload",
+	Long: `Scale the nodes of a kubernetes environment, the selected one by default, from
+its workload. Every interval, the nodes and pods are read from the API server:
+nodes are added while pods are pending, and one is removed when the others
+would hold the resources requested under --scale-down-utilization. After
+scaling, cooldowns delay further changes.
+
+Decisions are printed, and appended to the autoscale.log file of the
+environment once carried out, as are failures. With --once, a single decision
+is taken, e.g. from cron; cooldowns hold across runs. The environment must
+have been created with autoscaling enabled.`,
+	RunE: runAutoscaleEnv,
+}
+
+var autoscaleEnvFlags struct {
+	min                  int
+	max                  int
+	pool                 string
+	interval             time.Duration
+	once                 bool
+	scaleUpCooldown      time.Duration
+	scaleDownCooldown    time.Duration
+	scaleDownUtilization float64
+	drain                bool
+}
+
+func init() {
+	defaults := autoscale.DefaultPolicy(1, 0)
+	autoscaleEnvCmd.Flags().IntVar(&autoscaleEnvFlags.min, "min", defaults.Min, "minimum number of nodes")
+	autoscaleEnvCmd.Flags().IntVar(&autoscaleEnvFlags.max, "max", defaults.Max, "maximum number of nodes")
+	autoscaleEnvCmd.Flags().StringVar(&autoscaleEnvFlags.pool, "pool", "", "node pool to scale, required if the environment has several")
+	autoscaleEnvCmd.Flags().DurationVar(&autoscaleEnvFlags.interval, "interval", 30*time.Second, "delay between decisions")
+	autoscaleEnvCmd.Flags().BoolVar(&autoscaleEnvFlags.once, "once", false, "take a single decision and exit")
+	autoscaleEnvCmd.Flags().DurationVar(&autoscaleEnvFlags.scaleUpCooldown, "scale-up-cooldown", defaults.ScaleUpCooldown, "delay after scaling before adding nodes")
+	autoscaleEnvCmd.Flags().DurationVar(&autoscaleEnvFlags.scaleDownCooldown, "scale-down-cooldown", defaults.ScaleDownCooldown, "delay after scaling before removing nodes")
+	autoscaleEnvCmd.Flags().Float64Var(&autoscaleEnvFlags.scaleDownUtilization, "scale-down-utilization", defaults.ScaleDownUtilization, "share of the allocatable resources requested under which a node is removed")
+	autoscaleEnvCmd.Flags().BoolVar(&autoscaleEnvFlags.drain, "drain", true, "cordon and drain nodes before removing them")
+	addParallelismFlag(autoscaleEnvCmd)
+	addLockFlag(autoscaleEnvCmd)
+	autoscaleCmd.AddCommand(autoscaleEnvCmd)
+}
+
+func runAutoscaleEnv(cmd *cobra.Command, args []string) error {
+	store := environments()
+	var name string
+	switch len(args) {
+	case 0:
+		var err error
+		if name, _, err = selectedEnvironment(store); err != nil {
+			return err
+		}
+	case 1:
+		name = args[0]
+	default:
+		return usageErrorf("at most one environment name is accepted")
+	}
+
+	e, err := store.Get(name)
+	if err != nil {
+		return err
+	}
+	if !e.Autoscale {
+		return fmt.Errorf("autoscaling is disabled for environment %q, enable it with \"autoscale: true\" in its specification", name)
+	}
+	pool, err := selectPool(e, autoscaleEnvFlags.pool)
+	if err != nil {
+		return err
+	}
+
+	if autoscaleEnvFlags.max == 0 {
+		return fmt.Errorf("--max is required")
+	}
+	policy := autoscale.Policy{
+		Min:                  autoscaleEnvFlags.min,
+		Max:                  autoscaleEnvFlags.max,
+		ScaleDownUtilization: autoscaleEnvFlags.scaleDownUtilization,
+		ScaleUpCooldown:      autoscaleEnvFlags.scaleUpCooldown,
+		ScaleDownCooldown:    autoscaleEnvFlags.scaleDownCooldown,
+	}
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid --min or --max: %v", err)
+	}
+
+	config, err := kubeclient.LoadConfig(store.KubeconfigPath(name))
+	if err != nil {
+		return err
+	}
+	client, err := kubeclient.New(config)
+	if err != nil {
+		return err
+	}
+
+	log, err := os.OpenFile(filepath.Join(store.Dir(name), autoscaleLogFileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	c := &autoscale.Controller{
+		Policy:    policy,
+		Cluster:   client,
+		Scaler:    &poolScaler{store: store, env: name, pool: pool.Name, drain: autoscaleEnvFlags.drain},
+		StatePath: filepath.Join(store.Dir(name), autoscaleStateFileName),
+		Out:       os.Stdout,
+		Log:       log,
+	}
+	if autoscaleEnvFlags.once {
+		_, err := c.Step()
+		return err
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	fmt.Printf("Autoscaling node pool %q of environment %q between %d and %d nodes.\n", pool.Name, name, policy.Min, policy.Max)
+	c.Run(autoscaleEnvFlags.interval, stop)
+	return nil
+}
+
+// poolScaler scales a node pool of a stored environment.
+type poolScaler struct {
+	store *envstore.Store
+	env   string
+	pool  string
+	drain bool
+}
+
+func (s *poolScaler) Size() (int, error) {
+	e, err := s.store.Get(s.env)
+	if err != nil {
+		return 0, err
+	}
+	p, err := selectPool(e, s.pool)
+	if err != nil {
+		return 0, err
+	}
+	return p.Nodes, nil
+}
+
+// Scale resizes the pool, locking the environment meanwhile only, so other
+// commands may change it between decisions.
+func (s *poolScaler) Scale(nodes int) error {
+	lock, err := lockEnvironment(s.store, s.env)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	e, err := s.store.Get(s.env)
+	if err != nil {
+		return err
+	}
+	desired := resized(e, s.pool, nodes)
+	if desired.Nodes < 1 {
+		return fmt.Errorf("environment %q needs at least one node", e.Name)
+	}
+	d, err := newDriver(s.store, desired)
+	if err != nil {
+		return err
+	}
+	if err := canResize(d, e, desired); err != nil {
+		return err
+	}
+	if err := resize(s.store, e, desired, d, s.drain); err != nil {
+		return err
+	}
+	return s.store.Save(desired)
+}
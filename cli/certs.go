@@ -0,0 +1,288 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/pki"
+)
+
+var certsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Manage the certificates of kubernetes environments",
+}
+
+var certsEnvCmd = &cobra.Command{
+	Use:   "env [NAME]",
+	Short: "List the certificates of a kubernetes environment",
+	Long: `List the certificate authority, API server, admin and node certificates of
+a kubernetes environment, the selected one by default, with their expiration.`,
+	RunE: runCertsEnv,
+}
+
+var certsRotateCmd = &cobra.Command{
+	Use:   "rotate [NAME]",
+	Short: "Reissue the certificates of a kubernetes environment",
+	Long: `Reissue the API server, admin and node certificates of a kubernetes
+environment, the selected one by default, with new keys. With --ca, the
+certificate authority is replaced as well. The kubeconfig of the environment
+is updated afterwards, and so are the contexts it was merged into with
+"kubeconfig --merge".`,
+	RunE: runCertsRotate,
+}
+
+var certsRotateFlags struct {
+	ca           bool
+	keyAlgorithm string
+}
+
+func init() {
+	certsRotateCmd.Flags().BoolVar(&certsRotateFlags.ca, "ca", false, "replace the certificate authority too")
+	certsRotateCmd.Flags().StringVar(&certsRotateFlags.keyAlgorithm, "key-algorithm", "", "algorithm of the new keys, "+pki.RSA+" or "+pki.ECDSA+", unchanged if empty")
+	addLockFlag(certsRotateCmd)
+	certsCmd.AddCommand(certsEnvCmd, certsRotateCmd)
+}
+
+func runCertsEnv(cmd *cobra.Command, args []string) error {
+	store := environments()
+	name, err := environmentArg(store, args)
+	if err != nil {
+		return err
+	}
+	e, err := store.Get(name)
+	if err != nil {
+		return err
+	}
+
+	certs, err := environmentPKI(store, e).List()
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		fmt.Printf("Environment %q has no certificates.\n", e.Name)
+		return nil
+	}
+	return printCertificates(os.Stdout, certs)
+}
+
+func runCertsRotate(cmd *cobra.Command, args []string) error {
+	store := environments()
+	name, err := environmentArg(store, args)
+	if err != nil {
+		return err
+	}
+	if err := pki.ValidateAlgorithm(certsRotateFlags.keyAlgorithm); err != nil {
+		return err
+	}
+
+	lock, err := lockEnvironment(store, name)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	e, err := store.Get(name)
+	if err != nil {
+		return err
+	}
+	rotateCA := certsRotateFlags.ca
+	if a := certsRotateFlags.keyAlgorithm; a != "" && a != keyAlgorithm(e) {
+		// the certificate authority signs with its own key, so it has
+		// to be replaced as well
+		e.KeyAlgorithm = a
+		rotateCA = true
+	}
+
+	d, err := newDriver(store, e)
+	if err != nil {
+		return err
+	}
+	sans, err := certificateSANs(e, d)
+	if err != nil {
+		return err
+	}
+	if err := environmentPKI(store, e).Rotate(e.Name, sans, rotateCA); err != nil {
+		return err
+	}
+	if err := store.Save(e); err != nil {
+		return err
+	}
+	if err := installCertificates(store, e, d); err != nil {
+		return err
+	}
+	if err := remergeKubeconfigs(store, e); err != nil {
+		return err
+	}
+	fmt.Printf("Certificates of environment %q reissued.\n", e.Name)
+	return nil
+}
+
+// environmentArg returns the environment named by args, the selected one if
+// args is empty.
+func environmentArg(store *envstore.Store, args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		name, _, err := selectedEnvironment(store)
+		return name, err
+	case 1:
+		return args[0], nil
+	}
+	return "", usageErrorf("at most one environment name is accepted")
+}
+
+// environmentPKI returns the certificates of e.
+func environmentPKI(store *envstore.Store, e *envstore.Environment) *pki.PKI {
+	return pki.New(store.PKIDir(e.Name), pki.Options{Algorithm: e.KeyAlgorithm})
+}
+
+func keyAlgorithm(e *envstore.Environment) string {
+	if e.KeyAlgorithm == "" {
+		return pki.RSA
+	}
+	return e.KeyAlgorithm
+}
+
+// certificateSANs returns the names the API server of e is reached by: the
+// in-cluster service names and address, the endpoint, and the names and
+// addresses of the nodes d reports.
+func certificateSANs(e *envstore.Environment, d driver.Driver) (pki.SANs, error) {
+	network := driverNetworking(e).WithDefaults()
+	domain := network.DNSDomain
+	serviceIP, err := network.APIServerServiceIP()
+	if err != nil {
+		return pki.SANs{}, err
+	}
+
+	sans := pki.SANs{
+		DNSNames: []string{
+			"kubernetes",
+			"kubernetes.default",
+			"kubernetes.default.svc",
+			"kubernetes.default.svc." + domain,
+			"localhost",
+		},
+		IPs: []net.IP{serviceIP, net.ParseIP("127.0.0.1")},
+	}
+	add := func(host string) {
+		if host == "" {
+			return
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			sans.IPs = append(sans.IPs, ip)
+		} else {
+			sans.DNSNames = append(sans.DNSNames, host)
+		}
+	}
+
+	endpoint := e.Endpoint
+	if endpoint == "" {
+		var err error
+		if endpoint, err = d.GetURL(); err != nil {
+			return pki.SANs{}, err
+		}
+	}
+	if u, err := url.Parse(endpoint); err == nil {
+		host := u.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		add(host)
+	}
+
+	if l, ok := d.(driver.NodeLister); ok {
+		nodes, err := l.Nodes()
+		if err != nil {
+			return pki.SANs{}, err
+		}
+		for _, n := range nodes {
+			add(n.Name)
+			add(n.Address)
+		}
+	}
+	return sans, nil
+}
+
+// ensureCertificates generates the certificates e is missing, and hands them to
+// d if it installs certificates.
+func ensureCertificates(store *envstore.Store, e *envstore.Environment, d driver.Driver) error {
+	sans, err := certificateSANs(e, d)
+	if err != nil {
+		return err
+	}
+	if err := environmentPKI(store, e).Ensure(e.Name, sans); err != nil {
+		return err
+	}
+	if i, ok := d.(driver.CertificateInstaller); ok {
+		return i.InstallCertificates()
+	}
+	return nil
+}
+
+// installCertificates hands reissued certificates to d, if it installs them,
+// and refreshes the kubeconfig of e, which may embed them.
+func installCertificates(store *envstore.Store, e *envstore.Environment, d driver.Driver) error {
+	if i, ok := d.(driver.CertificateInstaller); ok {
+		if err := i.InstallCertificates(); err != nil {
+			return err
+		}
+	}
+	kubeconfig, err := d.GetKubeconfig()
+	if err != nil {
+		return err
+	}
+	return store.SaveKubeconfig(e.Name, kubeconfig)
+}
+
+func printCertificates(w io.Writer, certs []pki.Certificate) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 4, ' ', 0)
+	fmt.Fprintln(tw, "certificate\tsubject\tkey\texpires\tremaining")
+	for _, c := range certs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			c.Name,
+			subject(c),
+			strings.ToLower(c.PublicKeyAlgorithm.String()),
+			c.NotAfter.Local().Format("2006-01-02 15:04"),
+			remaining(c.NotAfter),
+		)
+	}
+	return tw.Flush()
+}
+
+func subject(c pki.Certificate) string {
+	s := "CN=" + c.Subject.CommonName
+	for _, o := range c.Subject.Organization {
+		s += ",O=" + o
+	}
+	return s
+}
+
+// remaining returns the time left until t in the format of age, or "expired".
+func remaining(t time.Time) string {
+	if !time.Now().Before(t) {
+		return "expired"
+	}
+	return duration(time.Until(t))
+}
@@ -0,0 +1,52 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+func TestCertificateSANsFollowNetworking(t *testing.T) {
+	e := &envstore.Environment{
+		Name:       "dev",
+		Endpoint:   "https://203.0.113.10:6443",
+		Networking: &envstore.Networking{ServiceCIDR: "10.96.0.0/12", DNSDomain: "dev.local"},
+	}
+	sans, err := certificateSANs(e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := make(map[string]bool)
+	for _, ip := range sans.IPs {
+		ips[ip.String()] = true
+	}
+	for _, want := range []string{"10.96.0.1", "127.0.0.1", "203.0.113.10"} {
+		if !ips[want] {
+			t.Errorf("SANs lack %s: %v", want, sans.IPs)
+		}
+	}
+	if ips["10.0.0.1"] {
+		t.Error("SANs hold the default service address")
+	}
+	found := false
+	for _, name := range sans.DNSNames {
+		found = found || name == "kubernetes.default.svc.dev.local"
+	}
+	if !found {
+		t.Errorf("SANs lack the service name in dev.local: %v", sans.DNSNames)
+	}
+}
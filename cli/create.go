@@ -0,0 +1,359 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/pflag"
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/kubeclient"
+	"github.com/gerred/kube-cluster/kubectl"
+	"github.com/gerred/kube-cluster/pki"
+	"github.com/gerred/kube-cluster/prompt"
+	"github.com/gerred/kube-cluster/spec"
+)
+
+// serverKubectlVersion asks for the kubectl version matching the API server.
+const serverKubectlVersion = "server"
+
+var createCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a resource",
+	Long:  "Create a resource. Use \"create env\" to create a kubernetes environment, anything else is handed to kubectl.",
+}
+
+var createEnvCmd = &cobra.Command{
+	Use:   "env NAME | -f FILENAME",
+	Short: "Create a kubernetes environment",
+	Long: `Create a kubernetes environment, and register it in the local environment
+store. The environment is described either by flags, or by a YAML or JSON
+specification file:
+
+apiVersion: ` + spec.APIVersion + `
+kind: ` + spec.Kind + `
+metadata:
+  name: stage1
+spec:
+  driver: fake
+  kubernetesVersion: v1.1.2
+  nodePools:
+  - name: default
+    nodes: 3`,
+	RunE: runCreateEnv,
+}
+
+var createEnvFlags struct {
+	driver    string
+	nodes     int
+	autoscale bool
+
+	kubectlVersion  string
+	kubectlChecksum string
+	keyAlgorithm    string
+
+	interactive bool
+	filename    string
+}
+
+func init() {
+	createEnvCmd.Flags().StringVar(&createEnvFlags.driver, "driver", "", "driver used to provision the environment")
+	createEnvCmd.Flags().IntVar(&createEnvFlags.nodes, "nodes", 1, "number of nodes")
+	createEnvCmd.Flags().BoolVar(&createEnvFlags.autoscale, "autoscale", false, "scale nodes automatically")
+	createEnvCmd.Flags().StringVar(&createEnvFlags.kubectlVersion, "kubectl-version", "", "kubectl release used for the environment, e.g. v1.1.2, or \""+serverKubectlVersion+"\" to match the API server; kubectl is taken from PATH if empty")
+	createEnvCmd.Flags().StringVar(&createEnvFlags.kubectlChecksum, "kubectl-checksum", "", "expected SHA-256 of the kubectl binary, the mirror published one is used if empty")
+	createEnvCmd.Flags().StringVar(&createEnvFlags.keyAlgorithm, "key-algorithm", pki.RSA, "algorithm of the certificate keys, "+pki.RSA+" or "+pki.ECDSA)
+	createEnvCmd.Flags().BoolVarP(&createEnvFlags.interactive, "interactive", "i", false, "ask for the settings not given as flags")
+	createEnvCmd.Flags().StringVarP(&createEnvFlags.filename, "filename", "f", "", "YAML or JSON environment specification, - for stdin")
+	addParallelismFlag(createEnvCmd)
+	addLockFlag(createEnvCmd)
+	createCmd.AddCommand(createEnvCmd)
+}
+
+func runCreateEnv(cmd *cobra.Command, args []string) error {
+	store := environments()
+	if len(args) == 1 {
+		lock, err := lockEnvironment(store, args[0])
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		// Checked under the lock, so that a creation still running in
+		// another process is not taken for an interrupted one.
+		if creationPending(store, args[0]) {
+			e, err := store.Get(args[0])
+			if err != nil {
+				return err
+			}
+			return createEnvironment(store, e)
+		}
+	}
+
+	var e *envstore.Environment
+	var err error
+	if createEnvFlags.filename != "" {
+		e, err = environmentFromSpec(cmd, args)
+	} else {
+		e, err = environmentFromFlags(cmd, args)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		lock, err := lockEnvironment(store, e.Name)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+	return createEnvironment(store, e)
+}
+
+// creationPending reports whether the creation of the named environment was
+// interrupted.
+func creationPending(store *envstore.Store, name string) bool {
+	j, err := driver.OpenJournal(store.JournalPath(name))
+	return err == nil && j.Operation == createOperation && j.Pending()
+}
+
+// createOperation names environment creations in journals.
+const createOperation = "create"
+
+// createEnvironment registers e in store, and has its driver provision it. The
+// caller locks the environment.
+// Every step is journaled: if an earlier creation of the environment was
+// interrupted, it resumes where it stopped, with the settings it started with.
+func createEnvironment(store *envstore.Store, e *envstore.Environment) error {
+	j, err := driver.OpenJournal(store.JournalPath(e.Name))
+	if err != nil {
+		return err
+	}
+
+	existing, err := store.Get(e.Name)
+	switch {
+	case envstore.IsNotFound(err):
+		e.CreatedAt = time.Now().UTC()
+		fmt.Printf("Creating environment %q.\n", e.Name)
+		if err := store.Create(e); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !creationPending(store, e.Name):
+		return &envstore.ExistsError{Name: e.Name}
+	default:
+		e = existing
+		fmt.Printf("Resuming the creation of environment %q.\n", e.Name)
+	}
+
+	if err := j.Begin(createOperation); err != nil {
+		return err
+	}
+	d, err := newJournaledDriver(store, e, j)
+	if err != nil {
+		return err
+	}
+	if _, ok := d.(driver.AddonManager); len(e.Addons) > 0 && !ok {
+		return fmt.Errorf("environment %q: the %s driver cannot manage addons", e.Name, e.Driver)
+	}
+	if err := provision(store, e, d, j); err != nil {
+		return fmt.Errorf("%v\nRun \"kube-cluster create env %s\" again to resume, or \"kube-cluster delete env %s\" to remove what was created.", err, e.Name, e.Name)
+	}
+	if err := j.Finish(); err != nil {
+		return err
+	}
+	fmt.Println("Done.")
+	return nil
+}
+
+// environmentFromFlags builds the environment described by the command line
+// flags, asking for the missing ones if --interactive.
+func environmentFromFlags(cmd *cobra.Command, args []string) (*envstore.Environment, error) {
+	if len(args) != 1 {
+		return nil, usageErrorf("exactly one environment name is required")
+	}
+	if createEnvFlags.interactive {
+		if err := askCreateEnv(cmd, prompt.New(stdin, os.Stdout)); err != nil {
+			return nil, err
+		}
+	}
+	if createEnvFlags.driver == "" {
+		return nil, usageErrorf("--driver is required, available drivers: %v", driver.Names())
+	}
+	if createEnvFlags.nodes < 1 {
+		return nil, fmt.Errorf("--nodes must be at least 1, got %d", createEnvFlags.nodes)
+	}
+	if err := pki.ValidateAlgorithm(createEnvFlags.keyAlgorithm); err != nil {
+		return nil, err
+	}
+	if v := createEnvFlags.kubectlVersion; v != "" && v != serverKubectlVersion {
+		if err := kubectl.ValidateVersion(v); err != nil {
+			return nil, err
+		}
+	}
+
+	opts, err := driverOptions(cmd, createEnvFlags.driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &envstore.Environment{
+		Name:      args[0],
+		Driver:    createEnvFlags.driver,
+		Nodes:     createEnvFlags.nodes,
+		Autoscale: createEnvFlags.autoscale,
+
+		KubectlVersion:  createEnvFlags.kubectlVersion,
+		KubectlChecksum: createEnvFlags.kubectlChecksum,
+		KeyAlgorithm:    createEnvFlags.keyAlgorithm,
+
+		DriverOptions: opts,
+	}, nil
+}
+
+// environmentFromSpec builds the environment described by the --filename
+// specification. The environment name defaults to the specification one.
+func environmentFromSpec(cmd *cobra.Command, args []string) (*envstore.Environment, error) {
+	var conflicts []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		switch f.Name {
+		case "filename", "kubectl-checksum", "key-algorithm", "parallelism", "lock-timeout":
+		default:
+			conflicts = append(conflicts, "--"+f.Name)
+		}
+	})
+	if len(conflicts) > 0 {
+		return nil, usageErrorf("--filename cannot be combined with %s, set them in the specification", strings.Join(conflicts, ", "))
+	}
+
+	s, err := loadSpec(createEnvFlags.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	e := s.Environment()
+	e.KubectlChecksum = createEnvFlags.kubectlChecksum
+	if err := pki.ValidateAlgorithm(createEnvFlags.keyAlgorithm); err != nil {
+		return nil, err
+	}
+	e.KeyAlgorithm = createEnvFlags.keyAlgorithm
+	switch {
+	case len(args) > 1:
+		return nil, usageErrorf("at most one environment name is accepted")
+	case len(args) == 1 && args[0] != e.Name:
+		return nil, usageErrorf("environment name %q does not match the specification one, %q", args[0], e.Name)
+	}
+	return e, nil
+}
+
+// loadSpec reads an environment specification, from stdin if filename is "-".
+func loadSpec(filename string) (*spec.Spec, error) {
+	if filename != "-" {
+		return spec.Load(filename)
+	}
+
+	data, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return nil, err
+	}
+	return spec.Parse("<stdin>", data)
+}
+
+// provision runs the driver creation of e, installs its addons, and records
+// the resulting endpoint and kubeconfig, skipping the steps j records as done.
+func provision(store *envstore.Store, e *envstore.Environment, d driver.Driver, j *driver.Journal) error {
+	err := j.Run("provision", func(record func(kind, id string)) error {
+		err := d.Create()
+		// Drivers journal what they create in steps of their own, the
+		// nodes are recorded here for those which do not.
+		if l, ok := d.(driver.NodeLister); ok {
+			if nodes, lerr := l.Nodes(); lerr == nil {
+				for _, n := range nodes {
+					record("node", n.Name)
+				}
+			}
+		}
+		return err
+	})
+	nodeProgress.summary()
+	if err != nil {
+		return err
+	}
+
+	err = j.Run("certificates", func(record func(kind, id string)) error {
+		return ensureCertificates(store, e, d)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, a := range e.Addons {
+		a := a
+		err := j.Run("addon "+a.Name, func(record func(kind, id string)) error {
+			fmt.Printf("Installing addon %s %s.\n", a.Name, a.Version)
+			return d.(driver.AddonManager).InstallAddon(a.Name, a.Version)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return j.Run("configure", func(record func(kind, id string)) error {
+		url, err := d.GetURL()
+		if err != nil {
+			return err
+		}
+		e.Endpoint = url
+		kubeconfig, err := d.GetKubeconfig()
+		if err != nil {
+			return err
+		}
+		if err := store.SaveKubeconfig(e.Name, kubeconfig); err != nil {
+			return err
+		}
+		if e.KubectlVersion == serverKubectlVersion {
+			e.KubectlVersion = ""
+			if version, err := serverVersion(store, e.Name); err != nil {
+				fmt.Printf("Could not detect the API server version, kubectl will be taken from PATH: %v\n", err)
+			} else {
+				e.KubectlVersion = version
+			}
+		}
+		return store.Save(e)
+	})
+}
+
+// serverVersion asks the API server of the named environment for its release,
+// trusting it and authenticating as the environment kubeconfig says.
+func serverVersion(store *envstore.Store, name string) (string, error) {
+	config, err := kubeclient.LoadConfig(store.KubeconfigPath(name))
+	if err != nil {
+		return "", err
+	}
+	client, err := config.HTTPClient()
+	if err != nil {
+		return "", err
+	}
+	client.Timeout = 10 * time.Second
+	return kubectl.ServerVersion(client, config.Server)
+}
@@ -0,0 +1,204 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/driver/fake"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/pki"
+)
+
+// fakeEnvironment returns an environment of the fake driver, talking to the
+// stub API server srv rather than to one it starts.
+func fakeEnvironment(srv *httptest.Server, options map[string]string) *envstore.Environment {
+	opts := map[string]string{"fake-api-server": srv.URL}
+	for k, v := range options {
+		opts[k] = v
+	}
+	return &envstore.Environment{
+		Name:          "dev",
+		Driver:        "fake",
+		Nodes:         1,
+		KeyAlgorithm:  pki.ECDSA,
+		DriverOptions: opts,
+	}
+}
+
+func fakeStore(t *testing.T) (*envstore.Store, *httptest.Server, func()) {
+	root, err := ioutil.TempDir("", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := envstore.New(root)
+	srv := httptest.NewServer(fake.Handler(store.Dir("dev")))
+	return store, srv, func() {
+		srv.Close()
+		os.RemoveAll(root)
+	}
+}
+
+func TestCreateEnvironmentInstallsAddons(t *testing.T) {
+	store, srv, cleanup := fakeStore(t)
+	defer cleanup()
+
+	e := fakeEnvironment(srv, map[string]string{"fake-fail-on": "addon"})
+	e.Addons = []envstore.Addon{{Name: "dns", Version: "v1.0"}}
+	if err := createEnvironment(store, e); err == nil {
+		t.Fatal("creation succeeded, want the addon installation to fail")
+	}
+	j, err := driver.OpenJournal(store.JournalPath("dev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := j.Failed(); s == nil || s.Name != "addon dns" {
+		t.Fatalf("failed step %+v, want addon dns", s)
+	}
+
+	// resume once the failure is gone
+	e, err = store.Get("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(e.DriverOptions, "fake-fail-on")
+	if err := store.Save(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := createEnvironment(store, fakeEnvironment(srv, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(store.Dir("dev"), "fake-state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state struct {
+		Addons map[string]string `json:"addons"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"dns": "v1.0"}; !reflect.DeepEqual(state.Addons, want) {
+		t.Errorf("installed addons %v, want %v", state.Addons, want)
+	}
+}
+
+func TestRunCreateEnvResumes(t *testing.T) {
+	store, srv, cleanup := fakeStore(t)
+	defer cleanup()
+	os.Setenv(envstore.HomeVariable, store.Root())
+	defer os.Unsetenv(envstore.HomeVariable)
+
+	e := fakeEnvironment(srv, map[string]string{"fake-fail-node": "1"})
+	e.Nodes = 2
+	if err := createEnvironment(store, e); err == nil {
+		t.Fatal("creation succeeded, want node 1 to fail")
+	}
+	e, err := store.Get("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(e.DriverOptions, "fake-fail-node")
+	if err := store.Save(e); err != nil {
+		t.Fatal(err)
+	}
+
+	// no --driver needed to resume
+	createEnvFlags.driver = ""
+	if err := runCreateEnv(createEnvCmd, []string{"dev"}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := driver.OpenJournal(store.JournalPath("dev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Pending() {
+		t.Error("the creation is still pending")
+	}
+	var nodes []string
+	for _, r := range j.Resources() {
+		if r.Kind == "node" {
+			nodes = append(nodes, r.ID)
+		}
+	}
+	if len(nodes) != 2 {
+		t.Errorf("journaled nodes %v, want 2", nodes)
+	}
+
+	if err := runCreateEnv(createEnvCmd, []string{"dev"}); err == nil {
+		t.Error("created an existing environment again")
+	}
+}
+
+func TestServerVersionUsesKubeconfig(t *testing.T) {
+	store, srv, cleanup := fakeStore(t)
+	defer cleanup()
+
+	e := fakeEnvironment(srv, nil)
+	if err := store.Create(e); err != nil {
+		t.Fatal(err)
+	}
+	p := pki.New(store.PKIDir("dev"), pki.Options{Algorithm: pki.ECDSA})
+	if err := p.Ensure("dev", pki.SANs{IPs: []net.IP{net.ParseIP("127.0.0.1")}}); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ioutil.ReadFile(p.CertPath(pki.CA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(p.CertPath(pki.APIServer), p.KeyPath(pki.APIServer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := x509.NewCertPool()
+	clients.AppendCertsFromPEM(ca)
+
+	// an API server with a certificate of the environment, only answering
+	// the clients it issued certificates to
+	apiServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"major": "1", "minor": "1", "gitVersion": "v1.1.2"}`)
+	}))
+	apiServer.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clients,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	apiServer.StartTLS()
+	defer apiServer.Close()
+
+	kubeconfig, err := driver.AdminKubeconfig(&driver.Config{Name: "dev", PKI: p}, apiServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveKubeconfig("dev", kubeconfig); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := serverVersion(store, "dev"); err != nil || version != "v1.1.2" {
+		t.Errorf("got version %q, %v", version, err)
+	}
+}
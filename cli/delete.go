@@ -0,0 +1,96 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a resource",
+	Long:  "Delete a resource. Use \"delete env\" to remove a kubernetes environment, anything else is handed to kubectl.",
+}
+
+var deleteEnvCmd = &cobra.Command{
+	Use:   "env NAME...",
+	Short: "Delete kubernetes environments",
+	Long:  "Delete kubernetes environments, and remove their local configuration.",
+	RunE:  runDeleteEnv,
+}
+
+var deleteEnvFlags struct {
+	force bool
+}
+
+func init() {
+	deleteEnvCmd.Flags().BoolVar(&deleteEnvFlags.force, "force", false, "remove the local configuration even if the driver fails to tear the environment down")
+	addLockFlag(deleteEnvCmd)
+	deleteCmd.AddCommand(deleteEnvCmd)
+}
+
+func runDeleteEnv(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("at least one environment name is required")
+	}
+
+	store := environments()
+	for _, name := range args {
+		if err := deleteEnvironment(store, name); err != nil {
+			return err
+		}
+		fmt.Printf("Environment %q deleted.\n", name)
+	}
+	return nil
+}
+
+// deleteEnvironment tears the named environment down, and removes it from
+// store.
+func deleteEnvironment(store *envstore.Store, name string) error {
+	lock, err := lockEnvironment(store, name)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	e, err := store.Get(name)
+	if err != nil {
+		return err
+	}
+	if err := teardown(store, e); err != nil {
+		if !deleteEnvFlags.force {
+			return err
+		}
+		fmt.Printf("Ignoring driver error: %v\n", err)
+	}
+	for _, m := range e.KubeconfigMerges {
+		if err := unmergeKubeconfig(m.Path, m.Context); err != nil {
+			fmt.Printf("Could not remove context %q from %s: %v\n", m.Context, m.Path, err)
+		}
+	}
+	return store.Remove(name)
+}
+
+// teardown has the driver of e remove every resource backing it.
+func teardown(store *envstore.Store, e *envstore.Environment) error {
+	d, err := newDriver(store, e)
+	if err != nil {
+		return err
+	}
+	return d.Remove()
+}
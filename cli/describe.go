@@ -0,0 +1,80 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+var describeCmd = &cobra.Command{
+	Use:   "describe",
+	Short: "Show details of a resource",
+	Long:  "Show details of a resource. Use \"describe env\" to describe kubernetes environments, anything else is handed to kubectl.",
+}
+
+var describeEnvCmd = &cobra.Command{
+	Use:   "env [NAME...]",
+	Short: "Show details of kubernetes environments",
+	Long:  "Show details of kubernetes environments, the current one if none is named.",
+	RunE:  runDescribeEnv,
+}
+
+var describeEnvFlags struct {
+	output string
+}
+
+func init() {
+	addOutputFlag(describeEnvCmd, &describeEnvFlags.output)
+	describeCmd.AddCommand(describeEnvCmd)
+}
+
+func runDescribeEnv(cmd *cobra.Command, args []string) error {
+	store := environments()
+
+	names := args
+	if len(names) == 0 {
+		name, _, err := selectedEnvironment(store)
+		if err != nil {
+			return err
+		}
+		names = []string{name}
+	}
+
+	var envs []*envstore.Environment
+	for _, name := range names {
+		e, err := store.Get(name)
+		if err != nil {
+			return err
+		}
+		envs = append(envs, e)
+	}
+
+	if describeEnvFlags.output != "" {
+		return printEnvironments(os.Stdout, describeEnvFlags.output, envs, len(args) <= 1)
+	}
+	for i, e := range envs {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := describeEnvironment(os.Stdout, store, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
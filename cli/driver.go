@@ -0,0 +1,122 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+// addDriverFlags exposes the options of every registered driver as flags of
+// cmd. Drivers register from their package init functions, so this must run
+// once all of them are linked in, i.e. from Execute.
+func addDriverFlags(cmd *cobra.Command) {
+	for _, name := range driver.Names() {
+		for _, opt := range driver.Options(name) {
+			if cmd.Flags().Lookup(opt.Name) != nil {
+				continue
+			}
+			cmd.Flags().String(opt.Name, opt.Default, opt.Usage)
+		}
+	}
+}
+
+// driverOptions collects the values of the named driver options from the flags
+// of cmd, and checks them.
+func driverOptions(cmd *cobra.Command, name string) (map[string]string, error) {
+	opts := make(map[string]string)
+	for _, opt := range driver.Options(name) {
+		f := cmd.Flags().Lookup(opt.Name)
+		if f == nil {
+			continue
+		}
+		value := f.Value.String()
+		if err := opt.Check(value); err != nil {
+			return nil, fmt.Errorf("invalid --%s: %v", opt.Name, err)
+		}
+		opts[opt.Name] = value
+	}
+	return opts, nil
+}
+
+// isSecretOption reports whether the named option of driverName must be
+// hidden when displayed.
+func isSecretOption(driverName, name string) bool {
+	for _, opt := range driver.Options(driverName) {
+		if opt.Name == name {
+			return opt.Secret
+		}
+	}
+	return false
+}
+
+// newDriver instantiates the driver managing e, recording its operations in
+// the journal of e.
+func newDriver(store *envstore.Store, e *envstore.Environment) (driver.Driver, error) {
+	j, err := driver.OpenJournal(store.JournalPath(e.Name))
+	if err != nil {
+		return nil, err
+	}
+	return newJournaledDriver(store, e, j)
+}
+
+// newJournaledDriver instantiates the driver managing e, recording its
+// operations in j.
+func newJournaledDriver(store *envstore.Store, e *envstore.Environment, j *driver.Journal) (driver.Driver, error) {
+	var pools []driver.Pool
+	for _, p := range e.NodePools() {
+		pools = append(pools, driverPool(p))
+	}
+	return driver.New(e.Driver, &driver.Config{
+		Name:      e.Name,
+		StorePath: store.Dir(e.Name),
+		Nodes:     e.Nodes,
+		Pools:     pools,
+		Options:   e.DriverOptions,
+
+		KubernetesVersion: e.KubernetesVersion,
+		Networking:        driverNetworking(e),
+
+		Parallelism: parallelism,
+		Progress:    nodeProgress,
+		Journal:     j,
+		PKI:         environmentPKI(store, e),
+	})
+}
+
+// driverNetworking returns the network settings of e, those left empty being
+// the driver defaults.
+func driverNetworking(e *envstore.Environment) driver.Networking {
+	if e.Networking == nil {
+		return driver.Networking{}
+	}
+	return driver.Networking{
+		PodCIDR:     e.Networking.PodCIDR,
+		ServiceCIDR: e.Networking.ServiceCIDR,
+		DNSDomain:   e.Networking.DNSDomain,
+	}
+}
+
+func driverPool(p envstore.NodePool) driver.Pool {
+	return driver.Pool{
+		Name:        p.Name,
+		Nodes:       p.Nodes,
+		MachineType: p.MachineType,
+		Labels:      p.Labels,
+	}
+}
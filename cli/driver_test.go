@@ -0,0 +1,70 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/driver"
+)
+
+func init() {
+	driver.Register("cli-stub", func(c *driver.Config) (driver.Driver, error) {
+		return nil, errors.New("stub driver")
+	},
+		driver.Option{Name: "cli-stub-nodes", Default: "1", Type: driver.IntOption, Prompt: "Stub nodes"},
+		driver.Option{Name: "cli-stub-token", Secret: true, Prompt: "Stub token"},
+		driver.Option{Name: "cli-stub-debug", Default: "false", Type: driver.BoolOption, Prompt: "Debug"},
+	)
+}
+
+func TestDriverOptions(t *testing.T) {
+	tests := []struct {
+		args []string
+		want map[string]string
+		ok   bool
+	}{
+		{nil, map[string]string{"cli-stub-nodes": "1", "cli-stub-token": "", "cli-stub-debug": "false"}, true},
+		{[]string{"--cli-stub-nodes=3", "--cli-stub-token=s3cret"}, map[string]string{"cli-stub-nodes": "3", "cli-stub-token": "s3cret", "cli-stub-debug": "false"}, true},
+		{[]string{"--cli-stub-nodes=three"}, nil, false},
+	}
+	for _, test := range tests {
+		cmd := &cobra.Command{Use: "env"}
+		addDriverFlags(cmd)
+		if err := cmd.Flags().Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		got, err := driverOptions(cmd, "cli-stub")
+		if (err == nil) != test.ok {
+			t.Errorf("%v: error %v, want ok %v", test.args, err, test.ok)
+			continue
+		}
+		if test.ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: options %v, want %v", test.args, got, test.want)
+		}
+	}
+}
+
+func TestIsSecretOption(t *testing.T) {
+	if !isSecretOption("cli-stub", "cli-stub-token") {
+		t.Error("cli-stub-token is not secret")
+	}
+	if isSecretOption("cli-stub", "cli-stub-nodes") || isSecretOption("missing", "cli-stub-token") {
+		t.Error("option wrongly reported secret")
+	}
+}
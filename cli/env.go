@@ -0,0 +1,58 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+var envCmd = &cobra.Command{
+	Use:   "env [NAME]",
+	Short: "Show or change the current environment",
+	Long: `Show or change the current environment. Calls are sent to the first
+environment named by, in order: the --env flag, the KUBE_CLUSTER_ENVIRONMENT
+variable, a .kube-cluster file in the working directory or its parents, and the
+current environment.`,
+	RunE: runEnv,
+}
+
+func runEnv(cmd *cobra.Command, args []string) error {
+	store := environments()
+
+	switch len(args) {
+	case 0:
+		name, source, err := selectedEnvironment(store)
+		if err != nil {
+			return err
+		}
+		if source == envstore.CurrentSource {
+			fmt.Printf("Current environment is %q\n", name)
+		} else {
+			fmt.Printf("Current environment is %q (set by %s)\n", name, source)
+		}
+		return nil
+	case 1:
+		if err := store.SetCurrent(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Current environment is %q\n", args[0])
+		return nil
+	default:
+		return usageErrorf("at most one environment name is accepted")
+	}
+}
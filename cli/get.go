@@ -0,0 +1,65 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"os"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+var getCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Display one or many resources",
+	Long:  "Display one or many resources. Use \"get env\" to list kubernetes environments, anything else is handed to kubectl.",
+}
+
+var getEnvCmd = &cobra.Command{
+	Use:   "env [NAME...]",
+	Short: "List kubernetes environments",
+	Long:  "List the kubernetes environments in the local environment store.",
+	RunE:  runGetEnv,
+}
+
+var getEnvFlags struct {
+	output string
+}
+
+func init() {
+	addOutputFlag(getEnvCmd, &getEnvFlags.output)
+	getCmd.AddCommand(getEnvCmd)
+}
+
+func runGetEnv(cmd *cobra.Command, args []string) error {
+	store := environments()
+
+	var envs []*envstore.Environment
+	if len(args) == 0 {
+		var err error
+		if envs, err = store.List(); err != nil {
+			return err
+		}
+	}
+	for _, name := range args {
+		e, err := store.Get(name)
+		if err != nil {
+			return err
+		}
+		envs = append(envs, e)
+	}
+
+	return printEnvironments(os.Stdout, getEnvFlags.output, envs, len(args) == 1)
+}
@@ -14,22 +14,159 @@
 
 package cli
 
-import "github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/envstore"
+)
 
 // KubeClusterCmd is the root command. Attach all other commands to this.
 var KubeClusterCmd = &cobra.Command{
 	Use:   "kube-cluster",
 	Short: "kube-cluster provisions, scales, and manages kubernetes environments",
-	Long:  "kube-cluster provisions, scales, and manages kubernetes environments",
+	Long: `kube-cluster provisions, scales, and manages kubernetes environments.
+
+Calls which are not kube-cluster commands, e.g. "kube-cluster get po", are run
+with kubectl against the selected environment.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		cmd.HelpFunc()(cmd, args)
 	},
 }
 
+var globalFlags struct {
+	env string
+}
+
+func init() {
+	KubeClusterCmd.PersistentFlags().StringVar(&globalFlags.env, "env", "", "environment to use, overriding "+envstore.EnvironmentVariable+" and the current environment")
+}
+
+var addCommandsOnce sync.Once
+
+// stdin is where commands read answers to their questions from.
+var stdin = os.Stdin
+
 // Execute adds all child commands to the root command KubeClusterCmd and sets all flags appropriately.
-func Execute() {
-	KubeClusterCmd.Execute()
+// Questions are answered from in.
+func Execute(in *os.File) {
+	stdin = in
+	if err := execute(Root(), os.Stdout, os.Stderr); err != nil {
+		os.Exit(1)
+	}
+}
+
+// execute runs root, writing help to stdout and errors to stderr. Usage is
+// only printed along flag and argument errors, the errors of commands which
+// ran are printed alone.
+func execute(root *cobra.Command, stdout, stderr io.Writer) error {
+	out := &errorOutput{Writer: stderr}
+	root.SetOutput(out)
+	root.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+		cmd.SetOutput(stdout)
+		if err := cmd.Help(); err != nil {
+			fmt.Fprintln(stderr, err)
+		}
+	})
+	silenceUsage(root, out)
+
+	err := root.Execute()
+	if out.failed {
+		fmt.Fprintln(stderr, "Error:", err)
+	}
+	return err
+}
+
+// errorOutput receives the usage and error messages of cobra, and drops them
+// once a command failed on its own.
+type errorOutput struct {
+	io.Writer
+	failed bool
+}
+
+func (o *errorOutput) Write(p []byte) (int, error) {
+	if o.failed {
+		return len(p), nil
+	}
+	return o.Writer.Write(p)
+}
+
+// silenceUsage makes cmd and its subcommands mark out as failed when they
+// return an error other than a usageError.
+func silenceUsage(cmd *cobra.Command, out *errorOutput) {
+	if run := cmd.RunE; run != nil {
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			err := run(cmd, args)
+			if _, ok := err.(usageError); err != nil && !ok {
+				out.failed = true
+			}
+			return err
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		silenceUsage(sub, out)
+	}
+}
+
+// usageError is returned by commands called with wrong arguments, so their
+// usage is printed along the error.
+type usageError struct {
+	error
+}
+
+// usageErrorf formats a usageError.
+func usageErrorf(format string, a ...interface{}) error {
+	return usageError{fmt.Errorf(format, a...)}
+}
+
+// Root returns the root command KubeClusterCmd, with all child commands and
+// flags attached.
+func Root() *cobra.Command {
+	addCommandsOnce.Do(addCommands)
+	return KubeClusterCmd
 }
 
 func addCommands() {
+	KubeClusterCmd.AddCommand(
+		applyCmd,
+		autoscaleCmd,
+		certsCmd,
+		createCmd,
+		getCmd,
+		describeCmd,
+		deleteCmd,
+		envCmd,
+		kubeconfigCmd,
+		scaleCmd,
+	)
+	addDriverFlags(createEnvCmd)
+}
+
+// environments returns the environment store used by all commands.
+func environments() *envstore.Store {
+	return envstore.New(envstore.DefaultRoot())
+}
+
+// selectedEnvironment resolves the environment targeted by the call.
+func selectedEnvironment(store *envstore.Store) (string, envstore.Source, error) {
+	return envstore.NewResolver(store, globalFlags.env).Resolve()
+}
+
+// lockTimeout is how long commands wait for other processes to release the
+// environment they change.
+var lockTimeout time.Duration
+
+// addLockFlag adds the --lock-timeout flag to commands changing environments.
+func addLockFlag(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&lockTimeout, "lock-timeout", 0, "how long to wait for other kube-cluster processes to release the environment, e.g. 1m")
+}
 
+// lockEnvironment locks the named environment against changes by other
+// processes, until the returned lock is released.
+func lockEnvironment(store *envstore.Store, name string) (*envstore.Lock, error) {
+	return store.Lock(name, lockTimeout)
 }
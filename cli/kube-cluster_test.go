@@ -0,0 +1,88 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+)
+
+func TestExecuteErrors(t *testing.T) {
+	tests := []struct {
+		args      []string
+		err       error
+		wantUsage bool
+		wantError string
+	}{
+		{[]string{"run"}, errors.New("boom"), false, "Error: boom"},
+		{[]string{"run", "a", "b"}, usageErrorf("at most one name is accepted"), true, "Error: at most one name is accepted"},
+		{[]string{"run", "--bogus"}, nil, true, "Error: unknown flag: --bogus"},
+	}
+	for _, test := range tests {
+		root := &cobra.Command{Use: "kube-cluster"}
+		root.AddCommand(&cobra.Command{
+			Use: "run",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return test.err
+			},
+		})
+		root.SetArgs(test.args)
+
+		var stdout, stderr bytes.Buffer
+		if err := execute(root, &stdout, &stderr); err == nil {
+			t.Errorf("%v: no error", test.args)
+			continue
+		}
+		if got := strings.Contains(stderr.String(), "Usage:"); got != test.wantUsage {
+			t.Errorf("%v: usage printed %v, want %v:\n%s", test.args, got, test.wantUsage, stderr.String())
+		}
+		if !strings.Contains(stderr.String(), test.wantError+"\n") {
+			t.Errorf("%v: stderr %q does not hold %q", test.args, stderr.String(), test.wantError)
+		}
+		if strings.Count(stderr.String(), "Error:") != 1 {
+			t.Errorf("%v: error printed more than once:\n%s", test.args, stderr.String())
+		}
+		if stdout.Len() != 0 {
+			t.Errorf("%v: unexpected output %q", test.args, stdout.String())
+		}
+	}
+}
+
+func TestExecuteHelp(t *testing.T) {
+	root := &cobra.Command{Use: "kube-cluster", Long: "manages environments"}
+	root.AddCommand(&cobra.Command{
+		Use:  "run",
+		Long: "runs something",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+	})
+	root.SetArgs([]string{"run", "--help"})
+
+	var stdout, stderr bytes.Buffer
+	if err := execute(root, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "runs something") {
+		t.Errorf("help not printed to stdout: %q", stdout.String())
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("unexpected errors %q", stderr.String())
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/kubeconfig"
+)
+
+var kubeconfigCmd = &cobra.Command{
+	Use:   "kubeconfig [NAME]",
+	Short: "Print or export the kubeconfig of a kubernetes environment",
+	Long: `Print the kubeconfig of a kubernetes environment, the selected one by default:
+the file calls forwarded to kubectl use. --minify keeps the current context
+only, and --flatten inlines the certificates and keys it refers to.
+
+With --write, the kubeconfig is written to a file instead. With --merge, the
+environment is added to the kubeconfig kubectl uses by default, as a context
+named after the environment or --context. Existing entries are never
+replaced, unless they come from an earlier merge of the same environment.
+Merged contexts are removed when the environment is deleted, or with --remove.`,
+	RunE: runKubeconfig,
+}
+
+var kubeconfigFlags struct {
+	write     string
+	merge     bool
+	mergeFile string
+	context   string
+	remove    bool
+	minify    bool
+	flatten   bool
+}
+
+func init() {
+	kubeconfigCmd.Flags().StringVarP(&kubeconfigFlags.write, "write", "w", "", "write the kubeconfig to this file instead of printing it")
+	kubeconfigCmd.Flags().BoolVar(&kubeconfigFlags.merge, "merge", false, "merge the environment into the default kubeconfig, $KUBECONFIG or ~/.kube/config")
+	kubeconfigCmd.Flags().StringVar(&kubeconfigFlags.mergeFile, "merge-file", "", "kubeconfig file --merge and --remove change instead of the default one")
+	kubeconfigCmd.Flags().StringVar(&kubeconfigFlags.context, "context", "", "name of the merged context, cluster and user, the environment name by default")
+	kubeconfigCmd.Flags().BoolVar(&kubeconfigFlags.remove, "remove", false, "remove the environment from the kubeconfig it was merged into")
+	kubeconfigCmd.Flags().BoolVar(&kubeconfigFlags.minify, "minify", false, "keep the current context only")
+	kubeconfigCmd.Flags().BoolVar(&kubeconfigFlags.flatten, "flatten", false, "inline the files the kubeconfig refers to")
+	addLockFlag(kubeconfigCmd)
+}
+
+func runKubeconfig(cmd *cobra.Command, args []string) error {
+	store := environments()
+	var name string
+	switch len(args) {
+	case 0:
+		var err error
+		if name, _, err = selectedEnvironment(store); err != nil {
+			return err
+		}
+	case 1:
+		name = args[0]
+	default:
+		return usageErrorf("at most one environment name is accepted")
+	}
+	if kubeconfigFlags.merge && kubeconfigFlags.remove {
+		return fmt.Errorf("--merge and --remove cannot be combined")
+	}
+
+	if kubeconfigFlags.merge || kubeconfigFlags.remove {
+		lock, err := lockEnvironment(store, name)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+	e, err := store.Get(name)
+	if err != nil {
+		return err
+	}
+
+	path := kubeconfigFlags.mergeFile
+	if path == "" {
+		path = kubeconfig.DefaultPath()
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return err
+	}
+	context := kubeconfigFlags.context
+	if context == "" {
+		context = e.Name
+	}
+
+	if kubeconfigFlags.remove {
+		if !cmd.Flags().Changed("context") {
+			for _, m := range e.KubeconfigMerges {
+				if m.Path == path {
+					context = m.Context
+				}
+			}
+		}
+		if err := unmergeKubeconfig(path, context); err != nil {
+			return err
+		}
+		e.KubeconfigMerges = withoutMerge(e.KubeconfigMerges, path, context)
+		if err := store.Save(e); err != nil {
+			return err
+		}
+		fmt.Printf("Context %q removed from %s.\n", context, path)
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(store.KubeconfigPath(name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("environment %q has no kubeconfig", name)
+	} else if err != nil {
+		return err
+	}
+	if kubeconfigFlags.merge {
+		return mergeKubeconfig(store, e, data, path, context)
+	}
+
+	if kubeconfigFlags.minify || kubeconfigFlags.flatten {
+		kc, err := kubeconfig.Parse(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", store.KubeconfigPath(name), err)
+		}
+		if kubeconfigFlags.minify {
+			if err := kc.Minify(); err != nil {
+				return err
+			}
+		}
+		if kubeconfigFlags.flatten {
+			if err := kc.Flatten(store.Dir(name)); err != nil {
+				return err
+			}
+		}
+		if data, err = kc.Marshal(); err != nil {
+			return err
+		}
+	}
+
+	if kubeconfigFlags.write != "" {
+		return ioutil.WriteFile(kubeconfigFlags.write, data, 0600)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// mergeKubeconfig adds the current context of data, the kubeconfig of e, to
+// the kubeconfig file at path as context, and records it in e.
+func mergeKubeconfig(store *envstore.Store, e *envstore.Environment, data []byte, path, context string) error {
+	dst, err := kubeconfig.Load(path)
+	if err != nil {
+		return err
+	}
+	merged := false
+	for _, m := range e.KubeconfigMerges {
+		merged = merged || m.Path == path && m.Context == context
+	}
+	if dst.Has(context) && !merged {
+		return fmt.Errorf("%s already has a cluster, user or context named %q, choose another name with --context", path, context)
+	}
+	if err := mergeInto(store, e, data, dst, path, context); err != nil {
+		return err
+	}
+
+	if !merged {
+		e.KubeconfigMerges = append(e.KubeconfigMerges, envstore.KubeconfigMerge{Path: path, Context: context})
+		if err := store.Save(e); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Environment %q merged into %s as context %q.\n", e.Name, path, context)
+	fmt.Printf("Switch to it with \"kubectl config use-context %s\".\n", context)
+	return nil
+}
+
+// remergeKubeconfigs updates the contexts e was merged into with its current
+// kubeconfig, e.g. once its certificates are reissued. Contexts removed from
+// their file since are left out.
+func remergeKubeconfigs(store *envstore.Store, e *envstore.Environment) error {
+	if len(e.KubeconfigMerges) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(store.KubeconfigPath(e.Name))
+	if err != nil {
+		return err
+	}
+	for _, m := range e.KubeconfigMerges {
+		dst, err := kubeconfig.Load(m.Path)
+		if err != nil {
+			return err
+		}
+		if !dst.Has(m.Context) {
+			continue
+		}
+		if err := mergeInto(store, e, data, dst, m.Path, m.Context); err != nil {
+			return err
+		}
+		fmt.Printf("Context %q of %s updated.\n", m.Context, m.Path)
+	}
+	return nil
+}
+
+// mergeInto merges the current context of data, the kubeconfig of e, into
+// dst as context, and saves dst to path.
+func mergeInto(store *envstore.Store, e *envstore.Environment, data []byte, dst *kubeconfig.Config, path, context string) error {
+	src, err := kubeconfig.Parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", store.KubeconfigPath(e.Name), err)
+	}
+	if err := src.Minify(); err != nil {
+		return err
+	}
+	if err := src.Flatten(store.Dir(e.Name)); err != nil {
+		return err
+	}
+	if err := dst.Merge(src, context); err != nil {
+		return err
+	}
+	return dst.Save(path)
+}
+
+// unmergeKubeconfig removes context from the kubeconfig file at path.
+func unmergeKubeconfig(path, context string) error {
+	kc, err := kubeconfig.Load(path)
+	if err != nil {
+		return err
+	}
+	if !kc.Remove(context) {
+		return nil
+	}
+	return kc.Save(path)
+}
+
+func withoutMerge(merges []envstore.KubeconfigMerge, path, context string) []envstore.KubeconfigMerge {
+	var kept []envstore.KubeconfigMerge
+	for _, m := range merges {
+		if m.Path != path || m.Context != context {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
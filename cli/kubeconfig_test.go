@@ -0,0 +1,103 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/kubeconfig"
+)
+
+func TestMergeKubeconfig(t *testing.T) {
+	store, srv, cleanup := fakeStore(t)
+	defer cleanup()
+	os.Setenv(envstore.HomeVariable, store.Root())
+	defer os.Unsetenv(envstore.HomeVariable)
+
+	e := fakeEnvironment(srv, nil)
+	if err := createEnvironment(store, e); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(store.KubeconfigPath("dev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(store.Root(), "config")
+	if err := ioutil.WriteFile(path, []byte("# mine\ncurrent-context: mine\ncontexts:\n- name: taken\n  context: {cluster: taken}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mergeKubeconfig(store, e, data, path, "taken"); err == nil || !strings.Contains(err.Error(), "choose another name") {
+		t.Errorf("got %v merging over an existing context", err)
+	}
+	if err := mergeKubeconfig(store, e, data, path, "dev"); err != nil {
+		t.Fatal(err)
+	}
+	if e, err = store.Get("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.KubeconfigMerges) != 1 || e.KubeconfigMerges[0].Path != path || e.KubeconfigMerges[0].Context != "dev" {
+		t.Fatalf("got merges %+v", e.KubeconfigMerges)
+	}
+	server := func() string {
+		kc, err := kubeconfig.Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !kc.Has("taken") || kc.CurrentContext() != "mine" {
+			t.Errorf("merge changed the other entries")
+		}
+		cluster, _, err := kc.Context("dev")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, _ := kc.Cluster(cluster)["server"].(string)
+		return s
+	}
+	if got := server(); got != srv.URL {
+		t.Errorf("merged server %q, want %q", got, srv.URL)
+	}
+
+	// the merged context is updated along with the certificates
+	stale, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Replace(string(stale), srv.URL, "https://stale", 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCertsRotate(certsRotateCmd, []string{"dev"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := server(); got != srv.URL {
+		t.Errorf("merged server %q after the rotation, want %q", got, srv.URL)
+	}
+
+	if err := unmergeKubeconfig(path, "dev"); err != nil {
+		t.Fatal(err)
+	}
+	kc, err := kubeconfig.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kc.Has("dev") || !kc.Has("taken") {
+		t.Error("unmerge did not remove the dev context only")
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/printer"
+)
+
+const (
+	wideOutput = "wide"
+	nameOutput = "name"
+)
+
+// environmentList wraps environments printed in machine readable formats,
+// unless a single one was asked for by name.
+type environmentList struct {
+	Kind  string                  `json:"kind"`
+	Items []*envstore.Environment `json:"items"`
+}
+
+// addOutputFlag registers the kubectl style --output flag of cmd.
+func addOutputFlag(cmd *cobra.Command, output *string) {
+	cmd.Flags().StringVarP(output, "output", "o", "", "output format, one of: json, yaml, wide, name, or jsonpath=TEMPLATE")
+}
+
+// printEnvironments writes envs in a machine readable format, the wide or
+// name formats, or as a table if format is empty. single tells whether a
+// single environment was asked for by name. Secret driver options are hidden.
+func printEnvironments(w io.Writer, format string, envs []*envstore.Environment, single bool) error {
+	p, ok, err := printer.New(format)
+	if err != nil {
+		return err
+	}
+	if ok {
+		envs = hideSecrets(envs)
+		if single && len(envs) == 1 {
+			return p.Print(w, envs[0])
+		}
+		return p.Print(w, &environmentList{Kind: "EnvironmentList", Items: envs})
+	}
+
+	switch format {
+	case "", wideOutput:
+		return printTable(w, envs, format == wideOutput)
+	case nameOutput:
+		for _, e := range envs {
+			fmt.Fprintf(w, "env/%s\n", e.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expected one of: json, yaml, wide, name, or jsonpath=TEMPLATE", format)
+}
+
+// hiddenValue replaces the value of secret driver options when displayed.
+const hiddenValue = "<hidden>"
+
+// hideSecrets returns copies of envs whose secret driver options are hidden.
+func hideSecrets(envs []*envstore.Environment) []*envstore.Environment {
+	hidden := make([]*envstore.Environment, len(envs))
+	for i, e := range envs {
+		c := *e
+		c.DriverOptions = make(map[string]string, len(e.DriverOptions))
+		for k, v := range e.DriverOptions {
+			if isSecretOption(e.Driver, k) && v != "" {
+				v = hiddenValue
+			}
+			c.DriverOptions[k] = v
+		}
+		if e.DriverOptions == nil {
+			c.DriverOptions = nil
+		}
+		hidden[i] = &c
+	}
+	return hidden
+}
+
+func printTable(w io.Writer, envs []*envstore.Environment, wide bool) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 4, ' ', 0)
+	if wide {
+		fmt.Fprintln(tw, "environment\tdriver\tnodes\tautoscale\tendpoint\tkubectl\tage")
+	} else {
+		fmt.Fprintln(tw, "environment\tdriver\tnodes")
+	}
+
+	for _, e := range envs {
+		if !wide {
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", e.Name, e.Driver, e.Nodes)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			e.Name,
+			e.Driver,
+			e.Nodes,
+			yesNo(e.Autoscale),
+			orNone(e.Endpoint),
+			orNone(e.KubectlVersion),
+			age(e.CreatedAt),
+		)
+	}
+	return tw.Flush()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "Yes"
+	}
+	return "No"
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+// age returns the time elapsed since t in the short kubectl format, e.g. 5m.
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	return duration(time.Since(t))
+}
+
+// duration formats d in the short kubectl format.
+func duration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// describeEnvironment writes the human readable description of e.
+func describeEnvironment(w io.Writer, store *envstore.Store, e *envstore.Environment) error {
+	status := "Unknown"
+	if d, err := newDriver(store, e); err != nil {
+		status += " (" + err.Error() + ")"
+	} else if st, err := d.Status(); err != nil {
+		status += " (" + err.Error() + ")"
+	} else {
+		status = string(st)
+	}
+	if j, err := driver.OpenJournal(store.JournalPath(e.Name)); err == nil && j.Pending() {
+		status = fmt.Sprintf("Incomplete (%s interrupted)", j.Operation)
+		if step := j.Failed(); step != nil {
+			status = fmt.Sprintf("Incomplete (%s failed at step %q: %s)", j.Operation, step.Name, step.Error)
+		}
+	}
+
+	kubectl := e.KubectlVersion
+	if kubectl == "" {
+		kubectl = "from PATH"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "Environment:\t%s\n", e.Name)
+	fmt.Fprintf(tw, "Driver:\t%s\n", e.Driver)
+	fmt.Fprintf(tw, "Nodes:\t%d\n", e.Nodes)
+	fmt.Fprintf(tw, "Autoscale:\t%s\n", yesNo(e.Autoscale))
+	fmt.Fprintf(tw, "Status:\t%s\n", status)
+	fmt.Fprintf(tw, "Endpoint:\t%s\n", orNone(e.Endpoint))
+	fmt.Fprintf(tw, "Kubectl:\t%s\n", kubectl)
+	fmt.Fprintf(tw, "Created:\t%s (%s ago)\n", e.CreatedAt.Local().Format(time.RFC1123Z), age(e.CreatedAt))
+	if len(e.DriverOptions) > 0 {
+		fmt.Fprintf(tw, "Driver options:\n")
+		for _, k := range sortedOptionNames(e.DriverOptions) {
+			v := orNone(strings.TrimSpace(e.DriverOptions[k]))
+			if isSecretOption(e.Driver, k) && e.DriverOptions[k] != "" {
+				v = hiddenValue
+			}
+			fmt.Fprintf(tw, "  %s:\t%s\n", k, v)
+		}
+	}
+	return tw.Flush()
+}
+
+func sortedOptionNames(opts map[string]string) []string {
+	names := make([]string, 0, len(opts))
+	for name := range opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,138 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+func testEnvironments() []*envstore.Environment {
+	return []*envstore.Environment{
+		{
+			Name:          "dev",
+			Driver:        "cli-stub",
+			Nodes:         1,
+			CreatedAt:     time.Now().Add(-2 * time.Hour),
+			DriverOptions: map[string]string{"cli-stub-nodes": "1", "cli-stub-token": "s3cret"},
+		},
+		{
+			Name:     "stage",
+			Driver:   "cli-stub",
+			Nodes:    6,
+			Endpoint: "https://10.0.0.1:6443",
+		},
+	}
+}
+
+func TestPrintEnvironmentsHidesSecrets(t *testing.T) {
+	for _, format := range []string{"json", "yaml", "jsonpath={.items[0].driverOptions}"} {
+		envs := testEnvironments()
+		var buf bytes.Buffer
+		if err := printEnvironments(&buf, format, envs, false); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if strings.Contains(buf.String(), "s3cret") {
+			t.Errorf("%s output shows the secret option:\n%s", format, buf.String())
+		}
+		if !strings.Contains(buf.String(), hiddenValue) {
+			t.Errorf("%s output does not show the secret option as hidden:\n%s", format, buf.String())
+		}
+		if envs[0].DriverOptions["cli-stub-token"] != "s3cret" {
+			t.Errorf("%s: printing changed the environment", format)
+		}
+	}
+}
+
+func TestPrintEnvironments(t *testing.T) {
+	tests := []struct {
+		format string
+		single bool
+		want   []string
+	}{
+		{"", false, []string{"environment    driver      nodes", "dev            cli-stub    1", "stage          cli-stub    6"}},
+		{"wide", false, []string{"autoscale", "https://10.0.0.1:6443", "<none>", "2h"}},
+		{"name", false, []string{"env/dev\nenv/stage\n"}},
+		{"json", false, []string{`"kind": "EnvironmentList"`}},
+		{"jsonpath={.name}", true, []string{"dev"}},
+	}
+	for _, test := range tests {
+		envs := testEnvironments()
+		if test.single {
+			envs = envs[:1]
+		}
+		var buf bytes.Buffer
+		if err := printEnvironments(&buf, test.format, envs, test.single); err != nil {
+			t.Errorf("%q: %v", test.format, err)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%q output does not hold %q:\n%s", test.format, want, buf.String())
+			}
+		}
+	}
+
+	if err := printEnvironments(&bytes.Buffer{}, "xml", nil, false); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestDescribeEnvironmentHidesSecrets(t *testing.T) {
+	root, err := ioutil.TempDir("", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	store := envstore.New(root)
+	e := testEnvironments()[0]
+	if err := store.Create(e); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := describeEnvironment(&buf, store, e); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Environment: dev", "Status:      Unknown (stub driver)", "cli-stub-token: " + hiddenValue} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("description does not hold %q:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("description shows the secret option:\n%s", buf.String())
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		30 * time.Second: "30s",
+		5 * time.Minute:  "5m",
+		3 * time.Hour:    "3h",
+		47 * time.Hour:   "47h",
+		72 * time.Hour:   "3d",
+	}
+	for d, want := range tests {
+		if got := duration(d); got != want {
+			t.Errorf("duration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/prompt"
+)
+
+// parallelism bounds the number of nodes provisioned at once.
+var parallelism int
+
+// nodeProgress reports the progress of the node operations of the command.
+var nodeProgress = newProgress(os.Stdout, prompt.IsTerminal(os.Stdout))
+
+// addParallelismFlag adds the --parallelism flag to commands provisioning
+// nodes.
+func addParallelismFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&parallelism, "parallelism", 10, "maximum number of nodes provisioned at once")
+}
+
+type nodeStatus struct {
+	name   string
+	action string
+	start  time.Time
+	end    time.Time
+	err    error
+}
+
+func (s *nodeStatus) String() string {
+	switch {
+	case s.end.IsZero():
+		return fmt.Sprintf("node %s: %s...", s.name, s.action)
+	case s.err != nil:
+		return fmt.Sprintf("node %s: failed: %v", s.name, s.err)
+	}
+	return fmt.Sprintf("node %s: done in %.1fs", s.name, s.end.Sub(s.start).Seconds())
+}
+
+// progress implements driver.Progress. On terminals, it keeps a line per node
+// up to date, otherwise it prints a line when a node starts and ends.
+type progress struct {
+	mu    sync.Mutex
+	out   io.Writer
+	live  bool
+	nodes []*nodeStatus
+	drawn int
+}
+
+func newProgress(out io.Writer, live bool) *progress {
+	return &progress{out: out, live: live}
+}
+
+func (p *progress) Start(node, action string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &nodeStatus{name: node, action: action, start: time.Now()}
+	p.nodes = append(p.nodes, s)
+	p.report(s)
+}
+
+func (p *progress) Done(node string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range p.nodes {
+		if s.name == node && s.end.IsZero() {
+			s.end, s.err = time.Now(), err
+			p.report(s)
+			return
+		}
+	}
+}
+
+// report shows the change of s.
+func (p *progress) report(s *nodeStatus) {
+	if !p.live {
+		fmt.Fprintln(p.out, s)
+		return
+	}
+
+	// Redraw the lines of the nodes, moving up over the previous ones.
+	if p.drawn > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA", p.drawn)
+	}
+	for _, s := range p.nodes {
+		fmt.Fprintf(p.out, "\x1b[2K%s\n", s)
+	}
+	p.drawn = len(p.nodes)
+}
+
+// summary prints how many nodes succeeded and failed since the last summary,
+// if any node was reported.
+func (p *progress) summary() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.nodes) == 0 {
+		return
+	}
+	var failed []*nodeStatus
+	for _, s := range p.nodes {
+		if s.err != nil {
+			failed = append(failed, s)
+		}
+	}
+	fmt.Fprintf(p.out, "%d of %d node(s) provisioned", len(p.nodes)-len(failed), len(p.nodes))
+	if len(failed) == 0 {
+		fmt.Fprintln(p.out, ".")
+	} else {
+		fmt.Fprintf(p.out, ", %d failed:\n", len(failed))
+		for _, s := range failed {
+			fmt.Fprintf(p.out, "  %s: %v\n", s.name, s.err)
+		}
+	}
+	p.nodes, p.drawn = nil, 0
+}
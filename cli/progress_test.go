@@ -0,0 +1,72 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgress(&buf, false)
+	p.Start("node-1", "creating")
+	p.Start("node-2", "creating")
+	p.Done("node-2", errors.New("no capacity"))
+	p.Done("node-1", nil)
+	p.summary()
+
+	want := regexp.MustCompile(`^node node-1: creating\.\.\.
+node node-2: creating\.\.\.
+node node-2: failed: no capacity
+node node-1: done in \d+\.\ds
+1 of 2 node\(s\) provisioned, 1 failed:
+  node-2: no capacity
+$`)
+	if !want.MatchString(buf.String()) {
+		t.Errorf("got:\n%s", buf.String())
+	}
+
+	// summaries cover the nodes reported since the previous one
+	buf.Reset()
+	p.summary()
+	p.Start("node-3", "removing")
+	p.Done("node-3", nil)
+	p.summary()
+	if !strings.HasSuffix(buf.String(), "1 of 1 node(s) provisioned.\n") || strings.Contains(buf.String(), "node-1") {
+		t.Errorf("got:\n%s", buf.String())
+	}
+}
+
+func TestProgressLive(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgress(&buf, true)
+	p.Start("node-1", "creating")
+	p.Start("node-2", "creating")
+	p.Done("node-1", nil)
+
+	want := "\x1b[2Knode node-1: creating...\n" +
+		"\x1b[1A\x1b[2Knode node-1: creating...\n\x1b[2Knode node-2: creating...\n" +
+		"\x1b[2A\x1b[2Knode node-1: done in "
+	if !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("got %q, want %q...", buf.String(), want)
+	}
+	if !strings.HasSuffix(buf.String(), "\x1b[2Knode node-2: creating...\n") {
+		t.Errorf("got %q, want the lines of both nodes redrawn", buf.String())
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/kubectl"
+)
+
+var scaleCmd = &cobra.Command{
+	Use:   "scale",
+	Short: "Set a new size for a resource",
+	Long:  "Set a new size for a resource. Use \"scale env\" to change the number of nodes of a kubernetes environment, anything else is handed to kubectl.",
+}
+
+var scaleEnvCmd = &cobra.Command{
+	Use:   "env [NAME] --nodes [+|-]COUNT",
+	Short: "Change the number of nodes of a kubernetes environment",
+	Long: `Change the number of nodes of a kubernetes environment, the selected one by
+default. The count is either absolute, e.g. --nodes 5, or relative to the
+current one, e.g. --nodes +2 or --nodes -1. Environments with several node
+pools need the pool to scale chosen with --pool.
+
+Before scaling down, the nodes about to be removed are cordoned and drained with
+kubectl, so their pods are rescheduled elsewhere.`,
+	RunE: runScaleEnv,
+}
+
+var scaleEnvFlags struct {
+	nodes string
+	pool  string
+	drain bool
+}
+
+func init() {
+	scaleEnvCmd.Flags().StringVar(&scaleEnvFlags.nodes, "nodes", "", "number of nodes, or +N/-N to add or remove nodes")
+	scaleEnvCmd.Flags().StringVar(&scaleEnvFlags.pool, "pool", "", "node pool to scale, required if the environment has several")
+	scaleEnvCmd.Flags().BoolVar(&scaleEnvFlags.drain, "drain", true, "cordon and drain nodes before removing them")
+	addParallelismFlag(scaleEnvCmd)
+	addLockFlag(scaleEnvCmd)
+	scaleCmd.AddCommand(scaleEnvCmd)
+}
+
+func runScaleEnv(cmd *cobra.Command, args []string) error {
+	store := environments()
+	var name string
+	switch len(args) {
+	case 0:
+		var err error
+		if name, _, err = selectedEnvironment(store); err != nil {
+			return err
+		}
+	case 1:
+		name = args[0]
+	default:
+		return usageErrorf("at most one environment name is accepted")
+	}
+
+	lock, err := lockEnvironment(store, name)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	e, err := store.Get(name)
+	if err != nil {
+		return err
+	}
+	pool, err := selectPool(e, scaleEnvFlags.pool)
+	if err != nil {
+		return err
+	}
+	nodes, err := scaleTarget(scaleEnvFlags.nodes, pool.Nodes)
+	if err != nil {
+		return err
+	}
+	if nodes == pool.Nodes {
+		fmt.Printf("Node pool %q of environment %q already has %d node(s).\n", pool.Name, e.Name, nodes)
+		return nil
+	}
+
+	desired := resized(e, pool.Name, nodes)
+	if desired.Nodes < 1 {
+		return fmt.Errorf("environment %q needs at least one node", e.Name)
+	}
+	d, err := newDriver(store, desired)
+	if err != nil {
+		return err
+	}
+	if err := canResize(d, e, desired); err != nil {
+		return err
+	}
+
+	if err := resize(store, e, desired, d, scaleEnvFlags.drain); err != nil {
+		return err
+	}
+	if err := store.Save(desired); err != nil {
+		return err
+	}
+	fmt.Printf("Environment %q scaled to %d node(s).\n", e.Name, desired.Nodes)
+	return nil
+}
+
+// selectPool returns the named node pool of e, or its only pool if name is
+// empty.
+func selectPool(e *envstore.Environment, name string) (envstore.NodePool, error) {
+	pools := e.NodePools()
+	var names []string
+	for _, p := range pools {
+		if p.Name == name || name == "" && len(pools) == 1 {
+			return p, nil
+		}
+		names = append(names, p.Name)
+	}
+	if name == "" {
+		return envstore.NodePool{}, fmt.Errorf("environment %q has several node pools, choose one with --pool: %s", e.Name, strings.Join(names, ", "))
+	}
+	return envstore.NodePool{}, fmt.Errorf("environment %q has no node pool %q, available pools: %s", e.Name, name, strings.Join(names, ", "))
+}
+
+// scaleTarget interprets the --nodes value, relative to current if signed.
+func scaleTarget(value string, current int) (int, error) {
+	if value == "" {
+		return 0, fmt.Errorf("--nodes is required")
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --nodes %q, expected a count such as 3, +2 or -1", value)
+	}
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		n += current
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("cannot scale to %d nodes, the pool has %d", n, current)
+	}
+	return n, nil
+}
+
+// resized returns a copy of e with the named pool holding the given number of
+// nodes.
+func resized(e *envstore.Environment, pool string, nodes int) *envstore.Environment {
+	r := *e
+	if len(e.Pools) == 0 {
+		r.Nodes = nodes
+		return &r
+	}
+
+	r.Pools = make([]envstore.NodePool, len(e.Pools))
+	r.Nodes = 0
+	for i, p := range e.Pools {
+		if p.Name == pool {
+			p.Nodes = nodes
+		}
+		r.Pools[i] = p
+		r.Nodes += p.Nodes
+	}
+	return &r
+}
+
+// changedPools returns the pools of desired whose size differs from current,
+// and the pools current has but desired has not, scaled to zero.
+func changedPools(current, desired *envstore.Environment) []driver.Pool {
+	sizes := make(map[string]int)
+	for _, p := range current.NodePools() {
+		sizes[p.Name] = p.Nodes
+	}
+
+	var changed []driver.Pool
+	for _, p := range desired.NodePools() {
+		if n, ok := sizes[p.Name]; !ok || n != p.Nodes {
+			changed = append(changed, driverPool(p))
+		}
+		delete(sizes, p.Name)
+	}
+	for _, p := range current.NodePools() {
+		if _, removed := sizes[p.Name]; removed {
+			changed = append(changed, driver.Pool{Name: p.Name})
+		}
+	}
+	return changed
+}
+
+// canResize checks that d is able to bring the node pools of current to the
+// desired ones. Drivers without pool support only scale a single pool.
+func canResize(d driver.Driver, current, desired *envstore.Environment) error {
+	if _, ok := d.(driver.PoolScaler); ok {
+		return nil
+	}
+	c, n := current.NodePools(), desired.NodePools()
+	if len(c) == 1 && len(n) == 1 && c[0].Name == n[0].Name {
+		return nil
+	}
+	if len(changedPools(current, desired)) == 0 {
+		return nil
+	}
+	return fmt.Errorf("environment %q: the %s driver cannot scale node pools separately", current.Name, current.Driver)
+}
+
+// resize brings the node pools of an environment from their current sizes to
+// the desired ones. The nodes about to be removed are drained first if drain is
+// set.
+func resize(store *envstore.Store, current, desired *envstore.Environment, d driver.Driver, drain bool) error {
+	changed := changedPools(current, desired)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if drain {
+		nodes, err := removedNodes(d, desired, changed)
+		if err != nil {
+			return err
+		}
+		if err := drainNodes(store, current, nodes); err != nil {
+			return err
+		}
+	}
+
+	defer nodeProgress.summary()
+	ps, ok := d.(driver.PoolScaler)
+	if !ok {
+		fmt.Printf("Scaling to %d node(s).\n", desired.Nodes)
+		return d.Scale(desired.Nodes)
+	}
+	for _, p := range changed {
+		fmt.Printf("Scaling node pool %s to %d node(s).\n", p.Name, p.Nodes)
+		if err := ps.ScalePool(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removedNodes returns the nodes d removes when scaling the changed pools:
+// the most recent ones of each pool shrinking.
+func removedNodes(d driver.Driver, desired *envstore.Environment, changed []driver.Pool) ([]driver.Node, error) {
+	lister, ok := d.(driver.NodeLister)
+	if !ok {
+		fmt.Printf("The %s driver cannot list nodes, they are removed without draining.\n", desired.Driver)
+		return nil, nil
+	}
+	nodes, err := lister.Nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	// Drivers without pool support remove the most recent nodes, whatever
+	// their pool.
+	if _, ok := d.(driver.PoolScaler); !ok {
+		if excess := len(nodes) - desired.Nodes; excess > 0 {
+			return nodes[len(nodes)-excess:], nil
+		}
+		return nil, nil
+	}
+
+	var removed []driver.Node
+	for _, p := range changed {
+		var pool []driver.Node
+		for _, n := range nodes {
+			if n.Pool == p.Name {
+				pool = append(pool, n)
+			}
+		}
+		if excess := len(pool) - p.Nodes; excess > 0 {
+			removed = append(removed, pool[len(pool)-excess:]...)
+		}
+	}
+	return removed, nil
+}
+
+// drainNodes cordons the given nodes of e, and evicts their pods. On failure,
+// the nodes already cordoned are uncordoned, so they take pods again.
+func drainNodes(store *envstore.Store, e *envstore.Environment, nodes []driver.Node) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	binary, err := kubectlLocator(store).Find(e.KubectlVersion, e.KubectlChecksum)
+	if err != nil {
+		return fmt.Errorf("cannot drain nodes: %v", err)
+	}
+
+	kubectl := func(args ...string) error {
+		cmd := exec.Command(binary, append([]string{"--kubeconfig=" + store.KubeconfigPath(e.Name)}, args...)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	var cordoned []string
+	uncordon := func() {
+		for _, name := range cordoned {
+			if err := kubectl("uncordon", name); err != nil {
+				fmt.Printf("Could not uncordon node %s: %v\n", name, err)
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		fmt.Printf("Draining node %s.\n", n.Name)
+		if err := kubectl("cordon", n.Name); err != nil {
+			uncordon()
+			return fmt.Errorf("cannot drain node %s: kubectl cordon: %v", n.Name, err)
+		}
+		cordoned = append(cordoned, n.Name)
+		if err := kubectl("drain", n.Name, "--force", "--ignore-daemonsets"); err != nil {
+			uncordon()
+			return fmt.Errorf("cannot drain node %s: kubectl drain: %v", n.Name, err)
+		}
+	}
+	return nil
+}
+
+// kubectlLocator returns the kubectl locator caching releases in store.
+func kubectlLocator(store *envstore.Store) *kubectl.Locator {
+	return kubectl.NewLocator(filepath.Join(store.CacheDir(), kubectl.BinaryName))
+}
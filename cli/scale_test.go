@@ -0,0 +1,179 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/kubectl"
+)
+
+func TestScaleTarget(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{"3", 3, true},
+		{"+2", 5, true},
+		{"-1", 2, true},
+		{"0", 0, true},
+		{"-4", 0, false},
+		{"", 0, false},
+		{"three", 0, false},
+	}
+	for _, test := range tests {
+		got, err := scaleTarget(test.value, 3)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("%q: got %d, %v, want %d, ok %v", test.value, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestResized(t *testing.T) {
+	e := &envstore.Environment{Name: "dev", Nodes: 3, Pools: []envstore.NodePool{{Name: "default", Nodes: 2}, {Name: "large", Nodes: 1}}}
+	r := resized(e, "large", 4)
+	if r.Nodes != 6 || r.Pools[1].Nodes != 4 || r.Pools[0].Nodes != 2 {
+		t.Errorf("got %+v", r)
+	}
+	if e.Pools[1].Nodes != 1 {
+		t.Error("the environment was changed")
+	}
+
+	if r := resized(&envstore.Environment{Name: "dev", Nodes: 3}, envstore.DefaultPoolName, 1); r.Nodes != 1 || len(r.Pools) != 0 {
+		t.Errorf("got %+v", r)
+	}
+}
+
+func TestChangedPools(t *testing.T) {
+	current := &envstore.Environment{Pools: []envstore.NodePool{{Name: "default", Nodes: 2}, {Name: "large", Nodes: 1}, {Name: "old", Nodes: 1}}}
+	desired := &envstore.Environment{Pools: []envstore.NodePool{{Name: "default", Nodes: 2}, {Name: "large", Nodes: 3}, {Name: "gpu", Nodes: 1}}}
+	want := []driver.Pool{{Name: "large", Nodes: 3}, {Name: "gpu", Nodes: 1}, {Name: "old"}}
+	if got := changedPools(current, desired); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSelectPool(t *testing.T) {
+	e := &envstore.Environment{Name: "dev", Pools: []envstore.NodePool{{Name: "default", Nodes: 2}, {Name: "large", Nodes: 1}}}
+	if p, err := selectPool(e, "large"); err != nil || p.Name != "large" {
+		t.Errorf("got %+v, %v", p, err)
+	}
+	if _, err := selectPool(e, ""); err == nil {
+		t.Error("got no error without --pool among several pools")
+	}
+	if _, err := selectPool(e, "gpu"); err == nil {
+		t.Error("got no error for an unknown pool")
+	}
+	if p, err := selectPool(&envstore.Environment{Nodes: 1}, ""); err != nil || p.Name != envstore.DefaultPoolName {
+		t.Errorf("got %+v, %v", p, err)
+	}
+}
+
+// stubKubectl puts a kubectl on PATH appending its arguments to a log,
+// failing to drain the node named fail. It returns the log path.
+func stubKubectl(t *testing.T, fail string) (string, func()) {
+	dir, err := ioutil.TempDir("", "kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "log")
+	script := "#!/bin/sh\nshift\necho \"$@\" >>" + log + "\n[ \"$1 $2\" != \"drain " + fail + "\" ]\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, kubectl.BinaryName), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return log, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDrainNodes(t *testing.T) {
+	nodes := []driver.Node{{Name: "node-1"}, {Name: "node-2"}, {Name: "node-3"}}
+	tests := []struct {
+		fail string
+		ok   bool
+		want []string
+	}{
+		{"", true, []string{
+			"cordon node-1", "drain node-1 --force --ignore-daemonsets",
+			"cordon node-2", "drain node-2 --force --ignore-daemonsets",
+			"cordon node-3", "drain node-3 --force --ignore-daemonsets",
+		}},
+		{"node-2", false, []string{
+			"cordon node-1", "drain node-1 --force --ignore-daemonsets",
+			"cordon node-2", "drain node-2 --force --ignore-daemonsets",
+			"uncordon node-1", "uncordon node-2",
+		}},
+	}
+	for _, test := range tests {
+		log, cleanup := stubKubectl(t, test.fail)
+		store := envstore.New(filepath.Dir(log))
+		err := drainNodes(store, &envstore.Environment{Name: "dev"}, nodes)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v, want ok %v", test.fail, err, test.ok)
+		}
+		data, _ := ioutil.ReadFile(log)
+		if got := strings.Split(strings.TrimSpace(string(data)), "\n"); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got kubectl calls %q, want %q", test.fail, got, test.want)
+		}
+		cleanup()
+	}
+}
+
+func TestRemovedNodes(t *testing.T) {
+	store, srv, cleanup := fakeStore(t)
+	defer cleanup()
+
+	e := fakeEnvironment(srv, nil)
+	e.Nodes = 3
+	e.Pools = []envstore.NodePool{{Name: "default", Nodes: 2}, {Name: "large", Nodes: 1}}
+	if err := createEnvironment(store, e); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDriver(store, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desired := resized(e, "default", 0)
+	removed, err := removedNodes(d, desired, changedPools(e, desired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || removed[0].Pool != "default" || removed[1].Pool != "default" {
+		t.Errorf("got %+v, want the 2 nodes of the default pool", removed)
+	}
+
+	if err := resize(store, e, desired, d, false); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := d.(driver.NodeLister).Nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Pool != "large" {
+		t.Errorf("got nodes %+v, want the large one", nodes)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"strconv"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/prompt"
+)
+
+// askCreateEnv completes the "create env" flags not given on the command
+// line by asking for them: driver, node count, autoscaling, and the driver
+// options having a prompt.
+func askCreateEnv(cmd *cobra.Command, p *prompt.Prompter) error {
+	flags := cmd.Flags()
+
+	if !flags.Changed("driver") {
+		name, err := p.Choice("Driver", driver.Names(), createEnvFlags.driver)
+		if err != nil {
+			return err
+		}
+		createEnvFlags.driver = name
+	}
+
+	if !flags.Changed("nodes") {
+		nodes, err := p.Int("How many nodes", createEnvFlags.nodes, 1)
+		if err != nil {
+			return err
+		}
+		createEnvFlags.nodes = nodes
+	}
+
+	if !flags.Changed("autoscale") {
+		autoscale, err := p.YesNo("Autoscale", createEnvFlags.autoscale)
+		if err != nil {
+			return err
+		}
+		createEnvFlags.autoscale = autoscale
+	}
+
+	for _, opt := range driver.Options(createEnvFlags.driver) {
+		f := flags.Lookup(opt.Name)
+		if opt.Prompt == "" || f == nil || f.Changed {
+			continue
+		}
+
+		answer, err := askOption(p, opt, f.Value.String())
+		if err != nil {
+			return err
+		}
+		if err := flags.Set(opt.Name, answer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// askOption asks for the value of a driver option, def being its current
+// value.
+func askOption(p *prompt.Prompter, opt driver.Option, def string) (string, error) {
+	if opt.Type == driver.BoolOption {
+		b, _ := strconv.ParseBool(def)
+		answer, err := p.YesNo(opt.Prompt, b)
+		return strconv.FormatBool(answer), err
+	}
+
+	return p.Ask(prompt.Question{
+		Text:     opt.Prompt,
+		Default:  def,
+		Secret:   opt.Secret,
+		Validate: opt.Check,
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/prompt"
+)
+
+func TestAskCreateEnv(t *testing.T) {
+	saved := createEnvFlags
+	defer func() { createEnvFlags = saved }()
+
+	cmd := &cobra.Command{Use: "env"}
+	cmd.Flags().StringVar(&createEnvFlags.driver, "driver", "", "")
+	cmd.Flags().IntVar(&createEnvFlags.nodes, "nodes", 1, "")
+	cmd.Flags().BoolVar(&createEnvFlags.autoscale, "autoscale", false, "")
+	addDriverFlags(cmd)
+	// flags given on the command line are not asked for
+	if err := cmd.Flags().Parse([]string{"--cli-stub-token=s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	answers, err := ioutil.TempFile("", "answers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(answers.Name())
+	defer answers.Close()
+	// driver, nodes (once invalid), autoscale, stub nodes, debug
+	answers.WriteString("cli-stub\n0\n4\ny\nmany\n2\nyes\n")
+	answers.Seek(0, 0)
+
+	var out bytes.Buffer
+	if err := askCreateEnv(cmd, prompt.New(answers, &out)); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+
+	if createEnvFlags.driver != "cli-stub" || createEnvFlags.nodes != 4 || !createEnvFlags.autoscale {
+		t.Errorf("answers give driver %q, %d nodes and autoscale %v", createEnvFlags.driver, createEnvFlags.nodes, createEnvFlags.autoscale)
+	}
+	opts, err := driverOptions(cmd, "cli-stub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"cli-stub-nodes": "2", "cli-stub-token": "s3cret", "cli-stub-debug": "true"}
+	for name, value := range want {
+		if opts[name] != value {
+			t.Errorf("option %s = %q, want %q", name, opts[name], value)
+		}
+	}
+}
@@ -0,0 +1,1033 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aws implements a driver running kubernetes environments on Amazon EC2
+// instances.
+//
+// Every environment gets its own VPC, with an internet gateway, a subnet and a
+// security group letting the machines talk to each other and exposing SSH and
+// the API server. The master and the nodes are launched from the aws-ami
+// image, expected to bootstrap kubernetes from its cloud-init user data, see
+// driver.CloudConfig, and get their certificates over SSH once they are
+// issued. All resources are tagged with the environment name,
+// which is how they are found again, and deleted.
+//
+// The EC2 Query API is called directly. aws-endpoint points the driver at
+// another EC2 compatible service, e.g. a local stand-in for testing.
+package aws
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+const (
+	driverName    = "aws"
+	stateFileName = "aws-state.json"
+	defaultPool   = "default"
+
+	optAccessKeyID        = "aws-access-key-id"
+	optSecretAccessKey    = "aws-secret-access-key"
+	optRegion             = "aws-region"
+	optZone               = "aws-zone"
+	optAMI                = "aws-ami"
+	optInstanceType       = "aws-instance-type"
+	optMasterInstanceType = "aws-master-instance-type"
+	optKeyName            = "aws-key-name"
+	optVPCCIDR            = "aws-vpc-cidr"
+	optSubnetCIDR         = "aws-subnet-cidr"
+	optEndpoint           = "aws-endpoint"
+	optTimeout            = "aws-timeout"
+
+	// tagEnvironment holds the environment name on every resource.
+	tagEnvironment = "kube-cluster/environment"
+	tagRole        = "kube-cluster/role"
+	tagPool        = "kube-cluster/pool"
+
+	apiServerPort = 6443
+)
+
+func init() {
+	driver.Register(driverName, New,
+		driver.Option{
+			Name:   optAccessKeyID,
+			Usage:  "AWS access key ID, $AWS_ACCESS_KEY_ID if empty",
+			Prompt: "Give AWS Credential ID",
+		},
+		driver.Option{
+			Name:   optSecretAccessKey,
+			Usage:  "AWS secret access key, $AWS_SECRET_ACCESS_KEY if empty",
+			Prompt: "Give AWS Credential KEY",
+			Secret: true,
+		},
+		driver.Option{
+			Name:    optRegion,
+			Usage:   "AWS region",
+			Default: "us-east-1",
+			Prompt:  "AWS region",
+		},
+		driver.Option{
+			Name:  optZone,
+			Usage: "AWS availability zone of the subnet, chosen by AWS if empty",
+		},
+		driver.Option{
+			Name:     optAMI,
+			Usage:    "AWS image the machines are launched from",
+			Prompt:   "AWS image (AMI)",
+			Required: true,
+		},
+		driver.Option{
+			Name:    optInstanceType,
+			Usage:   "AWS instance type of the nodes, unless their pool sets a machine type",
+			Default: "t2.medium",
+		},
+		driver.Option{
+			Name:    optMasterInstanceType,
+			Usage:   "AWS instance type of the master",
+			Default: "t2.medium",
+		},
+		driver.Option{
+			Name:  optKeyName,
+			Usage: "AWS key pair granting SSH access to the machines",
+		},
+		driver.Option{
+			Name:     optVPCCIDR,
+			Usage:    "address range of the AWS VPC",
+			Default:  "10.0.0.0/16",
+			Validate: validateCIDR,
+		},
+		driver.Option{
+			Name:     optSubnetCIDR,
+			Usage:    "address range of the AWS subnet, within the VPC one",
+			Default:  "10.0.0.0/24",
+			Validate: validateCIDR,
+		},
+		driver.Option{
+			Name:     optEndpoint,
+			Usage:    "EC2 API endpoint, the one of the region if empty",
+			Validate: validateEndpoint,
+		},
+		driver.Option{
+			Name:     optTimeout,
+			Usage:    "how long to wait for AWS instances to start or stop",
+			Default:  "10m",
+			Validate: driver.ValidateDuration,
+		},
+	)
+}
+
+func validateCIDR(value string) error {
+	if _, _, err := net.ParseCIDR(value); err != nil {
+		return fmt.Errorf("invalid CIDR %q", value)
+	}
+	return nil
+}
+
+func validateEndpoint(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint %q, expected an http or https URL", value)
+	}
+	return nil
+}
+
+// pollInterval is how often pending operations are checked.
+var pollInterval = 5 * time.Second
+
+// waitForSSH is replaced in tests, where no instance ever boots.
+var waitForSSH = driver.WaitForSSH
+
+// machine is an EC2 instance of the environment.
+type machine struct {
+	Name       string `json:"name"`
+	Pool       string `json:"pool,omitempty"`
+	InstanceID string `json:"instanceId,omitempty"`
+	PrivateIP  string `json:"privateIp,omitempty"`
+	PublicIP   string `json:"publicIp,omitempty"`
+}
+
+type state struct {
+	VPC             string    `json:"vpc,omitempty"`
+	InternetGateway string    `json:"internetGateway,omitempty"`
+	Subnet          string    `json:"subnet,omitempty"`
+	SecurityGroup   string    `json:"securityGroup,omitempty"`
+	Master          *machine  `json:"master,omitempty"`
+	Nodes           []machine `json:"nodes"`
+}
+
+// Driver manages an environment made of EC2 instances.
+type Driver struct {
+	name      string
+	storePath string
+	pools     []driver.Pool
+	config    *driver.Config
+	ec2       *client
+
+	zone               string
+	ami                string
+	instanceType       string
+	masterInstanceType string
+	keyName            string
+	vpcCIDR            string
+	subnetCIDR         string
+	timeout            time.Duration
+
+	// mu guards the state while instances are launched concurrently.
+	mu sync.Mutex
+}
+
+// New instantiates an AWS driver.
+func New(c *driver.Config) (driver.Driver, error) {
+	accessKey := c.Option(optAccessKeyID, "")
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	secretKey := c.Option(optSecretAccessKey, "")
+	if secretKey == "" {
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("aws: no credentials, set --%s and --%s, or $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY", optAccessKeyID, optSecretAccessKey)
+	}
+
+	region := c.Option(optRegion, "us-east-1")
+	endpoint := c.Option(optEndpoint, "")
+	if endpoint == "" {
+		endpoint = "https://ec2." + region + ".amazonaws.com/"
+	} else if err := validateEndpoint(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optEndpoint, err)
+	}
+	timeout, err := time.ParseDuration(c.Option(optTimeout, "10m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optTimeout, err)
+	}
+
+	pools := c.Pools
+	if len(pools) == 0 {
+		pools = []driver.Pool{{Name: defaultPool, Nodes: c.Nodes}}
+	}
+
+	return &Driver{
+		name:      c.Name,
+		storePath: c.StorePath,
+		pools:     pools,
+		config:    c,
+		ec2: &client{
+			endpoint:  endpoint,
+			region:    region,
+			accessKey: accessKey,
+			secretKey: secretKey,
+			http:      &http.Client{Timeout: time.Minute},
+		},
+
+		zone:               c.Option(optZone, ""),
+		ami:                c.Option(optAMI, ""),
+		instanceType:       c.Option(optInstanceType, "t2.medium"),
+		masterInstanceType: c.Option(optMasterInstanceType, "t2.medium"),
+		keyName:            c.Option(optKeyName, ""),
+		vpcCIDR:            c.Option(optVPCCIDR, "10.0.0.0/16"),
+		subnetCIDR:         c.Option(optSubnetCIDR, "10.0.0.0/24"),
+		timeout:            timeout,
+	}, nil
+}
+
+// Create sets up the network, then launches the master and the nodes of every
+// pool, a journal step each. Resources left over from a previous attempt are
+// found by their tags and reused.
+func (d *Driver) Create() error {
+	if d.ami == "" {
+		return fmt.Errorf("aws: --%s is required", optAMI)
+	}
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+
+	steps := []struct {
+		name string
+		fn   func(s *state, record func(kind, id string)) error
+	}{
+		{"create vpc", d.createVPC},
+		{"create internet gateway", d.createInternetGateway},
+		{"create subnet", d.createSubnet},
+		{"create security group", d.createSecurityGroup},
+		{"create master", d.createMaster},
+	}
+	for _, step := range steps {
+		fn := step.fn
+		err := d.config.Journal.Run(step.name, func(record func(kind, id string)) error {
+			if err := fn(s, record); err != nil {
+				return err
+			}
+			return d.save(s)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range d.pools {
+		p := p
+		err := d.config.Journal.Run("create node pool "+p.Name, func(record func(kind, id string)) error {
+			return d.resize(s, p, record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) createVPC(s *state, record func(kind, id string)) error {
+	ids, err := d.ec2.ids("DescribeVpcs", d.tags())
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		var resp struct {
+			ID string `xml:"vpc>vpcId"`
+		}
+		params := url.Values{"CidrBlock": {d.vpcCIDR}}
+		tagSpecification(params, "vpc", d.tags("Name", d.name))
+		if err := d.ec2.call("CreateVpc", params, &resp); err != nil {
+			return err
+		}
+		record("vpc", resp.ID)
+		ids = []string{resp.ID}
+	}
+	s.VPC = ids[0]
+	return d.ec2.call("ModifyVpcAttribute", url.Values{"VpcId": {s.VPC}, "EnableDnsHostnames.Value": {"true"}}, nil)
+}
+
+func (d *Driver) createInternetGateway(s *state, record func(kind, id string)) error {
+	gateways, err := d.ec2.internetGateways(filters(url.Values{}, d.tags()))
+	if err != nil {
+		return err
+	}
+	if len(gateways) == 0 {
+		var resp struct {
+			ID string `xml:"internetGateway>internetGatewayId"`
+		}
+		params := url.Values{}
+		tagSpecification(params, "internet-gateway", d.tags("Name", d.name))
+		if err := d.ec2.call("CreateInternetGateway", params, &resp); err != nil {
+			return err
+		}
+		record("internet-gateway", resp.ID)
+		gateways = []internetGateway{{ID: resp.ID}}
+	}
+	gw := gateways[0]
+	s.InternetGateway = gw.ID
+
+	if len(gw.VPCs) == 0 {
+		if err := d.ec2.call("AttachInternetGateway", url.Values{"InternetGatewayId": {gw.ID}, "VpcId": {s.VPC}}, nil); err != nil {
+			return err
+		}
+	}
+
+	// route the internet through the gateway from the main route table
+	var tables struct {
+		IDs []string `xml:"routeTableSet>item>routeTableId"`
+	}
+	params := url.Values{
+		"Filter.1.Name":    {"vpc-id"},
+		"Filter.1.Value.1": {s.VPC},
+		"Filter.2.Name":    {"association.main"},
+		"Filter.2.Value.1": {"true"},
+	}
+	if err := d.ec2.call("DescribeRouteTables", params, &tables); err != nil {
+		return err
+	}
+	if len(tables.IDs) == 0 {
+		return fmt.Errorf("aws: VPC %s has no main route table", s.VPC)
+	}
+	err = d.ec2.call("CreateRoute", url.Values{
+		"RouteTableId":         {tables.IDs[0]},
+		"DestinationCidrBlock": {"0.0.0.0/0"},
+		"GatewayId":            {gw.ID},
+	}, nil)
+	if e, ok := err.(*APIError); ok && e.Code == "RouteAlreadyExists" {
+		return nil
+	}
+	return err
+}
+
+func (d *Driver) createSubnet(s *state, record func(kind, id string)) error {
+	ids, err := d.ec2.ids("DescribeSubnets", d.tags())
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		params := url.Values{"VpcId": {s.VPC}, "CidrBlock": {d.subnetCIDR}}
+		if d.zone != "" {
+			params.Set("AvailabilityZone", d.zone)
+		}
+		tagSpecification(params, "subnet", d.tags("Name", d.name))
+		var resp struct {
+			ID string `xml:"subnet>subnetId"`
+		}
+		if err := d.ec2.call("CreateSubnet", params, &resp); err != nil {
+			return err
+		}
+		record("subnet", resp.ID)
+		ids = []string{resp.ID}
+	}
+	s.Subnet = ids[0]
+	return d.ec2.call("ModifySubnetAttribute", url.Values{"SubnetId": {s.Subnet}, "MapPublicIpOnLaunch.Value": {"true"}}, nil)
+}
+
+func (d *Driver) createSecurityGroup(s *state, record func(kind, id string)) error {
+	ids, err := d.ec2.ids("DescribeSecurityGroups", d.tags())
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		var resp struct {
+			ID string `xml:"groupId"`
+		}
+		params := url.Values{
+			"GroupName":        {d.name + "-kube-cluster"},
+			"GroupDescription": {"kube-cluster environment " + d.name},
+			"VpcId":            {s.VPC},
+		}
+		tagSpecification(params, "security-group", d.tags("Name", d.name))
+		if err := d.ec2.call("CreateSecurityGroup", params, &resp); err != nil {
+			return err
+		}
+		record("security-group", resp.ID)
+		ids = []string{resp.ID}
+	}
+	s.SecurityGroup = ids[0]
+
+	// SSH and the API server from anywhere, everything within the group
+	err = d.ec2.call("AuthorizeSecurityGroupIngress", url.Values{
+		"GroupId":                           {s.SecurityGroup},
+		"IpPermissions.1.IpProtocol":        {"tcp"},
+		"IpPermissions.1.FromPort":          {"22"},
+		"IpPermissions.1.ToPort":            {"22"},
+		"IpPermissions.1.IpRanges.1.CidrIp": {"0.0.0.0/0"},
+		"IpPermissions.2.IpProtocol":        {"tcp"},
+		"IpPermissions.2.FromPort":          {strconv.Itoa(apiServerPort)},
+		"IpPermissions.2.ToPort":            {strconv.Itoa(apiServerPort)},
+		"IpPermissions.2.IpRanges.1.CidrIp": {"0.0.0.0/0"},
+		"IpPermissions.3.IpProtocol":        {"-1"},
+		"IpPermissions.3.Groups.1.GroupId":  {s.SecurityGroup},
+	}, nil)
+	if e, ok := err.(*APIError); ok && e.Code == "InvalidPermission.Duplicate" {
+		return nil
+	}
+	return err
+}
+
+func (d *Driver) createMaster(s *state, record func(kind, id string)) error {
+	if s.Master == nil {
+		s.Master = &machine{Name: d.name + "-master"}
+	}
+	boot := driver.BootConfig{Role: driver.MasterRole, Name: s.Master.Name}
+	return driver.ForEachNode(d.config, "creating", []string{s.Master.Name}, func(string) error {
+		return d.launch(s, s.Master, boot, d.masterInstanceType, record)
+	})
+}
+
+// Remove terminates the instances of the environment, then deletes its
+// network. They are found by their environment tag, and in the journal, which
+// records what an interrupted creation may have left untagged.
+func (d *Driver) Remove() error {
+	recorded := make(map[string][]string)
+	for _, r := range d.config.Journal.Resources() {
+		recorded[r.Kind] = append(recorded[r.Kind], r.ID)
+	}
+
+	instances, err := d.ec2.instances(d.tags())
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, i := range instances {
+		ids = append(ids, i.ID)
+	}
+	if instances, err = d.ec2.instancesOf(recorded["instance"]); err != nil {
+		return err
+	}
+	for _, i := range instances {
+		ids = append(ids, i.ID)
+	}
+	ids = unique(ids)
+	if len(ids) > 0 {
+		if err := d.ec2.call("TerminateInstances", instanceIDs(url.Values{}, ids), nil); err != nil {
+			return err
+		}
+		err := d.wait("instances to terminate", func() (bool, error) {
+			instances, err := d.ec2.instancesOf(ids)
+			return len(instances) == 0, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// terminated instances release their network interfaces with some
+	// delay, deleting what they used fails meanwhile
+	deleteAll := func(describe, filter, kind, del, param string) error {
+		ids, err := d.ec2.ids(describe, d.tags())
+		if err != nil {
+			return err
+		}
+		found, err := d.ec2.idsOf(describe, filter, recorded[kind])
+		if err != nil {
+			return err
+		}
+		for _, id := range unique(append(ids, found...)) {
+			err := d.wait(del+" "+id, func() (bool, error) {
+				err := d.ec2.call(del, url.Values{param: {id}}, nil)
+				if e, ok := err.(*APIError); ok && e.Code == "DependencyViolation" {
+					return false, nil
+				}
+				return true, err
+			})
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := deleteAll("DescribeSecurityGroups", "group-id", "security-group", "DeleteSecurityGroup", "GroupId"); err != nil {
+		return err
+	}
+	if err := deleteAll("DescribeSubnets", "subnet-id", "subnet", "DeleteSubnet", "SubnetId"); err != nil {
+		return err
+	}
+
+	gateways, err := d.ec2.internetGateways(filters(url.Values{}, d.tags()))
+	if err != nil {
+		return err
+	}
+	if ids := recorded["internet-gateway"]; len(ids) > 0 {
+		found, err := d.ec2.internetGateways(idFilter(url.Values{}, "internet-gateway-id", ids))
+		if err != nil {
+			return err
+		}
+		gateways = append(gateways, found...)
+	}
+	deleted := make(map[string]bool)
+	for _, gw := range gateways {
+		if deleted[gw.ID] {
+			continue
+		}
+		deleted[gw.ID] = true
+		for _, vpc := range gw.VPCs {
+			if err := d.ec2.call("DetachInternetGateway", url.Values{"InternetGatewayId": {gw.ID}, "VpcId": {vpc}}, nil); err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+		if err := d.ec2.call("DeleteInternetGateway", url.Values{"InternetGatewayId": {gw.ID}}, nil); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+
+	if err := deleteAll("DescribeVpcs", "vpc-id", "vpc", "DeleteVpc", "VpcId"); err != nil {
+		return err
+	}
+
+	if err := os.Remove(d.statePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Start starts the stopped instances, and records their new addresses.
+func (d *Driver) Start() error {
+	return d.transition("StartInstances", "running")
+}
+
+// Stop stops the instances.
+func (d *Driver) Stop() error {
+	return d.transition("StopInstances", "stopped")
+}
+
+// transition runs action on all the instances, and waits for them to reach
+// target.
+func (d *Driver) transition(action, target string) error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, m := range s.machines() {
+		ids = append(ids, m.InstanceID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := d.ec2.call(action, instanceIDs(url.Values{}, ids), nil); err != nil {
+		return err
+	}
+
+	err = d.wait("instances to be "+target, func() (bool, error) {
+		instances, err := d.ec2.instances(d.tags())
+		if err != nil {
+			return false, err
+		}
+		for _, i := range instances {
+			if i.State != target {
+				return false, nil
+			}
+		}
+		s.update(instances)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	return d.save(s)
+}
+
+// Status aggregates the state of the instances: the environment is Running or
+// Stopped when all of them are, in Error otherwise.
+func (d *Driver) Status() (driver.State, error) {
+	s, err := d.load()
+	if err != nil {
+		return driver.Error, err
+	}
+	if s.Master == nil {
+		return driver.None, nil
+	}
+	instances, err := d.ec2.instances(d.tags())
+	if err != nil {
+		return driver.Error, err
+	}
+
+	found := make(map[string]string)
+	for _, i := range instances {
+		found[i.ID] = i.State
+	}
+	states := make(map[driver.State]bool)
+	for _, m := range s.machines() {
+		states[instanceStates.Of(found[m.InstanceID])] = true
+	}
+	if len(states) == 1 {
+		for st := range states {
+			return st, nil
+		}
+	}
+	return driver.Error, nil
+}
+
+// GetURL returns the API server address, on the master public address.
+func (d *Driver) GetURL() (string, error) {
+	s, err := d.load()
+	if err != nil {
+		return "", err
+	}
+	if s.Master == nil || (s.Master.PublicIP == "" && s.Master.PrivateIP == "") {
+		return "", fmt.Errorf("aws: the master of environment %q has no address", d.name)
+	}
+	ip := s.Master.PublicIP
+	if ip == "" {
+		ip = s.Master.PrivateIP
+	}
+	return "https://" + net.JoinHostPort(ip, strconv.Itoa(apiServerPort)), nil
+}
+
+// GetKubeconfig returns an admin kubeconfig for the API server.
+func (d *Driver) GetKubeconfig() ([]byte, error) {
+	url, err := d.GetURL()
+	if err != nil {
+		return nil, err
+	}
+	return driver.AdminKubeconfig(d.config, url)
+}
+
+// Scale adds nodes to the first pool, or removes the most recent nodes.
+func (d *Driver) Scale(nodes int) error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	if nodes <= len(s.Nodes) {
+		for len(s.Nodes) > nodes {
+			if err := d.removeNode(s, len(s.Nodes)-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	p := d.pools[0]
+	p.Nodes = len(s.poolNodes(p.Name)) + nodes - len(s.Nodes)
+	return d.resize(s, p, func(kind, id string) {})
+}
+
+// ScalePool launches or terminates instances of a pool.
+func (d *Driver) ScalePool(pool driver.Pool) error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	return d.resize(s, pool, func(kind, id string) {})
+}
+
+// Nodes returns the node instances, the master not being a schedulable node.
+func (d *Driver) Nodes() ([]driver.Node, error) {
+	s, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	instances, err := d.ec2.instances(d.tags())
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]string)
+	for _, i := range instances {
+		found[i.ID] = i.State
+	}
+
+	nodes := make([]driver.Node, len(s.Nodes))
+	for i, n := range s.Nodes {
+		nodes[i] = driver.Node{Name: n.Name, Pool: n.pool(), Address: n.PrivateIP, State: instanceStates.Of(found[n.InstanceID])}
+	}
+	return nodes, nil
+}
+
+// resize launches or terminates instances of pool until it holds pool.Nodes.
+// The most recent instances are terminated first, new ones are launched
+// concurrently.
+func (d *Driver) resize(s *state, pool driver.Pool, record func(kind, id string)) error {
+	for excess := len(s.poolNodes(pool.Name)) - pool.Nodes; excess > 0; excess-- {
+		for i := len(s.Nodes) - 1; i >= 0; i-- {
+			if s.Nodes[i].pool() == pool.Name {
+				if err := d.removeNode(s, i); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, n := range s.Nodes {
+		used[n.Name] = true
+	}
+	for i := 1; len(s.poolNodes(pool.Name)) < pool.Nodes; i++ {
+		m := machine{Name: fmt.Sprintf("%s-node-%d", d.name, i)}
+		if pool.Name != defaultPool {
+			m.Name = fmt.Sprintf("%s-%s-node-%d", d.name, pool.Name, i)
+			m.Pool = pool.Name
+		}
+		if !used[m.Name] {
+			s.Nodes = append(s.Nodes, m)
+		}
+	}
+	if err := d.save(s); err != nil {
+		return err
+	}
+
+	// launch the instances of the pool without an address yet, those left
+	// over from an interrupted attempt included
+	var pending []string
+	for _, n := range s.Nodes {
+		if n.pool() == pool.Name && n.PrivateIP == "" {
+			pending = append(pending, n.Name)
+		}
+	}
+	instanceType := pool.MachineType
+	if instanceType == "" {
+		instanceType = d.instanceType
+	}
+	err := driver.ForEachNode(d.config, "creating", pending, func(name string) error {
+		m := s.node(name)
+		boot := driver.BootConfig{
+			Role:   driver.NodeRole,
+			Name:   m.Name,
+			Pool:   m.pool(),
+			Labels: pool.Labels,
+			Master: s.Master.PrivateIP,
+		}
+		return d.launch(s, m, boot, instanceType, record)
+	})
+	if saveErr := d.save(s); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// launch runs the instance of m, unless an instance tagged with its name
+// exists, and waits for it to be running.
+func (d *Driver) launch(s *state, m *machine, boot driver.BootConfig, instanceType string, record func(kind, id string)) error {
+	tags := d.tags("Name", m.Name, tagRole, boot.Role, tagPool, boot.Pool)
+	instances, err := d.ec2.instances(d.tags("Name", m.Name))
+	if err != nil {
+		return err
+	}
+
+	var id string
+	if len(instances) > 0 {
+		id = instances[0].ID
+	} else {
+		userData, err := d.config.CloudConfig(boot)
+		if err != nil {
+			return err
+		}
+		params := url.Values{
+			"ImageId":           {d.ami},
+			"InstanceType":      {instanceType},
+			"MinCount":          {"1"},
+			"MaxCount":          {"1"},
+			"SubnetId":          {s.Subnet},
+			"SecurityGroupId.1": {s.SecurityGroup},
+			"UserData":          {base64.StdEncoding.EncodeToString(userData)},
+		}
+		if d.keyName != "" {
+			params.Set("KeyName", d.keyName)
+		}
+		tagSpecification(params, "instance", tags)
+		var resp struct {
+			IDs []string `xml:"instancesSet>item>instanceId"`
+		}
+		if err := d.ec2.call("RunInstances", params, &resp); err != nil {
+			return err
+		}
+		if len(resp.IDs) == 0 {
+			return fmt.Errorf("aws: RunInstances returned no instance")
+		}
+		id = resp.IDs[0]
+	}
+	record("instance", id)
+	d.mu.Lock()
+	m.InstanceID = id
+	d.mu.Unlock()
+
+	var running instance
+	err = d.wait("instance "+id+" to run", func() (bool, error) {
+		instances, err := d.ec2.instances(d.tags("Name", m.Name))
+		if err != nil {
+			return false, err
+		}
+		for _, i := range instances {
+			if i.ID == id && i.State == "running" {
+				running = i
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	m.PrivateIP, m.PublicIP = running.PrivateIP, running.PublicIP
+	err = d.save(s)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// machines added to a created environment get their certificates
+	// right away, the others once they are issued
+	if d.config.HasCertificates(boot.Role) {
+		return d.copyCertificates(m, boot.Role)
+	}
+	return nil
+}
+
+// InstallCertificates copies the certificates of every instance to it, over
+// SSH, see driver.CopyCertificates.
+func (d *Driver) InstallCertificates() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	machines := make(map[string]*machine)
+	var names []string
+	for _, m := range s.machines() {
+		machines[m.Name] = m
+		names = append(names, m.Name)
+	}
+	return driver.ForEachNode(d.config, "installing certificates", names, func(name string) error {
+		role := driver.NodeRole
+		if machines[name] == s.Master {
+			role = driver.MasterRole
+		}
+		return d.copyCertificates(machines[name], role)
+	})
+}
+
+// copyCertificates copies the certificates of role to m, on its public
+// address, once it answers on SSH.
+func (d *Driver) copyCertificates(m *machine, role string) error {
+	host := m.PublicIP
+	if host == "" {
+		host = m.PrivateIP
+	}
+	if err := waitForSSH(net.JoinHostPort(host, "22"), d.timeout); err != nil {
+		return err
+	}
+	return d.config.CopyCertificates(host, role)
+}
+
+// removeNode terminates the instance of the ith node, and forgets it.
+func (d *Driver) removeNode(s *state, i int) error {
+	if id := s.Nodes[i].InstanceID; id != "" {
+		err := d.ec2.call("TerminateInstances", instanceIDs(url.Values{}, []string{id}), nil)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	s.Nodes = append(s.Nodes[:i], s.Nodes[i+1:]...)
+	return d.save(s)
+}
+
+// wait polls done until it returns true, for at most the aws-timeout option.
+func (d *Driver) wait(what string, done func() (bool, error)) error {
+	deadline := time.Now().Add(d.timeout)
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("aws: timed out after %s waiting for %s", d.timeout, what)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// tags returns the environment tag, and the further key and value pairs of
+// kv, the empty values left out.
+func (d *Driver) tags(kv ...string) map[string]string {
+	tags := map[string]string{tagEnvironment: d.name}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			tags[kv[i]] = kv[i+1]
+		}
+	}
+	return tags
+}
+
+// unique returns values without duplicates, in order.
+func unique(values []string) []string {
+	seen := make(map[string]bool)
+	var kept []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// instanceStates maps the state of an instance to a driver State.
+var instanceStates = driver.States{
+	"running":       driver.Running,
+	"stopped":       driver.Stopped,
+	"pending":       driver.Starting,
+	"stopping":      driver.Stopping,
+	"shutting-down": driver.Stopping,
+}
+
+func (m machine) pool() string {
+	if m.Pool == "" {
+		return defaultPool
+	}
+	return m.Pool
+}
+
+func (s *state) poolNodes(pool string) []machine {
+	var nodes []machine
+	for _, n := range s.Nodes {
+		if n.pool() == pool {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// node returns the node named name.
+func (s *state) node(name string) *machine {
+	for i := range s.Nodes {
+		if s.Nodes[i].Name == name {
+			return &s.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// machines returns pointers to the master and nodes of s.
+func (s *state) machines() []*machine {
+	var machines []*machine
+	if s.Master != nil {
+		machines = append(machines, s.Master)
+	}
+	for i := range s.Nodes {
+		machines = append(machines, &s.Nodes[i])
+	}
+	return machines
+}
+
+// update records the addresses of instances, which change when they are
+// stopped and started.
+func (s *state) update(instances []instance) {
+	byID := make(map[string]instance)
+	for _, i := range instances {
+		byID[i.ID] = i
+	}
+	for _, m := range s.machines() {
+		if i, ok := byID[m.InstanceID]; ok {
+			m.PrivateIP, m.PublicIP = i.PrivateIP, i.PublicIP
+		}
+	}
+}
+
+func (d *Driver) statePath() string {
+	return filepath.Join(d.storePath, stateFileName)
+}
+
+func (d *Driver) load() (*state, error) {
+	s := &state{}
+	data, err := ioutil.ReadFile(d.statePath())
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("aws: corrupt state: %v", err)
+	}
+	return s, nil
+}
+
+func (d *Driver) save(s *state) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.storePath, 0700); err != nil {
+		return err
+	}
+	return envstore.WriteFileAtomic(d.statePath(), data, 0600)
+}
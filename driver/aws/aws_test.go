@@ -0,0 +1,544 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/pki"
+)
+
+func TestMain(m *testing.M) {
+	pollInterval = time.Millisecond
+	waitForSSH = func(string, time.Duration) error { return nil }
+	os.Exit(m.Run())
+}
+
+// resource is something the fake EC2 API holds.
+type resource struct {
+	id    string
+	kind  string
+	tags  map[string]string
+	state string
+	// vpcs are the VPCs an internet gateway is attached to
+	vpcs []string
+}
+
+// fakeEC2 serves the EC2 Query API calls of the driver, checking they are
+// signed, from resources kept in memory.
+type fakeEC2 struct {
+	*httptest.Server
+	mu        sync.Mutex
+	resources map[string]*resource
+	order     []string
+	calls     []string
+	next      int
+}
+
+func newFakeEC2(t *testing.T) *fakeEC2 {
+	f := &fakeEC2{resources: make(map[string]*resource)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := checkSignature(r, body); err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		params, _ := url.ParseQuery(string(body))
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.calls = append(f.calls, params.Get("Action"))
+		out, code, err := f.serve(params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "<Response><Errors><Error><Code>%s</Code><Message>%v</Message></Error></Errors></Response>", code, err)
+			return
+		}
+		fmt.Fprintf(w, "<Response>%s</Response>", out)
+	}))
+	return f
+}
+
+// checkSignature signs r again, as of its X-Amz-Date, and compares it with the
+// Authorization it came with.
+func checkSignature(r *http.Request, body []byte) error {
+	now, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return fmt.Errorf("%s: X-Amz-Date: %v", r.URL, err)
+	}
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	signV4(req, body, "AKID", "SECRET", "us-east-1", "ec2", now)
+	if got, want := r.Header.Get("Authorization"), req.Header.Get("Authorization"); got != want {
+		return fmt.Errorf("got Authorization %s, want %s", got, want)
+	}
+	return nil
+}
+
+// add creates a resource of kind, and returns it.
+func (f *fakeEC2) add(kind string, tags map[string]string) *resource {
+	f.next++
+	prefix := map[string]string{"security-group": "sg", "internet-gateway": "igw", "instance": "i"}[kind]
+	if prefix == "" {
+		prefix = kind
+	}
+	r := &resource{id: fmt.Sprintf("%s-%d", prefix, f.next), kind: kind, tags: tags, state: "available"}
+	if kind == "instance" {
+		r.state = "running"
+	}
+	f.resources[r.id] = r
+	f.order = append(f.order, r.id)
+	return r
+}
+
+// Add is add for tests, which do not hold the lock.
+func (f *fakeEC2) Add(kind string, tags map[string]string) *resource {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.add(kind, tags)
+}
+
+// list returns the resources of kind, terminated instances included.
+func (f *fakeEC2) list(kind string) []*resource {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []*resource
+	for _, id := range f.order {
+		if r := f.resources[id]; r != nil && r.kind == kind {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func (f *fakeEC2) called(action string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if c == action {
+			n++
+		}
+	}
+	return n
+}
+
+// match returns the resources of kind matching the Filter.N parameters.
+func (f *fakeEC2) match(kind string, params url.Values) []*resource {
+	var found []*resource
+	for _, id := range f.order {
+		r := f.resources[id]
+		if r == nil || r.kind != kind {
+			continue
+		}
+		ok := true
+		for n := 1; params.Get("Filter."+strconv.Itoa(n)+".Name") != ""; n++ {
+			name := params.Get("Filter." + strconv.Itoa(n) + ".Name")
+			var values []string
+			for m := 1; params.Get("Filter."+strconv.Itoa(n)+".Value."+strconv.Itoa(m)) != ""; m++ {
+				values = append(values, params.Get("Filter."+strconv.Itoa(n)+".Value."+strconv.Itoa(m)))
+			}
+			var value string
+			switch {
+			case strings.HasPrefix(name, "tag:"):
+				value = r.tags[name[4:]]
+			case name == "instance-state-name":
+				value = r.state
+			case name == kind+"-id", name == "group-id" && kind == "security-group":
+				value = r.id
+			default:
+				continue
+			}
+			ok = ok && contains(values, value)
+		}
+		if ok {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// created returns a resource of kind, tagged as TagSpecification says.
+func (f *fakeEC2) created(kind string, params url.Values) (*resource, string, error) {
+	if got := params.Get("TagSpecification.1.ResourceType"); got != kind {
+		return nil, "InvalidParameterValue", fmt.Errorf("tagging %q resources, not %q", got, kind)
+	}
+	tags := make(map[string]string)
+	for n := 1; params.Get("TagSpecification.1.Tag."+strconv.Itoa(n)+".Key") != ""; n++ {
+		tags[params.Get("TagSpecification.1.Tag."+strconv.Itoa(n)+".Key")] = params.Get("TagSpecification.1.Tag." + strconv.Itoa(n) + ".Value")
+	}
+	return f.add(kind, tags), "", nil
+}
+
+// remove deletes the resource of kind identified by id.
+func (f *fakeEC2) remove(kind, id string) (string, string, error) {
+	r := f.resources[id]
+	if r == nil || r.kind != kind {
+		code := map[string]string{
+			"vpc":              "InvalidVpcID.NotFound",
+			"subnet":           "InvalidSubnetID.NotFound",
+			"security-group":   "InvalidGroup.NotFound",
+			"internet-gateway": "InvalidInternetGatewayID.NotFound",
+		}[kind]
+		return "", code, fmt.Errorf("%s does not exist", id)
+	}
+	delete(f.resources, id)
+	return "", "", nil
+}
+
+func (f *fakeEC2) serve(params url.Values) (string, string, error) {
+	items := func(kind, field string) string {
+		var out string
+		for _, r := range f.match(kind, params) {
+			out += "<item><" + field + ">" + r.id + "</" + field + "></item>"
+		}
+		return out
+	}
+	switch action := params.Get("Action"); action {
+	case "DescribeVpcs":
+		return "<vpcSet>" + items("vpc", "vpcId") + "</vpcSet>", "", nil
+	case "DescribeSubnets":
+		return "<subnetSet>" + items("subnet", "subnetId") + "</subnetSet>", "", nil
+	case "DescribeSecurityGroups":
+		return "<securityGroupInfo>" + items("security-group", "groupId") + "</securityGroupInfo>", "", nil
+	case "DescribeInternetGateways":
+		var out string
+		for _, r := range f.match("internet-gateway", params) {
+			out += "<item><internetGatewayId>" + r.id + "</internetGatewayId><attachmentSet>"
+			for _, vpc := range r.vpcs {
+				out += "<item><vpcId>" + vpc + "</vpcId></item>"
+			}
+			out += "</attachmentSet></item>"
+		}
+		return "<internetGatewaySet>" + out + "</internetGatewaySet>", "", nil
+	case "DescribeRouteTables":
+		return "<routeTableSet><item><routeTableId>rtb-1</routeTableId></item></routeTableSet>", "", nil
+	case "DescribeInstances":
+		var out string
+		for _, r := range f.match("instance", params) {
+			n := strings.TrimPrefix(r.id, "i-")
+			out += "<item><instanceId>" + r.id + "</instanceId><instanceState><name>" + r.state + "</name></instanceState>" +
+				"<privateIpAddress>10.0.0." + n + "</privateIpAddress><ipAddress>203.0.113." + n + "</ipAddress><tagSet>"
+			for k, v := range r.tags {
+				out += "<item><key>" + k + "</key><value>" + v + "</value></item>"
+			}
+			out += "</tagSet></item>"
+		}
+		return "<reservationSet><item><instancesSet>" + out + "</instancesSet></item></reservationSet>", "", nil
+
+	case "CreateVpc", "CreateSubnet", "CreateSecurityGroup", "CreateInternetGateway", "RunInstances":
+		kind := map[string]string{
+			"CreateVpc":             "vpc",
+			"CreateSubnet":          "subnet",
+			"CreateSecurityGroup":   "security-group",
+			"CreateInternetGateway": "internet-gateway",
+			"RunInstances":          "instance",
+		}[action]
+		r, code, err := f.created(kind, params)
+		if err != nil {
+			return "", code, err
+		}
+		switch kind {
+		case "vpc":
+			return "<vpc><vpcId>" + r.id + "</vpcId></vpc>", "", nil
+		case "subnet":
+			return "<subnet><subnetId>" + r.id + "</subnetId></subnet>", "", nil
+		case "security-group":
+			return "<groupId>" + r.id + "</groupId>", "", nil
+		case "internet-gateway":
+			return "<internetGateway><internetGatewayId>" + r.id + "</internetGatewayId></internetGateway>", "", nil
+		}
+		return "<instancesSet><item><instanceId>" + r.id + "</instanceId></item></instancesSet>", "", nil
+	case "CreateTags":
+		return "", "UnexpectedCall", fmt.Errorf("resources are tagged at creation")
+
+	case "AttachInternetGateway", "DetachInternetGateway":
+		r := f.resources[params.Get("InternetGatewayId")]
+		if r == nil {
+			return "", "InvalidInternetGatewayID.NotFound", fmt.Errorf("no gateway")
+		}
+		if action == "AttachInternetGateway" {
+			r.vpcs = append(r.vpcs, params.Get("VpcId"))
+		} else {
+			r.vpcs = nil
+		}
+	case "DeleteInternetGateway":
+		if r := f.resources[params.Get("InternetGatewayId")]; r != nil && len(r.vpcs) > 0 {
+			return "", "DependencyViolation", fmt.Errorf("%s is attached", r.id)
+		}
+		return f.remove("internet-gateway", params.Get("InternetGatewayId"))
+	case "DeleteVpc":
+		for _, r := range f.resources {
+			if r.kind == "internet-gateway" && contains(r.vpcs, params.Get("VpcId")) {
+				return "", "DependencyViolation", fmt.Errorf("%s has a gateway", params.Get("VpcId"))
+			}
+		}
+		return f.remove("vpc", params.Get("VpcId"))
+	case "DeleteSubnet":
+		return f.remove("subnet", params.Get("SubnetId"))
+	case "DeleteSecurityGroup":
+		return f.remove("security-group", params.Get("GroupId"))
+
+	case "TerminateInstances", "StopInstances", "StartInstances":
+		state := map[string]string{"TerminateInstances": "terminated", "StopInstances": "stopped", "StartInstances": "running"}[action]
+		for n := 1; params.Get("InstanceId."+strconv.Itoa(n)) != ""; n++ {
+			r := f.resources[params.Get("InstanceId."+strconv.Itoa(n))]
+			if r == nil || r.kind != "instance" {
+				return "", "InvalidInstanceID.NotFound", fmt.Errorf("no instance %s", params.Get("InstanceId."+strconv.Itoa(n)))
+			}
+			r.state = state
+		}
+	case "ModifyVpcAttribute", "ModifySubnetAttribute", "AuthorizeSecurityGroupIngress", "CreateRoute":
+	default:
+		return "", "InvalidAction", fmt.Errorf("unknown action %s", action)
+	}
+	return "", "", nil
+}
+
+// recorder is a driver.Runner recording the commands it is given.
+type recorder struct {
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (r *recorder) Run(name string, args ...string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, append([]string{name}, args...))
+	return "", nil
+}
+
+// copied returns the files copied with scp to each host.
+func (r *recorder) copied() map[string][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := make(map[string][]string)
+	for _, call := range r.calls {
+		if call[0] != "scp" {
+			continue
+		}
+		target := call[len(call)-1]
+		host := target[strings.Index(target, "@")+1 : strings.Index(target, ":")]
+		for _, arg := range call[1 : len(call)-1] {
+			if filepath.IsAbs(arg) && !strings.Contains(arg, "=") && !strings.HasSuffix(arg, "id_ecdsa") {
+				copied[host] = append(copied[host], filepath.Base(arg))
+			}
+		}
+		sort.Strings(copied[host])
+	}
+	return copied
+}
+
+func newTestDriver(t *testing.T, f *fakeEC2, nodes int) (*Driver, *recorder, func()) {
+	dir, err := ioutil.TempDir("", "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := driver.OpenJournal(filepath.Join(dir, "journal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &recorder{}
+	d, err := New(&driver.Config{
+		Name:      "dev",
+		StorePath: dir,
+		Nodes:     nodes,
+		Options: map[string]string{
+			optAccessKeyID:     "AKID",
+			optSecretAccessKey: "SECRET",
+			optAMI:             "ami-1",
+			optEndpoint:        f.URL + "/",
+		},
+		Journal: j,
+		PKI:     pki.New(filepath.Join(dir, "pki"), pki.Options{Algorithm: pki.ECDSA}),
+		Runner:  r,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.(*Driver), r, func() { os.RemoveAll(dir) }
+}
+
+func TestLifecycle(t *testing.T) {
+	f := newFakeEC2(t)
+	defer f.Close()
+	d, r, cleanup := newTestDriver(t, f, 2)
+	defer cleanup()
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{"vpc", "internet-gateway", "subnet", "security-group", "instance"} {
+		for _, res := range f.list(kind) {
+			if res.tags[tagEnvironment] != "dev" || res.tags["Name"] == "" {
+				t.Errorf("%s was created with tags %v", res.id, res.tags)
+			}
+		}
+	}
+	var names []string
+	for _, i := range f.list("instance") {
+		names = append(names, i.tags["Name"]+"/"+i.tags[tagRole])
+	}
+	if got := strings.Join(names, " "); got != "dev-master/master dev-node-1/node dev-node-2/node" {
+		t.Errorf("got instances %s", got)
+	}
+	if url, err := d.GetURL(); err != nil || url != "https://203.0.113.5:6443" {
+		t.Errorf("got URL %q, %v", url, err)
+	}
+	if st, err := d.Status(); err != nil || st != driver.Running {
+		t.Errorf("got status %v, %v", st, err)
+	}
+	if len(r.calls) != 0 {
+		t.Errorf("certificates copied before they were issued: %v", r.calls)
+	}
+
+	if err := d.config.PKI.Ensure("dev", pki.SANs{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InstallCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"203.0.113.5": "apiserver.crt apiserver.key ca.crt",
+		"203.0.113.6": "ca.crt node.crt node.key",
+		"203.0.113.7": "ca.crt node.crt node.key",
+	}
+	copied := r.copied()
+	for host, files := range want {
+		if got := strings.Join(copied[host], " "); got != files {
+			t.Errorf("copied %s to %s, want %s", got, host, files)
+		}
+	}
+
+	// nodes added once the certificates exist get them at once
+	if err := d.Scale(3); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(r.copied()["203.0.113.8"], " "); got != "ca.crt node.crt node.key" {
+		t.Errorf("copied %s to the added node", got)
+	}
+	if err := d.Scale(1); err != nil {
+		t.Fatal(err)
+	}
+	if nodes, err := d.Nodes(); err != nil || len(nodes) != 1 || nodes[0].Name != "dev-node-1" {
+		t.Errorf("got nodes %v, %v", nodes, err)
+	}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{"vpc", "internet-gateway", "subnet", "security-group"} {
+		if left := f.list(kind); len(left) != 0 {
+			t.Errorf("left %d %s", len(left), kind)
+		}
+	}
+	for _, i := range f.list("instance") {
+		if i.state != "terminated" {
+			t.Errorf("instance %s is %s", i.id, i.state)
+		}
+	}
+	if n := f.called("CreateTags"); n != 0 {
+		t.Errorf("CreateTags called %d times", n)
+	}
+}
+
+func TestRemoveJournaledResources(t *testing.T) {
+	f := newFakeEC2(t)
+	defer f.Close()
+	d, _, cleanup := newTestDriver(t, f, 1)
+	defer cleanup()
+
+	// resources whose tags were lost, and one of another environment
+	vpc := f.Add("vpc", nil)
+	gw := f.Add("internet-gateway", nil)
+	gw.vpcs = []string{vpc.id}
+	subnet := f.Add("subnet", nil)
+	group := f.Add("security-group", nil)
+	instance := f.Add("instance", nil)
+	other := f.Add("vpc", map[string]string{tagEnvironment: "prod"})
+	err := d.config.Journal.Run("create", func(record func(kind, id string)) error {
+		for _, r := range []*resource{vpc, gw, subnet, group, instance} {
+			record(r.kind, r.id)
+		}
+		return fmt.Errorf("interrupted")
+	})
+	if err == nil {
+		t.Fatal("the step did not fail")
+	}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if left := f.list("vpc"); len(left) != 1 || left[0] != other {
+		t.Errorf("left VPCs %v", left)
+	}
+	for _, kind := range []string{"internet-gateway", "subnet", "security-group"} {
+		if left := f.list(kind); len(left) != 0 {
+			t.Errorf("left %d %s", len(left), kind)
+		}
+	}
+	if instance.state != "terminated" {
+		t.Errorf("instance is %s", instance.state)
+	}
+
+	// deleting again finds nothing left
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	f := newFakeEC2(t)
+	defer f.Close()
+	d, _, cleanup := newTestDriver(t, f, 1)
+	defer cleanup()
+
+	err := d.ec2.call("DeleteVpc", url.Values{"VpcId": {"vpc-42"}}, nil)
+	if e, ok := err.(*APIError); !ok || e.Action != "DeleteVpc" || e.Code != "InvalidVpcID.NotFound" {
+		t.Fatalf("got %#v", err)
+	}
+	if !isNotFound(err) {
+		t.Error("not found error not recognized")
+	}
+	if isNotFound(d.ec2.call("Reboot", url.Values{}, nil)) {
+		t.Error("unknown action taken for a missing resource")
+	}
+}
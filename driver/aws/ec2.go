@@ -0,0 +1,266 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// apiVersion is the EC2 Query API version requests are made with.
+const apiVersion = "2016-11-15"
+
+// client calls the EC2 Query API.
+type client struct {
+	endpoint  string
+	region    string
+	accessKey string
+	secretKey string
+	http      *http.Client
+}
+
+// APIError is an error returned by the EC2 API.
+type APIError struct {
+	Action  string
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("aws: %s: %s: %s", e.Action, e.Code, e.Message)
+}
+
+// isNotFound reports whether err is the EC2 API failing on a missing resource,
+// e.g. InvalidVpcID.NotFound.
+func isNotFound(err error) bool {
+	e, ok := err.(*APIError)
+	return ok && len(e.Code) > 9 && e.Code[len(e.Code)-9:] == ".NotFound"
+}
+
+// call runs action with params, and decodes the response into out, unless it
+// is nil.
+func (c *client) call(action string, params url.Values, out interface{}) error {
+	form := url.Values{}
+	for k, v := range params {
+		form[k] = v
+	}
+	form.Set("Action", action)
+	form.Set("Version", apiVersion)
+	body := []byte(form.Encode())
+
+	req, err := http.NewRequest("POST", c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, body, c.accessKey, c.secretKey, c.region, "ec2", time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("aws: %s: %v", action, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("aws: %s: %v", action, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Errors []struct {
+				Code    string
+				Message string
+			} `xml:"Errors>Error"`
+		}
+		if xml.Unmarshal(data, &e) == nil && len(e.Errors) > 0 {
+			return &APIError{Action: action, Code: e.Errors[0].Code, Message: e.Errors[0].Message}
+		}
+		return &APIError{Action: action, Code: resp.Status, Message: string(bytes.TrimSpace(data))}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("aws: %s: invalid response: %v", action, err)
+	}
+	return nil
+}
+
+// tag is a resource tag.
+type tag struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+// instance is an EC2 instance, as described by DescribeInstances.
+type instance struct {
+	ID        string `xml:"instanceId"`
+	State     string `xml:"instanceState>name"`
+	PrivateIP string `xml:"privateIpAddress"`
+	PublicIP  string `xml:"ipAddress"`
+	Tags      []tag  `xml:"tagSet>item"`
+}
+
+func (i *instance) tag(key string) string {
+	for _, t := range i.Tags {
+		if t.Key == key {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+// filters adds the Filter.N parameters matching the tags in tags to params.
+func filters(params url.Values, tags map[string]string) url.Values {
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		n := strconv.Itoa(i + 1)
+		params.Set("Filter."+n+".Name", "tag:"+k)
+		params.Set("Filter."+n+".Value.1", tags[k])
+	}
+	return params
+}
+
+// tagSpecification adds the TagSpecification parameters applying tags to the
+// launched resource type to params.
+func tagSpecification(params url.Values, resourceType string, tags map[string]string) {
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params.Set("TagSpecification.1.ResourceType", resourceType)
+	for i, k := range keys {
+		n := strconv.Itoa(i + 1)
+		params.Set("TagSpecification.1.Tag."+n+".Key", k)
+		params.Set("TagSpecification.1.Tag."+n+".Value", tags[k])
+	}
+}
+
+// idFilter adds a filter matching the resources identified by ids to params,
+// after the filters already set.
+func idFilter(params url.Values, name string, ids []string) url.Values {
+	n := 1
+	for params.Get("Filter."+strconv.Itoa(n)+".Name") != "" {
+		n++
+	}
+	params.Set("Filter."+strconv.Itoa(n)+".Name", name)
+	for i, id := range ids {
+		params.Set("Filter."+strconv.Itoa(n)+".Value."+strconv.Itoa(i+1), id)
+	}
+	return params
+}
+
+// instances returns the instances bearing tags, terminated ones excepted.
+func (c *client) instances(tags map[string]string) ([]instance, error) {
+	return c.describeInstances(filters(url.Values{}, tags))
+}
+
+// instancesOf returns the instances among ids, terminated ones excepted.
+func (c *client) instancesOf(ids []string) ([]instance, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return c.describeInstances(idFilter(url.Values{}, "instance-id", ids))
+}
+
+// describeInstances returns the instances matching the filters of params, and
+// not terminated.
+func (c *client) describeInstances(params url.Values) ([]instance, error) {
+	idFilter(params, "instance-state-name", []string{"pending", "running", "stopping", "stopped"})
+
+	var resp struct {
+		Reservations []struct {
+			Instances []instance `xml:"instancesSet>item"`
+		} `xml:"reservationSet>item"`
+	}
+	if err := c.call("DescribeInstances", params, &resp); err != nil {
+		return nil, err
+	}
+	var instances []instance
+	for _, r := range resp.Reservations {
+		instances = append(instances, r.Instances...)
+	}
+	return instances, nil
+}
+
+// instanceIDs adds the InstanceId.N parameters to params.
+func instanceIDs(params url.Values, ids []string) url.Values {
+	for i, id := range ids {
+		params.Set("InstanceId."+strconv.Itoa(i+1), id)
+	}
+	return params
+}
+
+// resourceIDs decodes the responses of DescribeVpcs, DescribeSubnets and
+// DescribeSecurityGroups, each filling a single field.
+type resourceIDs struct {
+	VPCs           []string `xml:"vpcSet>item>vpcId"`
+	Subnets        []string `xml:"subnetSet>item>subnetId"`
+	SecurityGroups []string `xml:"securityGroupInfo>item>groupId"`
+}
+
+// ids returns the identifiers of the resources bearing tags listed by the
+// Describe action.
+func (c *client) ids(action string, tags map[string]string) ([]string, error) {
+	return c.describeIDs(action, filters(url.Values{}, tags))
+}
+
+// idsOf returns those of ids still listed by the Describe action, filter being
+// its identifier filter, e.g. vpc-id.
+func (c *client) idsOf(action, filter string, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return c.describeIDs(action, idFilter(url.Values{}, filter, ids))
+}
+
+func (c *client) describeIDs(action string, params url.Values) ([]string, error) {
+	var resp resourceIDs
+	if err := c.call(action, params, &resp); err != nil {
+		return nil, err
+	}
+	return append(append(resp.VPCs, resp.Subnets...), resp.SecurityGroups...), nil
+}
+
+// internetGateway is an internet gateway, as described by
+// DescribeInternetGateways.
+type internetGateway struct {
+	ID   string   `xml:"internetGatewayId"`
+	VPCs []string `xml:"attachmentSet>item>vpcId"`
+}
+
+// internetGateways returns the internet gateways matching the filters of
+// params.
+func (c *client) internetGateways(params url.Values) ([]internetGateway, error) {
+	var resp struct {
+		Gateways []internetGateway `xml:"internetGatewaySet>item"`
+	}
+	if err := c.call("DescribeInternetGateways", params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Gateways, nil
+}
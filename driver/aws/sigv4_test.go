@@ -0,0 +1,136 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The requests and signatures come from the AWS signature version 4 test
+// suite, see
+// http://docs.aws.amazon.com/general/latest/gr/signature-v4-test-suite.html
+func TestSignV4(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name        string
+		method, url string
+		contentType string
+		body        string
+		signed      string
+		signature   string
+	}{
+		{
+			name:      "get-vanilla",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/",
+			signed:    "host;x-amz-date",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:      "post-vanilla",
+			method:    "POST",
+			url:       "https://example.amazonaws.com/",
+			signed:    "host;x-amz-date",
+			signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:      "get-vanilla-empty-query-key",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/?Param1=value1",
+			signed:    "host;x-amz-date",
+			signature: "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb",
+		},
+		{
+			name:      "get-vanilla-query-order-key-case",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signed:    "host;x-amz-date",
+			signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:      "get-vanilla-query-order-value",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/?Param1=value2&Param1=Value1",
+			signed:    "host;x-amz-date",
+			signature: "eedbc4e291e521cf13422ffca22be7d2eb8146eecf653089df300a15b2382bd1",
+		},
+		{
+			name:      "get-vanilla-query-unreserved",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			signed:    "host;x-amz-date",
+			signature: "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197",
+		},
+		{
+			name:        "post-x-www-form-urlencoded",
+			method:      "POST",
+			url:         "https://example.amazonaws.com/",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "Param1=value1",
+			signed:      "content-type;host;x-amz-date",
+			signature:   "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			name:        "post-x-www-form-urlencoded-parameters",
+			method:      "POST",
+			url:         "https://example.amazonaws.com/",
+			contentType: "application/x-www-form-urlencoded; charset=utf8",
+			body:        "Param1=value1",
+			signed:      "content-type;host;x-amz-date",
+			signature:   "1a72ec8f64bd914b0e42e42607c7fbce7fb2c7465f63e3092b3b0d39fa77a6fe",
+		},
+	} {
+		req, err := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		signV4(req, []byte(test.body), "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+			"SignedHeaders=" + test.signed + ", Signature=" + test.signature
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s: Authorization is\n%s\nwant\n%s", test.name, got, want)
+		}
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("%s: X-Amz-Date is %s", test.name, got)
+		}
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	for _, test := range []struct {
+		raw, want string
+	}{
+		{"", ""},
+		{"b=2&a=1", "a=1&b=2"},
+		{"a=x y&a=%2F", "a=%2F&a=x%20y"},
+		{"Param=2&Param-3=3", "Param=2&Param-3=3"},
+		{"k=%E1%88%B4", "k=%E1%88%B4"},
+	} {
+		req, err := http.NewRequest("GET", "https://example.amazonaws.com/?"+test.raw, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := canonicalQuery(req.URL.Query()); got != test.want {
+			t.Errorf("canonicalQuery(%q) = %q, want %q", test.raw, got, test.want)
+		}
+	}
+}
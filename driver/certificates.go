@@ -0,0 +1,163 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/pki"
+)
+
+const (
+	// SSHUser is the user CloudConfig creates on the machines, allowed to
+	// sudo, for drivers to deliver the certificates once they boot.
+	SSHUser = "kube-cluster"
+
+	sshKeyFileName     = "id_ecdsa"
+	knownHostsFileName = "ssh-known-hosts"
+	pkiDir             = "/etc/kube-cluster/pki"
+)
+
+// RoleCertificates lists the certificates the machines of each role get, with
+// their keys, besides the one of the certificate authority. The keys of the
+// certificate authority and of the admin never leave the host.
+var RoleCertificates = map[string][]string{
+	MasterRole: {pki.APIServer},
+	NodeRole:   {pki.Node},
+}
+
+// SSHPublicKey returns the public key the environment reaches its machines
+// with, in the authorized_keys format. The key pair is generated below
+// StorePath on first use.
+func (c *Config) SSHPublicKey() (string, error) {
+	path := filepath.Join(c.StorePath, sshKeyFileName)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return "", err
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err := os.MkdirAll(c.StorePath, 0700); err != nil {
+			return "", err
+		}
+		if err := envstore.WriteFileAtomic(path, data, 0600); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", fmt.Errorf("%s: no PEM data", path)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	// RFC 5656 section 3.1
+	var blob []byte
+	for _, field := range [][]byte{
+		[]byte("ecdsa-sha2-nistp256"),
+		[]byte("nistp256"),
+		elliptic.Marshal(elliptic.P256(), key.X, key.Y),
+	} {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(field)))
+		blob = append(append(blob, size[:]...), field...)
+	}
+	return fmt.Sprintf("ecdsa-sha2-nistp256 %s kube-cluster-%s", base64.StdEncoding.EncodeToString(blob), c.Name), nil
+}
+
+// CopyCertificates copies the certificate authority and the certificates of
+// role to /etc/kube-cluster/pki on the machine at host, a machine booted from
+// CloudConfig. It logs in as SSHUser, with the key of SSHPublicKey, recording
+// the host key below StorePath when first seen. Files are replaced at once, so
+// the machine may watch them.
+func (c *Config) CopyCertificates(host, role string) error {
+	if c.PKI == nil {
+		return fmt.Errorf("environment %q has no certificates", c.Name)
+	}
+	files := []string{c.PKI.CertPath(pki.CA)}
+	for _, name := range RoleCertificates[role] {
+		files = append(files, c.PKI.CertPath(name), c.PKI.KeyPath(name))
+	}
+
+	opts := []string{
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout=10",
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", "UserKnownHostsFile=" + filepath.Join(c.StorePath, knownHostsFileName),
+		"-i", filepath.Join(c.StorePath, sshKeyFileName),
+	}
+	target := SSHUser + "@" + host
+	stage := "kube-cluster-pki"
+	if _, err := c.Run("ssh", append(opts, target, "rm -rf "+stage+" && mkdir -m 0700 "+stage)...); err != nil {
+		return err
+	}
+	scpTarget := target
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		scpTarget = SSHUser + "@[" + host + "]"
+	}
+	if _, err := c.Run("scp", append(append(opts, files...), scpTarget+":"+stage+"/")...); err != nil {
+		return err
+	}
+
+	script := []string{"set -e", "mkdir -p -m 0755 " + pkiDir}
+	for _, f := range files {
+		name := filepath.Base(f)
+		mode := "0644"
+		if strings.HasSuffix(name, ".key") {
+			mode = "0600"
+		}
+		script = append(script, fmt.Sprintf("install -m %s %s/%s %s/.%s && mv %s/.%s %s/%s",
+			mode, stage, name, pkiDir, name, pkiDir, name, pkiDir, name))
+	}
+	script = append(script, "rm -rf "+stage)
+	_, err := c.Run("ssh", append(opts, target, "sudo -n sh -c "+ShellQuote(strings.Join(script, "\n")))...)
+	return err
+}
+
+// HasCertificates reports whether the certificates of role were issued, for
+// drivers adding machines to a created environment to copy them right away.
+func (c *Config) HasCertificates(role string) bool {
+	if c.PKI == nil {
+		return false
+	}
+	for _, name := range RoleCertificates[role] {
+		if _, err := os.Stat(c.PKI.CertPath(name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
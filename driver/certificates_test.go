@@ -0,0 +1,191 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gerred/kube-cluster/pki"
+)
+
+// commandRecorder is a Runner recording the commands it is given.
+type commandRecorder struct {
+	calls [][]string
+}
+
+func (r *commandRecorder) Run(name string, args ...string) (string, error) {
+	r.calls = append(r.calls, append([]string{name}, args...))
+	return "", nil
+}
+
+func newTestConfig(t *testing.T) (*Config, func()) {
+	dir, err := ioutil.TempDir("", "driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Config{
+		Name:      "dev",
+		StorePath: dir,
+		PKI:       pki.New(filepath.Join(dir, "pki"), pki.Options{Algorithm: pki.ECDSA}),
+	}, func() { os.RemoveAll(dir) }
+}
+
+func TestSSHPublicKey(t *testing.T) {
+	c, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	key, err := c.SSHPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(key)
+	if len(fields) != 3 || fields[0] != "ecdsa-sha2-nistp256" || fields[2] != "kube-cluster-dev" {
+		t.Fatalf("got key %q", key)
+	}
+	if _, err := base64.StdEncoding.DecodeString(fields[1]); err != nil {
+		t.Errorf("key blob: %v", err)
+	}
+	if again, err := c.SSHPublicKey(); err != nil || again != key {
+		t.Errorf("got another key %q, %v", again, err)
+	}
+	path := filepath.Join(c.StorePath, sshKeyFileName)
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("private key: %v, %v", fi, err)
+	}
+
+	// OpenSSH derives the same public key from the private one
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("no ssh-keygen")
+	}
+	out, err := exec.Command("ssh-keygen", "-y", "-f", path).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(out)); len(got) < 2 || got[1] != fields[1] {
+		t.Errorf("ssh-keygen derives %q", out)
+	}
+}
+
+func TestCopyCertificates(t *testing.T) {
+	c, cleanup := newTestConfig(t)
+	defer cleanup()
+	r := &commandRecorder{}
+	c.Runner = r
+
+	if c.HasCertificates(NodeRole) {
+		t.Error("certificates found before they were issued")
+	}
+	if err := c.PKI.Ensure("dev", pki.SANs{}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.HasCertificates(NodeRole) || !c.HasCertificates(MasterRole) {
+		t.Error("issued certificates not found")
+	}
+
+	for _, test := range []struct {
+		host, role string
+		target     string
+		files      string
+	}{
+		{"10.0.0.2", NodeRole, "kube-cluster@10.0.0.2:kube-cluster-pki/", "ca.crt node.crt node.key"},
+		{"10.0.0.1", MasterRole, "kube-cluster@10.0.0.1:kube-cluster-pki/", "ca.crt apiserver.crt apiserver.key"},
+		{"fd00::1", NodeRole, "kube-cluster@[fd00::1]:kube-cluster-pki/", "ca.crt node.crt node.key"},
+	} {
+		r.calls = nil
+		if err := c.CopyCertificates(test.host, test.role); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.calls) != 3 || r.calls[0][0] != "ssh" || r.calls[1][0] != "scp" || r.calls[2][0] != "ssh" {
+			t.Fatalf("got calls %q", r.calls)
+		}
+		scp := r.calls[1]
+		if got := scp[len(scp)-1]; got != test.target {
+			t.Errorf("copied to %s, want %s", got, test.target)
+		}
+		var files []string
+		dir := filepath.Dir(c.PKI.CertPath(pki.CA))
+		for _, arg := range scp[1 : len(scp)-1] {
+			if filepath.Dir(arg) == dir {
+				files = append(files, filepath.Base(arg))
+			}
+		}
+		if got := strings.Join(files, " "); got != test.files {
+			t.Errorf("%s: copied %s, want %s", test.role, got, test.files)
+		}
+
+		script := r.calls[2][len(r.calls[2])-1]
+		if !strings.HasPrefix(script, "sudo -n sh -c ") {
+			t.Errorf("script run as %q", script)
+		}
+		for _, name := range strings.Fields(test.files) {
+			if !strings.Contains(script, "/etc/kube-cluster/pki/"+name) {
+				t.Errorf("%s not installed by %q", name, script)
+			}
+		}
+		for _, secret := range []string{"ca.key", "admin.key"} {
+			if strings.Contains(strings.Join(scp, " ")+script, secret) {
+				t.Errorf("%s copied to a %s", secret, test.role)
+			}
+		}
+	}
+}
+
+func TestCloudConfig(t *testing.T) {
+	c, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	data, err := c.CloudConfig(BootConfig{Role: NodeRole, Name: "dev-node-1", Master: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := c.SSHPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ioutil.ReadFile(c.PKI.CertPath(pki.CA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		"#cloud-config\n",
+		"- name: kube-cluster\n  sudo: ALL=(ALL) NOPASSWD:ALL\n",
+		"  - \"" + key + "\"\n",
+		"- path: /etc/kube-cluster/environment\n",
+		"- path: /etc/kube-cluster/pki/ca.crt\n",
+		base64.StdEncoding.EncodeToString(ca),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("user data lacks %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "- path: "); n != 2 {
+		t.Errorf("user data writes %d files", n)
+	}
+	if strings.Contains(out, ".key") {
+		t.Errorf("user data holds a key:\n%s", out)
+	}
+
+	c.PKI = nil
+	if _, err := c.CloudConfig(BootConfig{Role: NodeRole}); err == nil {
+		t.Error("user data without certificates")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/gerred/kube-cluster/pki"
+)
+
+// Machine roles.
+const (
+	MasterRole = "master"
+	NodeRole   = "node"
+)
+
+// BootConfig describes a machine to the image it boots, see CloudConfig.
+type BootConfig struct {
+	// Role is MasterRole or NodeRole.
+	Role   string
+	Name   string
+	Pool   string
+	Labels map[string]string
+	// Master is the address of the master, for nodes.
+	Master string
+	// SSHAuthorizedKeys are granted access to the default user.
+	SSHAuthorizedKeys []string
+}
+
+// BootEnvironment returns the settings of a machine as shell variables:
+//
+//	KUBE_CLUSTER_ENVIRONMENT, KUBE_CLUSTER_ROLE, KUBE_CLUSTER_NAME,
+//	KUBE_CLUSTER_POOL, KUBE_CLUSTER_LABELS (k1=v1,k2=v2), KUBE_CLUSTER_MASTER,
+//	KUBE_CLUSTER_KUBERNETES_VERSION, KUBE_CLUSTER_POD_CIDR,
+//	KUBE_CLUSTER_SERVICE_CIDR, KUBE_CLUSTER_DNS_DOMAIN
+//
+// Machines find them in /etc/kube-cluster/environment.
+func (c *Config) BootEnvironment(b BootConfig) []byte {
+	var labels []string
+	for k, v := range b.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	network := c.Networking.WithDefaults()
+
+	var env bytes.Buffer
+	for _, v := range [][2]string{
+		{"KUBE_CLUSTER_ENVIRONMENT", c.Name},
+		{"KUBE_CLUSTER_ROLE", b.Role},
+		{"KUBE_CLUSTER_NAME", b.Name},
+		{"KUBE_CLUSTER_POOL", b.Pool},
+		{"KUBE_CLUSTER_LABELS", strings.Join(labels, ",")},
+		{"KUBE_CLUSTER_MASTER", b.Master},
+		{"KUBE_CLUSTER_KUBERNETES_VERSION", c.KubernetesVersion},
+		{"KUBE_CLUSTER_POD_CIDR", network.PodCIDR},
+		{"KUBE_CLUSTER_SERVICE_CIDR", network.ServiceCIDR},
+		{"KUBE_CLUSTER_DNS_DOMAIN", network.DNSDomain},
+	} {
+		fmt.Fprintf(&env, "%s=%s\n", v[0], ShellQuote(v[1]))
+	}
+	return env.Bytes()
+}
+
+// CloudConfig returns the cloud-init user data configuring a machine of the
+// environment at first boot. It writes the BootEnvironment of the machine to
+// /etc/kube-cluster/environment, and the certificate of the authority of
+// c.PKI, generated if needed, to /etc/kube-cluster/pki. It creates SSHUser as
+// well, for the driver to copy the other certificates of the machine once
+// they are issued, see CopyCertificates. Machines are expected to wait for
+// them, and to pick up their changes.
+func (c *Config) CloudConfig(b BootConfig) ([]byte, error) {
+	if c.PKI == nil {
+		return nil, fmt.Errorf("environment %q has no certificates", c.Name)
+	}
+	if err := c.PKI.EnsureCA(c.Name); err != nil {
+		return nil, err
+	}
+	key, err := c.SSHPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(c.PKI.CertPath(pki.CA))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("#cloud-config\n")
+	if len(b.SSHAuthorizedKeys) > 0 {
+		out.WriteString("ssh_authorized_keys:\n")
+		for _, key := range b.SSHAuthorizedKeys {
+			fmt.Fprintf(&out, "- %q\n", strings.TrimSpace(key))
+		}
+	}
+	fmt.Fprintf(&out, "users:\n- default\n- name: %s\n  sudo: ALL=(ALL) NOPASSWD:ALL\n  ssh_authorized_keys:\n  - %q\n", SSHUser, key)
+	out.WriteString("write_files:\n")
+	writeFile(&out, "/etc/kube-cluster/environment", "0644", c.BootEnvironment(b))
+	writeFile(&out, pkiDir+"/ca.crt", "0644", ca)
+	return out.Bytes(), nil
+}
+
+func writeFile(out *bytes.Buffer, path, perm string, data []byte) {
+	fmt.Fprintf(out, "- path: %s\n  permissions: '%s'\n  encoding: b64\n  content: %s\n",
+		path, perm, base64.StdEncoding.EncodeToString(data))
+}
+
+// ShellQuote quotes s for POSIX shells.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
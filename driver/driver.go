@@ -0,0 +1,301 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package driver defines the contract between kube-cluster and the backends
+// provisioning kubernetes environments, and keeps the registry of available
+// backends.
+//
+// Backends register themselves from an init function, as database/sql drivers
+// do, so adding a new one does not require any change to kube-cluster itself:
+//
+//	func init() {
+//		driver.Register("mydriver", New, options...)
+//	}
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gerred/kube-cluster/pki"
+)
+
+// State is the condition of an environment as reported by its driver.
+type State string
+
+const (
+	Running  State = "Running"
+	Stopped  State = "Stopped"
+	Starting State = "Starting"
+	Stopping State = "Stopping"
+	Error    State = "Error"
+	None     State = "None"
+)
+
+// Driver provisions and manages the machines backing a kubernetes environment.
+type Driver interface {
+	// Create provisions the environment described by the driver Config.
+	Create() error
+	// Remove tears down every resource created for the environment.
+	Remove() error
+	// Start boots a stopped environment.
+	Start() error
+	// Stop halts the environment, keeping its resources.
+	Stop() error
+	// Status reports the current state of the environment.
+	Status() (State, error)
+	// GetURL returns the address of the environment API server.
+	GetURL() (string, error)
+	// GetKubeconfig returns a kubeconfig file granting access to the
+	// environment.
+	GetKubeconfig() ([]byte, error)
+	// Scale changes the number of nodes to the given count.
+	Scale(nodes int) error
+}
+
+// Node is a machine of an environment. Its name is the one of the matching
+// kubernetes node.
+type Node struct {
+	Name    string
+	Pool    string
+	Address string
+	State   State
+}
+
+// Pool is a group of identical nodes.
+type Pool struct {
+	Name        string
+	Nodes       int
+	MachineType string
+	Labels      map[string]string
+}
+
+// NodeLister is implemented by drivers able to list the nodes of an
+// environment.
+type NodeLister interface {
+	// Nodes returns the nodes in creation order. Scaling down removes the
+	// most recently created nodes first.
+	Nodes() ([]Node, error)
+}
+
+// PoolScaler is implemented by drivers scaling node pools separately.
+type PoolScaler interface {
+	// ScalePool changes the number of nodes of a pool, creating the pool if
+	// needed. A pool scaled to zero nodes is removed.
+	ScalePool(pool Pool) error
+}
+
+// Upgrader is implemented by drivers able to change the kubernetes release of
+// a running environment.
+type Upgrader interface {
+	Upgrade(version string) error
+}
+
+// AddonManager is implemented by drivers able to install and remove cluster
+// addons, such as DNS or the dashboard.
+type AddonManager interface {
+	// InstallAddon installs, or replaces, the given version of an addon.
+	InstallAddon(name, version string) error
+	// RemoveAddon uninstalls an addon.
+	RemoveAddon(name string) error
+}
+
+// AddonLister is implemented by drivers able to tell which addons are
+// installed.
+type AddonLister interface {
+	// Addons returns the versions of the installed addons, keyed by name.
+	Addons() (map[string]string, error)
+}
+
+// CertificateInstaller is implemented by drivers that must distribute the
+// certificates of Config.PKI to the machines once they change.
+type CertificateInstaller interface {
+	InstallCertificates() error
+}
+
+// Config holds the settings a driver is instantiated with.
+type Config struct {
+	// Name is the environment name.
+	Name string
+	// StorePath is a directory owned by the environment where drivers may
+	// persist their state.
+	StorePath string
+	// Nodes is the requested number of nodes.
+	Nodes int
+	// Pools lists the requested node pools, holding Nodes nodes in total.
+	Pools []Pool
+	// Options holds the driver specific settings, keyed by Option name.
+	Options map[string]string
+	// KubernetesVersion is the kubernetes release to deploy, the driver
+	// default if empty.
+	KubernetesVersion string
+	// Networking holds the cluster network settings, the empty ones
+	// meaning their default.
+	Networking Networking
+
+	// Parallelism bounds the number of nodes provisioned at once, see
+	// ForEachNode.
+	Parallelism int
+	// Progress, if set, receives the progress of node operations.
+	Progress Progress
+	// Journal, if set, records the steps of the running operation. Drivers
+	// run their provisioning steps through it, so interrupted creations
+	// resume, and Remove may use the resources it recorded.
+	Journal *Journal
+	// PKI holds the certificate authority, API server, admin and node
+	// certificates of the environment. They are generated once Create
+	// returns, for the addresses of the created nodes, then handed to
+	// drivers implementing CertificateInstaller. Drivers may generate the
+	// certificate authority earlier, see CloudConfig.
+	PKI *pki.PKI
+	// Runner runs the command line tools the driver relies on, an
+	// ExecRunner if nil.
+	Runner Runner
+}
+
+// Option returns the named driver specific setting, or def if unset.
+func (c *Config) Option(name, def string) string {
+	if v, ok := c.Options[name]; ok {
+		return v
+	}
+	return def
+}
+
+// OptionType tells how the value of an Option is interpreted.
+type OptionType int
+
+const (
+	StringOption OptionType = iota
+	IntOption
+	BoolOption
+)
+
+// Option describes a driver specific setting. Options are exposed as flags of
+// "create env", so their names should be prefixed with the driver name, e.g.
+// "aws-region".
+type Option struct {
+	Name    string
+	Usage   string
+	Default string
+	Type    OptionType
+
+	// Prompt is the question asked for the option by "create env
+	// --interactive". Options without a prompt are not asked for.
+	Prompt string
+	// Secret options are read without echo, and hidden when displayed.
+	Secret bool
+	// Required options must not be empty.
+	Required bool
+	// Validate optionally checks the option value, once its type is known
+	// to be right.
+	Validate func(value string) error
+}
+
+// Check verifies that value is acceptable for the option.
+func (o Option) Check(value string) error {
+	if value == "" {
+		if o.Required {
+			return fmt.Errorf("%s is required", o.Name)
+		}
+		return nil
+	}
+
+	switch o.Type {
+	case IntOption:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s must be an integer, got %q", o.Name, value)
+		}
+	case BoolOption:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be true or false, got %q", o.Name, value)
+		}
+	}
+
+	if o.Validate != nil {
+		return o.Validate(value)
+	}
+	return nil
+}
+
+// Factory instantiates a driver for the given configuration.
+type Factory func(c *Config) (Driver, error)
+
+type registration struct {
+	factory Factory
+	options []Option
+}
+
+var (
+	mu      sync.RWMutex
+	drivers = make(map[string]registration)
+)
+
+// Register makes a driver available under name. It panics if called twice with
+// the same name, or if factory is nil.
+func Register(name string, factory Factory, options ...Option) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if factory == nil {
+		panic("driver: Register factory is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("driver: Register called twice for driver " + name)
+	}
+	drivers[name] = registration{factory: factory, options: options}
+}
+
+// New instantiates the named driver.
+func New(name string, c *Config) (Driver, error) {
+	mu.RLock()
+	r, ok := drivers[name]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, &UnknownError{Name: name}
+	}
+	return r.factory(c)
+}
+
+// Names returns the sorted names of the registered drivers.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var names []string
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options returns the settings accepted by the named driver.
+func Options(name string) []Option {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return drivers[name].options
+}
+
+// UnknownError is returned when instantiating a driver that is not registered.
+type UnknownError struct {
+	Name string
+}
+
+func (e *UnknownError) Error() string {
+	return fmt.Sprintf("unknown driver %q, available drivers: %v", e.Name, Names())
+}
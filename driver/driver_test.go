@@ -0,0 +1,129 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type stubDriver struct {
+	Driver
+	config *Config
+}
+
+func TestRegistry(t *testing.T) {
+	opt := Option{Name: "stub-size", Default: "1", Type: IntOption}
+	Register("stub", func(c *Config) (Driver, error) {
+		return &stubDriver{config: c}, nil
+	}, opt)
+
+	found := false
+	for _, name := range Names() {
+		if name == "stub" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Names() = %v, missing stub", Names())
+	}
+	if opts := Options("stub"); len(opts) != 1 || opts[0].Name != opt.Name {
+		t.Errorf("Options(stub) = %+v", opts)
+	}
+	if opts := Options("missing"); opts != nil {
+		t.Errorf("Options(missing) = %+v, want none", opts)
+	}
+
+	c := &Config{Name: "dev"}
+	d, err := New("stub", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.(*stubDriver).config != c {
+		t.Error("New did not hand the configuration to the factory")
+	}
+
+	_, err = New("missing", c)
+	if _, ok := err.(*UnknownError); !ok {
+		t.Fatalf("New(missing) = %v, want an UnknownError", err)
+	}
+	if !strings.Contains(err.Error(), "stub") {
+		t.Errorf("%q does not list the available drivers", err)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	factory := func(c *Config) (Driver, error) { return nil, nil }
+	Register("stub-twice", factory)
+
+	for _, test := range []struct {
+		name    string
+		factory Factory
+	}{
+		{"stub-twice", factory},
+		{"stub-nil", nil},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) did not panic", test.name)
+				}
+			}()
+			Register(test.name, test.factory)
+		}()
+	}
+}
+
+func TestOptionCheck(t *testing.T) {
+	errOdd := errors.New("odd")
+	even := func(v string) error {
+		if v[len(v)-1]%2 != 0 {
+			return errOdd
+		}
+		return nil
+	}
+
+	tests := []struct {
+		opt   Option
+		value string
+		ok    bool
+	}{
+		{Option{Name: "s"}, "", true},
+		{Option{Name: "s", Required: true}, "", false},
+		{Option{Name: "s", Required: true}, "x", true},
+		{Option{Name: "i", Type: IntOption}, "12", true},
+		{Option{Name: "i", Type: IntOption}, "twelve", false},
+		{Option{Name: "b", Type: BoolOption}, "true", true},
+		{Option{Name: "b", Type: BoolOption}, "yes", false},
+		{Option{Name: "v", Type: IntOption, Validate: even}, "4", true},
+		{Option{Name: "v", Type: IntOption, Validate: even}, "3", false},
+		{Option{Name: "v", Type: IntOption, Validate: even}, "", true},
+	}
+	for _, test := range tests {
+		if err := test.opt.Check(test.value); (err == nil) != test.ok {
+			t.Errorf("option %s: Check(%q) = %v, want ok %v", test.opt.Name, test.value, err, test.ok)
+		}
+	}
+}
+
+func TestConfigOption(t *testing.T) {
+	c := &Config{Options: map[string]string{"set": "value", "empty": ""}}
+	for name, want := range map[string]string{"set": "value", "empty": "", "unset": "default"} {
+		if got := c.Option(name, "default"); got != want {
+			t.Errorf("Option(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
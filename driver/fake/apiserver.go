@@ -0,0 +1,121 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gerred/kube-cluster/driver"
+)
+
+// podsFileName is the file of the environment directory holding the pods the
+// stub API server reports, as a kubernetes PodList.
+const podsFileName = "fake-pods.json"
+
+// Handler returns a stub kubernetes API server serving the simulated
+// environment persisted in storePath. It answers the version and discovery
+// endpoints, lists the simulated nodes, and reports the pods found in the
+// fake-pods.json file of storePath, if any. Run it with net/http/httptest, or
+// any http.Server, and point the fake-api-server option at it.
+func Handler(storePath string) http.Handler {
+	d := &Driver{storePath: storePath}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"major":      "1",
+			"minor":      "1",
+			"gitVersion": "v1.1.2",
+		})
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"kind":     "APIVersions",
+			"versions": []string{"v1"},
+		})
+	})
+	mux.HandleFunc("/api/v1/nodes", func(w http.ResponseWriter, r *http.Request) {
+		nodes, err := d.Nodes()
+		if err != nil {
+			writeStatus(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		resources := map[string]string{"cpu": "1", "memory": "2Gi", "pods": "40"}
+		items := make([]interface{}, 0, len(nodes))
+		for _, n := range nodes {
+			ready := "False"
+			if n.State == driver.Running {
+				ready = "True"
+			}
+			items = append(items, map[string]interface{}{
+				"metadata": map[string]interface{}{"name": n.Name},
+				"status": map[string]interface{}{
+					"addresses":   []map[string]string{{"type": "InternalIP", "address": n.Address}},
+					"conditions":  []map[string]string{{"type": "Ready", "status": ready}},
+					"capacity":    resources,
+					"allocatable": resources,
+				},
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"kind":       "NodeList",
+			"apiVersion": "v1",
+			"items":      items,
+		})
+	})
+	mux.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/pods") {
+			data, err := ioutil.ReadFile(filepath.Join(storePath, podsFileName))
+			switch {
+			case os.IsNotExist(err):
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"kind":       "PodList",
+					"apiVersion": "v1",
+					"items":      []interface{}{},
+				})
+			case err != nil:
+				writeStatus(w, http.StatusInternalServerError, err.Error())
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(data)
+			}
+			return
+		}
+		writeStatus(w, http.StatusNotFound, "the server could not find the requested resource")
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeStatus(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]interface{}{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"status":     "Failure",
+		"message":    message,
+		"code":       code,
+	})
+}
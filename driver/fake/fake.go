@@ -0,0 +1,466 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fake implements a driver simulating kubernetes environments on the
+// local disk. No machine is ever created: nodes and their state are kept in the
+// environment directory, and the generated kubeconfig points at a stub API
+// server (see Handler), which the driver runs in the background unless the
+// fake-api-server option names another one. It is meant for testing and
+// demos.
+//
+// Failures can be injected with the fake-fail-node and fake-fail-on options, and
+// fake-node-delay slows provisioning down.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+const (
+	driverName    = "fake"
+	stateFileName = "fake-state.json"
+	defaultPool   = "default"
+
+	optAPIServer = "fake-api-server"
+	optFailNode  = "fake-fail-node"
+	optFailOn    = "fake-fail-on"
+	optNodeDelay = "fake-node-delay"
+)
+
+func init() {
+	driver.Register(driverName, New,
+		driver.Option{
+			Name:  optAPIServer,
+			Usage: "URL of an API server written to the fake driver kubeconfig instead of the stub one it starts",
+		},
+		driver.Option{
+			Name:    optFailNode,
+			Usage:   "make the fake driver fail when provisioning the Nth node, 0 disables it",
+			Default: "0",
+			Type:    driver.IntOption,
+			Prompt:  "Fail when provisioning node number (0 never fails)",
+		},
+		driver.Option{
+			Name:     optNodeDelay,
+			Usage:    "time the fake driver takes to provision each node, e.g. 2s",
+			Default:  "0s",
+			Validate: driver.ValidateDuration,
+		},
+		driver.Option{
+			Name:  optFailOn,
+			Usage: "comma separated fake driver operations to fail: remove, start, stop, status, scale, upgrade or addon",
+		},
+	)
+}
+
+// node is a simulated machine.
+type node struct {
+	Name  string       `json:"name"`
+	Pool  string       `json:"pool,omitempty"`
+	IP    string       `json:"ip"`
+	State driver.State `json:"state"`
+}
+
+type state struct {
+	State             driver.State      `json:"state"`
+	Nodes             []node            `json:"nodes"`
+	KubernetesVersion string            `json:"kubernetesVersion,omitempty"`
+	Addons            map[string]string `json:"addons,omitempty"`
+}
+
+// Driver simulates an environment, persisting it to the environment directory.
+type Driver struct {
+	name      string
+	storePath string
+	pools     []driver.Pool
+	apiServer string
+	failNode  int
+	nodeDelay time.Duration
+	config    *driver.Config
+	failOn    map[string]bool
+}
+
+// New instantiates a fake driver.
+func New(c *driver.Config) (driver.Driver, error) {
+	failNode, err := strconv.Atoi(c.Option(optFailNode, "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optFailNode, err)
+	}
+
+	failOn := make(map[string]bool)
+	for _, op := range strings.Split(c.Option(optFailOn, ""), ",") {
+		if op = strings.TrimSpace(op); op != "" {
+			failOn[op] = true
+		}
+	}
+
+	nodeDelay, err := time.ParseDuration(c.Option(optNodeDelay, "0s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optNodeDelay, err)
+	}
+
+	pools := c.Pools
+	if len(pools) == 0 {
+		pools = []driver.Pool{{Name: defaultPool, Nodes: c.Nodes}}
+	}
+
+	return &Driver{
+		name:      c.Name,
+		storePath: c.StorePath,
+		pools:     pools,
+		apiServer: c.Option(optAPIServer, ""),
+		failNode:  failNode,
+		nodeDelay: nodeDelay,
+		failOn:    failOn,
+		config:    c,
+	}, nil
+}
+
+// Create provisions the simulated nodes, a journal step per pool, and starts
+// the stub API server. Nodes left over from a previous attempt are kept, so
+// Create can be re-run after an injected failure.
+func (d *Driver) Create() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	s.State = driver.Running
+	if err := d.startAPIServer(); err != nil {
+		return err
+	}
+	for _, p := range d.pools {
+		pool := p.Name
+		err := d.config.Journal.Run("create node pool "+pool, func(record func(kind, id string)) error {
+			err := d.resize(s, pool, p.Nodes)
+			for _, n := range s.poolNodes(pool) {
+				record("node", n.Name)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove stops the stub API server, and forgets the simulated environment.
+func (d *Driver) Remove() error {
+	if err := d.injected("remove"); err != nil {
+		return err
+	}
+	if err := d.stopAPIServer(); err != nil {
+		return err
+	}
+	if err := os.Remove(d.statePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Start marks the environment and all its nodes as running, and starts the
+// stub API server.
+func (d *Driver) Start() error {
+	if err := d.setState("start", driver.Running); err != nil {
+		return err
+	}
+	return d.startAPIServer()
+}
+
+// Stop marks the environment and all its nodes as stopped, and stops the stub
+// API server.
+func (d *Driver) Stop() error {
+	if err := d.setState("stop", driver.Stopped); err != nil {
+		return err
+	}
+	return d.stopAPIServer()
+}
+
+// Status reports the simulated environment state.
+func (d *Driver) Status() (driver.State, error) {
+	if err := d.injected("status"); err != nil {
+		return driver.Error, err
+	}
+	s, err := d.load()
+	if err != nil {
+		return driver.Error, err
+	}
+	return s.State, nil
+}
+
+// GetURL returns the API server URL.
+func (d *Driver) GetURL() (string, error) {
+	return d.apiServerURL()
+}
+
+// GetKubeconfig returns a kubeconfig pointing at the API server.
+func (d *Driver) GetKubeconfig() ([]byte, error) {
+	url, err := d.apiServerURL()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: %[1]s
+  cluster:
+    server: %[2]s
+users:
+- name: %[1]s
+  user:
+    token: fake
+contexts:
+- name: %[1]s
+  context:
+    cluster: %[1]s
+    user: %[1]s
+current-context: %[1]s
+`, d.name, url)), nil
+}
+
+// Scale adds nodes to the first pool, or removes the most recent nodes.
+func (d *Driver) Scale(nodes int) error {
+	if err := d.injected("scale"); err != nil {
+		return err
+	}
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	if nodes < len(s.Nodes) {
+		s.Nodes = s.Nodes[:nodes]
+		return d.save(s)
+	}
+	pool := d.pools[0].Name
+	return d.resize(s, pool, len(s.poolNodes(pool))+nodes-len(s.Nodes))
+}
+
+// ScalePool adds or removes simulated nodes of a pool.
+func (d *Driver) ScalePool(pool driver.Pool) error {
+	if err := d.injected("scale"); err != nil {
+		return err
+	}
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	return d.resize(s, pool.Name, pool.Nodes)
+}
+
+// Upgrade records the kubernetes release the environment runs.
+func (d *Driver) Upgrade(version string) error {
+	return d.update("upgrade", func(s *state) {
+		s.KubernetesVersion = version
+	})
+}
+
+// InstallAddon records an addon as installed.
+func (d *Driver) InstallAddon(name, version string) error {
+	return d.update("addon", func(s *state) {
+		if s.Addons == nil {
+			s.Addons = make(map[string]string)
+		}
+		s.Addons[name] = version
+	})
+}
+
+// RemoveAddon forgets an installed addon.
+func (d *Driver) RemoveAddon(name string) error {
+	return d.update("addon", func(s *state) {
+		delete(s.Addons, name)
+	})
+}
+
+// Addons returns the addons recorded as installed.
+func (d *Driver) Addons() (map[string]string, error) {
+	s, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	addons := make(map[string]string, len(s.Addons))
+	for name, version := range s.Addons {
+		addons[name] = version
+	}
+	return addons, nil
+}
+
+// Nodes returns the simulated nodes.
+func (d *Driver) Nodes() ([]driver.Node, error) {
+	s, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]driver.Node, len(s.Nodes))
+	for i, n := range s.Nodes {
+		nodes[i] = driver.Node{Name: n.Name, Pool: n.pool(), Address: n.IP, State: n.State}
+	}
+	return nodes, nil
+}
+
+// resize adds or removes nodes of pool until it holds the given number. The
+// most recent nodes are removed first, new ones are provisioned concurrently.
+// Nodes failing to provision are left out.
+func (d *Driver) resize(s *state, pool string, nodes int) error {
+	for excess := len(s.poolNodes(pool)) - nodes; excess > 0; excess-- {
+		for i := len(s.Nodes) - 1; i >= 0; i-- {
+			if s.Nodes[i].pool() == pool {
+				s.Nodes = append(s.Nodes[:i], s.Nodes[i+1:]...)
+				break
+			}
+		}
+	}
+
+	// Plan the new nodes, numbered in provisioning order, and named after
+	// the first free indexes of the pool.
+	used := make(map[string]bool)
+	for _, n := range s.Nodes {
+		used[n.Name] = true
+	}
+	ordinals := make(map[string]int)
+	var names []string
+	for i := 1; len(s.poolNodes(pool)) < nodes; i++ {
+		n := node{Name: fmt.Sprintf("%s-node-%d", d.name, i), IP: s.freeIP(), State: s.State}
+		if pool != defaultPool {
+			n.Name = fmt.Sprintf("%s-%s-node-%d", d.name, pool, i)
+			n.Pool = pool
+		}
+		if used[n.Name] {
+			continue
+		}
+		s.Nodes = append(s.Nodes, n)
+		ordinals[n.Name] = len(s.Nodes)
+		names = append(names, n.Name)
+	}
+
+	err := driver.ForEachNode(d.config, "creating", names, func(name string) error {
+		time.Sleep(d.nodeDelay)
+		if ordinals[name] == d.failNode {
+			return fmt.Errorf("fake: injected failure provisioning node %d", d.failNode)
+		}
+		return nil
+	})
+	if nodesErr, ok := err.(*driver.NodesError); ok {
+		kept := s.Nodes[:0]
+		for _, n := range s.Nodes {
+			if nodesErr.Failed[n.Name] == nil {
+				kept = append(kept, n)
+			}
+		}
+		s.Nodes = kept
+	}
+
+	if saveErr := d.save(s); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+func (d *Driver) setState(op string, st driver.State) error {
+	return d.update(op, func(s *state) {
+		s.State = st
+		for i := range s.Nodes {
+			s.Nodes[i].State = st
+		}
+	})
+}
+
+// update applies fn to the persisted state, unless a failure of op is injected.
+func (d *Driver) update(op string, fn func(s *state)) error {
+	if err := d.injected(op); err != nil {
+		return err
+	}
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	fn(s)
+	return d.save(s)
+}
+
+func (n node) pool() string {
+	if n.Pool == "" {
+		return defaultPool
+	}
+	return n.Pool
+}
+
+func (s *state) poolNodes(pool string) []node {
+	var nodes []node
+	for _, n := range s.Nodes {
+		if n.pool() == pool {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// freeIP returns the first simulated node address not in use.
+func (s *state) freeIP() string {
+	used := make(map[string]bool)
+	for _, n := range s.Nodes {
+		used[n.IP] = true
+	}
+	for i := 1; ; i++ {
+		ip := fmt.Sprintf("10.245.%d.%d", i/250, i%250+2)
+		if !used[ip] {
+			return ip
+		}
+	}
+}
+
+func (d *Driver) injected(op string) error {
+	if d.failOn[op] {
+		return fmt.Errorf("fake: injected %s failure", op)
+	}
+	return nil
+}
+
+func (d *Driver) statePath() string {
+	return filepath.Join(d.storePath, stateFileName)
+}
+
+func (d *Driver) load() (*state, error) {
+	s := &state{State: driver.None}
+	data, err := ioutil.ReadFile(d.statePath())
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("fake: corrupt state: %v", err)
+	}
+	return s, nil
+}
+
+func (d *Driver) save(s *state) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.storePath, 0700); err != nil {
+		return err
+	}
+	return envstore.WriteFileAtomic(d.statePath(), data, 0600)
+}
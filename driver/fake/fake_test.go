@@ -0,0 +1,277 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+)
+
+// TestMain lets the test binary serve the stub API server the driver starts
+// by running its own executable.
+func TestMain(m *testing.M) {
+	if storePath := os.Getenv(APIServerVariable); storePath != "" {
+		fmt.Fprintln(os.Stderr, ServeAPI(storePath))
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestDriver(t *testing.T, options map[string]string, pools ...driver.Pool) (*Driver, func()) {
+	dir, err := ioutil.TempDir("", "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := driver.OpenJournal(filepath.Join(dir, "journal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := 0
+	for _, p := range pools {
+		nodes += p.Nodes
+	}
+	d, err := New(&driver.Config{
+		Name:      "dev",
+		StorePath: dir,
+		Nodes:     nodes,
+		Pools:     pools,
+		Options:   options,
+		Journal:   j,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.(*Driver), func() { os.RemoveAll(dir) }
+}
+
+func nodeNames(t *testing.T, d *Driver) []string {
+	nodes, err := d.Nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, n := range nodes {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+func TestLifecycle(t *testing.T) {
+	d, cleanup := newTestDriver(t, nil,
+		driver.Pool{Name: "default", Nodes: 2},
+		driver.Pool{Name: "gpu", Nodes: 1},
+	)
+	defer cleanup()
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.stopAPIServer()
+
+	want := []string{"dev-node-1", "dev-node-2", "dev-gpu-node-1"}
+	if got := nodeNames(t, d); !reflect.DeepEqual(got, want) {
+		t.Errorf("nodes %v, want %v", got, want)
+	}
+	if st, err := d.Status(); err != nil || st != driver.Running {
+		t.Errorf("Status() = %v, %v, want Running", st, err)
+	}
+
+	// kubectl reaches the stub API server through the kubeconfig
+	url, err := d.GetURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeconfig, err := d.GetKubeconfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(kubeconfig), "server: "+url+"\n") {
+		t.Errorf("kubeconfig does not point at %s:\n%s", url, kubeconfig)
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct{ Name string }
+		}
+	}
+	resp, err := http.Get(url + "/api/v1/nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 3 {
+		t.Errorf("API server lists %d nodes, want 3", len(list.Items))
+	}
+
+	if err := d.Scale(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeNames(t, d); !reflect.DeepEqual(got, []string{"dev-node-1"}) {
+		t.Errorf("nodes after scaling down %v", got)
+	}
+
+	if err := d.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if answers(url) {
+		t.Error("the API server still answers once stopped")
+	}
+	if _, err := d.GetURL(); err == nil {
+		t.Error("GetURL succeeded with the API server stopped")
+	}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if url, err = d.GetURL(); err != nil || !answers(url) {
+		t.Errorf("the API server does not answer once restarted: %v", err)
+	}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if answers(url) {
+		t.Error("the API server still answers once removed")
+	}
+	if st, err := d.Status(); err != nil || st != driver.None {
+		t.Errorf("Status() = %v, %v after Remove, want None", st, err)
+	}
+}
+
+func TestStopSparesOtherProcesses(t *testing.T) {
+	d, cleanup := newTestDriver(t, nil)
+	defer cleanup()
+
+	// a process that took the PID of a server gone with a reboot
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer cmd.Process.Kill()
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	data, err := json.Marshal(server{PID: cmd.Process.Pid, URL: "http://127.0.0.1:1", StorePath: d.storePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(d.storePath, serverFileName), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.stopAPIServer(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-exited:
+		t.Errorf("the process recorded as stub API server was killed: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if srv, err := d.loadServer(); srv != nil || err != nil {
+		t.Errorf("the stale record was kept: %v, %v", srv, err)
+	}
+}
+
+func TestAPIServerOption(t *testing.T) {
+	d, cleanup := newTestDriver(t, map[string]string{optAPIServer: "https://10.0.0.1:6443"},
+		driver.Pool{Name: "default", Nodes: 1})
+	defer cleanup()
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if url, err := d.GetURL(); err != nil || url != "https://10.0.0.1:6443" {
+		t.Errorf("GetURL() = %q, %v", url, err)
+	}
+	if _, err := os.Stat(filepath.Join(d.storePath, serverFileName)); !os.IsNotExist(err) {
+		t.Errorf("a stub API server was started: %v", err)
+	}
+}
+
+func TestInjectedFailures(t *testing.T) {
+	d, cleanup := newTestDriver(t, map[string]string{
+		optFailNode:  "2",
+		optFailOn:    "remove, scale",
+		optAPIServer: "http://127.0.0.1:1",
+	}, driver.Pool{Name: "default", Nodes: 3})
+	defer cleanup()
+
+	err := d.Create()
+	if _, ok := err.(*driver.NodesError); !ok {
+		t.Fatalf("Create() = %v, want a NodesError", err)
+	}
+	if got, want := nodeNames(t, d), []string{"dev-node-1", "dev-node-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nodes %v, want %v", got, want)
+	}
+	if err := d.Scale(5); err == nil {
+		t.Error("Scale succeeded despite the injected failure")
+	}
+	if err := d.Remove(); err == nil {
+		t.Error("Remove succeeded despite the injected failure")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	d, cleanup := newTestDriver(t, map[string]string{optAPIServer: "http://127.0.0.1:1"},
+		driver.Pool{Name: "default", Nodes: 1})
+	defer cleanup()
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	pods := `{"kind":"PodList","apiVersion":"v1","items":[{"metadata":{"name":"nginx"}}]}`
+	if err := ioutil.WriteFile(filepath.Join(d.storePath, podsFileName), []byte(pods), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(Handler(d.storePath))
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/version", http.StatusOK, `"gitVersion":"v1.1.2"`},
+		{"/api", http.StatusOK, `"versions":["v1"]`},
+		{"/api/v1/nodes", http.StatusOK, `"name":"dev-node-1"`},
+		{"/api/v1/pods", http.StatusOK, `"name":"nginx"`},
+		{"/api/v1/namespaces/default/pods", http.StatusOK, `"name":"nginx"`},
+		{"/api/v1/services", http.StatusNotFound, `"kind":"Status"`},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(srv.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.code || !strings.Contains(string(body), test.want) {
+			t.Errorf("GET %s = %d %s, want %d with %s", test.path, resp.StatusCode, body, test.code, test.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+// APIServerVariable is the environment variable of the processes serving the
+// stub API server, holding the environment directory they serve. The fake
+// driver starts them by running its own executable with it set, see
+// ServeAPI.
+const APIServerVariable = "KUBE_CLUSTER_FAKE_API_SERVER"
+
+// serverFileName is the file of the environment directory where the stub API
+// server process records itself.
+const serverFileName = "fake-api-server.json"
+
+// apiServerTimeout bounds how long the stub API server takes to start.
+const apiServerTimeout = 10 * time.Second
+
+// serverPath is where the stub API server describes itself, so that it is
+// told apart from unrelated processes reusing its PID or its port.
+const serverPath = "/kube-cluster/fake-api-server"
+
+// server is the stub API server process of an environment.
+type server struct {
+	PID       int    `json:"pid"`
+	URL       string `json:"url"`
+	StorePath string `json:"storePath"`
+}
+
+// ServeAPI serves the stub API server of the environment persisted in
+// storePath on a free local port, recording its address for the fake driver.
+// It only returns on failure.
+func ServeAPI(storePath string) error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	self := server{PID: os.Getpid(), URL: "http://" + l.Addr().String(), StorePath: storePath}
+	data, err := json.Marshal(self)
+	if err != nil {
+		return err
+	}
+	if err := envstore.WriteFileAtomic(filepath.Join(storePath, serverFileName), data, 0600); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", Handler(storePath))
+	mux.HandleFunc(serverPath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, self)
+	})
+	return http.Serve(l, mux)
+}
+
+// apiServerURL returns the URL of the API server of the environment: the
+// fake-api-server option, or the stub API server.
+func (d *Driver) apiServerURL() (string, error) {
+	if d.apiServer != "" {
+		return d.apiServer, nil
+	}
+	srv, err := d.loadServer()
+	if err != nil {
+		return "", err
+	}
+	if srv == nil {
+		return "", fmt.Errorf("fake: the stub API server of environment %q is not running, start the environment", d.name)
+	}
+	return srv.URL, nil
+}
+
+// startAPIServer starts the stub API server, unless the fake-api-server
+// option is set or it already answers. It keeps running once kube-cluster
+// exits, until stopAPIServer.
+func (d *Driver) startAPIServer() error {
+	if d.apiServer != "" {
+		return nil
+	}
+	srv, err := d.loadServer()
+	if err != nil {
+		return err
+	}
+	if srv != nil && answers(srv.URL) {
+		return nil
+	}
+	if err := d.stopAPIServer(); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.storePath, 0700); err != nil {
+		return err
+	}
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), APIServerVariable+"="+d.storePath)
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("fake: cannot start the stub API server: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(apiServerTimeout)
+	for {
+		if srv, err := d.loadServer(); err != nil {
+			return err
+		} else if srv != nil && answers(srv.URL) {
+			return nil
+		}
+		select {
+		case err := <-exited:
+			return fmt.Errorf("fake: the stub API server exited: %v", err)
+		case <-deadline:
+			cmd.Process.Kill()
+			return fmt.Errorf("fake: the stub API server did not start within %v", apiServerTimeout)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// stopAPIServer stops the stub API server, if running.
+func (d *Driver) stopAPIServer() error {
+	srv, err := d.loadServer()
+	if err != nil || srv == nil {
+		return err
+	}
+	// the process may be gone already, e.g. after a reboot, and its PID
+	// taken by another one: only the server answering for the environment
+	// is killed
+	if running, err := identify(srv.URL); err == nil && *running == *srv && running.StorePath == d.storePath {
+		if p, err := os.FindProcess(srv.PID); err == nil {
+			p.Kill()
+			p.Release()
+		}
+	}
+	if err := os.Remove(filepath.Join(d.storePath, serverFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// loadServer returns the stub API server recorded for the environment, nil
+// if none is.
+func (d *Driver) loadServer() (*server, error) {
+	data, err := ioutil.ReadFile(filepath.Join(d.storePath, serverFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	srv := &server{}
+	if err := json.Unmarshal(data, srv); err != nil {
+		return nil, fmt.Errorf("fake: corrupt API server record: %v", err)
+	}
+	return srv, nil
+}
+
+// identify asks the stub API server at url to describe itself.
+func identify(url string) (*server, error) {
+	client := http.Client{Timeout: time.Second}
+	resp, err := client.Get(url + serverPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fake: %s is not a stub API server: %s", url, resp.Status)
+	}
+	srv := &server{}
+	if err := json.NewDecoder(resp.Body).Decode(srv); err != nil {
+		return nil, fmt.Errorf("fake: %s is not a stub API server: %v", url, err)
+	}
+	return srv, nil
+}
+
+// answers reports whether an API server answers at url.
+func answers(url string) bool {
+	client := http.Client{Timeout: time.Second}
+	resp, err := client.Get(url + "/version")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
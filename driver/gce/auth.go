@@ -0,0 +1,162 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gce
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	computeScope    = "https://www.googleapis.com/auth/compute"
+	defaultTokenURI = "https://oauth2.googleapis.com/token"
+)
+
+// serviceAccount is a service account key file, as downloaded from the
+// console.
+type serviceAccount struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// tokenSource obtains OAuth2 access tokens for a service account, with the JWT
+// bearer grant, and caches them until they expire.
+type tokenSource struct {
+	account serviceAccount
+	key     *rsa.PrivateKey
+	http    *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newTokenSource reads the service account key file at path.
+func newTokenSource(path string, client *http.Client) (*tokenSource, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var account serviceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("%s: invalid service account key: %v", path, err)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, fmt.Errorf("%s: not a service account key, client_email or private_key missing", path)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = defaultTokenURI
+	}
+
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("%s: invalid private_key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%s: invalid private_key: %v", path, err)
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: private_key is not an RSA key", path)
+	}
+	return &tokenSource{account: account, key: key, http: client}, nil
+}
+
+// Token returns a valid access token.
+func (ts *tokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && time.Now().Add(time.Minute).Before(ts.expires) {
+		return ts.token, nil
+	}
+
+	assertion, err := ts.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+	resp, err := ts.http.PostForm(ts.account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", fmt.Errorf("gce: token request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("gce: token request: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", fmt.Errorf("gce: token request: %s: %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	ts.token = body.AccessToken
+	ts.expires = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return ts.token, nil
+}
+
+// assertion returns the signed JWT exchanged for an access token.
+func (ts *tokenSource) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": ts.account.PrivateKeyID,
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   ts.account.ClientEmail,
+		"scope": computeScope,
+		"aud":   ts.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
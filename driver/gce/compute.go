@@ -0,0 +1,214 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// client calls the Compute Engine API of a project.
+type client struct {
+	// base is the API root, e.g. https://www.googleapis.com/compute/v1/,
+	// and project the URL of the project below it.
+	base    string
+	project string
+	zone    string
+	tokens  *tokenSource // nil sends unauthenticated requests
+	http    *http.Client
+	timeout time.Duration
+}
+
+// APIError is an error returned by the Compute Engine API.
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("gce: %d: %s", e.Code, e.Message)
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*APIError)
+	return ok && e.Code == http.StatusNotFound
+}
+
+// operation is a long running Compute Engine operation.
+type operation struct {
+	Name   string `json:"name"`
+	Zone   string `json:"zone"`
+	Status string `json:"status"`
+	Error  *struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error"`
+}
+
+// pollInterval is how often pending operations are checked.
+var pollInterval = 2 * time.Second
+
+// url returns the URL of path, relative to the project unless it is global to
+// the API, e.g. zones/us-central1-b/instances.
+func (c *client) url(path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return c.project + path
+}
+
+// zonal returns the project relative path of a resource of the zone.
+func (c *client) zonal(path string) string {
+	return "zones/" + c.zone + "/" + path
+}
+
+// do sends a request with the JSON encoding of in, unless nil, and decodes the
+// response into out, unless nil.
+func (c *client) do(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.url(path), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.tokens != nil {
+		token, err := c.tokens.Token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("gce: %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("gce: %s %s: %v", method, path, err)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		msg := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &e) == nil && e.Error.Message != "" {
+			msg = e.Error.Message
+		}
+		return &APIError{Code: resp.StatusCode, Message: msg}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("gce: %s %s: invalid response: %v", method, path, err)
+	}
+	return nil
+}
+
+// run sends a request starting an operation, and waits for it to complete.
+func (c *client) run(method, path string, in interface{}) error {
+	var op operation
+	if err := c.do(method, path, in, &op); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	for op.Status != "DONE" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gce: timed out after %s waiting for operation %s", c.timeout, op.Name)
+		}
+		time.Sleep(pollInterval)
+
+		opPath := "global/operations/" + op.Name
+		if op.Zone != "" {
+			opPath = c.zonal("operations/" + op.Name)
+		}
+		if err := c.do("GET", opPath, nil, &op); err != nil {
+			return err
+		}
+	}
+
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		e := op.Error.Errors[0]
+		if e.Code == "RESOURCE_NOT_FOUND" {
+			return &APIError{Code: http.StatusNotFound, Message: e.Message}
+		}
+		return fmt.Errorf("gce: %s: %s", e.Code, e.Message)
+	}
+	return nil
+}
+
+// list returns all the items of the collection at path, following pages.
+func (c *client) list(path string, items interface{}) error {
+	var all []json.RawMessage
+	token := ""
+	for {
+		p := path
+		if token != "" {
+			sep := "?"
+			if strings.Contains(path, "?") {
+				sep = "&"
+			}
+			p += sep + "pageToken=" + url.QueryEscape(token)
+		}
+		var page struct {
+			Items         []json.RawMessage `json:"items"`
+			NextPageToken string            `json:"nextPageToken"`
+		}
+		if err := c.do("GET", p, nil, &page); err != nil {
+			return err
+		}
+		all = append(all, page.Items...)
+		if token = page.NextPageToken; token == "" {
+			break
+		}
+	}
+
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, items)
+}
+
+// remove deletes the resource at path, and waits for it to be gone. Missing
+// resources are ignored.
+func (c *client) remove(path string) error {
+	if err := c.run("DELETE", path, nil); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
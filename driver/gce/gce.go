@@ -0,0 +1,900 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gce implements a driver running kubernetes environments on Google
+// Compute Engine.
+//
+// Every environment gets its own network and firewall rules. The master is a
+// single instance, the nodes of each pool a managed instance group created
+// from an instance template, so pools are resized by the group. Machines boot
+// the gce-image image, expected to set kubernetes up from its cloud-init user
+// data, see driver.CloudConfig, and get their certificates over SSH once they
+// are issued.
+//
+// Instances and templates are labelled kube-cluster-environment=NAME, networks
+// and firewall rules, which have no labels, carry it in their description:
+// this is how the resources of an environment are found again, and deleted.
+//
+// The API is authenticated with the service account key of gce-credentials.
+// gce-base-url sends the calls to another server, e.g. a local fake, in which
+// case the credentials are optional.
+package gce
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+)
+
+const (
+	driverName  = "gce"
+	defaultPool = "default"
+
+	optProject           = "gce-project"
+	optZone              = "gce-zone"
+	optCredentials       = "gce-credentials"
+	optImage             = "gce-image"
+	optMachineType       = "gce-machine-type"
+	optMasterMachineType = "gce-master-machine-type"
+	optDiskSize          = "gce-disk-size"
+	optBaseURL           = "gce-base-url"
+	optTimeout           = "gce-timeout"
+
+	defaultBaseURL = "https://www.googleapis.com/compute/v1/"
+
+	labelEnvironment = "kube-cluster-environment"
+	labelRole        = "kube-cluster-role"
+	labelPool        = "kube-cluster-pool"
+
+	apiServerPort = 6443
+	// internalRange holds the subnetworks of auto mode networks.
+	internalRange = "10.128.0.0/9"
+)
+
+func init() {
+	driver.Register(driverName, New,
+		driver.Option{
+			Name:     optProject,
+			Usage:    "GCE project the environment is created in",
+			Prompt:   "GCE project",
+			Required: true,
+		},
+		driver.Option{
+			Name:    optZone,
+			Usage:   "GCE zone of the machines",
+			Default: "us-central1-b",
+			Prompt:  "GCE zone",
+		},
+		driver.Option{
+			Name:   optCredentials,
+			Usage:  "GCE service account key file, $GOOGLE_APPLICATION_CREDENTIALS if empty",
+			Prompt: "GCE service account key file",
+		},
+		driver.Option{
+			Name:     optImage,
+			Usage:    "GCE image the machines boot, e.g. projects/PROJECT/global/images/NAME",
+			Prompt:   "GCE image",
+			Required: true,
+		},
+		driver.Option{
+			Name:    optMachineType,
+			Usage:   "GCE machine type of the nodes, unless their pool sets one",
+			Default: "n1-standard-1",
+		},
+		driver.Option{
+			Name:    optMasterMachineType,
+			Usage:   "GCE machine type of the master",
+			Default: "n1-standard-1",
+		},
+		driver.Option{
+			Name:    optDiskSize,
+			Usage:   "boot disk size of the GCE machines, in GB",
+			Default: "20",
+			Type:    driver.IntOption,
+		},
+		driver.Option{
+			Name:     optBaseURL,
+			Usage:    "GCE API root URL",
+			Default:  defaultBaseURL,
+			Validate: validateBaseURL,
+		},
+		driver.Option{
+			Name:     optTimeout,
+			Usage:    "how long to wait for GCE operations to complete",
+			Default:  "10m",
+			Validate: driver.ValidateDuration,
+		},
+	)
+}
+
+func validateBaseURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q, expected an http or https URL", value)
+	}
+	return nil
+}
+
+// waitForSSH is replaced in tests, where no instance ever boots.
+var waitForSSH = driver.WaitForSSH
+
+// instance is a Compute Engine instance.
+type instance struct {
+	Name              string            `json:"name"`
+	Status            string            `json:"status"`
+	CreationTimestamp string            `json:"creationTimestamp"`
+	SelfLink          string            `json:"selfLink"`
+	Labels            map[string]string `json:"labels"`
+	NetworkInterfaces []struct {
+		NetworkIP     string `json:"networkIP"`
+		AccessConfigs []struct {
+			NatIP string `json:"natIP"`
+		} `json:"accessConfigs"`
+	} `json:"networkInterfaces"`
+}
+
+func (i *instance) internalIP() string {
+	if len(i.NetworkInterfaces) == 0 {
+		return ""
+	}
+	return i.NetworkInterfaces[0].NetworkIP
+}
+
+func (i *instance) externalIP() string {
+	for _, n := range i.NetworkInterfaces {
+		for _, a := range n.AccessConfigs {
+			if a.NatIP != "" {
+				return a.NatIP
+			}
+		}
+	}
+	return ""
+}
+
+// byCreation sorts instances in creation order.
+type byCreation []instance
+
+func (b byCreation) Len() int      { return len(b) }
+func (b byCreation) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byCreation) Less(i, j int) bool {
+	if b[i].CreationTimestamp != b[j].CreationTimestamp {
+		return b[i].CreationTimestamp < b[j].CreationTimestamp
+	}
+	return b[i].Name < b[j].Name
+}
+
+// Driver manages an environment made of Compute Engine instances.
+type Driver struct {
+	name   string
+	pools  []driver.Pool
+	config *driver.Config
+	gce    *client
+
+	image             string
+	machineType       string
+	masterMachineType string
+	diskSize          int
+}
+
+// New instantiates a GCE driver.
+func New(c *driver.Config) (driver.Driver, error) {
+	project := c.Option(optProject, "")
+	if project == "" {
+		return nil, fmt.Errorf("gce: --%s is required", optProject)
+	}
+	base := c.Option(optBaseURL, defaultBaseURL)
+	if err := validateBaseURL(base); err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optBaseURL, err)
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	timeout, err := time.ParseDuration(c.Option(optTimeout, "10m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optTimeout, err)
+	}
+	diskSize, err := strconv.Atoi(c.Option(optDiskSize, "20"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optDiskSize, err)
+	}
+
+	httpClient := &http.Client{Timeout: time.Minute}
+	var tokens *tokenSource
+	credentials := c.Option(optCredentials, "")
+	if credentials == "" {
+		credentials = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	switch {
+	case credentials != "":
+		if tokens, err = newTokenSource(credentials, httpClient); err != nil {
+			return nil, err
+		}
+	case base == defaultBaseURL:
+		return nil, fmt.Errorf("gce: no credentials, set --%s or $GOOGLE_APPLICATION_CREDENTIALS", optCredentials)
+	}
+
+	pools := c.Pools
+	if len(pools) == 0 {
+		pools = []driver.Pool{{Name: defaultPool, Nodes: c.Nodes}}
+	}
+
+	return &Driver{
+		name:   c.Name,
+		pools:  pools,
+		config: c,
+		gce: &client{
+			base:    base,
+			project: base + "projects/" + project + "/",
+			zone:    c.Option(optZone, "us-central1-b"),
+			tokens:  tokens,
+			http:    httpClient,
+			timeout: timeout,
+		},
+
+		image:             c.Option(optImage, ""),
+		machineType:       c.Option(optMachineType, "n1-standard-1"),
+		masterMachineType: c.Option(optMasterMachineType, "n1-standard-1"),
+		diskSize:          diskSize,
+	}, nil
+}
+
+// Create sets up the network and firewall rules, then the master, then an
+// instance group per pool, a journal step each. Resources left over from a
+// previous attempt are reused.
+func (d *Driver) Create() error {
+	if d.image == "" {
+		return fmt.Errorf("gce: --%s is required", optImage)
+	}
+
+	steps := []struct {
+		name string
+		fn   func(record func(kind, id string)) error
+	}{
+		{"create network", d.createNetwork},
+		{"create firewall rules", d.createFirewallRules},
+		{"create master", d.createMaster},
+	}
+	for _, step := range steps {
+		if err := d.config.Journal.Run(step.name, step.fn); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range d.pools {
+		p := p
+		err := d.config.Journal.Run("create node pool "+p.Name, func(record func(kind, id string)) error {
+			record("instance-template", d.templateName(p.Name))
+			record("instance-group", d.groupName(p.Name))
+			return d.ScalePool(p)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) createNetwork(record func(kind, id string)) error {
+	return d.ensure("global/networks/"+d.networkName(), func() error {
+		record("network", d.networkName())
+		return d.gce.run("POST", "global/networks", map[string]interface{}{
+			"name":                  d.networkName(),
+			"description":           d.description(),
+			"autoCreateSubnetworks": true,
+		})
+	})
+}
+
+func (d *Driver) createFirewallRules(record func(kind, id string)) error {
+	rules := []map[string]interface{}{
+		{
+			"name":         d.name + "-internal",
+			"sourceRanges": []string{internalRange},
+			"allowed": []map[string]interface{}{
+				{"IPProtocol": "tcp"},
+				{"IPProtocol": "udp"},
+				{"IPProtocol": "icmp"},
+			},
+		},
+		{
+			"name":         d.name + "-external",
+			"sourceRanges": []string{"0.0.0.0/0"},
+			"allowed": []map[string]interface{}{
+				{"IPProtocol": "tcp", "ports": []string{"22", strconv.Itoa(apiServerPort)}},
+			},
+		},
+	}
+	for _, rule := range rules {
+		rule["description"] = d.description()
+		rule["network"] = d.gce.url("global/networks/" + d.networkName())
+		name := rule["name"].(string)
+		err := d.ensure("global/firewalls/"+name, func() error {
+			record("firewall", name)
+			return d.gce.run("POST", "global/firewalls", rule)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) createMaster(record func(kind, id string)) error {
+	name := d.name + "-master"
+	return driver.ForEachNode(d.config, "creating", []string{name}, func(string) error {
+		err := d.ensure(d.gce.zonal("instances/"+name), func() error {
+			props, err := d.properties(driver.BootConfig{Role: driver.MasterRole, Name: name}, d.gce.zonal("machineTypes/"+d.masterMachineType))
+			if err != nil {
+				return err
+			}
+			props["name"] = name
+			record("instance", name)
+			return d.gce.run("POST", d.gce.zonal("instances"), props)
+		})
+		if err != nil {
+			return err
+		}
+		if err := d.waitRunning(name); err != nil {
+			return err
+		}
+		return d.addedMachine(name, driver.MasterRole)
+	})
+}
+
+// ensure runs create unless the resource at path exists.
+func (d *Driver) ensure(path string, create func() error) error {
+	err := d.gce.do("GET", path, nil, nil)
+	if isNotFound(err) {
+		return create()
+	}
+	return err
+}
+
+// properties returns the instance properties of a machine booted with boot,
+// shared by instances and instance templates.
+func (d *Driver) properties(boot driver.BootConfig, machineType string) (map[string]interface{}, error) {
+	userData, err := d.config.CloudConfig(boot)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{labelEnvironment: d.name, labelRole: boot.Role}
+	if boot.Pool != "" {
+		labels[labelPool] = boot.Pool
+	}
+	return map[string]interface{}{
+		"machineType": machineType,
+		"labels":      labels,
+		"disks": []map[string]interface{}{{
+			"boot":       true,
+			"autoDelete": true,
+			"initializeParams": map[string]interface{}{
+				"sourceImage": d.image,
+				"diskSizeGb":  strconv.Itoa(d.diskSize),
+			},
+		}},
+		"networkInterfaces": []map[string]interface{}{{
+			"network": d.gce.url("global/networks/" + d.networkName()),
+			"accessConfigs": []map[string]string{{
+				"type": "ONE_TO_ONE_NAT",
+				"name": "External NAT",
+			}},
+		}},
+		"metadata": map[string]interface{}{
+			"items": []map[string]string{{"key": "user-data", "value": string(userData)}},
+		},
+	}, nil
+}
+
+// Remove deletes the instance groups, instances, templates, firewall rules
+// and network of the environment, found by their label or description, and
+// in the journal, which records what an interrupted creation may have left
+// unlabelled.
+func (d *Driver) Remove() error {
+	recorded := make(map[string][]string)
+	for _, r := range d.config.Journal.Resources() {
+		recorded[r.Kind] = append(recorded[r.Kind], r.ID)
+	}
+
+	var templates []struct {
+		Name       string `json:"name"`
+		SelfLink   string `json:"selfLink"`
+		Properties struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"properties"`
+	}
+	if err := d.gce.list("global/instanceTemplates", &templates); err != nil {
+		return err
+	}
+	ours := make(map[string]bool)
+	for _, t := range templates {
+		if t.Properties.Labels[labelEnvironment] == d.name {
+			ours[t.Name] = true
+		}
+	}
+	for _, name := range recorded["instance-template"] {
+		ours[name] = true
+	}
+
+	var groups []struct {
+		Name             string `json:"name"`
+		InstanceTemplate string `json:"instanceTemplate"`
+	}
+	if err := d.gce.list(d.gce.zonal("instanceGroupManagers"), &groups); err != nil {
+		return err
+	}
+	groupNames := recorded["instance-group"]
+	for _, g := range groups {
+		if ours[lastSegment(g.InstanceTemplate)] {
+			groupNames = append(groupNames, g.Name)
+		}
+	}
+	if err := d.removeAll(d.gce.zonal("instanceGroupManagers"), groupNames); err != nil {
+		return err
+	}
+
+	instances, err := d.instances()
+	if err != nil {
+		return err
+	}
+	instanceNames := recorded["instance"]
+	for _, i := range instances {
+		instanceNames = append(instanceNames, i.Name)
+	}
+	if err := d.removeAll(d.gce.zonal("instances"), instanceNames); err != nil {
+		return err
+	}
+
+	var templateNames []string
+	for name := range ours {
+		templateNames = append(templateNames, name)
+	}
+	sort.Strings(templateNames)
+	if err := d.removeAll("global/instanceTemplates", templateNames); err != nil {
+		return err
+	}
+
+	for _, c := range []struct{ collection, kind string }{
+		{"global/firewalls", "firewall"},
+		{"global/networks", "network"},
+	} {
+		var items []struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		if err := d.gce.list(c.collection, &items); err != nil {
+			return err
+		}
+		names := recorded[c.kind]
+		for _, item := range items {
+			if item.Description == d.description() {
+				names = append(names, item.Name)
+			}
+		}
+		if err := d.removeAll(c.collection, names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAll deletes the named resources of collection, once each.
+func (d *Driver) removeAll(collection string, names []string) error {
+	removed := make(map[string]bool)
+	for _, name := range names {
+		if removed[name] {
+			continue
+		}
+		removed[name] = true
+		if err := d.gce.remove(collection + "/" + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start starts the stopped instances.
+func (d *Driver) Start() error {
+	return d.each("starting", "start")
+}
+
+// Stop stops the instances.
+func (d *Driver) Stop() error {
+	return d.each("stopping", "stop")
+}
+
+// each runs the instance method, start or stop, on all the instances. The
+// members of an instance group are started and stopped through the group, so
+// it does not recreate them as failed.
+func (d *Driver) each(action, method string) error {
+	all, err := d.instances()
+	if err != nil {
+		return err
+	}
+	instances := make(map[string]instance)
+	var names []string
+	for _, i := range all {
+		instances[i.Name] = i
+		names = append(names, i.Name)
+	}
+	return driver.ForEachNode(d.config, action, names, func(name string) error {
+		i := instances[name]
+		if pool := i.Labels[labelPool]; pool != "" {
+			return d.gce.run("POST", d.gce.zonal("instanceGroupManagers/"+d.groupName(pool)+"/"+method+"Instances"), map[string]interface{}{
+				"instances": []string{i.SelfLink},
+			})
+		}
+		return d.gce.run("POST", d.gce.zonal("instances/"+name+"/"+method), nil)
+	})
+}
+
+// Status aggregates the state of the instances: the environment is Running or
+// Stopped when all of them are, in Error otherwise.
+func (d *Driver) Status() (driver.State, error) {
+	instances, err := d.instances()
+	if err != nil {
+		return driver.Error, err
+	}
+	if len(instances) == 0 {
+		return driver.None, nil
+	}
+	states := make(map[driver.State]bool)
+	for _, i := range instances {
+		states[instanceStates.Of(i.Status)] = true
+	}
+	if len(states) == 1 {
+		for st := range states {
+			return st, nil
+		}
+	}
+	return driver.Error, nil
+}
+
+// GetURL returns the API server address, on the master external address.
+func (d *Driver) GetURL() (string, error) {
+	var master instance
+	if err := d.gce.do("GET", d.gce.zonal("instances/"+d.name+"-master"), nil, &master); err != nil {
+		return "", err
+	}
+	ip := master.externalIP()
+	if ip == "" {
+		if ip = master.internalIP(); ip == "" {
+			return "", fmt.Errorf("gce: the master of environment %q has no address", d.name)
+		}
+	}
+	return "https://" + net.JoinHostPort(ip, strconv.Itoa(apiServerPort)), nil
+}
+
+// GetKubeconfig returns an admin kubeconfig for the API server.
+func (d *Driver) GetKubeconfig() ([]byte, error) {
+	url, err := d.GetURL()
+	if err != nil {
+		return nil, err
+	}
+	return driver.AdminKubeconfig(d.config, url)
+}
+
+// Scale adds nodes to the first pool, or removes the most recent nodes.
+func (d *Driver) Scale(nodes int) error {
+	current, err := d.nodeInstances()
+	if err != nil {
+		return err
+	}
+	if nodes < len(current) {
+		return d.deleteInstances(current[nodes:])
+	}
+
+	p := d.pools[0]
+	p.Nodes = nodes - len(current)
+	for _, i := range current {
+		if i.Labels[labelPool] == p.Name {
+			p.Nodes++
+		}
+	}
+	return d.ScalePool(p)
+}
+
+// ScalePool resizes the instance group of a pool, creating it with its
+// template if needed. Scaling down deletes the most recent instances, and
+// scaling to zero deletes the group and its template.
+func (d *Driver) ScalePool(pool driver.Pool) error {
+	group := d.groupName(pool.Name)
+	if pool.Nodes == 0 {
+		if err := d.gce.remove(d.gce.zonal("instanceGroupManagers/" + group)); err != nil {
+			return err
+		}
+		return d.gce.remove("global/instanceTemplates/" + d.templateName(pool.Name))
+	}
+
+	err := d.gce.do("GET", d.gce.zonal("instanceGroupManagers/"+group), nil, nil)
+	if isNotFound(err) {
+		return d.createGroup(pool)
+	} else if err != nil {
+		return err
+	}
+
+	var current []instance
+	all, err := d.nodeInstances()
+	if err != nil {
+		return err
+	}
+	for _, i := range all {
+		if i.Labels[labelPool] == pool.Name {
+			current = append(current, i)
+		}
+	}
+	switch {
+	case pool.Nodes < len(current):
+		return d.deleteInstances(current[pool.Nodes:])
+	case pool.Nodes > len(current):
+		if err := d.gce.run("POST", d.gce.zonal("instanceGroupManagers/"+group+"/resize?size="+strconv.Itoa(pool.Nodes)), nil); err != nil {
+			return err
+		}
+	}
+	return d.waitGroup(group, current)
+}
+
+// createGroup creates the instance template and group of pool, and waits for
+// the instances.
+func (d *Driver) createGroup(pool driver.Pool) error {
+	var master instance
+	if err := d.gce.do("GET", d.gce.zonal("instances/"+d.name+"-master"), nil, &master); err != nil {
+		return err
+	}
+
+	template := d.templateName(pool.Name)
+	err := d.ensure("global/instanceTemplates/"+template, func() error {
+		machineType := pool.MachineType
+		if machineType == "" {
+			machineType = d.machineType
+		}
+		boot := driver.BootConfig{
+			Role:   driver.NodeRole,
+			Pool:   pool.Name,
+			Labels: pool.Labels,
+			Master: master.internalIP(),
+		}
+		props, err := d.properties(boot, machineType)
+		if err != nil {
+			return err
+		}
+		return d.gce.run("POST", "global/instanceTemplates", map[string]interface{}{
+			"name":        template,
+			"description": d.description(),
+			"properties":  props,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	group := d.groupName(pool.Name)
+	err = d.gce.run("POST", d.gce.zonal("instanceGroupManagers"), map[string]interface{}{
+		"name":             group,
+		"description":      d.description(),
+		"baseInstanceName": group,
+		"instanceTemplate": d.gce.url("global/instanceTemplates/" + template),
+		"targetSize":       pool.Nodes,
+	})
+	if err != nil {
+		return err
+	}
+	return d.waitGroup(group, nil)
+}
+
+// waitGroup waits for the instances of group not in existing to run,
+// reporting their progress.
+func (d *Driver) waitGroup(group string, existing []instance) error {
+	old := make(map[string]bool)
+	for _, i := range existing {
+		old[i.Name] = true
+	}
+
+	var resp struct {
+		ManagedInstances []struct {
+			Instance string `json:"instance"`
+		} `json:"managedInstances"`
+	}
+	if err := d.gce.do("POST", d.gce.zonal("instanceGroupManagers/"+group+"/listManagedInstances"), nil, &resp); err != nil {
+		return err
+	}
+	var names []string
+	for _, m := range resp.ManagedInstances {
+		if name := lastSegment(m.Instance); !old[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return driver.ForEachNode(d.config, "creating", names, func(name string) error {
+		if err := d.waitRunning(name); err != nil {
+			return err
+		}
+		return d.addedMachine(name, driver.NodeRole)
+	})
+}
+
+// addedMachine copies the certificates of role to the running instance name,
+// if they were issued: machines added to a created environment get them right
+// away, the others once they are.
+func (d *Driver) addedMachine(name, role string) error {
+	if !d.config.HasCertificates(role) {
+		return nil
+	}
+	var i instance
+	if err := d.gce.do("GET", d.gce.zonal("instances/"+name), nil, &i); err != nil {
+		return err
+	}
+	return d.copyCertificates(i)
+}
+
+// InstallCertificates copies the certificates of every instance to it, over
+// SSH, see driver.CopyCertificates.
+func (d *Driver) InstallCertificates() error {
+	all, err := d.instances()
+	if err != nil {
+		return err
+	}
+	instances := make(map[string]instance)
+	var names []string
+	for _, i := range all {
+		instances[i.Name] = i
+		names = append(names, i.Name)
+	}
+	sort.Strings(names)
+	return driver.ForEachNode(d.config, "installing certificates", names, func(name string) error {
+		return d.copyCertificates(instances[name])
+	})
+}
+
+// copyCertificates copies the certificates of the role of i to it, on its
+// external address, once it answers on SSH.
+func (d *Driver) copyCertificates(i instance) error {
+	host := i.externalIP()
+	if host == "" {
+		host = i.internalIP()
+	}
+	if err := waitForSSH(net.JoinHostPort(host, "22"), d.gce.timeout); err != nil {
+		return err
+	}
+	return d.config.CopyCertificates(host, i.Labels[labelRole])
+}
+
+// waitRunning waits for the instance name to run.
+func (d *Driver) waitRunning(name string) error {
+	deadline := time.Now().Add(d.gce.timeout)
+	for {
+		var i instance
+		err := d.gce.do("GET", d.gce.zonal("instances/"+name), nil, &i)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if i.Status == "RUNNING" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gce: instance %s not running after %s", name, d.gce.timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// deleteInstances deletes instances of instance groups, shrinking the groups.
+func (d *Driver) deleteInstances(instances []instance) error {
+	byGroup := make(map[string][]string)
+	for _, i := range instances {
+		group := d.groupName(i.Labels[labelPool])
+		byGroup[group] = append(byGroup[group], i.SelfLink)
+	}
+	for group, links := range byGroup {
+		err := d.gce.run("POST", d.gce.zonal("instanceGroupManagers/"+group+"/deleteInstances"), map[string]interface{}{
+			"instances": links,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Nodes returns the node instances in creation order, the master not being a
+// schedulable node.
+func (d *Driver) Nodes() ([]driver.Node, error) {
+	instances, err := d.nodeInstances()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]driver.Node, len(instances))
+	for i, n := range instances {
+		nodes[i] = driver.Node{
+			Name:    n.Name,
+			Pool:    n.Labels[labelPool],
+			Address: n.internalIP(),
+			State:   instanceStates.Of(n.Status),
+		}
+	}
+	return nodes, nil
+}
+
+// nodeInstances returns the node instances in creation order.
+func (d *Driver) nodeInstances() ([]instance, error) {
+	all, err := d.instances()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []instance
+	for _, i := range all {
+		if i.Labels[labelRole] == driver.NodeRole {
+			nodes = append(nodes, i)
+		}
+	}
+	sort.Sort(byCreation(nodes))
+	return nodes, nil
+}
+
+// instances returns the instances labelled with the environment name.
+func (d *Driver) instances() ([]instance, error) {
+	filter := url.QueryEscape("labels." + labelEnvironment + "=" + d.name)
+	var all []instance
+	if err := d.gce.list(d.gce.zonal("instances?filter="+filter), &all); err != nil {
+		return nil, err
+	}
+	var instances []instance
+	for _, i := range all {
+		if i.Labels[labelEnvironment] == d.name {
+			instances = append(instances, i)
+		}
+	}
+	return instances, nil
+}
+
+func (d *Driver) networkName() string {
+	return d.name + "-network"
+}
+
+func (d *Driver) groupName(pool string) string {
+	return d.name + "-" + pool
+}
+
+func (d *Driver) templateName(pool string) string {
+	return d.name + "-" + pool + "-template"
+}
+
+// description marks the resources without labels as the environment ones.
+func (d *Driver) description() string {
+	return labelEnvironment + "=" + d.name
+}
+
+// instanceStates maps the status of an instance to a driver State.
+var instanceStates = driver.States{
+	"RUNNING":      driver.Running,
+	"TERMINATED":   driver.Stopped,
+	"STOPPED":      driver.Stopped,
+	"SUSPENDED":    driver.Stopped,
+	"PROVISIONING": driver.Starting,
+	"STAGING":      driver.Starting,
+	"STOPPING":     driver.Stopping,
+	"SUSPENDING":   driver.Stopping,
+}
+
+// lastSegment returns the resource name ending a resource URL.
+func lastSegment(u string) string {
+	return u[strings.LastIndex(u, "/")+1:]
+}
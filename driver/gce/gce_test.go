@@ -0,0 +1,637 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gce
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/pki"
+)
+
+func TestMain(m *testing.M) {
+	pollInterval = time.Millisecond
+	waitForSSH = func(string, time.Duration) error { return nil }
+	os.Exit(m.Run())
+}
+
+const (
+	testProject = "p"
+	testZone    = "z"
+)
+
+// fakeCompute serves the Compute Engine API calls of the driver from
+// resources kept in memory. Operations complete when first polled, and lists
+// come in pages of two items.
+type fakeCompute struct {
+	*httptest.Server
+	mu sync.Mutex
+	// items holds the resources of each collection, e.g. global/networks,
+	// in creation order.
+	items map[string][]map[string]interface{}
+	// groups holds the instance group of the instances created by one.
+	groups map[string]string
+	// next numbers the operations and resources, hosts the addresses and
+	// names of the instances.
+	next  int
+	hosts int
+	// token, if set, is the bearer token requests must carry.
+	token string
+}
+
+func newFakeCompute(t *testing.T) *fakeCompute {
+	f := &fakeCompute{items: make(map[string][]map[string]interface{}), groups: make(map[string]string)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+			writeError(w, http.StatusUnauthorized, "no valid token")
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/projects/"+testProject+"/")
+		var in map[string]interface{}
+		if data, _ := ioutil.ReadAll(r.Body); len(data) > 0 {
+			if err := json.Unmarshal(data, &in); err != nil {
+				t.Errorf("%s %s: %v", r.Method, path, err)
+			}
+		}
+		status, out := f.serve(r.Method, path, r.URL.Query().Get("pageToken"), r.URL.Query().Get("size"), in)
+		if status != http.StatusOK {
+			writeError(w, status, fmt.Sprint(out))
+			return
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	return f
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"message": message}})
+}
+
+var collections = []string{
+	"global/networks",
+	"global/firewalls",
+	"global/instanceTemplates",
+	"zones/" + testZone + "/instances",
+	"zones/" + testZone + "/instanceGroupManagers",
+}
+
+func (f *fakeCompute) serve(method, path, pageToken, size string, in map[string]interface{}) (int, interface{}) {
+	if strings.HasPrefix(path, "global/operations/") || strings.HasPrefix(path, "zones/"+testZone+"/operations/") {
+		return http.StatusOK, map[string]string{"name": path[strings.LastIndex(path, "/")+1:], "status": "DONE"}
+	}
+	var collection, name, verb string
+	for _, c := range collections {
+		if path == c || strings.HasPrefix(path, c+"/") {
+			collection = c
+			parts := strings.SplitN(strings.TrimPrefix(strings.TrimPrefix(path, c), "/"), "/", 2)
+			name = parts[0]
+			if len(parts) == 2 {
+				verb = parts[1]
+			}
+		}
+	}
+	if collection == "" {
+		return http.StatusNotFound, "unknown path " + path
+	}
+	instances := "zones/" + testZone + "/instances"
+	groups := "zones/" + testZone + "/instanceGroupManagers"
+
+	switch {
+	case method == "GET" && name == "":
+		items := f.items[collection]
+		start, _ := strconv.Atoi(pageToken)
+		page := map[string]interface{}{}
+		if start < len(items) {
+			end := start + 2
+			if end < len(items) {
+				page["nextPageToken"] = strconv.Itoa(end)
+			} else {
+				end = len(items)
+			}
+			page["items"] = items[start:end]
+		}
+		return http.StatusOK, page
+	case method == "GET" && verb == "":
+		if item := f.item(collection, name); item != nil {
+			return http.StatusOK, item
+		}
+		return http.StatusNotFound, "no " + path
+	case method == "POST" && name == "":
+		name, _ := in["name"].(string)
+		if f.item(collection, name) != nil {
+			return http.StatusConflict, name + " exists"
+		}
+		f.insert(collection, in)
+		if collection == groups {
+			f.resize(in, int(in["targetSize"].(float64)))
+		}
+	case method == "DELETE" && verb == "":
+		if f.item(collection, name) == nil {
+			return http.StatusNotFound, "no " + path
+		}
+		f.remove(collection, name)
+		if collection == groups {
+			for instance, group := range f.groups {
+				if group == name {
+					f.remove(instances, instance)
+				}
+			}
+		}
+	case collection == instances && (verb == "start" || verb == "stop"):
+		item := f.item(collection, name)
+		if item == nil {
+			return http.StatusNotFound, "no " + path
+		}
+		// the group would recreate a member stopped behind its back
+		if f.groups[name] != "" {
+			return http.StatusBadRequest, name + " is managed by " + f.groups[name]
+		}
+		item["status"] = map[string]string{"start": "RUNNING", "stop": "TERMINATED"}[verb]
+	case collection == groups && verb != "":
+		group := f.item(collection, name)
+		if group == nil {
+			return http.StatusNotFound, "no " + path
+		}
+		switch verb {
+		case "resize":
+			n, _ := strconv.Atoi(size)
+			f.resize(group, n)
+		case "listManagedInstances":
+			var managed []map[string]string
+			for _, i := range f.items[instances] {
+				if f.groups[i["name"].(string)] == name {
+					managed = append(managed, map[string]string{"instance": i["selfLink"].(string)})
+				}
+			}
+			return http.StatusOK, map[string]interface{}{"managedInstances": managed}
+		case "startInstances", "stopInstances":
+			for _, link := range in["instances"].([]interface{}) {
+				i := f.item(instances, lastSegment(link.(string)))
+				if i == nil || f.groups[i["name"].(string)] != name {
+					return http.StatusBadRequest, link.(string) + " is not a member of " + name
+				}
+				i["status"] = map[string]string{"startInstances": "RUNNING", "stopInstances": "TERMINATED"}[verb]
+			}
+		case "deleteInstances":
+			for _, link := range in["instances"].([]interface{}) {
+				f.remove(instances, lastSegment(link.(string)))
+				group["targetSize"] = group["targetSize"].(float64) - 1
+			}
+		default:
+			return http.StatusNotFound, "no " + path
+		}
+	default:
+		return http.StatusNotFound, "no " + method + " " + path
+	}
+
+	f.next++
+	op := map[string]string{"name": "op-" + strconv.Itoa(f.next), "status": "RUNNING"}
+	if strings.HasPrefix(collection, "zones/") {
+		op["zone"] = testZone
+	}
+	return http.StatusOK, op
+}
+
+func (f *fakeCompute) item(collection, name string) map[string]interface{} {
+	for _, item := range f.items[collection] {
+		if item["name"] == name {
+			return item
+		}
+	}
+	return nil
+}
+
+// insert adds item to collection, running and with addresses if it is an
+// instance.
+func (f *fakeCompute) insert(collection string, item map[string]interface{}) {
+	f.next++
+	item["selfLink"] = f.URL + "/projects/" + testProject + "/" + collection + "/" + item["name"].(string)
+	item["creationTimestamp"] = fmt.Sprintf("2015-11-20T10:00:%02d.000-08:00", f.next)
+	if strings.HasSuffix(collection, "/instances") {
+		f.hosts++
+		item["status"] = "RUNNING"
+		item["networkInterfaces"] = []map[string]interface{}{{
+			"networkIP":     fmt.Sprintf("10.128.0.%d", f.hosts),
+			"accessConfigs": []map[string]string{{"natIP": fmt.Sprintf("203.0.113.%d", f.hosts)}},
+		}}
+	}
+	f.items[collection] = append(f.items[collection], item)
+}
+
+func (f *fakeCompute) remove(collection, name string) {
+	var kept []map[string]interface{}
+	for _, item := range f.items[collection] {
+		if item["name"] != name {
+			kept = append(kept, item)
+		}
+	}
+	f.items[collection] = kept
+	delete(f.groups, name)
+}
+
+// resize creates instances of group, from its template, until it has size.
+func (f *fakeCompute) resize(group map[string]interface{}, size int) {
+	name := group["name"].(string)
+	n := 0
+	for _, g := range f.groups {
+		if g == name {
+			n++
+		}
+	}
+	template := f.item("global/instanceTemplates", lastSegment(group["instanceTemplate"].(string)))
+	for ; n < size; n++ {
+		i := make(map[string]interface{})
+		for k, v := range template["properties"].(map[string]interface{}) {
+			i[k] = v
+		}
+		i["name"] = fmt.Sprintf("%s-%04d", group["baseInstanceName"], f.hosts+1)
+		f.groups[i["name"].(string)] = name
+		f.insert("zones/"+testZone+"/instances", i)
+	}
+	group["targetSize"] = float64(size)
+}
+
+// names returns the names of the items of collection.
+func (f *fakeCompute) names(collection string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, item := range f.items[collection] {
+		names = append(names, item["name"].(string))
+	}
+	return names
+}
+
+// userData returns the user data of the instances and templates.
+func (f *fakeCompute) userData() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var all []string
+	for _, collection := range []string{"zones/" + testZone + "/instances", "global/instanceTemplates"} {
+		for _, item := range f.items[collection] {
+			props := item
+			if p, ok := item["properties"].(map[string]interface{}); ok {
+				props = p
+			}
+			for _, m := range props["metadata"].(map[string]interface{})["items"].([]interface{}) {
+				all = append(all, m.(map[string]interface{})["value"].(string))
+			}
+		}
+	}
+	return all
+}
+
+// recorder is a driver.Runner recording the commands it is given.
+type recorder struct {
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (r *recorder) Run(name string, args ...string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, append([]string{name}, args...))
+	return "", nil
+}
+
+// copied returns the files copied with scp to each host.
+func (r *recorder) copied(pkiDir string) map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := make(map[string][]string)
+	for _, call := range r.calls {
+		if call[0] != "scp" {
+			continue
+		}
+		target := call[len(call)-1]
+		host := target[strings.Index(target, "@")+1 : strings.Index(target, ":")]
+		for _, arg := range call[1 : len(call)-1] {
+			if filepath.Dir(arg) == pkiDir {
+				copied[host] = append(copied[host], filepath.Base(arg))
+			}
+		}
+	}
+	files := make(map[string]string)
+	for host, names := range copied {
+		sort.Strings(names)
+		files[host] = strings.Join(names, " ")
+	}
+	return files
+}
+
+func newTestDriver(t *testing.T, f *fakeCompute, options map[string]string) (*Driver, *recorder, func()) {
+	dir, err := ioutil.TempDir("", "gce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := driver.OpenJournal(filepath.Join(dir, "journal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := map[string]string{
+		optProject: testProject,
+		optZone:    testZone,
+		optImage:   "projects/p/global/images/k8s",
+		optBaseURL: f.URL,
+	}
+	for k, v := range options {
+		opts[k] = v
+	}
+	r := &recorder{}
+	d, err := New(&driver.Config{
+		Name:      "dev",
+		StorePath: dir,
+		Nodes:     2,
+		Options:   opts,
+		Journal:   j,
+		PKI:       pki.New(filepath.Join(dir, "pki"), pki.Options{Algorithm: pki.ECDSA}),
+		Runner:    r,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.(*Driver), r, func() { os.RemoveAll(dir) }
+}
+
+func TestLifecycle(t *testing.T) {
+	f := newFakeCompute(t)
+	defer f.Close()
+	d, r, cleanup := newTestDriver(t, f, nil)
+	defer cleanup()
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	for collection, want := range map[string]string{
+		"global/networks":               "dev-network",
+		"global/firewalls":              "dev-internal dev-external",
+		"global/instanceTemplates":      "dev-default-template",
+		"zones/z/instanceGroupManagers": "dev-default",
+		"zones/z/instances":             "dev-master dev-default-0002 dev-default-0003",
+	} {
+		if got := strings.Join(f.names(collection), " "); got != want {
+			t.Errorf("got %s %s, want %s", collection, got, want)
+		}
+	}
+	for _, data := range f.userData() {
+		if strings.Contains(data, ".key") || strings.Contains(data, "PRIVATE KEY") {
+			t.Errorf("user data holds a key:\n%s", data)
+		}
+	}
+	if url, err := d.GetURL(); err != nil || url != "https://203.0.113.1:6443" {
+		t.Errorf("got URL %q, %v", url, err)
+	}
+	if st, err := d.Status(); err != nil || st != driver.Running {
+		t.Errorf("got status %v, %v", st, err)
+	}
+	if len(r.calls) != 0 {
+		t.Errorf("certificates copied before they were issued: %v", r.calls)
+	}
+
+	if err := d.config.PKI.Ensure("dev", pki.SANs{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InstallCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	pkiDir := filepath.Dir(d.config.PKI.CertPath(pki.CA))
+	want := map[string]string{
+		"203.0.113.1": "apiserver.crt apiserver.key ca.crt",
+		"203.0.113.2": "ca.crt node.crt node.key",
+		"203.0.113.3": "ca.crt node.crt node.key",
+	}
+	copied := r.copied(pkiDir)
+	for host, files := range want {
+		if copied[host] != files {
+			t.Errorf("copied %s to %s, want %s", copied[host], host, files)
+		}
+	}
+
+	// nodes added once the certificates exist get them at once
+	if err := d.Scale(3); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.copied(pkiDir)["203.0.113.4"]; got != "ca.crt node.crt node.key" {
+		t.Errorf("copied %q to the added node", got)
+	}
+	if err := d.Scale(1); err != nil {
+		t.Fatal(err)
+	}
+	if nodes, err := d.Nodes(); err != nil || len(nodes) != 1 || nodes[0].Name != "dev-default-0002" {
+		t.Errorf("got nodes %v, %v", nodes, err)
+	}
+
+	if err := d.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := d.Status(); err != nil || st != driver.Stopped {
+		t.Errorf("got status %v, %v after stopping", st, err)
+	}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := d.Status(); err != nil || st != driver.Running {
+		t.Errorf("got status %v, %v after starting", st, err)
+	}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	for _, collection := range collections {
+		if left := f.names(collection); len(left) != 0 {
+			t.Errorf("left %s %v", collection, left)
+		}
+	}
+	if st, err := d.Status(); err != nil || st != driver.None {
+		t.Errorf("got status %v, %v once removed", st, err)
+	}
+}
+
+func TestRemoveKeepsOtherEnvironments(t *testing.T) {
+	f := newFakeCompute(t)
+	defer f.Close()
+	d, _, cleanup := newTestDriver(t, f, nil)
+	defer cleanup()
+
+	f.mu.Lock()
+	f.insert("global/networks", map[string]interface{}{"name": "prod-network", "description": labelEnvironment + "=prod"})
+	f.insert("zones/z/instances", map[string]interface{}{"name": "prod-master", "labels": map[string]string{labelEnvironment: "prod"}})
+	f.mu.Unlock()
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.names("global/networks"); len(got) != 1 || got[0] != "prod-network" {
+		t.Errorf("got networks %v", got)
+	}
+	if got := f.names("zones/z/instances"); len(got) != 1 || got[0] != "prod-master" {
+		t.Errorf("got instances %v", got)
+	}
+}
+
+// TestRemoveRecorded checks the resources recorded in the journal are removed
+// even when their labels and descriptions do not tell they are the
+// environment ones.
+func TestRemoveRecorded(t *testing.T) {
+	f := newFakeCompute(t)
+	defer f.Close()
+	d, _, cleanup := newTestDriver(t, f, nil)
+	defer cleanup()
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	for _, collection := range collections {
+		for _, item := range f.items[collection] {
+			delete(item, "description")
+			delete(item, "labels")
+			if props, ok := item["properties"].(map[string]interface{}); ok {
+				delete(props, "labels")
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	for _, collection := range collections {
+		if left := f.names(collection); len(left) != 0 {
+			t.Errorf("left %s %v", collection, left)
+		}
+	}
+}
+
+func TestServiceAccount(t *testing.T) {
+	f := newFakeCompute(t)
+	defer f.Close()
+	f.token = "t0ken"
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokenRequests int
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		if err := checkAssertion(r, &key.PublicKey); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": f.token, "expires_in": 3600})
+	}))
+	defer tokens.Close()
+
+	dir, err := ioutil.TempDir("", "gce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	credentials := filepath.Join(dir, "key.json")
+	data, err := json.Marshal(serviceAccount{
+		ClientEmail:  "kube-cluster@p.iam.gserviceaccount.com",
+		PrivateKeyID: "k1",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		TokenURI:     tokens.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(credentials, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, _, cleanup := newTestDriver(t, f, map[string]string{optCredentials: credentials})
+	defer cleanup()
+	for i := 0; i < 2; i++ {
+		if st, err := d.Status(); err != nil || st != driver.None {
+			t.Fatalf("got status %v, %v", st, err)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("got %d token requests, want the token cached", tokenRequests)
+	}
+
+	f.mu.Lock()
+	f.token = "other"
+	f.mu.Unlock()
+	_, err = d.Status()
+	if e, ok := err.(*APIError); !ok || e.Code != http.StatusUnauthorized || e.Message != "no valid token" {
+		t.Errorf("got %#v", err)
+	}
+}
+
+// checkAssertion checks the JWT bearer grant of r is signed by key, for the
+// compute scope.
+func checkAssertion(r *http.Request, key *rsa.PublicKey) error {
+	if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		return fmt.Errorf("got grant %q", r.FormValue("grant_type"))
+	}
+	parts := strings.Split(r.FormValue("assertion"), ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("got assertion %q", r.FormValue("assertion"))
+	}
+	enc := base64.RawURLEncoding
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return err
+	}
+	var claims struct {
+		Scope string `json:"scope"`
+		Aud   string `json:"aud"`
+	}
+	data, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return err
+	}
+	if claims.Scope != computeScope || claims.Aud != "http://"+r.Host {
+		return fmt.Errorf("got claims %+v", claims)
+	}
+	return nil
+}
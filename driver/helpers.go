@@ -0,0 +1,101 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidateDuration validates options holding a duration, such as "10m".
+func ValidateDuration(value string) error {
+	if _, err := time.ParseDuration(value); err != nil {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	return nil
+}
+
+// NotFound reports whether err is a command failing with one of messages on
+// its standard error, those the tool prints for missing objects.
+func NotFound(err error, messages ...string) bool {
+	e, ok := err.(*CommandError)
+	if !ok {
+		return false
+	}
+	for _, m := range messages {
+		if strings.Contains(e.Stderr, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// States maps the states a provider reports for its machines to driver
+// States.
+type States map[string]State
+
+// Of returns the State of a machine in state s: None for the empty state of
+// missing machines, and Error for states m does not know.
+func (m States) Of(s string) State {
+	if st, ok := m[s]; ok {
+		return st
+	}
+	if s == "" {
+		return None
+	}
+	return Error
+}
+
+// ParseFields parses lines of key and value pairs separated by sep, as
+// printed by VBoxManage or virsh, unquoting them.
+func ParseFields(out, sep string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		i := strings.Index(line, sep)
+		if i < 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+len(sep):])
+		fields[unquote(key)] = unquote(value)
+	}
+	return fields
+}
+
+func unquote(s string) string {
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// NthIP returns the ith address of the IPv4 network n.
+func NthIP(n *net.IPNet, i int) net.IP {
+	ip := append(net.IP(nil), n.IP.To4()...)
+	for b := 3; b >= 0 && i > 0; b-- {
+		sum := int(ip[b]) + i
+		ip[b] = byte(sum % 256)
+		i = sum / 256
+	}
+	return ip
+}
+
+// LastHost returns the index of the last host address of the IPv4 network n.
+func LastHost(n *net.IPNet) int {
+	ones, bits := n.Mask.Size()
+	return 1<<uint(bits-ones) - 2
+}
@@ -0,0 +1,94 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestValidateDuration(t *testing.T) {
+	if err := ValidateDuration("5m"); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateDuration("5"); err == nil {
+		t.Error("accepted a duration without unit")
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	missing := &CommandError{Stderr: "error: failed to get domain 'dev-master'"}
+	if !NotFound(missing, "not found", "failed to get") {
+		t.Error("missed a missing domain")
+	}
+	if NotFound(&CommandError{Stderr: "error: permission denied"}, "not found") {
+		t.Error("took another failure for a missing object")
+	}
+	if NotFound(errors.New("failed to get"), "failed to get") {
+		t.Error("took an error other than a CommandError for a missing object")
+	}
+}
+
+func TestStates(t *testing.T) {
+	states := States{"running": Running, "shut off": Stopped}
+	for s, want := range map[string]State{
+		"running":  Running,
+		"shut off": Stopped,
+		"":         None,
+		"crashed":  Error,
+	} {
+		if got := states.Of(s); got != want {
+			t.Errorf("%q is %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	fields := ParseFields("name=\"dev-master\"\nVMState=\"running\"\n\"SATA-0-0\"=\"/vms/dev.vdi\"\nno separator\n", "=")
+	for key, want := range map[string]string{"name": "dev-master", "VMState": "running", "SATA-0-0": "/vms/dev.vdi"} {
+		if got := fields[key]; got != want {
+			t.Errorf("%s is %q, want %q", key, got, want)
+		}
+	}
+	if len(fields) != 3 {
+		t.Errorf("got fields %v", fields)
+	}
+	if got := ParseFields("Active:         yes\nUUID: 8a:b\n", ":"); got["Active"] != "yes" || got["UUID"] != "8a:b" {
+		t.Errorf("got fields %v", got)
+	}
+}
+
+func TestNthIP(t *testing.T) {
+	for _, c := range []struct {
+		cidr     string
+		i        int
+		ip, last string
+	}{
+		{"192.168.124.0/24", 100, "192.168.124.100", "192.168.124.254"},
+		{"10.1.0.0/20", 300, "10.1.1.44", "10.1.15.254"},
+	} {
+		_, n, err := net.ParseCIDR(c.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := NthIP(n, c.i).String(); got != c.ip {
+			t.Errorf("address %d of %s is %s, want %s", c.i, c.cidr, got, c.ip)
+		}
+		if got := NthIP(n, LastHost(n)).String(); got != c.last {
+			t.Errorf("last host of %s is %s, want %s", c.cidr, got, c.last)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+// StepStatus is the progress of a journal step.
+type StepStatus string
+
+const (
+	StepRunning StepStatus = "running"
+	StepDone    StepStatus = "done"
+	StepFailed  StepStatus = "failed"
+)
+
+// Resource identifies something a step created, such as a machine or a
+// network.
+type Resource struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+}
+
+// Step is an entry of a Journal.
+type Step struct {
+	Name      string     `json:"name"`
+	Status    StepStatus `json:"status"`
+	Resources []Resource `json:"resources,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Started   time.Time  `json:"started"`
+	Ended     time.Time  `json:"ended,omitempty"`
+}
+
+// Journal records the steps of an operation on an environment, and the
+// resources they created, in a file of the environment directory. An
+// interrupted operation resumes by running its steps again: those already
+// done are skipped. The recorded resources let drivers clean up after
+// operations that never completed.
+//
+// A nil *Journal records nothing, and runs every step.
+type Journal struct {
+	path string
+	mu   sync.Mutex
+
+	Operation string     `json:"operation"`
+	Started   time.Time  `json:"started"`
+	Completed *time.Time `json:"completed,omitempty"`
+	Steps     []*Step    `json:"steps"`
+}
+
+// OpenJournal loads the journal persisted at path, or returns an empty one if
+// there is none yet.
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("%s: corrupt journal: %v", path, err)
+	}
+	return j, nil
+}
+
+// Begin starts recording operation, unless the journal already records it.
+func (j *Journal) Begin(operation string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.Operation == operation && j.Completed == nil {
+		return nil
+	}
+	j.Operation, j.Started, j.Completed, j.Steps = operation, time.Now().UTC(), nil, nil
+	return j.save()
+}
+
+// Finish marks the operation completed.
+func (j *Journal) Finish() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now().UTC()
+	j.Completed = &now
+	return j.save()
+}
+
+// Pending reports whether an operation began and did not complete.
+func (j *Journal) Pending() bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.Operation != "" && j.Completed == nil
+}
+
+// Failed returns the last failed step, or nil.
+func (j *Journal) Failed() *Step {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for i := len(j.Steps) - 1; i >= 0; i-- {
+		if j.Steps[i].Status == StepFailed {
+			return j.Steps[i]
+		}
+	}
+	return nil
+}
+
+// Run runs the named step, unless it is already done. fn records the
+// resources it creates with record as soon as they exist, so they are known
+// even if fn does not return. record is safe for concurrent use.
+func (j *Journal) Run(name string, fn func(record func(kind, id string)) error) error {
+	if j == nil {
+		return fn(func(kind, id string) {})
+	}
+
+	j.mu.Lock()
+	s := j.step(name)
+	if s.Status == StepDone {
+		j.mu.Unlock()
+		return nil
+	}
+	s.Status, s.Error, s.Started, s.Ended = StepRunning, "", time.Now().UTC(), time.Time{}
+	err := j.save()
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = fn(func(kind, id string) {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		for _, r := range s.Resources {
+			if r.Kind == kind && r.ID == id {
+				return
+			}
+		}
+		s.Resources = append(s.Resources, Resource{Kind: kind, ID: id})
+		j.save()
+	})
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s.Status, s.Ended = StepDone, time.Now().UTC()
+	if err != nil {
+		s.Status, s.Error = StepFailed, err.Error()
+	}
+	if saveErr := j.save(); saveErr != nil && err == nil {
+		return saveErr
+	}
+	return err
+}
+
+// Resources returns the resources recorded by all steps, in creation order,
+// once each.
+func (j *Journal) Resources() []Resource {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var resources []Resource
+	seen := make(map[Resource]bool)
+	for _, s := range j.Steps {
+		for _, r := range s.Resources {
+			if !seen[r] {
+				seen[r] = true
+				resources = append(resources, r)
+			}
+		}
+	}
+	return resources
+}
+
+// step returns the named step, adding it if needed.
+func (j *Journal) step(name string) *Step {
+	for _, s := range j.Steps {
+		if s.Name == name {
+			return s
+		}
+	}
+	s := &Step{Name: name}
+	j.Steps = append(j.Steps, s)
+	return s
+}
+
+// save persists the journal, replacing the file atomically. Journals without
+// a path, not opened with OpenJournal, are kept in memory only.
+func (j *Journal) save() error {
+	if j.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return err
+	}
+	return envstore.WriteFileAtomic(j.path, data, 0600)
+}
@@ -0,0 +1,172 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func tempJournal(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "env", "journal.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestJournal(t *testing.T) {
+	path, cleanup := tempJournal(t)
+	defer cleanup()
+
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Pending() {
+		t.Error("an empty journal is pending")
+	}
+	if err := j.Begin("create"); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := 0
+	step := func(record func(kind, id string)) error {
+		ran++
+		record("vm", "dev-master")
+		record("vm", "dev-master")
+		return nil
+	}
+	if err := j.Run("create master", step); err != nil {
+		t.Fatal(err)
+	}
+	fail := errors.New("quota exceeded")
+	err = j.Run("create nodes", func(record func(kind, id string)) error {
+		record("vm", "dev-node-1")
+		record("vm", "dev-master")
+		return fail
+	})
+	if err != fail {
+		t.Fatalf("got %v, want the step error", err)
+	}
+
+	// an interrupted operation resumes from the file
+	j, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.Pending() || j.Operation != "create" {
+		t.Errorf("got operation %q, pending %v", j.Operation, j.Pending())
+	}
+	if s := j.Failed(); s == nil || s.Name != "create nodes" || s.Error != "quota exceeded" {
+		t.Errorf("got failed step %+v", s)
+	}
+	want := []Resource{{"vm", "dev-master"}, {"vm", "dev-node-1"}}
+	if got := j.Resources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got resources %v, want %v", got, want)
+	}
+
+	if err := j.Begin("create"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Run("create master", step); err != nil || ran != 1 {
+		t.Errorf("done step ran again: %v, %d runs", err, ran)
+	}
+	if err := j.Run("create nodes", func(record func(kind, id string)) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if s := j.Failed(); s != nil {
+		t.Errorf("got failed step %+v after the retry", s)
+	}
+	if err := j.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if j.Pending() {
+		t.Error("a finished journal is pending")
+	}
+
+	// another operation starts over
+	if err := j.Begin("scale"); err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Steps) != 0 || j.Resources() != nil {
+		t.Errorf("got steps %v from the previous operation", j.Steps)
+	}
+}
+
+func TestNilJournal(t *testing.T) {
+	var j *Journal
+	if err := j.Begin("create"); err != nil {
+		t.Error(err)
+	}
+	ran := false
+	if err := j.Run("step", func(record func(kind, id string)) error {
+		ran = true
+		record("vm", "dev")
+		return nil
+	}); err != nil || !ran {
+		t.Errorf("got %v, ran %v", err, ran)
+	}
+	if j.Pending() || j.Failed() != nil || j.Resources() != nil || j.Finish() != nil {
+		t.Error("a nil journal records something")
+	}
+}
+
+func TestJournalWithoutPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	j := &Journal{}
+	if err := j.Run("step", func(record func(kind, id string)) error {
+		record("vm", "dev")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := j.Resources(); len(got) != 1 {
+		t.Errorf("got resources %v", got)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("a journal without path wrote %s", files[0].Name())
+	}
+}
+
+func TestCorruptJournal(t *testing.T) {
+	path, cleanup := tempJournal(t)
+	defer cleanup()
+
+	os.MkdirAll(filepath.Dir(path), 0700)
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournal(path); err == nil {
+		t.Error("got no error")
+	}
+}
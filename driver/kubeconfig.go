@@ -0,0 +1,61 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/gerred/kube-cluster/pki"
+)
+
+// AdminKubeconfig returns a kubeconfig granting access to the API server at
+// server with the admin certificate of c.PKI. The certificates are embedded,
+// so the file can be copied around.
+func AdminKubeconfig(c *Config, server string) ([]byte, error) {
+	if c.PKI == nil {
+		return nil, fmt.Errorf("environment %q has no certificates", c.Name)
+	}
+
+	var data [3]string
+	for i, path := range []string{c.PKI.CertPath(pki.CA), c.PKI.CertPath(pki.Admin), c.PKI.KeyPath(pki.Admin)} {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data[i] = base64.StdEncoding.EncodeToString(b)
+	}
+
+	return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: %[1]s
+  cluster:
+    server: %[2]s
+    certificate-authority-data: %[3]s
+users:
+- name: %[1]s
+  user:
+    client-certificate-data: %[4]s
+    client-key-data: %[5]s
+contexts:
+- name: %[1]s
+  context:
+    cluster: %[1]s
+    user: %[1]s
+current-context: %[1]s
+`, c.Name, server, data[0], data[1], data[2])), nil
+}
@@ -0,0 +1,1045 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kvm implements a driver running kubernetes environments on KVM
+// virtual machines, managed through libvirt with virsh.
+//
+// Every environment gets a NAT network, kube-cluster-NAME, and a copy of the
+// kvm-image qcow2 image in the kvm-pool storage pool. The disk of each machine
+// is a volume backed by that copy, and the image is expected to set kubernetes
+// up from the cloud-init user data found on a second volume, a NoCloud ISO
+// built with genisoimage. The machines get their certificates over SSH once
+// they are issued.
+//
+// The libvirt definitions are kept in the environment directory, next to the
+// state, and virsh is run through Config.Runner.
+package kvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+const (
+	driverName    = "kvm"
+	stateFileName = "kvm-state.json"
+	defaultPool   = "default"
+
+	optURI         = "kvm-uri"
+	optImage       = "kvm-image"
+	optPool        = "kvm-pool"
+	optCPUs        = "kvm-cpus"
+	optMemory      = "kvm-memory"
+	optDiskSize    = "kvm-disk-size"
+	optNetworkCIDR = "kvm-network-cidr"
+	optBootTimeout = "kvm-boot-timeout"
+	optVirsh       = "kvm-virsh"
+	optGenisoimage = "kvm-genisoimage"
+
+	apiServerPort = 6443
+)
+
+func init() {
+	driver.Register(driverName, New,
+		driver.Option{
+			Name:    optURI,
+			Usage:   "libvirt connection URI",
+			Default: "qemu:///system",
+		},
+		driver.Option{
+			Name:     optImage,
+			Usage:    "qcow2 image the KVM machines boot, set up with kubernetes and cloud-init",
+			Prompt:   "Image file",
+			Required: true,
+		},
+		driver.Option{
+			Name:    optPool,
+			Usage:   "libvirt storage pool holding the volumes of the KVM machines",
+			Default: "default",
+		},
+		driver.Option{
+			Name:    optCPUs,
+			Usage:   "number of CPUs of each KVM machine",
+			Default: "1",
+			Type:    driver.IntOption,
+			Prompt:  "CPUs per machine",
+		},
+		driver.Option{
+			Name:    optMemory,
+			Usage:   "memory of each KVM machine, in MB",
+			Default: "1024",
+			Type:    driver.IntOption,
+			Prompt:  "Memory per machine (MB)",
+		},
+		driver.Option{
+			Name:    optDiskSize,
+			Usage:   "disk size of each KVM machine, in GB",
+			Default: "20",
+			Type:    driver.IntOption,
+		},
+		driver.Option{
+			Name:     optNetworkCIDR,
+			Usage:    "host address and network of the libvirt NAT network of the environment, a free 192.168.N.1/24 by default",
+			Validate: validateNetworkCIDR,
+		},
+		driver.Option{
+			Name:     optBootTimeout,
+			Usage:    "how long to wait for KVM machines to answer on SSH once started",
+			Default:  "5m",
+			Validate: driver.ValidateDuration,
+		},
+		driver.Option{
+			Name:    optVirsh,
+			Usage:   "virsh command",
+			Default: "virsh",
+		},
+		driver.Option{
+			Name:    optGenisoimage,
+			Usage:   "genisoimage command, or a compatible one such as mkisofs, building the cloud-init ISOs",
+			Default: "genisoimage",
+		},
+	)
+}
+
+func validateNetworkCIDR(value string) error {
+	ip, n, err := net.ParseCIDR(value)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("invalid IPv4 CIDR %q", value)
+	}
+	if ones, _ := n.Mask.Size(); ones > 24 {
+		return fmt.Errorf("network %s is too small, use at most a /24", n)
+	}
+	if ip.Equal(n.IP) {
+		return fmt.Errorf("%s is the network address, give the host one, e.g. %s", ip, driver.NthIP(n, 1))
+	}
+	return nil
+}
+
+// waitForSSH is replaced in tests, where no machine ever boots.
+var waitForSSH = driver.WaitForSSH
+
+// vm is a virtual machine of the environment.
+type vm struct {
+	Name string `json:"name"`
+	Pool string `json:"pool,omitempty"`
+	IP   string `json:"ip,omitempty"`
+}
+
+type state struct {
+	// Base is the path of the volume holding the image copy.
+	Base   string `json:"base,omitempty"`
+	Master *vm    `json:"master,omitempty"`
+	Nodes  []vm   `json:"nodes"`
+}
+
+// Driver manages an environment made of KVM virtual machines.
+type Driver struct {
+	name      string
+	storePath string
+	pools     []driver.Pool
+	config    *driver.Config
+
+	uri         string
+	image       string
+	pool        string
+	cpus        int
+	memory      int
+	diskSize    int
+	networkCIDR string
+	bootTimeout time.Duration
+	virsh       string
+	genisoimage string
+
+	// mu guards the state while machines are provisioned concurrently.
+	mu sync.Mutex
+}
+
+// New instantiates a KVM driver.
+func New(c *driver.Config) (driver.Driver, error) {
+	ints := make(map[string]int)
+	for opt, def := range map[string]string{optCPUs: "1", optMemory: "1024", optDiskSize: "20"} {
+		v, err := strconv.Atoi(c.Option(opt, def))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s: %v", opt, err)
+		}
+		ints[opt] = v
+	}
+
+	bootTimeout, err := time.ParseDuration(c.Option(optBootTimeout, "5m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optBootTimeout, err)
+	}
+	cidr := c.Option(optNetworkCIDR, "")
+	if cidr != "" {
+		if err := validateNetworkCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid --%s: %v", optNetworkCIDR, err)
+		}
+	}
+
+	pools := c.Pools
+	if len(pools) == 0 {
+		pools = []driver.Pool{{Name: defaultPool, Nodes: c.Nodes}}
+	}
+
+	return &Driver{
+		name:      c.Name,
+		storePath: c.StorePath,
+		pools:     pools,
+		config:    c,
+
+		uri:         c.Option(optURI, "qemu:///system"),
+		image:       c.Option(optImage, ""),
+		pool:        c.Option(optPool, "default"),
+		cpus:        ints[optCPUs],
+		memory:      ints[optMemory],
+		diskSize:    ints[optDiskSize],
+		networkCIDR: cidr,
+		bootTimeout: bootTimeout,
+		virsh:       c.Option(optVirsh, "virsh"),
+		genisoimage: c.Option(optGenisoimage, "genisoimage"),
+	}, nil
+}
+
+// Create sets up the network and uploads the image, then creates the master,
+// then the nodes of every pool, a journal step each. Machines left over from a
+// previous attempt are reused.
+func (d *Driver) Create() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+
+	err = d.config.Journal.Run("create network", func(record func(kind, id string)) error {
+		record("network", d.networkName())
+		return d.createNetwork()
+	})
+	if err != nil {
+		return err
+	}
+
+	err = d.config.Journal.Run("upload image", func(record func(kind, id string)) error {
+		if s.Base != "" {
+			return nil
+		}
+		record("volume", d.baseVolume())
+		path, err := d.uploadImage()
+		if err != nil {
+			return err
+		}
+		s.Base = path
+		return d.save(s)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = d.config.Journal.Run("create master", func(record func(kind, id string)) error {
+		if s.Master == nil {
+			s.Master = &vm{Name: d.name + "-master"}
+			if err := d.save(s); err != nil {
+				return err
+			}
+		}
+		record("domain", s.Master.Name)
+		return driver.ForEachNode(d.config, "creating", []string{s.Master.Name}, func(string) error {
+			return d.provision(s, s.Master, driver.MasterRole, nil)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range d.pools {
+		p := p
+		err := d.config.Journal.Run("create node pool "+p.Name, func(record func(kind, id string)) error {
+			return d.resize(s, p, record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes the machines of the environment with their volumes, the
+// image copy and the network. Resources recorded by an interrupted creation
+// are removed as well.
+func (d *Driver) Remove() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, v := range s.vms() {
+		names = append(names, v.Name)
+	}
+	for _, r := range d.config.Journal.Resources() {
+		if r.Kind == "domain" {
+			names = append(names, r.ID)
+		}
+	}
+
+	removed := make(map[string]bool)
+	for _, name := range names {
+		if removed[name] {
+			continue
+		}
+		removed[name] = true
+		if err := d.removeVM(name); err != nil {
+			return err
+		}
+	}
+	if err := d.removeVolume(d.baseVolume()); err != nil {
+		return err
+	}
+	if err := d.removeNetwork(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(d.statePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Start boots the stopped machines, and waits for them to be reachable.
+func (d *Driver) Start() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	vms := make(map[string]*vm)
+	for _, v := range s.vms() {
+		vms[v.Name] = v
+	}
+	err = driver.ForEachNode(d.config, "starting", names(s.vms()), func(name string) error {
+		v := vms[name]
+		st, err := d.domState(v.Name)
+		if err != nil {
+			return err
+		}
+		if st != "running" {
+			if _, err := d.run("start", v.Name); err != nil {
+				return err
+			}
+		}
+		ip, err := d.waitForBoot(v.Name)
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		v.IP = ip
+		d.mu.Unlock()
+		return nil
+	})
+	if saveErr := d.save(s); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// Stop shuts the machines down, destroying those ignoring the ACPI shutdown
+// request.
+func (d *Driver) Stop() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	return driver.ForEachNode(d.config, "stopping", names(s.vms()), d.stopVM)
+}
+
+// Status aggregates the state of the machines: the environment is Running or
+// Stopped when all of them are, in Error otherwise.
+func (d *Driver) Status() (driver.State, error) {
+	s, err := d.load()
+	if err != nil {
+		return driver.Error, err
+	}
+	if s.Master == nil {
+		return driver.None, nil
+	}
+
+	states := make(map[driver.State]bool)
+	for _, v := range s.vms() {
+		st, err := d.domState(v.Name)
+		if err != nil {
+			return driver.Error, err
+		}
+		states[domStates.Of(st)] = true
+	}
+	if len(states) == 1 {
+		for st := range states {
+			return st, nil
+		}
+	}
+	return driver.Error, nil
+}
+
+// GetURL returns the API server address, on the master network address.
+func (d *Driver) GetURL() (string, error) {
+	s, err := d.load()
+	if err != nil {
+		return "", err
+	}
+	if s.Master == nil || s.Master.IP == "" {
+		return "", fmt.Errorf("kvm: the master of environment %q has no address", d.name)
+	}
+	return fmt.Sprintf("https://%s", net.JoinHostPort(s.Master.IP, strconv.Itoa(apiServerPort))), nil
+}
+
+// GetKubeconfig returns an admin kubeconfig for the API server.
+func (d *Driver) GetKubeconfig() ([]byte, error) {
+	url, err := d.GetURL()
+	if err != nil {
+		return nil, err
+	}
+	return driver.AdminKubeconfig(d.config, url)
+}
+
+// Scale adds nodes to the first pool, or removes the most recent nodes.
+func (d *Driver) Scale(nodes int) error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	if nodes <= len(s.Nodes) {
+		for len(s.Nodes) > nodes {
+			if err := d.removeNode(s, len(s.Nodes)-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	p := d.pools[0]
+	p.Nodes = len(s.poolNodes(p.Name)) + nodes - len(s.Nodes)
+	return d.resize(s, p, func(kind, id string) {})
+}
+
+// ScalePool adds or removes machines of a pool.
+func (d *Driver) ScalePool(pool driver.Pool) error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	return d.resize(s, pool, func(kind, id string) {})
+}
+
+// Nodes returns the worker machines, the master not being a schedulable node.
+func (d *Driver) Nodes() ([]driver.Node, error) {
+	s, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]driver.Node, len(s.Nodes))
+	for i, n := range s.Nodes {
+		st, err := d.domState(n.Name)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = driver.Node{Name: n.Name, Pool: n.pool(), Address: n.IP, State: domStates.Of(st)}
+	}
+	return nodes, nil
+}
+
+// resize adds or removes machines of pool until it holds pool.Nodes. The most
+// recent machines are removed first, new ones are provisioned concurrently.
+// Machines failing to provision are kept, so that retrying reuses them.
+func (d *Driver) resize(s *state, pool driver.Pool, record func(kind, id string)) error {
+	for excess := len(s.poolNodes(pool.Name)) - pool.Nodes; excess > 0; excess-- {
+		for i := len(s.Nodes) - 1; i >= 0; i-- {
+			if s.Nodes[i].pool() == pool.Name {
+				if err := d.removeNode(s, i); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, n := range s.Nodes {
+		used[n.Name] = true
+	}
+	for i := 1; len(s.poolNodes(pool.Name)) < pool.Nodes; i++ {
+		v := vm{Name: fmt.Sprintf("%s-node-%d", d.name, i)}
+		if pool.Name != defaultPool {
+			v.Name = fmt.Sprintf("%s-%s-node-%d", d.name, pool.Name, i)
+			v.Pool = pool.Name
+		}
+		if !used[v.Name] {
+			s.Nodes = append(s.Nodes, v)
+		}
+	}
+	if err := d.save(s); err != nil {
+		return err
+	}
+
+	// provision the machines of the pool without an address yet, those
+	// left over from an interrupted attempt included
+	var pending []string
+	for _, n := range s.Nodes {
+		if n.pool() == pool.Name && n.IP == "" {
+			record("domain", n.Name)
+			pending = append(pending, n.Name)
+		}
+	}
+	err := driver.ForEachNode(d.config, "creating", pending, func(name string) error {
+		return d.provision(s, s.node(name), driver.NodeRole, pool.Labels)
+	})
+	if saveErr := d.save(s); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// provision defines the machine v unless it exists, with its disk and
+// cloud-init volumes, boots it, and waits for it to be reachable.
+func (d *Driver) provision(s *state, v *vm, role string, labels map[string]string) error {
+	st, err := d.domState(v.Name)
+	if err != nil {
+		return err
+	}
+	if st == "" {
+		b := driver.BootConfig{Role: role, Name: v.Name, Labels: labels}
+		if role == driver.NodeRole {
+			b.Pool, b.Master = v.pool(), s.Master.IP
+		}
+		if err := d.define(s, v.Name, b); err != nil {
+			return err
+		}
+	}
+	if st != "running" {
+		if _, err := d.run("start", v.Name); err != nil {
+			return err
+		}
+	}
+
+	ip, err := d.waitForBoot(v.Name)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	v.IP = ip
+	err = d.save(s)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// machines added to a created environment get their certificates
+	// right away, the others once they are issued
+	if d.config.HasCertificates(role) {
+		return d.config.CopyCertificates(ip, role)
+	}
+	return nil
+}
+
+// InstallCertificates copies the certificates of every machine to it, over
+// SSH, see driver.CopyCertificates.
+func (d *Driver) InstallCertificates() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	vms := make(map[string]*vm)
+	for _, v := range s.vms() {
+		vms[v.Name] = v
+	}
+	return driver.ForEachNode(d.config, "installing certificates", names(s.vms()), func(name string) error {
+		role := driver.NodeRole
+		if vms[name] == s.Master {
+			role = driver.MasterRole
+		}
+		return d.config.CopyCertificates(vms[name].IP, role)
+	})
+}
+
+// define creates the volumes of the machine name, then defines its domain.
+func (d *Driver) define(s *state, name string, b driver.BootConfig) error {
+	diskVolume, isoVolume := name+".qcow2", name+"-cidata.iso"
+
+	exists, err := d.volumeExists(diskVolume)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		def, err := volumeXML(diskVolume, d.diskSize, s.Base)
+		if err != nil {
+			return err
+		}
+		path, err := d.writeDefinition(diskVolume, def)
+		if err != nil {
+			return err
+		}
+		if _, err := d.run("vol-create", d.pool, path); err != nil {
+			return err
+		}
+	}
+
+	iso, err := d.cloudInitISO(name, b)
+	if err != nil {
+		return err
+	}
+	if err := d.removeVolume(isoVolume); err != nil {
+		return err
+	}
+	if err := d.upload(isoVolume, iso, "raw"); err != nil {
+		return err
+	}
+
+	def, err := domainXML(domainSpec{
+		Name:      name,
+		CPUs:      d.cpus,
+		MemoryMB:  d.memory,
+		Pool:      d.pool,
+		Disk:      diskVolume,
+		CloudInit: isoVolume,
+		Network:   d.networkName(),
+	})
+	if err != nil {
+		return err
+	}
+	path, err := d.writeDefinition(name, def)
+	if err != nil {
+		return err
+	}
+	_, err = d.run("define", path)
+	return err
+}
+
+// cloudInitISO builds the NoCloud ISO configuring the machine name, and
+// returns its path.
+func (d *Driver) cloudInitISO(name string, b driver.BootConfig) (string, error) {
+	userData, err := d.config.CloudConfig(b)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(d.storePath, "cloud-init", name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", name, name)
+	if err := ioutil.WriteFile(filepath.Join(dir, "user-data"), userData, 0600); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "meta-data"), []byte(metaData), 0600); err != nil {
+		return "", err
+	}
+
+	iso := dir + ".iso"
+	_, err = d.config.Run(d.genisoimage, "-quiet", "-output", iso, "-volid", "cidata", "-joliet", "-rock",
+		filepath.Join(dir, "user-data"), filepath.Join(dir, "meta-data"))
+	if err != nil {
+		return "", err
+	}
+	return iso, nil
+}
+
+// createNetwork defines and starts the NAT network of the environment, unless
+// it exists.
+func (d *Driver) createNetwork() error {
+	out, err := d.run("net-info", d.networkName())
+	if err != nil && !driver.NotFound(err, virshNotFound...) {
+		return err
+	}
+	if err != nil {
+		cidr, err := d.networkAddress()
+		if err != nil {
+			return err
+		}
+		def, err := networkXML(d.networkName(), cidr)
+		if err != nil {
+			return err
+		}
+		path, err := d.writeDefinition(d.networkName(), def)
+		if err != nil {
+			return err
+		}
+		if _, err := d.run("net-define", path); err != nil {
+			return err
+		}
+	} else if driver.ParseFields(out, ":")["Active"] == "yes" {
+		return nil
+	}
+	if _, err := d.run("net-start", d.networkName()); err != nil {
+		return err
+	}
+	_, err = d.run("net-autostart", d.networkName())
+	return err
+}
+
+// networkAddress returns the host address and network of the network to
+// define: the kvm-network-cidr one, failing if a libvirt network overlaps it,
+// or else the first 192.168.N.1/24 from 192.168.124.1/24 on no libvirt
+// network overlaps, so that the networks of several environments do not
+// clash.
+func (d *Driver) networkAddress() (string, error) {
+	used, err := d.usedNetworks()
+	if err != nil {
+		return "", err
+	}
+	overlapping := func(n *net.IPNet) string {
+		for name, u := range used {
+			for _, u := range u {
+				if n.Contains(u.IP) || u.Contains(n.IP) {
+					return fmt.Sprintf("%s of libvirt network %s", u, name)
+				}
+			}
+		}
+		return ""
+	}
+
+	if d.networkCIDR != "" {
+		_, n, err := net.ParseCIDR(d.networkCIDR)
+		if err != nil {
+			return "", err
+		}
+		if other := overlapping(n); other != "" {
+			return "", fmt.Errorf("kvm: network %s overlaps %s, choose another --%s", n, other, optNetworkCIDR)
+		}
+		return d.networkCIDR, nil
+	}
+	for i := 124; i < 256; i++ {
+		n := &net.IPNet{IP: net.IPv4(192, 168, byte(i), 0).To4(), Mask: net.CIDRMask(24, 32)}
+		if overlapping(n) == "" {
+			return driver.NthIP(n, 1).String() + "/24", nil
+		}
+	}
+	return "", fmt.Errorf("kvm: libvirt networks overlap 192.168.124.0/24 to 192.168.255.0/24, choose a network with --%s", optNetworkCIDR)
+}
+
+// usedNetworks returns the IPv4 networks of the libvirt networks, by name.
+func (d *Driver) usedNetworks() (map[string][]*net.IPNet, error) {
+	out, err := d.run("net-list", "--all", "--name")
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string][]*net.IPNet)
+	for _, name := range strings.Fields(out) {
+		out, err := d.run("net-dumpxml", name)
+		if err != nil {
+			if driver.NotFound(err, virshNotFound...) {
+				continue
+			}
+			return nil, err
+		}
+		networks, err := parseNetworks([]byte(out))
+		if err != nil {
+			return nil, fmt.Errorf("kvm: network %s: %v", name, err)
+		}
+		used[name] = networks
+	}
+	return used, nil
+}
+
+// removeNetwork stops and undefines the network of the environment. A missing
+// network is ignored.
+func (d *Driver) removeNetwork() error {
+	out, err := d.run("net-info", d.networkName())
+	if err != nil {
+		if driver.NotFound(err, virshNotFound...) {
+			return nil
+		}
+		return err
+	}
+	if driver.ParseFields(out, ":")["Active"] == "yes" {
+		if _, err := d.run("net-destroy", d.networkName()); err != nil {
+			return err
+		}
+	}
+	_, err = d.run("net-undefine", d.networkName())
+	return err
+}
+
+// uploadImage copies the kvm-image file to the base volume, replacing a copy
+// left incomplete by an interrupted attempt, and returns the volume path.
+func (d *Driver) uploadImage() (string, error) {
+	if err := d.removeVolume(d.baseVolume()); err != nil {
+		return "", err
+	}
+	if err := d.upload(d.baseVolume(), d.image, "qcow2"); err != nil {
+		return "", err
+	}
+	out, err := d.run("vol-path", "--pool", d.pool, d.baseVolume())
+	return strings.TrimSpace(out), err
+}
+
+// upload creates the volume name, in the given format, with the content of
+// the local file path.
+func (d *Driver) upload(name, path, format string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if _, err := d.run("vol-create-as", d.pool, name, strconv.FormatInt(info.Size(), 10), "--format", format); err != nil {
+		return err
+	}
+	_, err = d.run("vol-upload", "--pool", d.pool, name, path)
+	return err
+}
+
+// volumeExists reports whether the pool has the volume name.
+func (d *Driver) volumeExists(name string) (bool, error) {
+	_, err := d.run("vol-info", "--pool", d.pool, name)
+	if err != nil {
+		if driver.NotFound(err, virshNotFound...) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// removeVolume deletes the volume name. Missing volumes are ignored.
+func (d *Driver) removeVolume(name string) error {
+	_, err := d.run("vol-delete", "--pool", d.pool, name)
+	if err != nil && driver.NotFound(err, virshNotFound...) {
+		return nil
+	}
+	return err
+}
+
+// waitForBoot waits for the machine name to be leased an address on the
+// network, then for SSH to answer on it.
+func (d *Driver) waitForBoot(name string) (string, error) {
+	deadline := time.Now().Add(d.bootTimeout)
+	for {
+		out, err := d.run("domifaddr", name)
+		if err != nil {
+			return "", err
+		}
+		if ip := parseAddress(out); ip != "" {
+			return ip, waitForSSH(net.JoinHostPort(ip, "22"), time.Until(deadline))
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("kvm: machine %s got no address after %s", name, d.bootTimeout)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// stopVM shuts the machine name down, destroying it if it does not stop within
+// a minute.
+func (d *Driver) stopVM(name string) error {
+	st, err := d.domState(name)
+	if err != nil || st != "running" {
+		return err
+	}
+	if _, err := d.run("shutdown", name); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); {
+		time.Sleep(2 * time.Second)
+		if st, err := d.domState(name); err != nil || st != "running" && st != "in shutdown" {
+			return err
+		}
+	}
+	_, err = d.run("destroy", name)
+	return err
+}
+
+// removeNode deletes the machine of the ith node, and forgets it.
+func (d *Driver) removeNode(s *state, i int) error {
+	if err := d.removeVM(s.Nodes[i].Name); err != nil {
+		return err
+	}
+	s.Nodes = append(s.Nodes[:i], s.Nodes[i+1:]...)
+	return d.save(s)
+}
+
+// removeVM destroys and undefines the machine name, and deletes its volumes
+// and local files. Missing machines and volumes are ignored.
+func (d *Driver) removeVM(name string) error {
+	st, err := d.domState(name)
+	if err != nil {
+		return err
+	}
+	if st != "" && st != "shut off" {
+		if _, err := d.run("destroy", name); err != nil {
+			return err
+		}
+	}
+	if st != "" {
+		if _, err := d.run("undefine", name); err != nil {
+			return err
+		}
+	}
+	for _, volume := range []string{name + ".qcow2", name + "-cidata.iso"} {
+		if err := d.removeVolume(volume); err != nil {
+			return err
+		}
+	}
+	for _, path := range []string{
+		filepath.Join(d.storePath, "cloud-init", name),
+		filepath.Join(d.storePath, "cloud-init", name+".iso"),
+		filepath.Join(d.storePath, "libvirt", name+".xml"),
+		filepath.Join(d.storePath, "libvirt", name+".qcow2.xml"),
+	} {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// domState returns the state of the domain name, e.g. running or shut off, or
+// an empty string if it does not exist.
+func (d *Driver) domState(name string) (string, error) {
+	out, err := d.run("domstate", name)
+	if err != nil {
+		if driver.NotFound(err, virshNotFound...) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// writeDefinition saves the libvirt XML definition of name in the environment
+// directory, and returns its path.
+func (d *Driver) writeDefinition(name string, def []byte) (string, error) {
+	dir := filepath.Join(d.storePath, "libvirt")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name+".xml")
+	return path, ioutil.WriteFile(path, def, 0600)
+}
+
+func (d *Driver) networkName() string {
+	return "kube-cluster-" + d.name
+}
+
+func (d *Driver) baseVolume() string {
+	return d.name + "-base.qcow2"
+}
+
+func (d *Driver) run(args ...string) (string, error) {
+	return d.config.Run(d.virsh, append([]string{"--connect", d.uri}, args...)...)
+}
+
+// virshNotFound are the errors of virsh failing on a missing object.
+var virshNotFound = []string{"not found", "failed to get"}
+
+// domStates maps the state of a domain to a driver State.
+var domStates = driver.States{
+	"running":     driver.Running,
+	"shut off":    driver.Stopped,
+	"in shutdown": driver.Stopping,
+}
+
+// parseAddress returns the first IPv4 address of a domifaddr listing:
+//
+//	 Name       MAC address          Protocol     Address
+//	-------------------------------------------------------------------
+//	 vnet0      52:54:00:6c:3c:01    ipv4         192.168.124.100/24
+func parseAddress(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 4 && fields[2] == "ipv4" {
+			if ip, _, err := net.ParseCIDR(fields[3]); err == nil {
+				return ip.String()
+			}
+		}
+	}
+	return ""
+}
+
+func (n vm) pool() string {
+	if n.Pool == "" {
+		return defaultPool
+	}
+	return n.Pool
+}
+
+func (s *state) poolNodes(pool string) []vm {
+	var nodes []vm
+	for _, n := range s.Nodes {
+		if n.pool() == pool {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// vms returns pointers to the master and nodes of s.
+func (s *state) vms() []*vm {
+	var vms []*vm
+	if s.Master != nil {
+		vms = append(vms, s.Master)
+	}
+	for i := range s.Nodes {
+		vms = append(vms, &s.Nodes[i])
+	}
+	return vms
+}
+
+func names(vms []*vm) []string {
+	names := make([]string, len(vms))
+	for i, v := range vms {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// node returns the node named name.
+func (s *state) node(name string) *vm {
+	for i := range s.Nodes {
+		if s.Nodes[i].Name == name {
+			return &s.Nodes[i]
+		}
+	}
+	return nil
+}
+
+func (d *Driver) statePath() string {
+	return filepath.Join(d.storePath, stateFileName)
+}
+
+func (d *Driver) load() (*state, error) {
+	s := &state{}
+	data, err := ioutil.ReadFile(d.statePath())
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("kvm: corrupt state: %v", err)
+	}
+	return s, nil
+}
+
+func (d *Driver) save(s *state) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.storePath, 0700); err != nil {
+		return err
+	}
+	return envstore.WriteFileAtomic(d.statePath(), data, 0600)
+}
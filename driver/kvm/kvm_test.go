@@ -0,0 +1,594 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvm
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/pki"
+)
+
+func TestMain(m *testing.M) {
+	waitForSSH = func(string, time.Duration) error { return nil }
+	os.Exit(m.Run())
+}
+
+type stubNetwork struct {
+	def    network
+	active bool
+}
+
+type stubDomain struct {
+	def   domain
+	state string
+	ip    string
+}
+
+// stubVirsh is a Runner standing in for virsh and genisoimage, keeping the
+// libvirt objects it manages in memory. The definitions it is given are
+// parsed, so that the tests check what libvirt would get. ssh and scp calls
+// are recorded only.
+type stubVirsh struct {
+	t  *testing.T
+	mu sync.Mutex
+
+	networks map[string]*stubNetwork
+	// volumes holds the definitions of the volumes of the pool, uploaded
+	// ones having an empty one
+	volumes map[string]*volume
+	domains map[string]*stubDomain
+	started int
+	calls   []string
+	// fail lists prefixes of the calls to fail
+	fail []string
+}
+
+func newStubVirsh(t *testing.T) *stubVirsh {
+	return &stubVirsh{
+		t:        t,
+		networks: make(map[string]*stubNetwork),
+		volumes:  make(map[string]*volume),
+		domains:  make(map[string]*stubDomain),
+	}
+}
+
+func (s *stubVirsh) Run(name string, args ...string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name == "virsh" {
+		if len(args) < 3 || args[0] != "--connect" || args[1] != "qemu:///system" {
+			s.t.Fatalf("virsh run with %q", args)
+		}
+		args = args[2:]
+	}
+	call := strings.Join(append([]string{name}, args...), " ")
+	s.calls = append(s.calls, call)
+	for _, prefix := range s.fail {
+		if strings.HasPrefix(call, prefix) {
+			return "", commandError(name, args, "error: injected failure")
+		}
+	}
+
+	switch name {
+	case "virsh":
+		return s.virsh(args)
+	case "genisoimage":
+		// -quiet -output ISO ... user-data meta-data
+		for _, f := range args[len(args)-2:] {
+			if _, err := os.Stat(f); err != nil {
+				s.t.Errorf("genisoimage: %v", err)
+			}
+		}
+		return "", ioutil.WriteFile(args[2], []byte("iso"), 0600)
+	case "ssh", "scp":
+		return "", nil
+	}
+	s.t.Fatalf("unexpected command %s", call)
+	return "", nil
+}
+
+func commandError(name string, args []string, stderr string) error {
+	return &driver.CommandError{Args: append([]string{name}, args...), Stderr: stderr, Err: errors.New("exit status 1")}
+}
+
+func (s *stubVirsh) virsh(args []string) (string, error) {
+	arg := func(i int) string { return args[len(args)-i] }
+	missing := func(kind, name string) error {
+		return commandError("virsh", args, fmt.Sprintf("error: failed to get %s '%s'", kind, name))
+	}
+
+	switch args[0] {
+	case "net-info":
+		n := s.networks[arg(1)]
+		if n == nil {
+			return "", missing("network", arg(1))
+		}
+		active := "no"
+		if n.active {
+			active = "yes"
+		}
+		return fmt.Sprintf("Name:           %s\nActive:         %s\nBridge:         virbr1\n", arg(1), active), nil
+	case "net-list":
+		var names []string
+		for name := range s.networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, "\n") + "\n\n", nil
+	case "net-dumpxml":
+		n := s.networks[arg(1)]
+		if n == nil {
+			return "", missing("network", arg(1))
+		}
+		data, err := marshal(n.def)
+		return string(data), err
+	case "net-define":
+		var def network
+		s.parse(arg(1), &def)
+		if s.networks[def.Name] != nil {
+			return "", commandError("virsh", args, "error: network '"+def.Name+"' already exists")
+		}
+		s.networks[def.Name] = &stubNetwork{def: def}
+	case "net-start", "net-destroy":
+		n := s.networks[arg(1)]
+		if n == nil {
+			return "", missing("network", arg(1))
+		}
+		n.active = args[0] == "net-start"
+	case "net-autostart":
+		if s.networks[arg(1)] == nil {
+			return "", missing("network", arg(1))
+		}
+	case "net-undefine":
+		if s.networks[arg(1)] == nil {
+			return "", missing("network", arg(1))
+		}
+		delete(s.networks, arg(1))
+	case "vol-create":
+		var def volume
+		s.parse(arg(1), &def)
+		s.volumes[def.Name] = &def
+	case "vol-create-as":
+		// vol-create-as POOL NAME SIZE --format FORMAT
+		s.volumes[args[2]] = &volume{}
+	case "vol-upload":
+		if s.volumes[arg(2)] == nil {
+			return "", missing("vol", arg(2))
+		}
+		if _, err := os.Stat(arg(1)); err != nil {
+			s.t.Errorf("vol-upload: %v", err)
+		}
+	case "vol-path":
+		if s.volumes[arg(1)] == nil {
+			return "", missing("vol", arg(1))
+		}
+		return "/var/lib/libvirt/images/" + arg(1) + "\n", nil
+	case "vol-info", "vol-delete":
+		if s.volumes[arg(1)] == nil {
+			return "", missing("vol", arg(1))
+		}
+		if args[0] == "vol-delete" {
+			delete(s.volumes, arg(1))
+		}
+	case "define":
+		var def domain
+		s.parse(arg(1), &def)
+		for _, d := range def.Devices.Disks {
+			if s.volumes[d.Source.Volume] == nil {
+				return "", missing("vol", d.Source.Volume)
+			}
+		}
+		if s.networks[def.Devices.Interface.Source.Network] == nil {
+			return "", missing("network", def.Devices.Interface.Source.Network)
+		}
+		s.domains[def.Name] = &stubDomain{def: def, state: "shut off"}
+	case "start":
+		d := s.domains[arg(1)]
+		if d == nil {
+			return "", missing("domain", arg(1))
+		}
+		if d.state == "running" {
+			return "", commandError("virsh", args, "error: Domain is already active")
+		}
+		d.state = "running"
+		if d.ip == "" {
+			s.started++
+			d.ip = fmt.Sprintf("192.168.124.%d", 99+s.started)
+		}
+	case "shutdown", "destroy":
+		d := s.domains[arg(1)]
+		if d == nil {
+			return "", missing("domain", arg(1))
+		}
+		d.state = "shut off"
+	case "undefine":
+		if s.domains[arg(1)] == nil {
+			return "", missing("domain", arg(1))
+		}
+		delete(s.domains, arg(1))
+	case "domstate":
+		d := s.domains[arg(1)]
+		if d == nil {
+			return "", missing("domain", arg(1))
+		}
+		return d.state + "\n\n", nil
+	case "domifaddr":
+		d := s.domains[arg(1)]
+		if d == nil {
+			return "", missing("domain", arg(1))
+		}
+		out := " Name       MAC address          Protocol     Address\n" +
+			"-------------------------------------------------------------------\n"
+		if d.state == "running" {
+			out += fmt.Sprintf(" vnet0      52:54:00:6c:3c:01    ipv4         %s/24\n", d.ip)
+		}
+		return out, nil
+	default:
+		s.t.Fatalf("unexpected virsh command %q", args)
+	}
+	return "", nil
+}
+
+// parse unmarshals the libvirt definition at path into v.
+func (s *stubVirsh) parse(path string, v interface{}) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		s.t.Fatalf("%s: %v", path, err)
+	}
+}
+
+// count returns the number of calls starting with prefix.
+func (s *stubVirsh) count(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, call := range s.calls {
+		if strings.HasPrefix(call, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+type testEnv struct {
+	*Driver
+	dir  string
+	stub *stubVirsh
+}
+
+func newTestDriver(t *testing.T, nodes int) (*testEnv, func()) {
+	dir, err := ioutil.TempDir("", "kvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := filepath.Join(dir, "image.qcow2")
+	if err := ioutil.WriteFile(image, []byte("qcow2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	j, err := driver.OpenJournal(filepath.Join(dir, "journal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := newStubVirsh(t)
+	d, err := New(&driver.Config{
+		Name:      "dev",
+		StorePath: dir,
+		Nodes:     nodes,
+		Options: map[string]string{
+			optImage:  image,
+			optMemory: "2048",
+			optCPUs:   "2",
+		},
+		Journal: j,
+		PKI:     pki.New(filepath.Join(dir, "pki"), pki.Options{Algorithm: pki.ECDSA}),
+		Runner:  stub,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{Driver: d.(*Driver), dir: dir, stub: stub}, func() { os.RemoveAll(dir) }
+}
+
+func TestLifecycle(t *testing.T) {
+	d, cleanup := newTestDriver(t, 2)
+	defer cleanup()
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	n := d.stub.networks["kube-cluster-dev"]
+	if n == nil || !n.active {
+		t.Fatalf("got network %+v", n)
+	}
+	if n.def.Forward.Mode != "nat" || n.def.IP.Address != "192.168.124.1" || n.def.IP.Netmask != "255.255.255.0" {
+		t.Errorf("got network definition %+v", n.def)
+	}
+	if r := n.def.IP.DHCP.Range; r.Start != "192.168.124.100" || r.End != "192.168.124.254" {
+		t.Errorf("got DHCP range %+v", r)
+	}
+
+	var domains []string
+	for name, dom := range d.stub.domains {
+		domains = append(domains, name)
+		if dom.state != "running" {
+			t.Errorf("domain %s is %s", name, dom.state)
+		}
+	}
+	sort.Strings(domains)
+	if strings.Join(domains, " ") != "dev-master dev-node-1 dev-node-2" {
+		t.Fatalf("got domains %v", domains)
+	}
+
+	master := d.stub.domains["dev-master"].def
+	if master.Type != "kvm" || master.VCPU != 2 || master.Memory.Unit != "MiB" || master.Memory.Value != 2048 {
+		t.Errorf("got domain definition %+v", master)
+	}
+	if master.Devices.Interface.Source.Network != "kube-cluster-dev" || master.Devices.Console.Type != "pty" {
+		t.Errorf("got devices %+v", master.Devices)
+	}
+	if disks := master.Devices.Disks; len(disks) != 2 ||
+		disks[0].Device != "disk" || disks[0].Source.Volume != "dev-master.qcow2" || disks[0].Target.Bus != "virtio" ||
+		disks[1].Device != "cdrom" || disks[1].Source.Volume != "dev-master-cidata.iso" || disks[1].ReadOnly == nil {
+		t.Errorf("got disks %+v", disks)
+	}
+
+	disk := d.stub.volumes["dev-node-1.qcow2"]
+	if disk == nil || disk.Capacity.Unit != "G" || disk.Capacity.Value != 20 || disk.Target.Format.Type != "qcow2" {
+		t.Fatalf("got volume definition %+v", disk)
+	}
+	if b := disk.BackingStore; b == nil || b.Path != "/var/lib/libvirt/images/dev-base.qcow2" || b.Format.Type != "qcow2" {
+		t.Errorf("got backing store %+v", b)
+	}
+
+	// nodes learn the master address from their cloud-init user data
+	userData, err := ioutil.ReadFile(filepath.Join(d.dir, "cloud-init", "dev-node-1", "user-data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := d.config.BootEnvironment(driver.BootConfig{Role: driver.NodeRole, Name: "dev-node-1", Pool: "default", Master: "192.168.124.100"})
+	if !strings.Contains(string(userData), base64.StdEncoding.EncodeToString(env)) {
+		t.Errorf("dev-node-1 user data lacks its environment:\n%s", userData)
+	}
+
+	if url, err := d.GetURL(); err != nil || url != "https://192.168.124.100:6443" {
+		t.Errorf("got URL %q, %v", url, err)
+	}
+	if st, err := d.Status(); err != nil || st != driver.Running {
+		t.Errorf("got status %v, %v", st, err)
+	}
+	nodes, err := d.Nodes()
+	if err != nil || len(nodes) != 2 || nodes[1].Name != "dev-node-2" || nodes[1].State != driver.Running {
+		t.Errorf("got nodes %+v, %v", nodes, err)
+	}
+
+	if err := d.Scale(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.stub.domains["dev-node-2"]; ok {
+		t.Error("scaling down kept dev-node-2")
+	}
+	if _, ok := d.stub.volumes["dev-node-2.qcow2"]; ok {
+		t.Error("scaling down kept the disk of dev-node-2")
+	}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if s := d.stub; len(s.domains) != 0 || len(s.volumes) != 0 || len(s.networks) != 0 {
+		t.Errorf("left domains %v, volumes %v and networks %v", s.domains, s.volumes, s.networks)
+	}
+	if st, err := d.Status(); err != nil || st != driver.None {
+		t.Errorf("got status %v, %v after removal", st, err)
+	}
+}
+
+// TestNetworkAddress checks environments get networks not overlapping the
+// libvirt ones.
+func TestNetworkAddress(t *testing.T) {
+	d, cleanup := newTestDriver(t, 1)
+	defer cleanup()
+
+	for _, n := range []struct{ name, cidr string }{
+		{"default", "192.168.124.1/24"},
+		{"kube-cluster-other", "192.168.125.1/24"},
+	} {
+		def, err := networkXML(n.name, n.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v network
+		if err := xml.Unmarshal(def, &v); err != nil {
+			t.Fatal(err)
+		}
+		d.stub.networks[n.name] = &stubNetwork{def: v, active: true}
+	}
+	if cidr, err := d.networkAddress(); err != nil || cidr != "192.168.126.1/24" {
+		t.Errorf("got %q, %v, want the first free network", cidr, err)
+	}
+
+	d.networkCIDR = "192.168.125.1/24"
+	want := "kvm: network 192.168.125.0/24 overlaps 192.168.125.0/24 of libvirt network kube-cluster-other, choose another --kvm-network-cidr"
+	if err := d.Create(); err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+	if d.stub.networks["kube-cluster-dev"] != nil {
+		t.Error("overlapping network defined")
+	}
+
+	d.networkCIDR = "10.10.0.1/16"
+	if cidr, err := d.networkAddress(); err != nil || cidr != "10.10.0.1/16" {
+		t.Errorf("got %q, %v, want the given network", cidr, err)
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	def := `<network>
+  <name>default</name>
+  <ip address="192.168.122.1" netmask="255.255.255.0"/>
+  <ip address="10.20.0.1" prefix="16"/>
+  <ip address="172.16.1.1"/>
+  <ip family="ipv6" address="2001:db8:ca2:2::1" prefix="64"/>
+</network>`
+	networks, err := parseNetworks([]byte(def))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, n := range networks {
+		got = append(got, n.String())
+	}
+	if want := "192.168.122.0/24 10.20.0.0/16 172.16.0.0/16"; strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestCreateResumes(t *testing.T) {
+	d, cleanup := newTestDriver(t, 1)
+	defer cleanup()
+
+	d.stub.fail = []string{"virsh start dev-node-1"}
+	if err := d.Create(); err == nil {
+		t.Fatal("creation succeeded")
+	}
+	d.stub.fail = nil
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	for prefix, want := range map[string]int{
+		"virsh net-define": 1,
+		"virsh vol-upload --pool default dev-base.qcow2": 1,
+		"virsh define":           2,
+		"virsh start dev-master": 1,
+		"virsh start dev-node-1": 2,
+	} {
+		if got := d.stub.count(prefix); got != want {
+			t.Errorf("got %d calls of %s, want %d", got, prefix, want)
+		}
+	}
+}
+
+func TestRemoveJournaledDomains(t *testing.T) {
+	d, cleanup := newTestDriver(t, 1)
+	defer cleanup()
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	// a machine whose creation was interrupted before the state was saved
+	if err := d.config.Journal.Run("create stray", func(record func(kind, id string)) error {
+		record("domain", "dev-node-9")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	d.stub.domains["dev-node-9"] = &stubDomain{state: "running"}
+	d.stub.volumes["dev-node-9.qcow2"] = &volume{}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if s := d.stub; len(s.domains) != 0 || len(s.volumes) != 0 {
+		t.Errorf("left domains %v and volumes %v", s.domains, s.volumes)
+	}
+}
+
+func TestInstallCertificates(t *testing.T) {
+	d, cleanup := newTestDriver(t, 1)
+	defer cleanup()
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.stub.count("scp"); got != 0 {
+		t.Fatalf("copied certificates %d times before they were issued", got)
+	}
+	if err := d.config.PKI.Ensure("dev", pki.SANs{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InstallCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	for target, want := range map[string]string{
+		"kube-cluster@192.168.124.100": "apiserver.crt",
+		"kube-cluster@192.168.124.101": "node.crt",
+	} {
+		found := false
+		for _, call := range d.stub.calls {
+			if strings.HasPrefix(call, "scp ") && strings.HasSuffix(call, target+":kube-cluster-pki/") {
+				found = strings.Contains(call, "/"+want+" ")
+			}
+		}
+		if !found {
+			t.Errorf("%s did not get %s", target, want)
+		}
+	}
+
+	// machines added once certificates are issued get them right away
+	if err := d.Scale(2); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.stub.count("scp"); got != 3 {
+		t.Errorf("got %d certificate copies, want 3", got)
+	}
+}
+
+func TestXML(t *testing.T) {
+	data, err := networkXML("kube-cluster-dev", "10.1.0.1/20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n network
+	if err := xml.Unmarshal(data, &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.IP.Netmask != "255.255.240.0" || n.IP.DHCP.Range.Start != "10.1.0.100" || n.IP.DHCP.Range.End != "10.1.15.254" {
+		t.Errorf("got network %+v", n.IP)
+	}
+
+	if _, err := domainXML(domainSpec{Name: "dev-master", MemoryMB: 1024}); err == nil {
+		t.Error("defined a domain without CPUs")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	out := ` Name       MAC address          Protocol     Address
+-------------------------------------------------------------------
+ vnet0      52:54:00:6c:3c:01    ipv6         fe80::1/64
+ vnet0      52:54:00:6c:3c:01    ipv4         192.168.124.100/24
+`
+	if got := parseAddress(out); got != "192.168.124.100" {
+		t.Errorf("got %q", got)
+	}
+	if got := parseAddress(""); got != "" {
+		t.Errorf("got %q from an empty listing", got)
+	}
+}
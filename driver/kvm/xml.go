@@ -0,0 +1,255 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvm
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+
+	"github.com/gerred/kube-cluster/driver"
+)
+
+// The libvirt XML formats are described at https://libvirt.org/format.html.
+// Only the elements the driver sets are modelled.
+
+type network struct {
+	XMLName xml.Name `xml:"network"`
+	Name    string   `xml:"name"`
+	Forward struct {
+		Mode string `xml:"mode,attr"`
+	} `xml:"forward"`
+	IP struct {
+		Address string `xml:"address,attr"`
+		Netmask string `xml:"netmask,attr"`
+		DHCP    struct {
+			Range struct {
+				Start string `xml:"start,attr"`
+				End   string `xml:"end,attr"`
+			} `xml:"range"`
+		} `xml:"dhcp"`
+	} `xml:"ip"`
+}
+
+// networkXML returns the definition of a NAT network named name, the host
+// having the address of cidr, and machines being leased addresses from the
+// 100th of the network on.
+func networkXML(name, cidr string) ([]byte, error) {
+	hostIP, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	var v network
+	v.Name = name
+	v.Forward.Mode = "nat"
+	v.IP.Address = hostIP.String()
+	v.IP.Netmask = net.IP(n.Mask).String()
+	v.IP.DHCP.Range.Start = driver.NthIP(n, 100).String()
+	v.IP.DHCP.Range.End = driver.NthIP(n, driver.LastHost(n)).String()
+	return marshal(v)
+}
+
+// networkAddresses is the part of a libvirt network definition listing its
+// addresses, with a netmask or a prefix.
+type networkAddresses struct {
+	IPs []struct {
+		Family  string `xml:"family,attr"`
+		Address string `xml:"address,attr"`
+		Netmask string `xml:"netmask,attr"`
+		Prefix  int    `xml:"prefix,attr"`
+	} `xml:"ip"`
+}
+
+// parseNetworks returns the IPv4 networks of a libvirt network definition.
+func parseNetworks(def []byte) ([]*net.IPNet, error) {
+	var v networkAddresses
+	if err := xml.Unmarshal(def, &v); err != nil {
+		return nil, err
+	}
+	var networks []*net.IPNet
+	for _, ip := range v.IPs {
+		addr := net.ParseIP(ip.Address).To4()
+		if ip.Family == "ipv6" || addr == nil {
+			continue
+		}
+		// libvirt falls back on the class of the address
+		mask := addr.DefaultMask()
+		if ip.Prefix != 0 {
+			mask = net.CIDRMask(ip.Prefix, 32)
+		}
+		if ip.Netmask != "" {
+			m := net.ParseIP(ip.Netmask).To4()
+			if m == nil {
+				return nil, fmt.Errorf("invalid netmask %q", ip.Netmask)
+			}
+			mask = net.IPMask(m)
+		}
+		networks = append(networks, &net.IPNet{IP: addr.Mask(mask), Mask: mask})
+	}
+	return networks, nil
+}
+
+type volume struct {
+	XMLName  xml.Name `xml:"volume"`
+	Name     string   `xml:"name"`
+	Capacity struct {
+		Unit  string `xml:"unit,attr"`
+		Value int    `xml:",chardata"`
+	} `xml:"capacity"`
+	Target struct {
+		Format format `xml:"format"`
+	} `xml:"target"`
+	BackingStore *struct {
+		Path   string `xml:"path"`
+		Format format `xml:"format"`
+	} `xml:"backingStore"`
+}
+
+type format struct {
+	Type string `xml:"type,attr"`
+}
+
+// volumeXML returns the definition of a qcow2 volume of sizeGB gigabytes,
+// backed by the qcow2 image at backingPath, which it starts as a copy of.
+func volumeXML(name string, sizeGB int, backingPath string) ([]byte, error) {
+	var v volume
+	v.Name = name
+	v.Capacity.Unit, v.Capacity.Value = "G", sizeGB
+	v.Target.Format.Type = "qcow2"
+	v.BackingStore = &struct {
+		Path   string `xml:"path"`
+		Format format `xml:"format"`
+	}{Path: backingPath, Format: format{Type: "qcow2"}}
+	return marshal(v)
+}
+
+// domainSpec describes a virtual machine.
+type domainSpec struct {
+	Name     string
+	CPUs     int
+	MemoryMB int
+	// Pool holds the Disk and CloudInit volumes.
+	Pool      string
+	Disk      string
+	CloudInit string
+	Network   string
+}
+
+type domain struct {
+	XMLName xml.Name `xml:"domain"`
+	Type    string   `xml:"type,attr"`
+	Name    string   `xml:"name"`
+	Memory  struct {
+		Unit  string `xml:"unit,attr"`
+		Value int    `xml:",chardata"`
+	} `xml:"memory"`
+	VCPU int `xml:"vcpu"`
+	OS   struct {
+		Type struct {
+			Arch  string `xml:"arch,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"type"`
+		Boot struct {
+			Dev string `xml:"dev,attr"`
+		} `xml:"boot"`
+	} `xml:"os"`
+	Features struct {
+		ACPI struct{} `xml:"acpi"`
+		APIC struct{} `xml:"apic"`
+	} `xml:"features"`
+	CPU struct {
+		Mode string `xml:"mode,attr"`
+	} `xml:"cpu"`
+	Devices struct {
+		Disks     []disk `xml:"disk"`
+		Interface struct {
+			Type   string `xml:"type,attr"`
+			Source struct {
+				Network string `xml:"network,attr"`
+			} `xml:"source"`
+			Model struct {
+				Type string `xml:"type,attr"`
+			} `xml:"model"`
+		} `xml:"interface"`
+		Serial struct {
+			Type string `xml:"type,attr"`
+		} `xml:"serial"`
+		Console struct {
+			Type string `xml:"type,attr"`
+		} `xml:"console"`
+	} `xml:"devices"`
+}
+
+type disk struct {
+	Type   string `xml:"type,attr"`
+	Device string `xml:"device,attr"`
+	Driver struct {
+		Name string `xml:"name,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"driver"`
+	Source struct {
+		Pool   string `xml:"pool,attr"`
+		Volume string `xml:"volume,attr"`
+	} `xml:"source"`
+	Target struct {
+		Dev string `xml:"dev,attr"`
+		Bus string `xml:"bus,attr"`
+	} `xml:"target"`
+	ReadOnly *struct{} `xml:"readonly"`
+}
+
+// domainXML returns the definition of a KVM virtual machine booting from its
+// disk, with the cloud-init volume as a CD-ROM, on the NAT network. Its
+// console is a serial one, for "virsh console".
+func domainXML(spec domainSpec) ([]byte, error) {
+	if spec.CPUs < 1 || spec.MemoryMB < 1 {
+		return nil, fmt.Errorf("domain %s needs at least a CPU and some memory", spec.Name)
+	}
+
+	var v domain
+	v.Type = "kvm"
+	v.Name = spec.Name
+	v.Memory.Unit, v.Memory.Value = "MiB", spec.MemoryMB
+	v.VCPU = spec.CPUs
+	v.OS.Type.Arch, v.OS.Type.Value = "x86_64", "hvm"
+	v.OS.Boot.Dev = "hd"
+	v.CPU.Mode = "host-passthrough"
+
+	root := disk{Type: "volume", Device: "disk"}
+	root.Driver.Name, root.Driver.Type = "qemu", "qcow2"
+	root.Source.Pool, root.Source.Volume = spec.Pool, spec.Disk
+	root.Target.Dev, root.Target.Bus = "vda", "virtio"
+
+	cidata := disk{Type: "volume", Device: "cdrom", ReadOnly: &struct{}{}}
+	cidata.Driver.Name, cidata.Driver.Type = "qemu", "raw"
+	cidata.Source.Pool, cidata.Source.Volume = spec.Pool, spec.CloudInit
+	cidata.Target.Dev, cidata.Target.Bus = "sda", "sata"
+
+	v.Devices.Disks = []disk{root, cidata}
+	v.Devices.Interface.Type = "network"
+	v.Devices.Interface.Source.Network = spec.Network
+	v.Devices.Interface.Model.Type = "virtio"
+	v.Devices.Serial.Type = "pty"
+	v.Devices.Console.Type = "pty"
+	return marshal(v)
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
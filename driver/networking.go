@@ -0,0 +1,80 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"net"
+)
+
+// Default cluster network settings.
+const (
+	DefaultPodCIDR     = "10.244.0.0/16"
+	DefaultServiceCIDR = "10.0.0.0/24"
+	DefaultDNSDomain   = "cluster.local"
+)
+
+// Networking holds the cluster network settings of an environment.
+type Networking struct {
+	// PodCIDR is the range pods are given addresses from.
+	PodCIDR string
+	// ServiceCIDR is the range services are given addresses from, the
+	// first one going to the API server.
+	ServiceCIDR string
+	// DNSDomain is the domain of the cluster service names.
+	DNSDomain string
+}
+
+// WithDefaults returns n, the empty settings set to their default.
+func (n Networking) WithDefaults() Networking {
+	if n.PodCIDR == "" {
+		n.PodCIDR = DefaultPodCIDR
+	}
+	if n.ServiceCIDR == "" {
+		n.ServiceCIDR = DefaultServiceCIDR
+	}
+	if n.DNSDomain == "" {
+		n.DNSDomain = DefaultDNSDomain
+	}
+	return n
+}
+
+// APIServerServiceIP returns the address of the kubernetes service, the first
+// of ServiceCIDR.
+func (n Networking) APIServerServiceIP() (net.IP, error) {
+	_, cidr, err := net.ParseCIDR(n.WithDefaults().ServiceCIDR)
+	if err != nil {
+		return nil, err
+	}
+	return NthIP(cidr, 1), nil
+}
+
+// CheckHosts fails if the address of a machine is in the pod or the service
+// range, whose addresses would hide it within the cluster.
+func (n Networking) CheckHosts(ips ...net.IP) error {
+	n = n.WithDefaults()
+	for _, r := range []struct{ name, cidr string }{{"pod", n.PodCIDR}, {"service", n.ServiceCIDR}} {
+		_, cidr, err := net.ParseCIDR(r.cidr)
+		if err != nil {
+			return fmt.Errorf("invalid %s CIDR %q", r.name, r.cidr)
+		}
+		for _, ip := range ips {
+			if cidr.Contains(ip) {
+				return fmt.Errorf("machine address %s is in the %s range %s, set another one in the networking settings", ip, r.name, cidr)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNetworking(t *testing.T) {
+	n := Networking{ServiceCIDR: "10.96.0.0/12"}.WithDefaults()
+	if n.PodCIDR != DefaultPodCIDR || n.ServiceCIDR != "10.96.0.0/12" || n.DNSDomain != DefaultDNSDomain {
+		t.Errorf("got %+v", n)
+	}
+	if ip, err := n.APIServerServiceIP(); err != nil || ip.String() != "10.96.0.1" {
+		t.Errorf("got API server service address %v, %v", ip, err)
+	}
+	if ip, err := (Networking{}).APIServerServiceIP(); err != nil || ip.String() != "10.0.0.1" {
+		t.Errorf("got default API server service address %v, %v", ip, err)
+	}
+
+	if err := (Networking{}).CheckHosts(net.ParseIP("192.168.1.10"), net.ParseIP("10.1.0.1")); err != nil {
+		t.Error(err)
+	}
+	err := (Networking{}).CheckHosts(net.ParseIP("192.168.1.10"), net.ParseIP("10.0.0.2"))
+	if err == nil || !strings.Contains(err.Error(), "10.0.0.2 is in the service range 10.0.0.0/24") {
+		t.Errorf("got %v", err)
+	}
+	if err := (Networking{PodCIDR: "192.168.0.0/16"}).CheckHosts(net.ParseIP("192.168.1.10")); err == nil {
+		t.Error("accepted a machine in the pod range")
+	}
+}
+
+func TestBootEnvironmentNetworking(t *testing.T) {
+	c := &Config{Name: "dev", Networking: Networking{PodCIDR: "172.16.0.0/16"}}
+	env := string(c.BootEnvironment(BootConfig{Role: NodeRole, Name: "dev-node-1"}))
+	for _, want := range []string{
+		"KUBE_CLUSTER_POD_CIDR='172.16.0.0/16'\n",
+		"KUBE_CLUSTER_SERVICE_CIDR='10.0.0.0/24'\n",
+		"KUBE_CLUSTER_DNS_DOMAIN='cluster.local'\n",
+	} {
+		if !strings.Contains(env, want) {
+			t.Errorf("environment lacks %q:\n%s", want, env)
+		}
+	}
+}
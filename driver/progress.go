@@ -0,0 +1,109 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Progress receives the progress of the operations drivers run on nodes. It
+// must be safe for concurrent use.
+type Progress interface {
+	// Start is called when the operation on a node begins, action telling
+	// what it is, e.g. "creating".
+	Start(node, action string)
+	// Done is called when the operation on a node ends, err being nil on
+	// success.
+	Done(node string, err error)
+}
+
+// NodesError reports the nodes an operation failed on.
+type NodesError struct {
+	Total  int
+	Failed map[string]error
+}
+
+func (e *NodesError) Error() string {
+	var names []string
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d of %d node(s) failed", len(e.Failed), e.Total)
+	for i, name := range names {
+		sep := "; "
+		if i == 0 {
+			sep = ": "
+		}
+		fmt.Fprintf(&b, "%s%s: %v", sep, name, e.Failed[name])
+	}
+	return b.String()
+}
+
+// ForEachNode runs fn for every named node, at most c.Parallelism at a time,
+// and reports their progress to c.Progress. Nodes are started in order, and all
+// of them are attempted even if some fail, in which case a *NodesError is
+// returned.
+func ForEachNode(c *Config, action string, nodes []string, fn func(node string) error) error {
+	workers := c.Parallelism
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(nodes) {
+		workers = len(nodes)
+	}
+
+	var (
+		mu     sync.Mutex
+		failed = make(map[string]error)
+		wg     sync.WaitGroup
+	)
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for node := range queue {
+				if c.Progress != nil {
+					c.Progress.Start(node, action)
+				}
+				err := fn(node)
+				if c.Progress != nil {
+					c.Progress.Done(node, err)
+				}
+				if err != nil {
+					mu.Lock()
+					failed[node] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, node := range nodes {
+		queue <- node
+	}
+	close(queue)
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return &NodesError{Total: len(nodes), Failed: failed}
+	}
+	return nil
+}
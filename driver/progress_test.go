@@ -0,0 +1,130 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a Progress remembering the calls it got.
+type recorder struct {
+	mu     sync.Mutex
+	starts []string
+	done   map[string]error
+}
+
+func (r *recorder) Start(node, action string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.starts = append(r.starts, node+" "+action)
+}
+
+func (r *recorder) Done(node string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done == nil {
+		r.done = make(map[string]error)
+	}
+	r.done[node] = err
+}
+
+func TestForEachNode(t *testing.T) {
+	for _, parallelism := range []int{0, 1, 3, 20} {
+		var nodes []string
+		for i := 1; i <= 10; i++ {
+			nodes = append(nodes, fmt.Sprintf("node-%d", i))
+		}
+
+		var (
+			mu            sync.Mutex
+			running, peak int
+			ran           []string
+		)
+		progress := &recorder{}
+		c := &Config{Parallelism: parallelism, Progress: progress}
+		err := ForEachNode(c, "creating", nodes, func(node string) error {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			ran = append(ran, node)
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			if node == "node-4" || node == "node-7" {
+				return errors.New("no capacity")
+			}
+			return nil
+		})
+
+		want := parallelism
+		if want < 1 {
+			want = 1
+		}
+		if want > len(nodes) {
+			want = len(nodes)
+		}
+		if peak > want || want > 1 && peak < 2 {
+			t.Errorf("parallelism %d: %d nodes ran at once", parallelism, peak)
+		}
+		sort.Strings(ran)
+		sorted := append([]string(nil), nodes...)
+		sort.Strings(sorted)
+		if !reflect.DeepEqual(ran, sorted) {
+			t.Errorf("parallelism %d: ran %v, want all nodes", parallelism, ran)
+		}
+
+		ne, ok := err.(*NodesError)
+		if !ok {
+			t.Fatalf("parallelism %d: got %v, want a *NodesError", parallelism, err)
+		}
+		if ne.Total != 10 || len(ne.Failed) != 2 || ne.Failed["node-4"] == nil || ne.Failed["node-7"] == nil {
+			t.Errorf("parallelism %d: got %+v", parallelism, ne)
+		}
+		if len(progress.starts) != 10 || len(progress.done) != 10 || progress.done["node-4"] == nil || progress.done["node-1"] != nil {
+			t.Errorf("parallelism %d: progress got starts %v, done %v", parallelism, progress.starts, progress.done)
+		}
+		if progress.starts[0] != "node-1 creating" && parallelism <= 1 {
+			t.Errorf("parallelism %d: first start %q, want node-1 creating", parallelism, progress.starts[0])
+		}
+	}
+}
+
+func TestForEachNodeNoProgress(t *testing.T) {
+	if err := ForEachNode(&Config{}, "creating", []string{"a", "b"}, func(string) error { return nil }); err != nil {
+		t.Error(err)
+	}
+	if err := ForEachNode(&Config{}, "creating", nil, func(string) error { return errors.New("called") }); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNodesError(t *testing.T) {
+	err := &NodesError{Total: 3, Failed: map[string]error{"node-3": errors.New("timeout"), "node-1": errors.New("no capacity")}}
+	if want := "2 of 3 node(s) failed: node-1: no capacity; node-3: timeout"; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Runner runs the command line tools drivers are built on, such as VBoxManage
+// or virsh. Drivers take it from Config.Runner, so they can be exercised with a
+// stub instead of the real tool.
+type Runner interface {
+	// Run runs name with args, and returns its standard output. Failures
+	// are reported as *CommandError.
+	Run(name string, args ...string) (string, error)
+}
+
+// ExecRunner is the Runner executing commands on the local machine.
+type ExecRunner struct{}
+
+// Run executes name with args.
+func (ExecRunner) Run(name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), &CommandError{
+			Args:   append([]string{name}, args...),
+			Stderr: strings.TrimSpace(stderr.String()),
+			Err:    err,
+		}
+	}
+	return stdout.String(), nil
+}
+
+// CommandError reports a command that could not run, or exited with an error.
+type CommandError struct {
+	Args   []string
+	Stderr string
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s: %v", strings.Join(e.Args, " "), e.Err)
+	}
+	return fmt.Sprintf("%s: %v: %s", strings.Join(e.Args, " "), e.Err, e.Stderr)
+}
+
+// Run runs a command with the Runner of c, an ExecRunner if unset.
+func (c *Config) Run(name string, args ...string) (string, error) {
+	if c.Runner == nil {
+		return ExecRunner{}.Run(name, args...)
+	}
+	return c.Runner.Run(name, args...)
+}
@@ -0,0 +1,213 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import "fmt"
+
+// nodeKubeconfigPath is the kubeconfig the kubelet and kube-proxy of the
+// nodes authenticate with.
+const nodeKubeconfigPath = "/etc/kube-cluster/kubeconfig"
+
+// nodeKubeconfig returns the kubeconfig of the node name of environment,
+// reaching the API server at server with the node certificate.
+func nodeKubeconfig(environment, server, name string) []byte {
+	return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: %[1]s
+  cluster:
+    server: %[2]s
+    certificate-authority: /etc/kube-cluster/pki/ca.crt
+users:
+- name: %[3]s
+  user:
+    client-certificate: /etc/kube-cluster/pki/node.crt
+    client-key: /etc/kube-cluster/pki/node.key
+contexts:
+- name: %[1]s
+  context:
+    cluster: %[1]s
+    user: %[3]s
+current-context: %[1]s
+`, environment, server, name))
+}
+
+// bootstrapScript installs the kubernetes components of a machine, as set by
+// /etc/kube-cluster/environment, the nodes using the nodeKubeconfig installed
+// beside it. It takes the paths already installed by
+// kube-cluster as arguments, and fails rather than replace anything else,
+// systemd units found anywhere included.
+// What it creates is reported on standard output, one per line:
+//
+//	file PATH  a file
+//	dir PATH   an empty directory
+//	tree PATH  a directory, removed with all its content
+//	unit NAME  an enabled systemd unit
+const bootstrapScript = `#!/bin/sh
+set -e
+
+. /etc/kube-cluster/environment
+
+VERSION=${KUBE_CLUSTER_KUBERNETES_VERSION:-v1.1.2}
+POD_CIDR=${KUBE_CLUSTER_POD_CIDR:-10.244.0.0/16}
+SERVICE_CIDR=${KUBE_CLUSTER_SERVICE_CIDR:-10.0.0.0/24}
+DNS_DOMAIN=${KUBE_CLUSTER_DNS_DOMAIN:-cluster.local}
+MIRROR=https://storage.googleapis.com/kubernetes-release/release/$VERSION/bin/linux/amd64
+ETCD_VERSION=v2.2.1
+ETCD_URL=https://github.com/coreos/etcd/releases/download/$ETCD_VERSION/etcd-$ETCD_VERSION-linux-amd64.tar.gz
+BIN=/opt/kube-cluster/bin
+CONF=/etc/kube-cluster
+DATA=/var/lib/kube-cluster
+INSTALLED=" $* "
+
+fail() {
+	echo "kube-cluster: $*" >&2
+	exit 1
+}
+
+# missing succeeds if PATH has to be created, and fails the script if it
+# exists without having been installed by kube-cluster.
+missing() {
+	if [ -e "$1" ] || [ -L "$1" ]; then
+		case $INSTALLED in
+		*" $1 "*) return 1 ;;
+		esac
+		fail "$1 already exists"
+	fi
+}
+
+for cmd in curl tar install systemctl; do
+	command -v $cmd >/dev/null || fail "$cmd is required"
+done
+if [ "$KUBE_CLUSTER_ROLE" = node ]; then
+	command -v docker >/dev/null || fail "docker is required on nodes"
+fi
+
+if missing $BIN; then
+	mkdir -m 0755 $BIN
+	echo "dir $BIN"
+fi
+if missing $DATA; then
+	mkdir -m 0700 $DATA
+	echo "tree $DATA"
+fi
+
+binary() {
+	missing "$BIN/$1" || return 0
+	curl -fsSL -o "$BIN/$1.download" "$MIRROR/$1" || {
+		rm -f "$BIN/$1.download"
+		fail "cannot download $MIRROR/$1"
+	}
+	chmod 0755 "$BIN/$1.download"
+	mv "$BIN/$1.download" "$BIN/$1"
+	echo "file $BIN/$1"
+}
+
+etcd() {
+	missing $BIN/etcd || return 0
+	tmp=$(mktemp -d)
+	curl -fsSL "$ETCD_URL" | tar -xz -C "$tmp" --strip-components=1 || {
+		rm -rf "$tmp"
+		fail "cannot download $ETCD_URL"
+	}
+	install -m 0755 "$tmp/etcd" $BIN/etcd
+	rm -rf "$tmp"
+	echo "file $BIN/etcd"
+}
+
+UNITS=
+unit() {
+	name=$1
+	shift
+	UNITS="$UNITS $name"
+	missing /etc/systemd/system/$name.service || return 0
+	# units shipped elsewhere, e.g. a distribution kubelet in
+	# /lib/systemd/system, would be overridden, then disabled on removal
+	if systemctl cat $name.service >/dev/null 2>&1; then
+		fail "unit $name already exists"
+	fi
+	cat >/etc/systemd/system/$name.service <<EOF
+[Unit]
+Description=kube-cluster $name
+After=network.target
+
+[Service]
+ExecStart=$*
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+EOF
+	echo "file /etc/systemd/system/$name.service"
+}
+
+case $KUBE_CLUSTER_ROLE in
+master)
+	etcd
+	binary kube-apiserver
+	binary kube-controller-manager
+	binary kube-scheduler
+	unit kube-etcd $BIN/etcd \
+		--name=$KUBE_CLUSTER_NAME \
+		--data-dir=$DATA/etcd \
+		--listen-client-urls=http://127.0.0.1:2379 \
+		--advertise-client-urls=http://127.0.0.1:2379
+	unit kube-apiserver $BIN/kube-apiserver \
+		--etcd-servers=http://127.0.0.1:2379 \
+		--service-cluster-ip-range=$SERVICE_CIDR \
+		--insecure-bind-address=127.0.0.1 \
+		--bind-address=0.0.0.0 \
+		--secure-port=6443 \
+		--client-ca-file=$CONF/pki/ca.crt \
+		--tls-cert-file=$CONF/pki/apiserver.crt \
+		--tls-private-key-file=$CONF/pki/apiserver.key \
+		--service-account-key-file=$CONF/pki/apiserver.key \
+		--admission-control=NamespaceLifecycle,LimitRanger,ServiceAccount,ResourceQuota
+	unit kube-controller-manager $BIN/kube-controller-manager \
+		--master=http://127.0.0.1:8080 \
+		--allocate-node-cidrs=true \
+		--cluster-cidr=$POD_CIDR \
+		--root-ca-file=$CONF/pki/ca.crt \
+		--service-account-private-key-file=$CONF/pki/apiserver.key
+	unit kube-scheduler $BIN/kube-scheduler \
+		--master=http://127.0.0.1:8080
+	;;
+node)
+	binary kubelet
+	binary kube-proxy
+	unit kubelet $BIN/kubelet \
+		--api-servers=https://$KUBE_CLUSTER_MASTER:6443 \
+		--kubeconfig=$CONF/kubeconfig \
+		--hostname-override=$KUBE_CLUSTER_NAME \
+		--cluster-domain=$DNS_DOMAIN \
+		--root-dir=$DATA/kubelet
+	unit kube-proxy $BIN/kube-proxy \
+		--master=https://$KUBE_CLUSTER_MASTER:6443 \
+		--kubeconfig=$CONF/kubeconfig \
+		--hostname-override=$KUBE_CLUSTER_NAME
+	;;
+*)
+	fail "unknown role $KUBE_CLUSTER_ROLE"
+	;;
+esac
+
+systemctl daemon-reload
+for name in $UNITS; do
+	systemctl enable $name >/dev/null 2>&1
+	systemctl restart $name
+	echo "unit $name"
+done
+`
@@ -0,0 +1,425 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+)
+
+// machinePrefixes are the absolute paths the driver installs to, which the
+// test server maps below its root.
+var machinePrefixes = []string{"/etc/kube-cluster", "/opt/kube-cluster", "/var/lib/kube-cluster", "/etc/systemd/system"}
+
+// stubs stand in for the commands the bootstrap script relies on. systemctl
+// logs its calls, and only finds the units marked in the units directory.
+var stubs = map[string]string{
+	"sudo": `[ "$1" = -n ] && shift
+exec "$@"`,
+	"systemctl": `echo "$*" >>"$STUB_ROOT/systemctl.log"
+case $1 in
+cat) [ -e "$STUB_ROOT/units/${2%.service}" ] ;;
+is-active) shift; for u; do echo active; done ;;
+esac`,
+	"curl": `while [ $# -gt 0 ]; do
+	[ "$1" = -o ] && printf '#!/bin/sh\n' >"$2"
+	shift
+done`,
+	"tar": `while [ $# -gt 0 ]; do
+	[ "$1" = -C ] && printf '#!/bin/sh\n' >"$2/etcd"
+	shift
+done
+cat >/dev/null`,
+	"docker": `exit 0`,
+}
+
+// server is an SSH server standing for a machine. It runs the commands it is
+// given with sh, and serves scp over SFTP, in a home directory. The paths the
+// driver installs to are mapped below root, in commands, uploaded files and
+// output alike.
+type server struct {
+	root     string
+	home     string
+	listener net.Listener
+	config   *ssh.ServerConfig
+	forward  *strings.Replacer
+	reverse  *strings.Replacer
+	env      []string
+	wg       sync.WaitGroup
+}
+
+// newServer starts a server accepting key, with its files in a new directory.
+func newServer(t *testing.T, key ssh.PublicKey) *server {
+	root, err := ioutil.TempDir("", "ssh-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{root: root, home: filepath.Join(root, "home")}
+	for _, dir := range []string{"home", "bin", "units", "etc/systemd/system", "opt", "var/lib"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, script := range stubs {
+		if err := ioutil.WriteFile(filepath.Join(root, "bin", name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var forward, reverse []string
+	for _, p := range machinePrefixes {
+		forward = append(forward, p, root+p)
+		reverse = append(reverse, root+p, p)
+	}
+	s.forward, s.reverse = strings.NewReplacer(forward...), strings.NewReplacer(reverse...)
+	s.env = []string{
+		"PATH=" + filepath.Join(root, "bin") + ":/usr/local/bin:/usr/bin:/bin",
+		"HOME=" + s.home,
+		"STUB_ROOT=" + root,
+	}
+
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, offered ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(offered.Marshal()) != string(key.Marshal()) {
+				return nil, fmt.Errorf("unknown key for %s", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	if s.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(t, conn)
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *server) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *server) close() {
+	s.listener.Close()
+	s.wg.Wait()
+	os.RemoveAll(s.root)
+}
+
+// path returns the path of the machine path p on the local disk.
+func (s *server) path(p string) string {
+	return s.forward.Replace(p)
+}
+
+// read returns the content of the machine file p, the paths of the machine
+// mapped back.
+func (s *server) read(p string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(p))
+	return []byte(s.reverse.Replace(string(data))), err
+}
+
+func (s *server) serve(t *testing.T, conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for nc := range channels {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		go s.session(t, ch, requests)
+	}
+}
+
+// session runs the first exec or sftp subsystem request of a session.
+func (s *server) session(t *testing.T, ch ssh.Channel, requests <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range requests {
+		var payload struct{ Value string }
+		switch req.Type {
+		case "exec", "subsystem":
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+		default:
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		status := 0
+		if req.Type == "subsystem" {
+			if payload.Value != "sftp" {
+				status = 1
+			} else if err := s.sftp(ch); err != nil && err != io.EOF {
+				t.Errorf("sftp: %v", err)
+				status = 1
+			}
+		} else {
+			status = s.exec(ch, payload.Value)
+		}
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+		return
+	}
+}
+
+// exec runs command, and returns its exit status.
+func (s *server) exec(ch ssh.Channel, command string) int {
+	cmd := exec.Command("sh", "-c", s.forward.Replace(command))
+	cmd.Dir, cmd.Env = s.home, s.env
+	var stdout, stderr strings.Builder
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	io.WriteString(ch, s.reverse.Replace(stdout.String()))
+	io.WriteString(ch.Stderr(), s.reverse.Replace(stderr.String()))
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.Sys().(syscall.WaitStatus).ExitStatus()
+	} else if err != nil {
+		return 127
+	}
+	return 0
+}
+
+// SFTP version 3 packets and status codes, see
+// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-02
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpWrite    = 6
+	fxpLstat    = 7
+	fxpFstat    = 8
+	fxpSetstat  = 9
+	fxpFsetstat = 10
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpName     = 104
+	fxpAttrs    = 105
+
+	fxOK          = 0
+	fxNoSuchFile  = 2
+	fxFailure     = 4
+	fxUnsupported = 8
+
+	attrSize        = 0x1
+	attrPermissions = 0x4
+)
+
+// sftp serves the SFTP requests scp makes to upload files, until the client
+// is done. Uploaded files have their machine paths mapped once closed.
+func (s *server) sftp(rw io.ReadWriter) error {
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for {
+		var size uint32
+		if err := binary.Read(rw, binary.BigEndian, &size); err != nil {
+			return err
+		}
+		packet := make([]byte, size)
+		if _, err := io.ReadFull(rw, packet); err != nil {
+			return err
+		}
+		r := &reader{data: packet[1:]}
+		if packet[0] == fxpInit {
+			if err := reply(rw, fxpVersion, uint32(3)); err != nil {
+				return err
+			}
+			continue
+		}
+		id := r.uint32()
+
+		var err error
+		switch packet[0] {
+		case fxpOpen:
+			path := s.resolve(r.string())
+			flags := os.O_WRONLY | os.O_CREATE
+			if r.uint32()&0x10 != 0 {
+				flags |= os.O_TRUNC
+			}
+			f, openErr := os.OpenFile(path, flags, 0600)
+			if openErr != nil {
+				err = status(rw, id, fxFailure, openErr.Error())
+				break
+			}
+			handle := fmt.Sprint(len(files))
+			files[handle] = f
+			err = reply(rw, fxpHandle, id, handle)
+		case fxpWrite:
+			f := files[r.string()]
+			offset := r.uint64()
+			if _, writeErr := f.WriteAt([]byte(r.string()), int64(offset)); writeErr != nil {
+				err = status(rw, id, fxFailure, writeErr.Error())
+				break
+			}
+			err = status(rw, id, fxOK, "")
+		case fxpClose:
+			handle := r.string()
+			f := files[handle]
+			delete(files, handle)
+			f.Close()
+			data, readErr := ioutil.ReadFile(f.Name())
+			if readErr == nil {
+				readErr = ioutil.WriteFile(f.Name(), []byte(s.forward.Replace(string(data))), 0600)
+			}
+			if readErr != nil {
+				err = status(rw, id, fxFailure, readErr.Error())
+				break
+			}
+			err = status(rw, id, fxOK, "")
+		case fxpStat, fxpLstat, fxpFstat:
+			var fi os.FileInfo
+			var statErr error
+			if packet[0] == fxpFstat {
+				fi, statErr = files[r.string()].Stat()
+			} else {
+				fi, statErr = os.Stat(s.resolve(r.string()))
+			}
+			if statErr != nil {
+				err = status(rw, id, fxNoSuchFile, statErr.Error())
+				break
+			}
+			mode := uint32(fi.Mode().Perm()) | 0100000
+			if fi.IsDir() {
+				mode = uint32(fi.Mode().Perm()) | 040000
+			}
+			err = reply(rw, fxpAttrs, id, uint32(attrSize|attrPermissions), uint64(fi.Size()), mode)
+		case fxpSetstat, fxpFsetstat:
+			err = status(rw, id, fxOK, "")
+		case fxpRealpath:
+			path := s.resolve(r.string())
+			err = reply(rw, fxpName, id, uint32(1), path, path, uint32(0))
+		default:
+			err = status(rw, id, fxUnsupported, "unsupported")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// resolve returns the local path of an SFTP path, relative to the home.
+func (s *server) resolve(path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.home, path)
+	}
+	return filepath.Clean(path)
+}
+
+func status(w io.Writer, id, code uint32, message string) error {
+	return reply(w, fxpStatus, id, code, message, "")
+}
+
+// reply sends a packet of type t made of fields, uint32, uint64 or string.
+func reply(w io.Writer, t byte, fields ...interface{}) error {
+	data := []byte{t}
+	for _, f := range fields {
+		switch v := f.(type) {
+		case uint32:
+			data = binary.BigEndian.AppendUint32(data, v)
+		case uint64:
+			data = binary.BigEndian.AppendUint64(data, v)
+		case string:
+			data = binary.BigEndian.AppendUint32(data, uint32(len(v)))
+			data = append(data, v...)
+		}
+	}
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	_, err := w.Write(append(packet, data...))
+	return err
+}
+
+// reader decodes the fields of a packet.
+type reader struct {
+	data []byte
+}
+
+func (r *reader) uint32() uint32 {
+	if len(r.data) < 4 {
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *reader) uint64() uint64 {
+	if len(r.data) < 8 {
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *reader) string() string {
+	n := int(r.uint32())
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	v := string(r.data[:n])
+	r.data = r.data[n:]
+	return v
+}
@@ -0,0 +1,780 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ssh implements a driver adopting existing machines into kubernetes
+// environments. The machines, given by the hosts option, are reached with the
+// ssh and scp commands, run through Config.Runner: the first one becomes the
+// master, the others the nodes.
+//
+// Every machine gets its settings in /etc/kube-cluster/environment, the
+// certificates it needs in /etc/kube-cluster/pki, and a bootstrap script
+// installing the kubernetes components as systemd units. Nothing existing is
+// ever replaced. Each file, directory and unit created is recorded in the
+// environment directory, and Remove deletes exactly those, leaving the
+// machines as they were found.
+//
+// Commands run as ssh-user, through sudo unless it is root.
+package ssh
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/pki"
+)
+
+const (
+	driverName         = "ssh"
+	stateFileName      = "ssh-state.json"
+	knownHostsFileName = "ssh-known-hosts"
+	defaultPool        = "default"
+
+	optHosts   = "hosts"
+	optUser    = "ssh-user"
+	optKey     = "ssh-key"
+	optTimeout = "ssh-timeout"
+
+	defaultPort   = "22"
+	apiServerPort = 6443
+	bootstrapPath = "/opt/kube-cluster/bootstrap.sh"
+)
+
+// directories are created on every machine before any file is installed.
+var directories = []string{"/etc/kube-cluster", "/etc/kube-cluster/pki", "/opt/kube-cluster"}
+
+func init() {
+	driver.Register(driverName, New,
+		driver.Option{
+			Name:     optHosts,
+			Usage:    "comma separated addresses, as host or host:port, of the machines adopted by the ssh driver, the master first",
+			Prompt:   "Hosts (master first, comma separated)",
+			Required: true,
+			Validate: validateHosts,
+		},
+		driver.Option{
+			Name:    optUser,
+			Usage:   "user the ssh driver logs in as, root or allowed to sudo without password",
+			Default: "root",
+			Prompt:  "SSH user",
+		},
+		driver.Option{
+			Name:   optKey,
+			Usage:  "private key the ssh driver logs in with, the SSH agent and default keys otherwise",
+			Prompt: "SSH private key file",
+		},
+		driver.Option{
+			Name:     optTimeout,
+			Usage:    "how long to wait for hosts to answer on SSH",
+			Default:  "1m",
+			Validate: driver.ValidateDuration,
+		},
+	)
+}
+
+func validateHosts(value string) error {
+	_, err := parseHosts(value)
+	return err
+}
+
+// parseHosts splits a comma separated list of host or host:port addresses.
+func parseHosts(value string) ([]string, error) {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, h := range strings.Split(value, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		host, port := splitAddress(h)
+		if host == "" {
+			return nil, fmt.Errorf("invalid host %q", h)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid port in %q", h)
+		}
+		if seen[h] {
+			return nil, fmt.Errorf("host %q is given twice", h)
+		}
+		seen[h] = true
+		hosts = append(hosts, h)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no host given")
+	}
+	return hosts, nil
+}
+
+// splitAddress returns the host and SSH port of a host or host:port address.
+func splitAddress(address string) (host, port string) {
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		return host, port
+	}
+	return strings.Trim(address, "[]"), defaultPort
+}
+
+// machine is an adopted host, and what was installed on it.
+type machine struct {
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	Address string `json:"address"`
+	// Installed lists what was created on the machine, in creation order.
+	Installed []driver.Resource `json:"installed,omitempty"`
+}
+
+func (m *machine) host() string {
+	host, _ := splitAddress(m.Address)
+	return host
+}
+
+// units returns the systemd units installed on the machine.
+func (m *machine) units() []string {
+	var units []string
+	for _, r := range m.Installed {
+		if r.Kind == "unit" {
+			units = append(units, r.ID)
+		}
+	}
+	return units
+}
+
+// paths returns the paths installed on the machine.
+func (m *machine) paths() []string {
+	var paths []string
+	for _, r := range m.Installed {
+		if r.Kind != "unit" {
+			paths = append(paths, r.ID)
+		}
+	}
+	return paths
+}
+
+// record adds the resources reported by commands run on the machine, as
+// "KIND ID" lines.
+func (m *machine) record(output string) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "file", "dir", "tree", "unit":
+		default:
+			continue
+		}
+		r := driver.Resource{Kind: fields[0], ID: fields[1]}
+		known := false
+		for _, i := range m.Installed {
+			known = known || i == r
+		}
+		if !known {
+			m.Installed = append(m.Installed, r)
+		}
+	}
+}
+
+type state struct {
+	Machines []*machine `json:"machines"`
+}
+
+func (s *state) machine(name string) *machine {
+	for _, m := range s.Machines {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// names returns the names of the machines of the given role.
+func (s *state) names(role string) []string {
+	var names []string
+	for _, m := range s.Machines {
+		if m.Role == role {
+			names = append(names, m.Name)
+		}
+	}
+	return names
+}
+
+// waitForSSH is replaced by tests.
+var waitForSSH = driver.WaitForSSH
+
+// Driver adopts existing machines into an environment over SSH.
+type Driver struct {
+	name    string
+	hosts   []string
+	user    string
+	key     string
+	timeout time.Duration
+	config  *driver.Config
+
+	// mu guards the state while machines are worked on concurrently.
+	mu sync.Mutex
+}
+
+// New instantiates an ssh driver.
+func New(c *driver.Config) (driver.Driver, error) {
+	hosts, err := parseHosts(c.Option(optHosts, ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optHosts, err)
+	}
+	timeout, err := time.ParseDuration(c.Option(optTimeout, "1m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optTimeout, err)
+	}
+	return &Driver{
+		name:    c.Name,
+		hosts:   hosts,
+		user:    c.Option(optUser, "root"),
+		key:     c.Option(optKey, ""),
+		timeout: timeout,
+		config:  c,
+	}, nil
+}
+
+// Create bootstraps the master, then the nodes. The number of nodes of the
+// environment must match the hosts, all in the default pool. Bootstrapping
+// can be run again after a failure, what is already installed is kept.
+func (d *Driver) Create() error {
+	nodes := d.config.Nodes
+	if len(d.config.Pools) > 1 || len(d.config.Pools) == 1 && d.config.Pools[0].Name != defaultPool {
+		return fmt.Errorf("ssh: node pools are not supported, all the hosts are in the %s pool", defaultPool)
+	} else if len(d.config.Pools) == 1 {
+		nodes = d.config.Pools[0].Nodes
+	}
+	if nodes != len(d.hosts)-1 {
+		return fmt.Errorf("ssh: %d host(s) make a master and %d node(s), not %d", len(d.hosts), len(d.hosts)-1, nodes)
+	}
+	// hosts given by name are not resolved, their addresses may differ
+	// from where the cluster stands
+	var ips []net.IP
+	for _, h := range d.hosts {
+		host, _ := splitAddress(h)
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	if err := d.config.Networking.CheckHosts(ips...); err != nil {
+		return fmt.Errorf("ssh: %v", err)
+	}
+
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	if len(s.Machines) == 0 {
+		for i, h := range d.hosts {
+			m := &machine{Name: d.name + "-master", Role: driver.MasterRole, Address: h}
+			if i > 0 {
+				m.Name, m.Role = fmt.Sprintf("%s-node-%d", d.name, i), driver.NodeRole
+			}
+			s.Machines = append(s.Machines, m)
+		}
+		if err := d.save(s); err != nil {
+			return err
+		}
+	}
+
+	for _, role := range []string{driver.MasterRole, driver.NodeRole} {
+		names := s.names(role)
+		if len(names) == 0 {
+			continue
+		}
+		err := d.config.Journal.Run("bootstrap "+role, func(record func(kind, id string)) error {
+			for _, name := range names {
+				record("host", s.machine(name).Address)
+			}
+			return driver.ForEachNode(d.config, "bootstrapping", names, func(name string) error {
+				return d.bootstrap(s, s.machine(name))
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootstrap installs the settings of m and the kubernetes components.
+func (d *Driver) bootstrap(s *state, m *machine) error {
+	host, port := splitAddress(m.Address)
+	if err := waitForSSH(net.JoinHostPort(host, port), d.timeout); err != nil {
+		return err
+	}
+
+	b := driver.BootConfig{Role: m.Role, Name: m.Name, Master: s.Machines[0].host()}
+	if m.Role == driver.NodeRole {
+		b.Pool = defaultPool
+	}
+	files := []file{
+		{path: "/etc/kube-cluster/environment", mode: "0644", data: d.config.BootEnvironment(b)},
+		{path: bootstrapPath, mode: "0755", data: []byte(bootstrapScript)},
+	}
+	if m.Role == driver.NodeRole {
+		files = append(files, d.kubeconfigFile(s, m))
+	}
+	if err := d.install(s, m, files); err != nil {
+		return err
+	}
+
+	args := []string{"sh", bootstrapPath}
+	for _, p := range m.paths() {
+		args = append(args, driver.ShellQuote(p))
+	}
+	out, err := d.ssh(m, d.privileged(strings.Join(args, " ")))
+	if saveErr := d.update(s, m, out); saveErr != nil && err == nil {
+		return saveErr
+	}
+	return err
+}
+
+// file is installed on machines by install.
+type file struct {
+	path string
+	mode string
+	data []byte
+}
+
+// install copies files to m, through a staging directory in the home of the
+// user. Files are only replaced if they were installed by kube-cluster.
+func (d *Driver) install(s *state, m *machine, files []file) error {
+	local, err := ioutil.TempDir("", "kube-cluster-ssh")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(local)
+
+	stage := ".kube-cluster-" + d.name
+	installed := make(map[string]bool)
+	for _, p := range m.paths() {
+		installed[p] = true
+	}
+
+	var sources []string
+	script := []string{"set -e", "trap " + driver.ShellQuote("rm -rf "+stage) + " EXIT"}
+	for _, dir := range directories {
+		q := driver.ShellQuote(dir)
+		script = append(script, fmt.Sprintf("if [ ! -d %s ]; then mkdir -m 0755 %s; echo dir %s; fi", q, q, q))
+	}
+	for i, f := range files {
+		src := filepath.Join(local, strconv.Itoa(i))
+		if err := ioutil.WriteFile(src, f.data, 0600); err != nil {
+			return err
+		}
+		sources = append(sources, src)
+
+		q := driver.ShellQuote(f.path)
+		if !installed[f.path] {
+			script = append(script, fmt.Sprintf("if [ -e %s ] || [ -L %s ]; then echo %s >&2; exit 1; fi", q, q, driver.ShellQuote(f.path+" already exists")))
+		}
+	}
+	for i, f := range files {
+		q := driver.ShellQuote(f.path)
+		script = append(script, fmt.Sprintf("install -m %s %s/%d %s", f.mode, stage, i, q))
+		if !installed[f.path] {
+			script = append(script, "echo file "+q)
+		}
+	}
+
+	if _, err := d.ssh(m, fmt.Sprintf("rm -rf %s && mkdir -m 0700 %s", stage, stage)); err != nil {
+		return err
+	}
+	if err := d.scp(m, sources, stage); err != nil {
+		return err
+	}
+	out, err := d.ssh(m, d.privileged(strings.Join(script, "\n")))
+	if saveErr := d.update(s, m, out); saveErr != nil && err == nil {
+		return saveErr
+	}
+	return err
+}
+
+// uninstall removes what was installed on m: the units are disabled, with the
+// containers the kubelet started, then the paths are deleted in reverse order.
+// Directories are only removed if empty, unless created as whole trees.
+func (d *Driver) uninstall(m *machine) error {
+	var script []string
+	if units := m.units(); len(units) > 0 {
+		script = append(script, "systemctl disable --now "+strings.Join(units, " ")+" >/dev/null 2>&1 || true")
+		for _, u := range units {
+			if u == "kubelet" {
+				script = append(script, "docker ps -aq --filter name=k8s_ | xargs -r docker rm -f >/dev/null 2>&1 || true")
+			}
+		}
+	}
+	for i := len(m.Installed) - 1; i >= 0; i-- {
+		q := driver.ShellQuote(m.Installed[i].ID)
+		switch m.Installed[i].Kind {
+		case "file":
+			script = append(script, "rm -f "+q)
+		case "tree":
+			script = append(script, "rm -rf "+q)
+		case "dir":
+			script = append(script, "rmdir "+q+" 2>/dev/null || true")
+		}
+	}
+	if len(m.units()) > 0 {
+		script = append(script, "systemctl daemon-reload")
+	}
+	if len(script) == 0 {
+		return nil
+	}
+	_, err := d.ssh(m, d.privileged(strings.Join(script, "\n")))
+	return err
+}
+
+// Remove uninstalls what was installed on every machine, which are otherwise
+// left untouched. Machines that cannot be cleaned up stay recorded, so Remove
+// can be retried.
+func (d *Driver) Remove() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, m := range s.Machines {
+		names = append(names, m.Name)
+	}
+	err = driver.ForEachNode(d.config, "uninstalling", names, func(name string) error {
+		return d.uninstall(s.machine(name))
+	})
+
+	failed := make(map[string]error)
+	if nodesErr, ok := err.(*driver.NodesError); ok {
+		failed = nodesErr.Failed
+	}
+	kept := s.Machines[:0]
+	for _, m := range s.Machines {
+		if failed[m.Name] != nil {
+			kept = append(kept, m)
+		}
+	}
+	s.Machines = kept
+	if len(s.Machines) == 0 {
+		if rmErr := os.Remove(d.statePath()); rmErr != nil && !os.IsNotExist(rmErr) {
+			return rmErr
+		}
+		return err
+	}
+	if saveErr := d.save(s); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// Start starts the installed units.
+func (d *Driver) Start() error {
+	return d.systemctl("starting", "start")
+}
+
+// Stop stops the installed units, the machines keep running.
+func (d *Driver) Stop() error {
+	return d.systemctl("stopping", "stop")
+}
+
+func (d *Driver) systemctl(action, command string) error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, m := range s.Machines {
+		names = append(names, m.Name)
+	}
+	return driver.ForEachNode(d.config, action, names, func(name string) error {
+		m := s.machine(name)
+		if len(m.units()) == 0 {
+			return fmt.Errorf("ssh: nothing is installed on %s", m.Address)
+		}
+		_, err := d.ssh(m, d.privileged("systemctl "+command+" "+strings.Join(m.units(), " ")))
+		return err
+	})
+}
+
+// Status reports Running when the units of all machines are active, Stopped
+// when they are all inactive, and Error otherwise.
+func (d *Driver) Status() (driver.State, error) {
+	s, err := d.load()
+	if err != nil {
+		return driver.Error, err
+	}
+	if len(s.Machines) == 0 {
+		return driver.None, nil
+	}
+
+	states := make(map[driver.State]bool)
+	for _, m := range s.Machines {
+		units := m.units()
+		if len(units) == 0 {
+			return driver.Error, nil
+		}
+		// is-active fails unless all units are active, the output tells
+		// whether the machine could be reached.
+		out, _ := d.ssh(m, "systemctl is-active "+strings.Join(units, " "))
+		lines := strings.Fields(out)
+		if len(lines) != len(units) {
+			return driver.Error, nil
+		}
+		for _, l := range lines {
+			switch l {
+			case "active":
+				states[driver.Running] = true
+			case "inactive":
+				states[driver.Stopped] = true
+			case "activating":
+				states[driver.Starting] = true
+			case "deactivating":
+				states[driver.Stopping] = true
+			default:
+				states[driver.Error] = true
+			}
+		}
+	}
+	if len(states) == 1 {
+		for st := range states {
+			return st, nil
+		}
+	}
+	return driver.Error, nil
+}
+
+// GetURL returns the API server address, on the master.
+func (d *Driver) GetURL() (string, error) {
+	host, _ := splitAddress(d.hosts[0])
+	return fmt.Sprintf("https://%s", net.JoinHostPort(host, strconv.Itoa(apiServerPort))), nil
+}
+
+// GetKubeconfig returns an admin kubeconfig for the API server.
+func (d *Driver) GetKubeconfig() ([]byte, error) {
+	url, err := d.GetURL()
+	if err != nil {
+		return nil, err
+	}
+	return driver.AdminKubeconfig(d.config, url)
+}
+
+// Scale fails: the nodes are the given hosts.
+func (d *Driver) Scale(nodes int) error {
+	return fmt.Errorf("ssh: environments cannot be scaled, their nodes are the hosts they were created with")
+}
+
+// Nodes returns the nodes, the master excluded.
+func (d *Driver) Nodes() ([]driver.Node, error) {
+	s, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []driver.Node
+	for _, m := range s.Machines {
+		if m.Role == driver.NodeRole {
+			nodes = append(nodes, driver.Node{Name: m.Name, Pool: defaultPool, Address: m.host()})
+		}
+	}
+	return nodes, nil
+}
+
+// InstallCertificates copies the certificates of the environment to the
+// machines, and restarts the units using them. Each machine gets the
+// certificate authority and the certificates of its role, see
+// driver.RoleCertificates: the master serves the API server one, the nodes
+// authenticate with the node client one.
+func (d *Driver) InstallCertificates() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, m := range s.Machines {
+		names = append(names, m.Name)
+	}
+	return driver.ForEachNode(d.config, "installing certificates", names, func(name string) error {
+		m := s.machine(name)
+		var files []file
+		for _, c := range append([]string{pki.CA}, driver.RoleCertificates[m.Role]...) {
+			data, err := ioutil.ReadFile(d.config.PKI.CertPath(c))
+			if err != nil {
+				return err
+			}
+			files = append(files, file{path: "/etc/kube-cluster/pki/" + c + ".crt", mode: "0644", data: data})
+			// the key of the certificate authority stays on the host
+			if c == pki.CA {
+				continue
+			}
+			if data, err = ioutil.ReadFile(d.config.PKI.KeyPath(c)); err != nil {
+				return err
+			}
+			files = append(files, file{path: "/etc/kube-cluster/pki/" + c + ".key", mode: "0600", data: data})
+		}
+		// environments bootstrapped by earlier releases get the kubeconfig
+		// using the node certificate
+		if m.Role == driver.NodeRole {
+			files = append(files, d.kubeconfigFile(s, m))
+		}
+		if err := d.install(s, m, files); err != nil {
+			return err
+		}
+		if err := d.removeStale(s, m, files); err != nil {
+			return err
+		}
+		if units := m.units(); len(units) > 0 {
+			_, err := d.ssh(m, d.privileged("systemctl try-restart "+strings.Join(units, " ")))
+			return err
+		}
+		return nil
+	})
+}
+
+// kubeconfigFile returns the kubeconfig of the node m.
+func (d *Driver) kubeconfigFile(s *state, m *machine) file {
+	server := "https://" + net.JoinHostPort(s.Machines[0].host(), strconv.Itoa(apiServerPort))
+	return file{path: nodeKubeconfigPath, mode: "0600", data: nodeKubeconfig(d.name, server, m.Name)}
+}
+
+// removeStale deletes the certificates and keys installed on m by earlier
+// releases and no longer among files, such as the admin ones on nodes.
+func (d *Driver) removeStale(s *state, m *machine, files []file) error {
+	current := make(map[string]bool)
+	for _, f := range files {
+		current[f.path] = true
+	}
+	var stale []string
+	for _, r := range m.Installed {
+		if r.Kind == "file" && strings.HasPrefix(r.ID, "/etc/kube-cluster/pki/") && !current[r.ID] {
+			stale = append(stale, driver.ShellQuote(r.ID))
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	if _, err := d.ssh(m, d.privileged("rm -f "+strings.Join(stale, " "))); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	kept := m.Installed[:0]
+	for _, r := range m.Installed {
+		if r.Kind != "file" || !strings.HasPrefix(r.ID, "/etc/kube-cluster/pki/") || current[r.ID] {
+			kept = append(kept, r)
+		}
+	}
+	m.Installed = kept
+	return d.save(s)
+}
+
+// options returns the options common to ssh and scp. Host keys are recorded
+// in the environment directory when first seen, and checked afterwards.
+func (d *Driver) options() []string {
+	opts := []string{
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout=10",
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", "UserKnownHostsFile=" + filepath.Join(d.config.StorePath, knownHostsFileName),
+	}
+	if d.key != "" {
+		opts = append(opts, "-i", d.key)
+	}
+	return opts
+}
+
+// ssh runs command on m, and returns its output.
+func (d *Driver) ssh(m *machine, command string) (string, error) {
+	host, port := splitAddress(m.Address)
+	args := append(d.options(), "-p", port, d.user+"@"+host, command)
+	out, err := d.config.Run("ssh", args...)
+	return out, commandError(m, err)
+}
+
+// scp copies the local files to dir on m, relative to the home of the user.
+func (d *Driver) scp(m *machine, files []string, dir string) error {
+	host, port := splitAddress(m.Address)
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	args := append(d.options(), "-P", port)
+	args = append(args, files...)
+	_, err := d.config.Run("scp", append(args, d.user+"@"+host+":"+dir+"/")...)
+	return commandError(m, err)
+}
+
+// commandError reports the failure of a command run on m by its error output,
+// the command lines holding whole scripts.
+func commandError(m *machine, err error) error {
+	cmdErr, ok := err.(*driver.CommandError)
+	if !ok {
+		return err
+	}
+	if cmdErr.Stderr != "" {
+		return fmt.Errorf("%s: %s", m.Address, cmdErr.Stderr)
+	}
+	return fmt.Errorf("%s: %s: %v", m.Address, cmdErr.Args[0], cmdErr.Err)
+}
+
+// privileged returns the command running script as root.
+func (d *Driver) privileged(script string) string {
+	command := "sh -c " + driver.ShellQuote(script)
+	if d.user != "root" {
+		command = "sudo -n " + command
+	}
+	return command
+}
+
+// update records what the output of a command run on m reports installed.
+func (d *Driver) update(s *state, m *machine, output string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m.record(output)
+	return d.save(s)
+}
+
+func (d *Driver) statePath() string {
+	return filepath.Join(d.config.StorePath, stateFileName)
+}
+
+func (d *Driver) load() (*state, error) {
+	s := &state{}
+	data, err := ioutil.ReadFile(d.statePath())
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("ssh: corrupt state: %v", err)
+	}
+	return s, nil
+}
+
+func (d *Driver) save(s *state) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.config.StorePath, 0700); err != nil {
+		return err
+	}
+	return envstore.WriteFileAtomic(d.statePath(), data, 0600)
+}
@@ -0,0 +1,336 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/pki"
+)
+
+type testEnv struct {
+	*Driver
+	dir          string
+	master, node *server
+}
+
+// newTestEnv returns a driver adopting a master and a node served by test
+// servers, logging in with the real ssh and scp commands.
+func newTestEnv(t *testing.T) (*testEnv, func()) {
+	for _, cmd := range []string{"ssh", "scp"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("no %s: %v", cmd, err)
+		}
+	}
+	dir, err := ioutil.TempDir("", "ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ecdsa")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	public, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, node := newServer(t, public), newServer(t, public)
+
+	j, err := driver.OpenJournal(filepath.Join(dir, "journal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(&driver.Config{
+		Name:      "dev",
+		StorePath: filepath.Join(dir, "env"),
+		Nodes:     1,
+		Options: map[string]string{
+			optHosts: master.address() + "," + node.address(),
+			optUser:  "admin",
+			optKey:   keyPath,
+		},
+		Networking: driver.Networking{ServiceCIDR: "10.32.0.0/24", DNSDomain: "dev.local"},
+		Journal:    j,
+		PKI:        pki.New(filepath.Join(dir, "env", "pki"), pki.Options{Algorithm: pki.ECDSA}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{Driver: d.(*Driver), dir: dir, master: master, node: node}, func() {
+		master.close()
+		node.close()
+		os.RemoveAll(dir)
+	}
+}
+
+func init() {
+	waitForSSH = func(string, time.Duration) error { return nil }
+}
+
+// exists reports which of the machine paths exist on s.
+func exists(s *server, paths ...string) map[string]bool {
+	found := make(map[string]bool)
+	for _, p := range paths {
+		_, err := os.Lstat(s.path(p))
+		found[p] = err == nil
+	}
+	return found
+}
+
+func systemctlLog(t *testing.T, s *server) string {
+	data, err := ioutil.ReadFile(filepath.Join(s.root, "systemctl.log"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLifecycle(t *testing.T) {
+	e, cleanup := newTestEnv(t)
+	defer cleanup()
+
+	if err := e.Create(); err != nil {
+		t.Fatal(err)
+	}
+	for p, ok := range exists(e.master,
+		"/etc/kube-cluster/environment",
+		"/opt/kube-cluster/bin/etcd",
+		"/opt/kube-cluster/bin/kube-apiserver",
+		"/etc/systemd/system/kube-apiserver.service",
+		"/etc/systemd/system/kube-etcd.service",
+		"/var/lib/kube-cluster",
+	) {
+		if !ok {
+			t.Errorf("master lacks %s", p)
+		}
+	}
+	kubeconfig, err := e.node.read(nodeKubeconfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"server: https://127.0.0.1:6443\n",
+		"client-certificate: /etc/kube-cluster/pki/node.crt\n",
+		"client-key: /etc/kube-cluster/pki/node.key\n",
+	} {
+		if !strings.Contains(string(kubeconfig), want) {
+			t.Errorf("node kubeconfig lacks %q:\n%s", want, kubeconfig)
+		}
+	}
+	unit, err := e.node.read("/etc/systemd/system/kubelet.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(unit), "ExecStart=/opt/kube-cluster/bin/kubelet ") || !strings.Contains(string(unit), "--cluster-domain=dev.local ") {
+		t.Errorf("got kubelet unit:\n%s", unit)
+	}
+	unit, err = e.master.read("/etc/systemd/system/kube-apiserver.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(unit), "--service-cluster-ip-range=10.32.0.0/24 ") {
+		t.Errorf("got kube-apiserver unit:\n%s", unit)
+	}
+	if log := systemctlLog(t, e.node); !strings.Contains(log, "restart kubelet\n") {
+		t.Errorf("kubelet not started:\n%s", log)
+	}
+
+	// bootstrapping again keeps what was installed
+	if err := e.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := e.Status(); err != nil || st != driver.Running {
+		t.Errorf("got status %v, %v", st, err)
+	}
+
+	if err := e.config.PKI.Ensure("dev", pki.SANs{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.InstallCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		server  *server
+		present []string
+		absent  []string
+	}{
+		{e.master, []string{"ca.crt", "apiserver.crt", "apiserver.key"}, []string{"ca.key", "admin.crt", "admin.key", "node.key"}},
+		{e.node, []string{"ca.crt", "node.crt", "node.key"}, []string{"ca.key", "admin.crt", "admin.key", "apiserver.key"}},
+	} {
+		for _, name := range test.present {
+			local, err := ioutil.ReadFile(filepath.Join(filepath.Dir(e.config.PKI.CertPath(pki.CA)), name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			remote, err := test.server.read("/etc/kube-cluster/pki/" + name)
+			if err != nil || string(remote) != string(local) {
+				t.Errorf("%s: got %s, %v", test.server.address(), name, err)
+			}
+		}
+		for _, name := range test.absent {
+			if exists(test.server, "/etc/kube-cluster/pki/"+name)["/etc/kube-cluster/pki/"+name] {
+				t.Errorf("%s got %s", test.server.address(), name)
+			}
+		}
+	}
+	if log := systemctlLog(t, e.master); !strings.Contains(log, "try-restart kube-etcd kube-apiserver") {
+		t.Errorf("units not restarted:\n%s", log)
+	}
+
+	if err := e.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*server{e.master, e.node} {
+		for p, ok := range exists(s, "/etc/kube-cluster", "/opt/kube-cluster", "/var/lib/kube-cluster",
+			"/etc/systemd/system/kubelet.service", "/etc/systemd/system/kube-apiserver.service") {
+			if ok {
+				t.Errorf("%s kept %s", s.address(), p)
+			}
+		}
+		if files, err := ioutil.ReadDir(s.home); err != nil || len(files) != 0 {
+			t.Errorf("%s home holds %v, %v", s.address(), files, err)
+		}
+	}
+	if log := systemctlLog(t, e.node); !strings.Contains(log, "disable --now kubelet kube-proxy") {
+		t.Errorf("units not disabled:\n%s", log)
+	}
+}
+
+func TestCreateKeepsExistingUnits(t *testing.T) {
+	e, cleanup := newTestEnv(t)
+	defer cleanup()
+
+	// a kubelet shipped by the distribution
+	if err := ioutil.WriteFile(filepath.Join(e.node.root, "units", "kubelet"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := e.Create()
+	if err == nil || !strings.Contains(err.Error(), "unit kubelet already exists") {
+		t.Fatalf("got %v", err)
+	}
+	if exists(e.node, "/etc/systemd/system/kubelet.service")["/etc/systemd/system/kubelet.service"] {
+		t.Error("the kubelet unit was overridden")
+	}
+
+	if err := e.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if log := systemctlLog(t, e.node); strings.Contains(log, "disable") {
+		t.Errorf("units disabled:\n%s", log)
+	}
+	if exists(e.node, "/opt/kube-cluster")["/opt/kube-cluster"] {
+		t.Error("the node was not cleaned up")
+	}
+}
+
+func TestCreateRejectsOverlappingNetworking(t *testing.T) {
+	e, cleanup := newTestEnv(t)
+	defer cleanup()
+
+	e.config.Networking.ServiceCIDR = "127.0.0.0/16"
+	err := e.Create()
+	if err == nil || !strings.Contains(err.Error(), "in the service range 127.0.0.0/16") {
+		t.Fatalf("got %v", err)
+	}
+	if exists(e.master, "/etc/kube-cluster/environment")["/etc/kube-cluster/environment"] {
+		t.Error("the master was bootstrapped")
+	}
+}
+
+func TestInstallCertificatesRemovesAdmin(t *testing.T) {
+	e, cleanup := newTestEnv(t)
+	defer cleanup()
+
+	if err := e.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.config.PKI.Ensure("dev", pki.SANs{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// earlier releases installed the admin certificate on the nodes
+	s, err := e.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := s.machine("dev-node-1")
+	for _, name := range []string{"admin.crt", "admin.key"} {
+		path := "/etc/kube-cluster/pki/" + name
+		if err := ioutil.WriteFile(e.node.path(path), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+		m.Installed = append(m.Installed, driver.Resource{Kind: "file", ID: path})
+	}
+	if err := e.save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.InstallCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	found := exists(e.node, "/etc/kube-cluster/pki/admin.crt", "/etc/kube-cluster/pki/admin.key", "/etc/kube-cluster/pki/node.key")
+	if found["/etc/kube-cluster/pki/admin.crt"] || found["/etc/kube-cluster/pki/admin.key"] || !found["/etc/kube-cluster/pki/node.key"] {
+		t.Errorf("got node certificates %v", found)
+	}
+	if s, err = e.load(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range s.machine("dev-node-1").paths() {
+		if strings.Contains(p, "admin") {
+			t.Errorf("%s still recorded", p)
+		}
+	}
+}
+
+func TestParseHosts(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"10.0.0.1, 10.0.0.2:2222", "10.0.0.1 10.0.0.2:2222", true},
+		{"[fd00::1]:22,fd00::2", "[fd00::1]:22 fd00::2", true},
+		{"10.0.0.1,10.0.0.1", "", false},
+		{"10.0.0.1:0", "", false},
+		{" , ", "", false},
+	} {
+		hosts, err := parseHosts(test.value)
+		if (err == nil) != test.ok || strings.Join(hosts, " ") != test.want {
+			t.Errorf("parseHosts(%q) = %v, %v", test.value, hosts, err)
+		}
+	}
+}
@@ -0,0 +1,929 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vbox implements a driver running kubernetes environments on
+// VirtualBox virtual machines, managed with VBoxManage.
+//
+// Every machine is a full clone of a base VM, vbox-base-vm, expected to have
+// the kubernetes components and the VirtualBox guest additions installed. The
+// clones get a NAT adapter for internet access and a host-only adapter, on a
+// network of their own, to reach each other and the host. The base image
+// configures itself at boot from guest properties:
+//
+//	/kube-cluster/environment  environment name
+//	/kube-cluster/role         master or node
+//	/kube-cluster/name         kubernetes node name
+//	/kube-cluster/pool         node pool
+//	/kube-cluster/labels       node labels, as k1=v1,k2=v2
+//	/kube-cluster/master-ip    address of the master, on nodes
+//	/kube-cluster/pod-cidr     range of the pod addresses
+//	/kube-cluster/service-cidr range of the service addresses
+//	/kube-cluster/dns-domain   domain of the cluster service names
+//
+// and finds the certificates of its role in the read-only kube-cluster-pki
+// shared folder, once they are issued: ca.crt, with apiserver.crt and
+// apiserver.key on the master, node.crt and node.key on the nodes. The keys of
+// the certificate authority and of the admin never leave the host.
+package vbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/pki"
+)
+
+const (
+	driverName    = "vbox"
+	stateFileName = "vbox-state.json"
+	defaultPool   = "default"
+
+	optBaseVM       = "vbox-base-vm"
+	optCPUs         = "vbox-cpus"
+	optMemory       = "vbox-memory"
+	optDiskSize     = "vbox-disk-size"
+	optHostOnlyCIDR = "vbox-hostonly-cidr"
+	optBootTimeout  = "vbox-boot-timeout"
+	optVBoxManage   = "vbox-manage"
+
+	apiServerPort = 6443
+	pkiShare      = "kube-cluster-pki"
+	// sharesDir holds, below the environment directory, the folder shared
+	// with the VMs of each role.
+	sharesDir = "shares"
+	// ipProperty is the address of the second, host-only, adapter as
+	// reported by the guest additions.
+	ipProperty = "/VirtualBox/GuestInfo/Net/1/V4/IP"
+)
+
+func init() {
+	driver.Register(driverName, New,
+		driver.Option{
+			Name:    optBaseVM,
+			Usage:   "VirtualBox VM cloned for every machine of the environment",
+			Default: "kube-cluster-base",
+			Prompt:  "Base VM",
+		},
+		driver.Option{
+			Name:    optCPUs,
+			Usage:   "number of CPUs of each VirtualBox VM",
+			Default: "1",
+			Type:    driver.IntOption,
+			Prompt:  "CPUs per VM",
+		},
+		driver.Option{
+			Name:    optMemory,
+			Usage:   "memory of each VirtualBox VM, in MB",
+			Default: "1024",
+			Type:    driver.IntOption,
+			Prompt:  "Memory per VM (MB)",
+		},
+		driver.Option{
+			Name:    optDiskSize,
+			Usage:   "disk size of each VirtualBox VM, in MB, 0 keeps the base VM one",
+			Default: "0",
+			Type:    driver.IntOption,
+		},
+		driver.Option{
+			Name:     optHostOnlyCIDR,
+			Usage:    "host address and network of the VirtualBox host-only network, reused if it exists",
+			Default:  "192.168.99.1/24",
+			Validate: validateHostOnlyCIDR,
+		},
+		driver.Option{
+			Name:     optBootTimeout,
+			Usage:    "how long to wait for VirtualBox VMs to answer on SSH once started",
+			Default:  "5m",
+			Validate: driver.ValidateDuration,
+		},
+		driver.Option{
+			Name:    optVBoxManage,
+			Usage:   "VBoxManage command",
+			Default: "VBoxManage",
+		},
+	)
+}
+
+func validateHostOnlyCIDR(value string) error {
+	ip, n, err := net.ParseCIDR(value)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("invalid IPv4 CIDR %q", value)
+	}
+	if ones, _ := n.Mask.Size(); ones > 24 {
+		return fmt.Errorf("network %s is too small, use at most a /24", n)
+	}
+	if ip.Equal(n.IP) {
+		return fmt.Errorf("%s is the network address, give the host one, e.g. %s", ip, driver.NthIP(n, 1))
+	}
+	return nil
+}
+
+// waitForSSH is replaced in tests, where no VM ever boots.
+var waitForSSH = driver.WaitForSSH
+
+// vm is a virtual machine of the environment.
+type vm struct {
+	Name string `json:"name"`
+	Pool string `json:"pool,omitempty"`
+	IP   string `json:"ip,omitempty"`
+}
+
+type state struct {
+	// Network is the host-only interface of the environment, and
+	// NetworkCreated tells whether it was created for it.
+	Network        string `json:"network,omitempty"`
+	NetworkCreated bool   `json:"networkCreated,omitempty"`
+	Master         *vm    `json:"master,omitempty"`
+	Nodes          []vm   `json:"nodes"`
+}
+
+// Driver manages an environment made of VirtualBox VMs.
+type Driver struct {
+	name      string
+	storePath string
+	pools     []driver.Pool
+	config    *driver.Config
+
+	baseVM       string
+	cpus         int
+	memory       int
+	diskSize     int
+	hostOnlyCIDR string
+	bootTimeout  time.Duration
+	vboxManage   string
+
+	// mu guards the state while nodes are provisioned concurrently, and
+	// cloneMu serializes clones, which lock the base VM.
+	mu      sync.Mutex
+	cloneMu sync.Mutex
+}
+
+// New instantiates a VirtualBox driver.
+func New(c *driver.Config) (driver.Driver, error) {
+	ints := make(map[string]int)
+	for opt, def := range map[string]string{optCPUs: "1", optMemory: "1024", optDiskSize: "0"} {
+		v, err := strconv.Atoi(c.Option(opt, def))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s: %v", opt, err)
+		}
+		ints[opt] = v
+	}
+
+	bootTimeout, err := time.ParseDuration(c.Option(optBootTimeout, "5m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optBootTimeout, err)
+	}
+	cidr := c.Option(optHostOnlyCIDR, "192.168.99.1/24")
+	if err := validateHostOnlyCIDR(cidr); err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", optHostOnlyCIDR, err)
+	}
+
+	pools := c.Pools
+	if len(pools) == 0 {
+		pools = []driver.Pool{{Name: defaultPool, Nodes: c.Nodes}}
+	}
+
+	return &Driver{
+		name:      c.Name,
+		storePath: c.StorePath,
+		pools:     pools,
+		config:    c,
+
+		baseVM:       c.Option(optBaseVM, "kube-cluster-base"),
+		cpus:         ints[optCPUs],
+		memory:       ints[optMemory],
+		diskSize:     ints[optDiskSize],
+		hostOnlyCIDR: cidr,
+		bootTimeout:  bootTimeout,
+		vboxManage:   c.Option(optVBoxManage, "VBoxManage"),
+	}, nil
+}
+
+// Create sets up the host-only network, then the master, then the nodes of
+// every pool, a journal step each. Machines left over from a previous attempt
+// are reused.
+func (d *Driver) Create() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+
+	err = d.config.Journal.Run("create host-only network", func(record func(kind, id string)) error {
+		if s.Network != "" {
+			return nil
+		}
+		// a network created but failing to be configured is
+		// recorded as well, for Remove to find it
+		name, created, err := d.hostOnlyNetwork()
+		if created {
+			record("hostonlyif", name)
+		}
+		if err != nil {
+			return err
+		}
+		s.Network, s.NetworkCreated = name, created
+		return d.save(s)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = d.config.Journal.Run("create master", func(record func(kind, id string)) error {
+		if s.Master == nil {
+			s.Master = &vm{Name: d.name + "-master"}
+			if err := d.save(s); err != nil {
+				return err
+			}
+		}
+		record("vm", s.Master.Name)
+		return driver.ForEachNode(d.config, "creating", []string{s.Master.Name}, func(string) error {
+			return d.provision(s, s.Master, "master")
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range d.pools {
+		p := p
+		err := d.config.Journal.Run("create node pool "+p.Name, func(record func(kind, id string)) error {
+			return d.resize(s, p, record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes the VMs of the environment, and its host-only network if it
+// was created for it. Machines recorded by an interrupted creation are removed
+// as well.
+func (d *Driver) Remove() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	networks := make(map[string]bool)
+	if s.Master != nil {
+		names = append(names, s.Master.Name)
+	}
+	for _, n := range s.Nodes {
+		names = append(names, n.Name)
+	}
+	if s.NetworkCreated {
+		networks[s.Network] = true
+	}
+	for _, r := range d.config.Journal.Resources() {
+		switch r.Kind {
+		case "vm":
+			names = append(names, r.ID)
+		case "hostonlyif":
+			networks[r.ID] = true
+		}
+	}
+
+	removed := make(map[string]bool)
+	for _, name := range names {
+		if removed[name] {
+			continue
+		}
+		removed[name] = true
+		if err := d.removeVM(name); err != nil {
+			return err
+		}
+	}
+	for network := range networks {
+		if err := d.removeHostOnlyNetwork(network); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(d.statePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Start boots the stopped VMs, and waits for them to be reachable.
+func (d *Driver) Start() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	vms := make(map[string]*vm)
+	for _, v := range s.vms() {
+		vms[v.Name] = v
+	}
+	err = driver.ForEachNode(d.config, "starting", names(s.vms()), func(name string) error {
+		v := vms[name]
+		st, err := d.vmState(v.Name)
+		if err != nil {
+			return err
+		}
+		if st != "running" {
+			if _, err := d.vbox("startvm", v.Name, "--type", "headless"); err != nil {
+				return err
+			}
+		}
+		ip, err := d.waitForBoot(v.Name)
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		v.IP = ip
+		d.mu.Unlock()
+		return nil
+	})
+	if saveErr := d.save(s); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// Stop shuts the VMs down, powering off those ignoring the ACPI shutdown
+// request.
+func (d *Driver) Stop() error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	return driver.ForEachNode(d.config, "stopping", names(s.vms()), d.stopVM)
+}
+
+// Status aggregates the state of the VMs: the environment is Running or
+// Stopped when all of them are, in Error otherwise.
+func (d *Driver) Status() (driver.State, error) {
+	s, err := d.load()
+	if err != nil {
+		return driver.Error, err
+	}
+	if s.Master == nil {
+		return driver.None, nil
+	}
+
+	states := make(map[driver.State]bool)
+	for _, v := range s.vms() {
+		st, err := d.vmState(v.Name)
+		if err != nil {
+			return driver.Error, err
+		}
+		states[vmStates.Of(st)] = true
+	}
+	if len(states) == 1 {
+		for st := range states {
+			return st, nil
+		}
+	}
+	return driver.Error, nil
+}
+
+// GetURL returns the API server address, on the master host-only address.
+func (d *Driver) GetURL() (string, error) {
+	s, err := d.load()
+	if err != nil {
+		return "", err
+	}
+	if s.Master == nil || s.Master.IP == "" {
+		return "", fmt.Errorf("vbox: the master of environment %q has no address", d.name)
+	}
+	return fmt.Sprintf("https://%s", net.JoinHostPort(s.Master.IP, strconv.Itoa(apiServerPort))), nil
+}
+
+// GetKubeconfig returns an admin kubeconfig for the API server.
+func (d *Driver) GetKubeconfig() ([]byte, error) {
+	url, err := d.GetURL()
+	if err != nil {
+		return nil, err
+	}
+	return driver.AdminKubeconfig(d.config, url)
+}
+
+// Scale adds nodes to the first pool, or removes the most recent nodes.
+func (d *Driver) Scale(nodes int) error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	if nodes <= len(s.Nodes) {
+		for len(s.Nodes) > nodes {
+			if err := d.removeNode(s, len(s.Nodes)-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	p := d.pools[0]
+	p.Nodes = len(s.poolNodes(p.Name)) + nodes - len(s.Nodes)
+	return d.resize(s, p, func(kind, id string) {})
+}
+
+// ScalePool adds or removes VMs of a pool.
+func (d *Driver) ScalePool(pool driver.Pool) error {
+	s, err := d.load()
+	if err != nil {
+		return err
+	}
+	return d.resize(s, pool, func(kind, id string) {})
+}
+
+// Nodes returns the worker VMs, the master not being a schedulable node.
+func (d *Driver) Nodes() ([]driver.Node, error) {
+	s, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]driver.Node, len(s.Nodes))
+	for i, n := range s.Nodes {
+		st, err := d.vmState(n.Name)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = driver.Node{Name: n.Name, Pool: n.pool(), Address: n.IP, State: vmStates.Of(st)}
+	}
+	return nodes, nil
+}
+
+// resize adds or removes VMs of pool until it holds pool.Nodes. The most
+// recent VMs are removed first, new ones are provisioned concurrently. VMs
+// failing to provision are kept, so that retrying reuses them.
+func (d *Driver) resize(s *state, pool driver.Pool, record func(kind, id string)) error {
+	for excess := len(s.poolNodes(pool.Name)) - pool.Nodes; excess > 0; excess-- {
+		for i := len(s.Nodes) - 1; i >= 0; i-- {
+			if s.Nodes[i].pool() == pool.Name {
+				if err := d.removeNode(s, i); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, n := range s.Nodes {
+		used[n.Name] = true
+	}
+	for i := 1; len(s.poolNodes(pool.Name)) < pool.Nodes; i++ {
+		v := vm{Name: fmt.Sprintf("%s-node-%d", d.name, i)}
+		if pool.Name != defaultPool {
+			v.Name = fmt.Sprintf("%s-%s-node-%d", d.name, pool.Name, i)
+			v.Pool = pool.Name
+		}
+		if !used[v.Name] {
+			s.Nodes = append(s.Nodes, v)
+		}
+	}
+	if err := d.save(s); err != nil {
+		return err
+	}
+
+	// provision the VMs of the pool without an address yet, those left
+	// over from an interrupted attempt included
+	var pending []string
+	for _, n := range s.Nodes {
+		if n.pool() == pool.Name && n.IP == "" {
+			record("vm", n.Name)
+			pending = append(pending, n.Name)
+		}
+	}
+	labels := formatLabels(pool.Labels)
+	err := driver.ForEachNode(d.config, "creating", pending, func(name string) error {
+		return d.provision(s, s.node(name), "node", "labels", labels)
+	})
+	if saveErr := d.save(s); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// provision clones the base VM as v unless it exists, configures and boots it
+// unless it runs, and waits for it to be reachable. role is master or node,
+// properties are further guest properties, as name and value pairs.
+func (d *Driver) provision(s *state, v *vm, role string, properties ...string) error {
+	st, err := d.vmState(v.Name)
+	if err != nil {
+		return err
+	}
+	if st == "" {
+		if err := d.clone(v.Name); err != nil {
+			return err
+		}
+	}
+	if st != "running" {
+		// a VM left over from an interrupted attempt may lack part of
+		// its configuration, or predate a change of the master address
+		switch st {
+		case "paused":
+			_, err = d.vbox("controlvm", v.Name, "poweroff")
+		case "saved":
+			_, err = d.vbox("discardstate", v.Name)
+		}
+		if err != nil {
+			return err
+		}
+		network := d.config.Networking.WithDefaults()
+		props := []string{
+			"environment", d.name, "role", role, "name", v.Name, "pool", v.pool(),
+			"pod-cidr", network.PodCIDR, "service-cidr", network.ServiceCIDR, "dns-domain", network.DNSDomain,
+		}
+		if role != "master" {
+			props = append(props, "master-ip", s.Master.IP)
+		}
+		if err := d.configure(v.Name, role, s.Network, append(props, properties...)); err != nil {
+			return err
+		}
+		if _, err := d.vbox("startvm", v.Name, "--type", "headless"); err != nil {
+			return err
+		}
+	}
+
+	ip, err := d.waitForBoot(v.Name)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v.IP = ip
+	return d.save(s)
+}
+
+// clone creates the VM name from the base VM, and resizes its disk.
+func (d *Driver) clone(name string) error {
+	d.cloneMu.Lock()
+	_, err := d.vbox("clonevm", d.baseVM, "--name", name, "--basefolder", filepath.Join(d.storePath, "vms"), "--register")
+	d.cloneMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if d.diskSize > 0 {
+		disk, err := d.disk(name)
+		if err != nil {
+			return err
+		}
+		if _, err := d.vbox("modifymedium", "disk", disk, "--resize", strconv.Itoa(d.diskSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configure sets the hardware, network, shared folder and guest properties of
+// the stopped VM name, of the given role.
+func (d *Driver) configure(name, role, network string, properties []string) error {
+	_, err := d.vbox("modifyvm", name,
+		"--cpus", strconv.Itoa(d.cpus),
+		"--memory", strconv.Itoa(d.memory),
+		"--nic1", "nat",
+		"--nic2", "hostonly", "--hostonlyadapter2", network,
+	)
+	if err != nil {
+		return err
+	}
+
+	if d.config.PKI != nil {
+		// the certificates are installed once the VMs exist, share
+		// their directory beforehand
+		dir := d.shareDir(role)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		if _, err := d.vbox("sharedfolder", "remove", name, "--name", pkiShare); err != nil && !driver.NotFound(err, vboxNotFound...) {
+			return err
+		}
+		if _, err := d.vbox("sharedfolder", "add", name, "--name", pkiShare, "--hostpath", dir, "--readonly", "--automount"); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i+1 < len(properties); i += 2 {
+		if _, err := d.vbox("guestproperty", "set", name, "/kube-cluster/"+properties[i], properties[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InstallCertificates copies the certificates each role needs to the folder
+// shared with its VMs.
+func (d *Driver) InstallCertificates() error {
+	if d.config.PKI == nil {
+		return nil
+	}
+	p := d.config.PKI
+	for role, names := range driver.RoleCertificates {
+		files := []string{p.CertPath(pki.CA)}
+		for _, name := range names {
+			files = append(files, p.CertPath(name), p.KeyPath(name))
+		}
+
+		dir := d.shareDir(role)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if err := envstore.WriteFileAtomic(filepath.Join(dir, filepath.Base(file)), data, 0600); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// shareDir returns the directory shared with the VMs of role.
+func (d *Driver) shareDir(role string) string {
+	return filepath.Join(d.storePath, sharesDir, role)
+}
+
+// disk returns the path of the first disk attached to the VM name.
+func (d *Driver) disk(name string) (string, error) {
+	info, err := d.vmInfo(name)
+	if err != nil {
+		return "", err
+	}
+	var keys []string
+	for k := range info {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := strings.ToLower(info[k])
+		if strings.HasSuffix(v, ".vdi") || strings.HasSuffix(v, ".vmdk") || strings.HasSuffix(v, ".vhd") {
+			return info[k], nil
+		}
+	}
+	return "", fmt.Errorf("vbox: VM %s has no disk", name)
+}
+
+// waitForBoot waits for the guest additions of the VM name to report its
+// host-only address, then for SSH to answer on it.
+func (d *Driver) waitForBoot(name string) (string, error) {
+	deadline := time.Now().Add(d.bootTimeout)
+	for {
+		out, err := d.vbox("guestproperty", "get", name, ipProperty)
+		if err != nil {
+			return "", err
+		}
+		if ip := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(out), "Value:")); net.ParseIP(ip) != nil {
+			return ip, waitForSSH(net.JoinHostPort(ip, "22"), time.Until(deadline))
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("vbox: VM %s got no address after %s", name, d.bootTimeout)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// stopVM shuts the VM name down, powering it off if it does not stop within a
+// minute.
+func (d *Driver) stopVM(name string) error {
+	st, err := d.vmState(name)
+	if err != nil || st != "running" {
+		return err
+	}
+	if _, err := d.vbox("controlvm", name, "acpipowerbutton"); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); {
+		time.Sleep(2 * time.Second)
+		if st, err := d.vmState(name); err != nil || st != "running" {
+			return err
+		}
+	}
+	_, err = d.vbox("controlvm", name, "poweroff")
+	return err
+}
+
+// removeNode deletes the VM of the ith node, and forgets it.
+func (d *Driver) removeNode(s *state, i int) error {
+	if err := d.removeVM(s.Nodes[i].Name); err != nil {
+		return err
+	}
+	s.Nodes = append(s.Nodes[:i], s.Nodes[i+1:]...)
+	return d.save(s)
+}
+
+// removeVM powers off and deletes the VM name, with its disks. Missing VMs are
+// ignored.
+func (d *Driver) removeVM(name string) error {
+	st, err := d.vmState(name)
+	if err != nil || st == "" {
+		return err
+	}
+	if st == "running" || st == "paused" {
+		if _, err := d.vbox("controlvm", name, "poweroff"); err != nil {
+			return err
+		}
+	}
+	_, err = d.vbox("unregistervm", name, "--delete")
+	return err
+}
+
+var createdInterface = regexp.MustCompile(`Interface '([^']+)' was successfully created`)
+
+// hostOnlyNetwork returns the host-only interface with the host address of
+// the vbox-hostonly-cidr option, creating it, with a DHCP server, if there is
+// none. created tells whether it was created.
+func (d *Driver) hostOnlyNetwork() (name string, created bool, err error) {
+	hostIP, n, _ := net.ParseCIDR(d.hostOnlyCIDR)
+
+	out, err := d.vbox("list", "hostonlyifs")
+	if err != nil {
+		return "", false, err
+	}
+	for _, block := range strings.Split(out, "\n\n") {
+		fields := driver.ParseFields(block, ":")
+		if fields["IPAddress"] == hostIP.String() {
+			return fields["Name"], false, nil
+		}
+	}
+
+	out, err = d.vbox("hostonlyif", "create")
+	if err != nil {
+		return "", false, err
+	}
+	m := createdInterface.FindStringSubmatch(out)
+	if m == nil {
+		return "", false, fmt.Errorf("vbox: unexpected hostonlyif create output %q", strings.TrimSpace(out))
+	}
+	name = m[1]
+
+	mask := net.IP(n.Mask).String()
+	if _, err := d.vbox("hostonlyif", "ipconfig", name, "--ip", hostIP.String(), "--netmask", mask); err != nil {
+		return name, true, err
+	}
+	_, err = d.vbox("dhcpserver", "add", "--ifname", name,
+		"--ip", driver.NthIP(n, 2).String(),
+		"--netmask", mask,
+		"--lowerip", driver.NthIP(n, 100).String(),
+		"--upperip", driver.NthIP(n, driver.LastHost(n)).String(),
+		"--enable",
+	)
+	return name, true, err
+}
+
+// removeHostOnlyNetwork deletes a host-only interface and its DHCP server.
+func (d *Driver) removeHostOnlyNetwork(name string) error {
+	// the DHCP server may not have been added yet
+	d.vbox("dhcpserver", "remove", "--ifname", name)
+	_, err := d.vbox("hostonlyif", "remove", name)
+	if err != nil && driver.NotFound(err, vboxNotFound...) {
+		return nil
+	}
+	return err
+}
+
+// vmState returns the VMState of the VM name, e.g. running or poweroff, or an
+// empty string if it does not exist.
+func (d *Driver) vmState(name string) (string, error) {
+	info, err := d.vmInfo(name)
+	if err != nil {
+		if driver.NotFound(err, vboxNotFound...) {
+			return "", nil
+		}
+		return "", err
+	}
+	return info["VMState"], nil
+}
+
+// vmInfo returns the machine readable settings of the VM name.
+func (d *Driver) vmInfo(name string) (map[string]string, error) {
+	out, err := d.vbox("showvminfo", name, "--machinereadable")
+	if err != nil {
+		return nil, err
+	}
+	return driver.ParseFields(out, "="), nil
+}
+
+func (d *Driver) vbox(args ...string) (string, error) {
+	return d.config.Run(d.vboxManage, args...)
+}
+
+func formatLabels(labels map[string]string) string {
+	var pairs []string
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// vboxNotFound are the errors of VBoxManage failing on a missing object.
+var vboxNotFound = []string{"Could not find", "VBOX_E_OBJECT_NOT_FOUND"}
+
+// vmStates maps the VMState of a VM to a driver State.
+var vmStates = driver.States{
+	"running":   driver.Running,
+	"poweroff":  driver.Stopped,
+	"saved":     driver.Stopped,
+	"aborted":   driver.Stopped,
+	"starting":  driver.Starting,
+	"restoring": driver.Starting,
+	"stopping":  driver.Stopping,
+	"saving":    driver.Stopping,
+}
+
+func (n vm) pool() string {
+	if n.Pool == "" {
+		return defaultPool
+	}
+	return n.Pool
+}
+
+func (s *state) poolNodes(pool string) []vm {
+	var nodes []vm
+	for _, n := range s.Nodes {
+		if n.pool() == pool {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// vms returns pointers to the master and nodes of s.
+func (s *state) vms() []*vm {
+	var vms []*vm
+	if s.Master != nil {
+		vms = append(vms, s.Master)
+	}
+	for i := range s.Nodes {
+		vms = append(vms, &s.Nodes[i])
+	}
+	return vms
+}
+
+func names(vms []*vm) []string {
+	names := make([]string, len(vms))
+	for i, v := range vms {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// node returns the node named name.
+func (s *state) node(name string) *vm {
+	for i := range s.Nodes {
+		if s.Nodes[i].Name == name {
+			return &s.Nodes[i]
+		}
+	}
+	return nil
+}
+
+func (d *Driver) statePath() string {
+	return filepath.Join(d.storePath, stateFileName)
+}
+
+func (d *Driver) load() (*state, error) {
+	s := &state{}
+	data, err := ioutil.ReadFile(d.statePath())
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("vbox: corrupt state: %v", err)
+	}
+	return s, nil
+}
+
+func (d *Driver) save(s *state) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.storePath, 0700); err != nil {
+		return err
+	}
+	return envstore.WriteFileAtomic(d.statePath(), data, 0600)
+}
@@ -0,0 +1,388 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/pki"
+)
+
+// stubVariable makes the test binary act as VBoxManage, keeping the
+// machines it manages in the directory it names.
+const stubVariable = "VBOX_TEST_STUB"
+
+// TestMain lets the test binary stand in for VBoxManage.
+func TestMain(m *testing.M) {
+	if dir := os.Getenv(stubVariable); dir != "" {
+		out, err := stubVBoxManage(dir, os.Args[1:])
+		fmt.Print(out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "VBoxManage: error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	waitForSSH = func(string, time.Duration) error { return nil }
+	os.Exit(m.Run())
+}
+
+type stubVM struct {
+	State      string
+	Properties map[string]string
+	Shares     map[string]string
+}
+
+type stubState struct {
+	VMs      map[string]*stubVM
+	Networks []string
+	Started  int
+}
+
+// stubVBoxManage runs the VBoxManage command args against the machines kept
+// in dir, logging it to dir/log. Commands starting as a line of dir/fail fail.
+func stubVBoxManage(dir string, args []string) (string, error) {
+	call := strings.Join(args, " ")
+	log, err := os.OpenFile(filepath.Join(dir, "log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintln(log, call)
+	log.Close()
+	fail, _ := ioutil.ReadFile(filepath.Join(dir, "fail"))
+	for _, prefix := range strings.Split(string(fail), "\n") {
+		if prefix != "" && strings.HasPrefix(call, prefix) {
+			return "", fmt.Errorf("injected failure")
+		}
+	}
+
+	s := stubState{VMs: make(map[string]*stubVM)}
+	path := filepath.Join(dir, "state.json")
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return "", err
+		}
+	}
+	vm := func(name string) (*stubVM, error) {
+		if v := s.VMs[name]; v != nil {
+			return v, nil
+		}
+		return nil, fmt.Errorf("Could not find a registered machine named '%s'", name)
+	}
+
+	out, err := "", error(nil)
+	switch args[0] + " " + args[1] {
+	case "list hostonlyifs":
+		for i, n := range s.Networks {
+			out += fmt.Sprintf("Name:            %s\nIPAddress:       192.168.%d.1\n\n", n, 99+i)
+		}
+	case "hostonlyif create":
+		name := fmt.Sprintf("vboxnet%d", len(s.Networks))
+		s.Networks = append(s.Networks, name)
+		out = fmt.Sprintf("Interface '%s' was successfully created\n", name)
+	case "hostonlyif remove":
+		var kept []string
+		for _, n := range s.Networks {
+			if n != args[2] {
+				kept = append(kept, n)
+			}
+		}
+		s.Networks = kept
+	case "clonevm " + args[1]:
+		s.VMs[args[3]] = &stubVM{State: "poweroff", Properties: make(map[string]string), Shares: make(map[string]string)}
+	case "showvminfo " + args[1]:
+		var v *stubVM
+		if v, err = vm(args[1]); err == nil {
+			out = fmt.Sprintf("name=%q\nVMState=%q\n\"SATA-0-0\"=%q\n", args[1], v.State, "/vms/"+args[1]+".vdi")
+		}
+	case "startvm " + args[1]:
+		var v *stubVM
+		if v, err = vm(args[1]); err == nil {
+			v.State = "running"
+			s.Started++
+			v.Properties[ipProperty] = fmt.Sprintf("192.168.99.%d", 100+s.Started)
+		}
+	case "controlvm " + args[1], "discardstate " + args[1]:
+		var v *stubVM
+		if v, err = vm(args[1]); err == nil {
+			v.State = "poweroff"
+		}
+	case "unregistervm " + args[1]:
+		if _, err = vm(args[1]); err == nil {
+			delete(s.VMs, args[1])
+		}
+	case "sharedfolder add", "sharedfolder remove":
+		var v *stubVM
+		if v, err = vm(args[2]); err == nil {
+			if args[1] == "add" {
+				v.Shares[args[4]] = args[6]
+			} else if _, ok := v.Shares[args[4]]; ok {
+				delete(v.Shares, args[4])
+			} else {
+				err = fmt.Errorf("Could not find a shared folder named '%s'", args[4])
+			}
+		}
+	case "guestproperty set", "guestproperty get":
+		var v *stubVM
+		if v, err = vm(args[2]); err == nil {
+			if args[1] == "set" {
+				v.Properties[args[3]] = args[4]
+			} else if value, ok := v.Properties[args[3]]; ok {
+				out = "Value: " + value + "\n"
+			} else {
+				out = "No value set!\n"
+			}
+		}
+	case "modifyvm " + args[1]:
+		var v *stubVM
+		if v, err = vm(args[1]); err == nil && v.State != "poweroff" {
+			err = fmt.Errorf("machine %s is %s", args[1], v.State)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return out, ioutil.WriteFile(path, data, 0600)
+}
+
+type testEnv struct {
+	*Driver
+	dir string
+}
+
+func newTestDriver(t *testing.T, nodes int) (*testEnv, func()) {
+	dir, err := ioutil.TempDir("", "vbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := filepath.Join(dir, "stub")
+	if err := os.Mkdir(stub, 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(stubVariable, stub)
+	j, err := driver.OpenJournal(filepath.Join(dir, "journal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(&driver.Config{
+		Name:      "dev",
+		StorePath: dir,
+		Nodes:     nodes,
+		Options: map[string]string{
+			optVBoxManage: os.Args[0],
+			optDiskSize:   "20000",
+		},
+		// the stub does not serialize its calls
+		Parallelism: 1,
+		Journal:     j,
+		PKI:         pki.New(filepath.Join(dir, "pki"), pki.Options{Algorithm: pki.ECDSA}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{Driver: d.(*Driver), dir: dir}, func() {
+		os.Unsetenv(stubVariable)
+		os.RemoveAll(dir)
+	}
+}
+
+// calls returns the VBoxManage calls starting with prefix.
+func (e *testEnv) calls(t *testing.T, prefix string) []string {
+	data, err := ioutil.ReadFile(filepath.Join(e.dir, "stub", "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	for _, call := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(call, prefix) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (e *testEnv) stub(t *testing.T) stubState {
+	var s stubState
+	data, err := ioutil.ReadFile(filepath.Join(e.dir, "stub", "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (e *testEnv) failOn(t *testing.T, prefixes ...string) {
+	if err := ioutil.WriteFile(filepath.Join(e.dir, "stub", "fail"), []byte(strings.Join(prefixes, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	d, cleanup := newTestDriver(t, 2)
+	defer cleanup()
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	s := d.stub(t)
+	var vms []string
+	for name, v := range s.VMs {
+		vms = append(vms, name)
+		if v.State != "running" {
+			t.Errorf("VM %s is %s", name, v.State)
+		}
+	}
+	sort.Strings(vms)
+	if strings.Join(vms, " ") != "dev-master dev-node-1 dev-node-2" {
+		t.Fatalf("got VMs %v", vms)
+	}
+	if got := s.VMs["dev-node-1"].Properties["/kube-cluster/master-ip"]; got != "192.168.99.101" {
+		t.Errorf("node got master address %q", got)
+	}
+	if got := s.VMs["dev-master"].Shares[pkiShare]; got != filepath.Join(d.dir, "shares", "master") {
+		t.Errorf("master shares %q", got)
+	}
+	if got := s.VMs["dev-node-2"].Shares[pkiShare]; got != filepath.Join(d.dir, "shares", "node") {
+		t.Errorf("node shares %q", got)
+	}
+	if url, err := d.GetURL(); err != nil || url != "https://192.168.99.101:6443" {
+		t.Errorf("got URL %q, %v", url, err)
+	}
+	if st, err := d.Status(); err != nil || st != driver.Running {
+		t.Errorf("got status %v, %v", st, err)
+	}
+	if got := len(d.calls(t, "modifymedium disk /vms/")); got != 3 {
+		t.Errorf("got %d disk resizes", got)
+	}
+
+	if err := d.Scale(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.stub(t).VMs["dev-node-2"]; ok {
+		t.Error("scaling down kept dev-node-2")
+	}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if s := d.stub(t); len(s.VMs) != 0 || len(s.Networks) != 0 {
+		t.Errorf("left VMs %v and networks %v", s.VMs, s.Networks)
+	}
+}
+
+func TestInstallCertificates(t *testing.T) {
+	d, cleanup := newTestDriver(t, 1)
+	defer cleanup()
+
+	if err := d.config.PKI.Ensure("dev", pki.SANs{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InstallCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	for role, want := range map[string]string{
+		"master": "apiserver.crt apiserver.key ca.crt",
+		"node":   "ca.crt node.crt node.key",
+	} {
+		files, err := ioutil.ReadDir(filepath.Join(d.dir, "shares", role))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+			if f.Mode().Perm() != 0600 {
+				t.Errorf("%s/%s has mode %v", role, f.Name(), f.Mode())
+			}
+		}
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("%s share holds %s, want %s", role, got, want)
+		}
+	}
+}
+
+func TestCreateRecordsFailedNetwork(t *testing.T) {
+	d, cleanup := newTestDriver(t, 1)
+	defer cleanup()
+
+	d.failOn(t, "hostonlyif ipconfig")
+	if err := d.Create(); err == nil {
+		t.Fatal("creation succeeded")
+	}
+	if got := d.stub(t).Networks; len(got) != 1 {
+		t.Fatalf("got networks %v", got)
+	}
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.stub(t).Networks; len(got) != 0 {
+		t.Errorf("the network of the failed creation was kept: %v", got)
+	}
+}
+
+func TestCreateReconfiguresStoppedVMs(t *testing.T) {
+	d, cleanup := newTestDriver(t, 1)
+	defer cleanup()
+
+	d.failOn(t, "guestproperty set dev-node-1 /kube-cluster/pool")
+	if err := d.Create(); err == nil {
+		t.Fatal("creation succeeded")
+	}
+	d.failOn(t)
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(d.calls(t, "clonevm")); got != 2 {
+		t.Errorf("got %d clones, want the node reused", got)
+	}
+	if got := len(d.calls(t, "modifyvm dev-node-1")); got != 2 {
+		t.Errorf("dev-node-1 configured %d times, want 2", got)
+	}
+	if got := d.stub(t).VMs["dev-node-1"].Properties["/kube-cluster/pool"]; got != "default" {
+		t.Errorf("got pool property %q", got)
+	}
+	// a share already set is replaced
+	if got := len(d.calls(t, "sharedfolder add dev-node-1")); got != 2 {
+		t.Errorf("got %d shared folders added", got)
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	if err := validateHostOnlyCIDR("192.168.99.0/24"); err == nil {
+		t.Error("accepted a network address")
+	}
+	if err := validateHostOnlyCIDR("192.168.99.1/28"); err == nil {
+		t.Error("accepted a /28")
+	}
+	if got := formatLabels(map[string]string{"b": "2", "a": "1"}); got != "a=1,b=2" {
+		t.Errorf("got labels %q", got)
+	}
+}
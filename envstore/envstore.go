@@ -0,0 +1,435 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package envstore persists the Kubernetes environments known to kube-cluster.
+// Every environment lives in its own directory below the store root, so
+// drivers may keep additional state next to the environment configuration:
+//
+//	~/.kube-cluster/envs/<name>/config.json
+//	~/.kube-cluster/envs/<name>/kubeconfig
+//
+// The environment selected with "kube-cluster env <name>" is recorded in
+// ~/.kube-cluster/current, and artifacts shared by all environments are cached
+// in ~/.kube-cluster/cache.
+//
+// The store root defaults to ~/.kube-cluster, and can be overridden with the
+// KUBE_CLUSTER_HOME environment variable.
+package envstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// HomeVariable is the environment variable overriding the store root.
+	HomeVariable = "KUBE_CLUSTER_HOME"
+	// EnvironmentVariable is the environment variable selecting the
+	// environment, see Resolver.
+	EnvironmentVariable = "KUBE_CLUSTER_ENVIRONMENT"
+
+	envsDirName        = "envs"
+	configFileName     = "config.json"
+	kubeconfigFileName = "kubeconfig"
+	journalFileName    = "journal.json"
+	pkiDirName         = "pki"
+	currentFileName    = "current"
+	cacheDirName       = "cache"
+	locksDirName       = "locks"
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Environment describes a Kubernetes environment managed by kube-cluster.
+type Environment struct {
+	Name      string    `json:"name"`
+	Driver    string    `json:"driver"`
+	Nodes     int       `json:"nodes"`
+	Autoscale bool      `json:"autoscale"`
+	CreatedAt time.Time `json:"createdAt"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+
+	// KubectlVersion pins the kubectl release forwarded calls are run with,
+	// the one found in PATH is used if empty. KubectlChecksum optionally
+	// holds its expected hex encoded SHA-256.
+	KubectlVersion  string `json:"kubectlVersion,omitempty"`
+	KubectlChecksum string `json:"kubectlChecksum,omitempty"`
+
+	// DriverOptions holds the driver specific settings the environment was
+	// created with.
+	DriverOptions map[string]string `json:"driverOptions,omitempty"`
+
+	// KubernetesVersion is the kubernetes release deployed, the driver
+	// default if empty.
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	// Pools splits the nodes in groups of identical machines. Nodes is
+	// their total. Environments without pools have a single implicit one,
+	// see NodePools.
+	Pools      []NodePool  `json:"nodePools,omitempty"`
+	Networking *Networking `json:"networking,omitempty"`
+	Addons     []Addon     `json:"addons,omitempty"`
+
+	// KeyAlgorithm is the algorithm of the environment certificate keys,
+	// rsa if empty.
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+
+	// KubeconfigMerges lists the kubeconfig files the environment was
+	// merged into, so it can be removed from them with the environment.
+	KubeconfigMerges []KubeconfigMerge `json:"kubeconfigMerges,omitempty"`
+}
+
+// KubeconfigMerge records the context an environment was merged into a
+// kubeconfig file as.
+type KubeconfigMerge struct {
+	Path    string `json:"path"`
+	Context string `json:"context"`
+}
+
+// DefaultPoolName names the implicit node pool of environments without pools.
+const DefaultPoolName = "default"
+
+// NodePool is a group of identical nodes.
+type NodePool struct {
+	Name        string            `json:"name"`
+	Nodes       int               `json:"nodes"`
+	MachineType string            `json:"machineType,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Networking holds the cluster network settings, drivers pick defaults for
+// the empty ones.
+type Networking struct {
+	PodCIDR     string `json:"podCIDR,omitempty"`
+	ServiceCIDR string `json:"serviceCIDR,omitempty"`
+	DNSDomain   string `json:"dnsDomain,omitempty"`
+}
+
+// Addon is an optional cluster component, such as dns or dashboard.
+type Addon struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// NodePools returns the node pools of e, the implicit default one holding all
+// nodes if e has none.
+func (e *Environment) NodePools() []NodePool {
+	if len(e.Pools) > 0 {
+		return e.Pools
+	}
+	return []NodePool{{Name: DefaultPoolName, Nodes: e.Nodes}}
+}
+
+// NotFoundError is returned when the requested environment is not in the store.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("environment %q not found", e.Name)
+}
+
+// ExistsError is returned when creating an environment whose name is taken.
+type ExistsError struct {
+	Name string
+}
+
+func (e *ExistsError) Error() string {
+	return fmt.Sprintf("environment %q already exists", e.Name)
+}
+
+// IsNotFound reports whether err is a *NotFoundError.
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// ValidateName checks that name can be used as an environment name. Names
+// follow the Kubernetes DNS label rules, so they can be reused for resources
+// created by drivers.
+func ValidateName(name string) error {
+	if len(name) > 63 || !validName.MatchString(name) {
+		return fmt.Errorf("invalid environment name %q: must consist of lower case alphanumeric characters or '-', and start and end with an alphanumeric character", name)
+	}
+	return nil
+}
+
+// Store keeps environments on disk below its root directory.
+type Store struct {
+	root string
+}
+
+// New instantiates a Store rooted at root.
+func New(root string) *Store {
+	return &Store{root: root}
+}
+
+// DefaultRoot returns the store root honouring KUBE_CLUSTER_HOME, and falling
+// back to ~/.kube-cluster.
+func DefaultRoot() string {
+	if root := os.Getenv(HomeVariable); root != "" {
+		return root
+	}
+
+	home := os.Getenv("HOME")
+	if home == "" {
+		if u, err := user.Current(); err == nil {
+			home = u.HomeDir
+		}
+	}
+	return filepath.Join(home, ".kube-cluster")
+}
+
+// Root returns the directory holding the store.
+func (s *Store) Root() string {
+	return s.root
+}
+
+// CacheDir returns the directory holding downloaded artifacts shared by all
+// environments, such as kubectl binaries.
+func (s *Store) CacheDir() string {
+	return filepath.Join(s.root, cacheDirName)
+}
+
+// Dir returns the directory holding the named environment state.
+func (s *Store) Dir(name string) string {
+	return filepath.Join(s.root, envsDirName, name)
+}
+
+// Create stores a new environment, failing if one with the same name exists.
+func (s *Store) Create(e *Environment) error {
+	if err := ValidateName(e.Name); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.root, envsDirName), 0700); err != nil {
+		return err
+	}
+	if err := os.Mkdir(s.Dir(e.Name), 0700); err != nil {
+		if os.IsExist(err) {
+			return &ExistsError{Name: e.Name}
+		}
+		return err
+	}
+
+	if err := s.Save(e); err != nil {
+		os.RemoveAll(s.Dir(e.Name))
+		return err
+	}
+	return nil
+}
+
+// Save writes the environment configuration, replacing any previous one.
+func (s *Store) Save(e *Environment) error {
+	if err := ValidateName(e.Name); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := s.Dir(e.Name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(dir, configFileName), append(data, '\n'), 0600)
+}
+
+// Get loads the named environment.
+func (s *Store) Get(name string) (*Environment, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir(name), configFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &NotFoundError{Name: name}
+		}
+		return nil, err
+	}
+
+	e := &Environment{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, fmt.Errorf("environment %q: corrupt configuration: %v", name, err)
+	}
+	e.Name = name
+	return e, nil
+}
+
+// List returns all stored environments sorted by name.
+func (s *Store) List() ([]*Environment, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(s.root, envsDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var envs []*Environment
+	for _, entry := range entries {
+		if !entry.IsDir() || ValidateName(entry.Name()) != nil {
+			continue
+		}
+		e, err := s.Get(entry.Name())
+		if IsNotFound(err) {
+			// directory without configuration, e.g. an interrupted create
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		envs = append(envs, e)
+	}
+
+	sort.Sort(byName(envs))
+	return envs, nil
+}
+
+// Names returns the names of all stored environments.
+func (s *Store) Names() ([]string, error) {
+	envs, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(envs))
+	for _, e := range envs {
+		names = append(names, e.Name)
+	}
+	return names, nil
+}
+
+// Remove deletes the named environment and all of its state. Removing the
+// current environment clears the selection.
+func (s *Store) Remove(name string) error {
+	if _, err := s.Get(name); err != nil {
+		return err
+	}
+	if current, err := s.Current(); err == nil && current == name {
+		if err := s.SetCurrent(""); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(s.Dir(name))
+}
+
+// KubeconfigPath returns the path of the kubeconfig granting access to the
+// named environment.
+func (s *Store) KubeconfigPath(name string) string {
+	return filepath.Join(s.Dir(name), kubeconfigFileName)
+}
+
+// JournalPath returns the path of the operation journal of the named
+// environment.
+func (s *Store) JournalPath(name string) string {
+	return filepath.Join(s.Dir(name), journalFileName)
+}
+
+// PKIDir returns the directory holding the certificates and keys of the named
+// environment.
+func (s *Store) PKIDir(name string) string {
+	return filepath.Join(s.Dir(name), pkiDirName)
+}
+
+// SaveKubeconfig stores the kubeconfig of the named environment.
+func (s *Store) SaveKubeconfig(name string, data []byte) error {
+	if _, err := s.Get(name); err != nil {
+		return err
+	}
+	return WriteFileAtomic(s.KubeconfigPath(name), data, 0600)
+}
+
+// Current returns the name of the selected environment, or an empty string if
+// none is selected.
+func (s *Store) Current() (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.root, currentFileName))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SetCurrent selects the named environment. An empty name clears the
+// selection.
+func (s *Store) SetCurrent(name string) error {
+	filename := filepath.Join(s.root, currentFileName)
+	if name == "" {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if _, err := s.Get(name); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filename, []byte(name+"\n"), 0600)
+}
+
+type byName []*Environment
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// WriteFileAtomic writes data to a temporary file and renames it over
+// filename, so readers never observe a partially written file.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := WriteTempFile(filename, data, perm)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// WriteTempFile writes data to a temporary file next to filename, and returns
+// its name. Callers replacing several files together write them all first,
+// then rename each over its target.
+func WriteTempFile(filename string, data []byte, perm os.FileMode) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
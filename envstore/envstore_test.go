@@ -0,0 +1,177 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func tempStore(t *testing.T) (*Store, func()) {
+	root, err := ioutil.TempDir("", "envstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(root), func() { os.RemoveAll(root) }
+}
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"dev", true},
+		{"stage-1", true},
+		{"a", true},
+		{"", false},
+		{"Dev", false},
+		{"-dev", false},
+		{"dev-", false},
+		{"dev_1", false},
+		{"../dev", false},
+		{string(make([]byte, 64)), false},
+	}
+	for _, test := range tests {
+		if err := ValidateName(test.name); (err == nil) != test.valid {
+			t.Errorf("ValidateName(%q) = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestCreateGetList(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	want := &Environment{
+		Name:          "dev",
+		Driver:        "fake",
+		Nodes:         2,
+		DriverOptions: map[string]string{"fake-node-delay": "1s"},
+	}
+	if err := store.Create(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(&Environment{Name: "dev"}); err == nil {
+		t.Error("creating dev twice succeeded")
+	} else if _, ok := err.(*ExistsError); !ok {
+		t.Errorf("creating dev twice: %v, want an ExistsError", err)
+	}
+	if err := store.Create(&Environment{Name: "alpha", Driver: "fake"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get(dev) = %+v, want %+v", got, want)
+	}
+	if _, err := store.Get("missing"); !IsNotFound(err) {
+		t.Errorf("Get(missing) = %v, want a NotFoundError", err)
+	}
+
+	// a directory without configuration, left by an interrupted create
+	if err := os.MkdirAll(store.Dir("broken"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	names, err := store.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alpha", "dev"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Names() = %v, want %v", names, want)
+	}
+}
+
+func TestSaveIsAtomic(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	e := &Environment{Name: "dev", Driver: "fake"}
+	if err := store.Create(e); err != nil {
+		t.Fatal(err)
+	}
+	e.Nodes = 3
+	if err := store.Save(e); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ioutil.ReadDir(store.Dir("dev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != configFileName {
+			t.Errorf("unexpected file %s left in the environment directory", entry.Name())
+		}
+		if entry.Mode().Perm() != 0600 {
+			t.Errorf("%s has mode %v, want 0600", entry.Name(), entry.Mode().Perm())
+		}
+	}
+}
+
+func TestCurrentAndRemove(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	if current, err := store.Current(); err != nil || current != "" {
+		t.Fatalf("Current() = %q, %v, want none", current, err)
+	}
+	if err := store.SetCurrent("dev"); !IsNotFound(err) {
+		t.Errorf("selecting a missing environment: %v, want a NotFoundError", err)
+	}
+
+	if err := store.Create(&Environment{Name: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetCurrent("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if current, err := store.Current(); err != nil || current != "dev" {
+		t.Fatalf("Current() = %q, %v, want dev", current, err)
+	}
+
+	if err := store.Remove("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.Dir("dev")); !os.IsNotExist(err) {
+		t.Errorf("environment directory left behind: %v", err)
+	}
+	if current, err := store.Current(); err != nil || current != "" {
+		t.Errorf("Current() = %q, %v after removing it, want none", current, err)
+	}
+	if err := store.Remove("dev"); !IsNotFound(err) {
+		t.Errorf("removing dev twice: %v, want a NotFoundError", err)
+	}
+}
+
+func TestDefaultRoot(t *testing.T) {
+	defer os.Setenv(HomeVariable, os.Getenv(HomeVariable))
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+
+	os.Setenv(HomeVariable, "/srv/kube-cluster")
+	if got := DefaultRoot(); got != "/srv/kube-cluster" {
+		t.Errorf("DefaultRoot() = %q with %s set", got, HomeVariable)
+	}
+	os.Setenv(HomeVariable, "")
+	os.Setenv("HOME", "/home/gopher")
+	if got, want := DefaultRoot(), filepath.Join("/home/gopher", ".kube-cluster"); got != want {
+		t.Errorf("DefaultRoot() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envstore
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockRetryInterval is the delay between attempts to take a held lock.
+const lockRetryInterval = 100 * time.Millisecond
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("locked")
+
+// LockedError is returned when an environment stays locked by another process
+// for longer than the lock timeout.
+type LockedError struct {
+	Name  string
+	PID   int
+	Since time.Time
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("environment %q is locked by another process", e.Name)
+	}
+	return fmt.Sprintf("environment %q is locked by PID %d since %s", e.Name, e.PID, e.Since.Local().Format(time.RFC1123Z))
+}
+
+// IsLocked reports whether err is a *LockedError.
+func IsLocked(err error) bool {
+	_, ok := err.(*LockedError)
+	return ok
+}
+
+// Lock is an advisory lock held on an environment, so that concurrent
+// kube-cluster processes do not change it at the same time.
+type Lock struct {
+	f    *os.File
+	path string
+}
+
+// Lock locks the named environment, waiting up to timeout for other processes
+// to release it. Lock files live outside the environment directories, so
+// environments may be locked before they are created and while they are
+// removed. Locks whose holder no longer runs are taken over.
+func (s *Store) Lock(name string, timeout time.Duration) (*Lock, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(s.root, locksDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, name+".lock")
+
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := lockFile(path)
+		if err == nil {
+			l := &Lock{f: f, path: path}
+			if err := l.writeOwner(); err != nil {
+				l.Unlock()
+				return nil, err
+			}
+			return l, nil
+		}
+		if err != errLocked {
+			return nil, err
+		}
+
+		pid, since := readOwner(path)
+		if pid != 0 && !processAlive(pid) && breakLock(path) {
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, &LockedError{Name: name, PID: pid, Since: since}
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	return unlockFile(l.f, l.path)
+}
+
+// writeOwner records the process holding the lock, for the LockedError of
+// other processes.
+func (l *Lock) writeOwner() error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.f.WriteAt([]byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339))), 0)
+	return err
+}
+
+// readOwner returns the process recorded in a lock file, if any.
+func readOwner(path string) (int, time.Time) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, time.Time{}
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, time.Time{}
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, time.Time{}
+	}
+	since, _ := time.Parse(time.RFC3339, fields[1])
+	return pid, since
+}
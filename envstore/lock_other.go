@@ -0,0 +1,50 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package envstore
+
+import "os"
+
+// lockFile takes the lock by creating path exclusively. Such locks survive
+// their holder if it crashes, see breakLock.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, errLocked
+	}
+	return f, err
+}
+
+func unlockFile(f *os.File, path string) error {
+	os.Remove(path)
+	return f.Close()
+}
+
+// breakLock removes the stale lock file of a process which no longer runs.
+func breakLock(path string) bool {
+	return os.Remove(path) == nil
+}
+
+// processAlive reports whether a process with the given PID runs.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
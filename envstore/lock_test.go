@@ -0,0 +1,133 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envstore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lockHelperVariable makes the test binary hold the lock of an environment,
+// given as ROOT:NAME, until killed.
+const lockHelperVariable = "ENVSTORE_TEST_LOCK"
+
+func TestMain(m *testing.M) {
+	if v := os.Getenv(lockHelperVariable); v != "" {
+		i := strings.LastIndex(v, ":")
+		if _, err := New(v[:i]).Lock(v[i+1:], 0); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("locked")
+		select {}
+	}
+	os.Exit(m.Run())
+}
+
+func TestLock(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	l, err := store.Lock("dev", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Lock("dev", 0)
+	le, ok := err.(*LockedError)
+	if !ok || !IsLocked(err) {
+		t.Fatalf("got %v, want a *LockedError", err)
+	}
+	if le.PID != os.Getpid() || le.Name != "dev" || time.Since(le.Since) > time.Minute {
+		t.Errorf("got %+v, want this process as the holder", le)
+	}
+
+	// environments are locked separately
+	other, err := store.Lock("stage", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Unlock()
+
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = store.Lock("dev", 0)
+	if err != nil {
+		t.Fatalf("got %v once unlocked", err)
+	}
+	l.Unlock()
+
+	if _, err := store.Lock("../dev", 0); err == nil {
+		t.Error("locked an invalid name")
+	}
+}
+
+func TestLockWaits(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	l, err := store.Lock("dev", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(3 * lockRetryInterval)
+		l.Unlock()
+	}()
+	waiter, err := store.Lock("dev", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter.Unlock()
+}
+
+// TestLockOtherProcess checks locks hold across processes, and are released
+// when their holder dies.
+func TestLockOtherProcess(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), lockHelperVariable+"="+store.Root()+":dev")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	if line, _ := bufio.NewReader(out).ReadString('\n'); line != "locked\n" {
+		t.Fatalf("helper printed %q", line)
+	}
+
+	_, err = store.Lock("dev", 2*lockRetryInterval)
+	if le, ok := err.(*LockedError); !ok || le.PID != cmd.Process.Pid {
+		t.Fatalf("got %v, want a lock held by PID %d", err, cmd.Process.Pid)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	l, err := store.Lock("dev", 0)
+	if err != nil {
+		t.Fatalf("got %v once the holder died", err)
+	}
+	l.Unlock()
+}
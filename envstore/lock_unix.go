@@ -0,0 +1,59 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package envstore
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an flock(2) lock on path. The kernel releases it when the
+// holder exits, however it exits.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// unlockFile releases the lock. The file is kept: removing it would let
+// another process lock a file no longer reachable from path.
+func unlockFile(f *os.File, path string) error {
+	f.Truncate(0)
+	return f.Close()
+}
+
+// breakLock cannot take over an flock(2) lock: one held by a process which no
+// longer runs was inherited by another process.
+func breakLock(path string) bool {
+	return false
+}
+
+// processAlive reports whether a process with the given PID runs.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,177 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envstore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DirFileName is the name of the file pinning an environment for a directory
+// tree. It holds the environment name on its first line, blank lines and lines
+// starting with # are ignored.
+const DirFileName = ".kube-cluster"
+
+// Source tells where the selection of an environment came from. Selections
+// made by a .kube-cluster file have the file path as source.
+type Source string
+
+const (
+	FlagSource     Source = "--env flag"
+	VariableSource Source = EnvironmentVariable
+	CurrentSource  Source = "current environment"
+)
+
+// NotSetError is returned when no environment is selected at all.
+type NotSetError struct {
+	Available []string
+}
+
+func (e *NotSetError) Error() string {
+	msg := "environment not set; select one with \"kube-cluster env NAME\", --env or " + EnvironmentVariable
+	if len(e.Available) == 0 {
+		return msg + " (no environments exist yet, create one with \"kube-cluster create env NAME\")"
+	}
+	return msg + " (available: " + strings.Join(e.Available, ", ") + ")"
+}
+
+// IsNotSet reports whether err is a *NotSetError.
+func IsNotSet(err error) bool {
+	_, ok := err.(*NotSetError)
+	return ok
+}
+
+// Resolver picks the environment a call targets. The first of the following
+// wins:
+//
+//  1. the --env global flag (Flag)
+//  2. the KUBE_CLUSTER_ENVIRONMENT variable
+//  3. a .kube-cluster file in Dir, or any of its parents
+//  4. the current environment of the store, see "kube-cluster env NAME"
+type Resolver struct {
+	Store *Store
+	// Flag is the value of the --env flag, if any.
+	Flag string
+	// Dir is the directory .kube-cluster files are looked up from. It
+	// defaults to the working directory.
+	Dir string
+}
+
+// NewResolver instantiates a resolver for store, given the --env flag value.
+func NewResolver(store *Store, flag string) *Resolver {
+	return &Resolver{Store: store, Flag: flag}
+}
+
+// Resolve returns the name of the selected environment, and where the
+// selection came from. It fails with *NotSetError if none is selected, and
+// with *NotFoundError if the selected one does not exist.
+func (r *Resolver) Resolve() (string, Source, error) {
+	name, source, err := r.lookup()
+	if err != nil {
+		return "", "", err
+	}
+
+	if name == "" {
+		available, err := r.Store.Names()
+		if err != nil {
+			return "", "", err
+		}
+		return "", "", &NotSetError{Available: available}
+	}
+
+	if _, err := r.Store.Get(name); err != nil {
+		if IsNotFound(err) {
+			return "", "", fmt.Errorf("%v (selected by %s)", err, source)
+		}
+		return "", "", err
+	}
+	return name, source, nil
+}
+
+func (r *Resolver) lookup() (string, Source, error) {
+	if r.Flag != "" {
+		return r.Flag, FlagSource, nil
+	}
+	if name := os.Getenv(EnvironmentVariable); name != "" {
+		return name, VariableSource, nil
+	}
+
+	dir := r.Dir
+	if dir == "" {
+		var err error
+		if dir, err = os.Getwd(); err != nil {
+			return "", "", err
+		}
+	}
+	name, filename, err := findDirFile(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if name != "" {
+		return name, Source(filename), nil
+	}
+
+	name, err = r.Store.Current()
+	return name, CurrentSource, err
+}
+
+// findDirFile looks for a .kube-cluster file in dir and its parents, returning
+// the environment it names and its path.
+func findDirFile(dir string) (string, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	for {
+		filename := filepath.Join(dir, DirFileName)
+		// ~/.kube-cluster is the store root, a directory, hence the
+		// regular file check.
+		if fi, err := os.Stat(filename); err == nil && fi.Mode().IsRegular() {
+			name, err := readDirFile(filename)
+			return name, filename, err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", nil
+		}
+		dir = parent
+	}
+}
+
+func readDirFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return line, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s: no environment name", filename)
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolverOrder(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+	for _, name := range []string{"flag", "variable", "dir", "current"} {
+		if err := store.Create(&Environment{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SetCurrent("current"); err != nil {
+		t.Fatal(err)
+	}
+
+	// project/.kube-cluster pins dir for project/sub, not for other
+	project := filepath.Join(store.Root(), "project")
+	sub := filepath.Join(project, "sub")
+	other := filepath.Join(store.Root(), "other")
+	for _, dir := range []string{sub, other} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dirFile := filepath.Join(project, DirFileName)
+	if err := ioutil.WriteFile(dirFile, []byte("# pinned\n\n  dir  \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Setenv(EnvironmentVariable, os.Getenv(EnvironmentVariable))
+
+	tests := []struct {
+		flag, variable, dir string
+		name                string
+		source              Source
+	}{
+		{"flag", "variable", sub, "flag", FlagSource},
+		{"", "variable", sub, "variable", VariableSource},
+		{"", "", sub, "dir", Source(dirFile)},
+		{"", "", project, "dir", Source(dirFile)},
+		{"", "", other, "current", CurrentSource},
+	}
+	for _, test := range tests {
+		os.Setenv(EnvironmentVariable, test.variable)
+		r := &Resolver{Store: store, Flag: test.flag, Dir: test.dir}
+		name, source, err := r.Resolve()
+		if err != nil {
+			t.Errorf("%+v: %v", test, err)
+			continue
+		}
+		if name != test.name || source != test.source {
+			t.Errorf("%+v: resolved %q from %q, want %q from %q", test, name, source, test.name, test.source)
+		}
+	}
+}
+
+func TestResolverErrors(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+	defer os.Setenv(EnvironmentVariable, os.Getenv(EnvironmentVariable))
+	os.Setenv(EnvironmentVariable, "")
+
+	r := &Resolver{Store: store, Dir: store.Root()}
+	if _, _, err := r.Resolve(); !IsNotSet(err) || !strings.Contains(err.Error(), "no environments exist yet") {
+		t.Errorf("Resolve() in an empty store = %v", err)
+	}
+
+	if err := store.Create(&Environment{Name: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Resolve(); !IsNotSet(err) || !strings.Contains(err.Error(), "available: dev") {
+		t.Errorf("Resolve() without selection = %v", err)
+	}
+
+	os.Setenv(EnvironmentVariable, "missing")
+	if _, _, err := r.Resolve(); err == nil || !strings.Contains(err.Error(), `"missing" not found (selected by `+EnvironmentVariable+")") {
+		t.Errorf("Resolve() of a missing environment = %v", err)
+	}
+}
@@ -16,31 +16,44 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"os"
-	"os/exec"
+	"path/filepath"
 
 	"github.com/gerred/kube-cluster/cli"
+	_ "github.com/gerred/kube-cluster/driver/aws"
+	"github.com/gerred/kube-cluster/driver/fake"
+	_ "github.com/gerred/kube-cluster/driver/gce"
+	_ "github.com/gerred/kube-cluster/driver/kvm"
+	_ "github.com/gerred/kube-cluster/driver/ssh"
+	_ "github.com/gerred/kube-cluster/driver/vbox"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/kubectl"
 	"github.com/gerred/kube-cluster/kubectlfwd"
 )
 
-const kubectlBinaryName = "kubectl"
-
 func main() {
-	kubectl, err := exec.LookPath(kubectlBinaryName)
-	if err != nil {
-		log.Fatal(err)
+	if storePath := os.Getenv(fake.APIServerVariable); storePath != "" {
+		// started by the fake driver to serve its stub API server
+		fmt.Fprintln(os.Stderr, fake.ServeAPI(storePath))
+		os.Exit(1)
 	}
 
+	store := envstore.New(envstore.DefaultRoot())
+
 	forwarder := kubectlfwd.New(
 		os.Args,
-		kubectl,
+		kubectl.NewLocator(filepath.Join(store.CacheDir(), kubectl.BinaryName)),
+		cli.Root(),
+		store,
 		os.Stdin,
 		os.Stdout,
 		os.Stderr,
 	)
 	if fwd, err := forwarder.Hijack(); !fwd {
-		cli.Execute()
+		cli.Execute(os.Stdin)
+	} else if exitErr, ok := err.(*kubectlfwd.ExitError); ok {
+		// kubectl already reported the failure
+		os.Exit(exitErr.Code)
 	} else if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
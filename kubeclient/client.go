@@ -0,0 +1,242 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Node is a kubernetes node, as far as scheduling capacity goes.
+type Node struct {
+	Name          string
+	Ready         bool
+	Unschedulable bool
+	// CPU and Memory are the allocatable resources, in thousandths of cores
+	// and of bytes.
+	CPU    int64
+	Memory int64
+}
+
+// Pod is a kubernetes pod and the resources it requests.
+type Pod struct {
+	Namespace string
+	Name      string
+	NodeName  string
+	Phase     string
+	// Unschedulable is set once the scheduler failed to find a node for the
+	// pod.
+	Unschedulable bool
+	// CPU and Memory are the requested resources, summed over containers,
+	// in thousandths of cores and of bytes.
+	CPU    int64
+	Memory int64
+}
+
+// Pending reports whether the pod waits for a node the scheduler could not
+// find. Pods not tried yet are left out, a node may well be free for them.
+func (p *Pod) Pending() bool {
+	return p.Phase == "Pending" && p.NodeName == "" && p.Unschedulable
+}
+
+// Terminated reports whether the pod no longer holds resources.
+func (p *Pod) Terminated() bool {
+	return p.Phase == "Succeeded" || p.Phase == "Failed"
+}
+
+// Client reads resources from an API server.
+type Client struct {
+	config *Config
+	client *http.Client
+}
+
+// New returns a client of the API server described by c.
+func New(c *Config) (*Client, error) {
+	if c.Server == "" {
+		return nil, fmt.Errorf("no API server address")
+	}
+	client, err := c.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &Client{config: c, client: client}, nil
+}
+
+type resources map[string]string
+
+type nodeList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			Unschedulable bool `json:"unschedulable"`
+		} `json:"spec"`
+		Status struct {
+			Capacity    resources `json:"capacity"`
+			Allocatable resources `json:"allocatable"`
+			Conditions  []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			NodeName   string `json:"nodeName"`
+			Containers []struct {
+				Resources struct {
+					Requests resources `json:"requests"`
+				} `json:"resources"`
+			} `json:"containers"`
+		} `json:"spec"`
+		Status struct {
+			Phase      string `json:"phase"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+				Reason string `json:"reason"`
+			} `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// Nodes lists the nodes of the cluster.
+func (c *Client) Nodes() ([]Node, error) {
+	var list nodeList
+	if err := c.get("/api/v1/nodes", &list); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(list.Items))
+	for _, item := range list.Items {
+		n := Node{Name: item.Metadata.Name, Unschedulable: item.Spec.Unschedulable}
+		for _, cond := range item.Status.Conditions {
+			if cond.Type == "Ready" {
+				n.Ready = cond.Status == "True"
+			}
+		}
+
+		// Allocatable resources are only reported by recent releases.
+		res := item.Status.Allocatable
+		if len(res) == 0 {
+			res = item.Status.Capacity
+		}
+		var err error
+		if n.CPU, err = res.quantity("cpu"); err != nil {
+			return nil, fmt.Errorf("node %s: %v", n.Name, err)
+		}
+		if n.Memory, err = res.quantity("memory"); err != nil {
+			return nil, fmt.Errorf("node %s: %v", n.Name, err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// Pods lists the pods of all namespaces.
+func (c *Client) Pods() ([]Pod, error) {
+	var list podList
+	if err := c.get("/api/v1/pods", &list); err != nil {
+		return nil, err
+	}
+
+	pods := make([]Pod, 0, len(list.Items))
+	for _, item := range list.Items {
+		p := Pod{
+			Namespace: item.Metadata.Namespace,
+			Name:      item.Metadata.Name,
+			NodeName:  item.Spec.NodeName,
+			Phase:     item.Status.Phase,
+		}
+		for _, cond := range item.Status.Conditions {
+			if cond.Type == "PodScheduled" && cond.Status == "False" && cond.Reason == "Unschedulable" {
+				p.Unschedulable = true
+			}
+		}
+		for _, container := range item.Spec.Containers {
+			cpu, err := container.Resources.Requests.quantity("cpu")
+			if err != nil {
+				return nil, fmt.Errorf("pod %s/%s: %v", p.Namespace, p.Name, err)
+			}
+			memory, err := container.Resources.Requests.quantity("memory")
+			if err != nil {
+				return nil, fmt.Errorf("pod %s/%s: %v", p.Namespace, p.Name, err)
+			}
+			p.CPU += cpu
+			p.Memory += memory
+		}
+		pods = append(pods, p)
+	}
+	return pods, nil
+}
+
+func (r resources) quantity(name string) (int64, error) {
+	v, ok := r[name]
+	if !ok {
+		return 0, nil
+	}
+	q, err := ParseQuantity(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return q, nil
+}
+
+// get decodes the JSON document served at path into v.
+func (c *Client) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(c.config.Server, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case c.config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	case c.config.Username != "":
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &status) == nil && status.Message != "" {
+			return fmt.Errorf("GET %s: %s: %s", path, resp.Status, status.Message)
+		}
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return json.Unmarshal(body, v)
+}
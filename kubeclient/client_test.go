@@ -0,0 +1,256 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gerred/kube-cluster/pki"
+)
+
+const nodesJSON = `{"items": [
+  {"metadata": {"name": "node-1"},
+   "status": {"capacity": {"cpu": "4", "memory": "8Gi"}, "allocatable": {"cpu": "3500m", "memory": "7Gi"},
+              "conditions": [{"type": "Ready", "status": "True"}]}},
+  {"metadata": {"name": "node-2"}, "spec": {"unschedulable": true},
+   "status": {"capacity": {"cpu": "2", "memory": "4Gi"},
+              "conditions": [{"type": "Ready", "status": "False"}]}}
+]}`
+
+const podsJSON = `{"items": [
+  {"metadata": {"name": "web", "namespace": "default"},
+   "spec": {"nodeName": "node-1", "containers": [
+     {"resources": {"requests": {"cpu": "250m", "memory": "64Mi"}}},
+     {"resources": {"requests": {"cpu": "0.5"}}}]},
+   "status": {"phase": "Running"}},
+  {"metadata": {"name": "batch", "namespace": "jobs"},
+   "spec": {"containers": [{"resources": {"requests": {"cpu": "2", "memory": "1G"}}}]},
+   "status": {"phase": "Pending",
+              "conditions": [{"type": "PodScheduled", "status": "False", "reason": "Unschedulable"}]}},
+  {"metadata": {"name": "new", "namespace": "jobs"},
+   "spec": {"containers": [{}]},
+   "status": {"phase": "Pending"}}
+]}`
+
+// apiServer serves the nodes and pods lists, and the failures of auth.
+func apiServer(auth func(r *http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"kind": "Status", "message": "Unauthorized"}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/nodes":
+			fmt.Fprint(w, nodesJSON)
+		case "/api/v1/pods":
+			fmt.Fprint(w, podsJSON)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestNodesAndPods(t *testing.T) {
+	srv := httptest.NewServer(apiServer(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer secret"
+	}))
+	defer srv.Close()
+
+	c, err := New(&Config{Server: srv.URL + "/", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := c.Nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNodes := []Node{
+		{Name: "node-1", Ready: true, CPU: 3500, Memory: 7 << 30 * 1000},
+		{Name: "node-2", Unschedulable: true, CPU: 2000, Memory: 4 << 30 * 1000},
+	}
+	if len(nodes) != len(wantNodes) {
+		t.Fatalf("got nodes %+v", nodes)
+	}
+	for i := range wantNodes {
+		if nodes[i] != wantNodes[i] {
+			t.Errorf("got node %+v, want %+v", nodes[i], wantNodes[i])
+		}
+	}
+
+	pods, err := c.Pods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPods := []Pod{
+		{Namespace: "default", Name: "web", NodeName: "node-1", Phase: "Running", CPU: 750, Memory: 64 << 20 * 1000},
+		{Namespace: "jobs", Name: "batch", Phase: "Pending", Unschedulable: true, CPU: 2000, Memory: 1e9 * 1000},
+		{Namespace: "jobs", Name: "new", Phase: "Pending"},
+	}
+	if len(pods) != len(wantPods) {
+		t.Fatalf("got pods %+v", pods)
+	}
+	for i := range wantPods {
+		if pods[i] != wantPods[i] {
+			t.Errorf("got pod %+v, want %+v", pods[i], wantPods[i])
+		}
+	}
+	if !pods[1].Pending() || pods[2].Pending() || pods[0].Pending() {
+		t.Errorf("only the unschedulable pod should be pending: %+v", pods)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(apiServer(func(r *http.Request) bool {
+		username, password, ok := r.BasicAuth()
+		return ok && username == "admin" && password == "pass"
+	}))
+	defer srv.Close()
+
+	c, err := New(&Config{Server: srv.URL, Username: "admin", Password: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Nodes(); err != nil {
+		t.Error(err)
+	}
+
+	c, err = New(&Config{Server: srv.URL, Username: "admin", Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "GET /api/v1/nodes: 401 Unauthorized: Unauthorized"
+	if _, err := c.Nodes(); err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
+
+// TestLoadConfigTLS checks a client loaded from a kubeconfig referencing
+// certificate files trusts the API server and presents its certificate.
+func TestLoadConfigTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := pki.New(filepath.Join(dir, "pki"), pki.Options{Algorithm: pki.ECDSA})
+	if err := p.Ensure("dev", pki.SANs{IPs: []net.IP{net.ParseIP("127.0.0.1")}}); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ioutil.ReadFile(p.CertPath(pki.CA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(p.CertPath(pki.APIServer), p.KeyPath(pki.APIServer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := x509.NewCertPool()
+	clients.AppendCertsFromPEM(ca)
+
+	srv := httptest.NewUnstartedServer(apiServer(func(r *http.Request) bool {
+		return len(r.TLS.PeerCertificates) > 0 && r.TLS.PeerCertificates[0].Subject.CommonName == "admin"
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clients,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	path := filepath.Join(dir, "kubeconfig")
+	kubeconfig := `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: ` + srv.URL + `
+    certificate-authority: pki/ca.crt
+users:
+- name: dev-admin
+  user:
+    client-certificate: pki/admin.crt
+    client-key: pki/admin.key
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev-admin
+`
+	if err := ioutil.WriteFile(path, []byte(kubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server != srv.URL || len(config.CAData) == 0 || len(config.CertData) == 0 || len(config.KeyData) == 0 {
+		t.Fatalf("got config %+v", config)
+	}
+	c, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes, err := c.Nodes(); err != nil || len(nodes) != 2 {
+		t.Errorf("got nodes %v, %v", nodes, err)
+	}
+
+	// without the certificate authority, the server is not trusted
+	config.CAData = nil
+	if c, err = New(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Nodes(); err == nil {
+		t.Error("got no error from an untrusted server")
+	}
+}
+
+func TestLoadConfigMissingCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kubeconfig")
+	kubeconfig := `apiVersion: v1
+kind: Config
+current-context: dev
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev-admin
+`
+	if err := ioutil.WriteFile(path, []byte(kubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("got no error")
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kubeclient is a minimal client of the kubernetes API, reading the
+// nodes and pods kube-cluster needs to size environments. It is configured from
+// the kubeconfig files drivers generate.
+package kubeclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gerred/kube-cluster/kubeconfig"
+)
+
+// Config holds the address of an API server and the credentials to use.
+type Config struct {
+	Server string
+
+	// Token or Username and Password authenticate requests, unless a client
+	// certificate is given.
+	Token    string
+	Username string
+	Password string
+
+	CAData   []byte
+	CertData []byte
+	KeyData  []byte
+	Insecure bool
+}
+
+// LoadConfig reads the current context of a kubeconfig file. Relative file
+// references are resolved from the kubeconfig directory.
+func LoadConfig(path string) (*Config, error) {
+	kc, err := kubeconfig.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	clusterName, userName, err := kc.Context(kc.CurrentContext())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	cluster := kc.Cluster(clusterName)
+	if cluster == nil {
+		return nil, fmt.Errorf("%s: cluster %q not found", path, clusterName)
+	}
+
+	c := &Config{}
+	dir := filepath.Dir(path)
+	c.Server, _ = cluster["server"].(string)
+	c.Insecure, _ = cluster["insecure-skip-tls-verify"].(bool)
+	if c.CAData, err = kubeconfig.Data(cluster, "certificate-authority", dir); err != nil {
+		return nil, fmt.Errorf("%s: cluster %q: %v", path, clusterName, err)
+	}
+
+	if user := kc.User(userName); user != nil {
+		c.Token, _ = user["token"].(string)
+		c.Username, _ = user["username"].(string)
+		c.Password, _ = user["password"].(string)
+		if c.CertData, err = kubeconfig.Data(user, "client-certificate", dir); err != nil {
+			return nil, fmt.Errorf("%s: user %q: %v", path, userName, err)
+		}
+		if c.KeyData, err = kubeconfig.Data(user, "client-key", dir); err != nil {
+			return nil, fmt.Errorf("%s: user %q: %v", path, userName, err)
+		}
+	}
+	return c, nil
+}
+
+// HTTPClient returns an http.Client trusting the API server, and presenting the
+// client certificate if any.
+func (c *Config) HTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.Insecure}
+	if len(c.CAData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(c.CAData) {
+			return nil, fmt.Errorf("invalid certificate authority")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(c.CertData) > 0 {
+		cert, err := tls.X509KeyPair(c.CertData, c.KeyData)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeclient
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+)
+
+var quantityRE = regexp.MustCompile(`^([+-]?[0-9]+(?:\.[0-9]*)?|[+-]?\.[0-9]+)(m|k|M|G|T|P|E|Ki|Mi|Gi|Ti|Pi|Ei|[eE][+-]?[0-9]+)?$`)
+
+var suffixes = map[string]*big.Rat{
+	"":   big.NewRat(1, 1),
+	"m":  big.NewRat(1, 1000),
+	"k":  big.NewRat(1000, 1),
+	"M":  big.NewRat(1000*1000, 1),
+	"G":  big.NewRat(1000*1000*1000, 1),
+	"T":  big.NewRat(1000*1000*1000*1000, 1),
+	"P":  big.NewRat(1000*1000*1000*1000*1000, 1),
+	"E":  big.NewRat(1000*1000*1000*1000*1000*1000, 1),
+	"Ki": big.NewRat(1<<10, 1),
+	"Mi": big.NewRat(1<<20, 1),
+	"Gi": big.NewRat(1<<30, 1),
+	"Ti": big.NewRat(1<<40, 1),
+	"Pi": big.NewRat(1<<50, 1),
+	"Ei": big.NewRat(1<<60, 1),
+}
+
+// ParseQuantity parses a kubernetes resource quantity, such as 100m, 1.5 or
+// 2Gi, and returns it in thousandths, rounded up.
+func ParseQuantity(s string) (int64, error) {
+	m := quantityRE.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+
+	v, ok := new(big.Rat).SetString(m[1])
+	if !ok {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	if mult, ok := suffixes[m[2]]; ok {
+		v.Mul(v, mult)
+	} else {
+		// Decimal exponent, e.g. 1e3.
+		exp, ok := new(big.Rat).SetString("1" + m[2])
+		if !ok {
+			return 0, fmt.Errorf("invalid quantity %q", s)
+		}
+		v.Mul(v, exp)
+	}
+	v.Mul(v, big.NewRat(1000, 1))
+
+	milli := new(big.Int).Quo(v.Num(), v.Denom())
+	if new(big.Rat).SetInt(milli).Cmp(v) < 0 {
+		milli.Add(milli, big.NewInt(1))
+	}
+	if !milli.IsInt64() {
+		return 0, fmt.Errorf("quantity %q is too large", s)
+	}
+	return milli.Int64(), nil
+}
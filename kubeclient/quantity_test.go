@@ -0,0 +1,49 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeclient
+
+import "testing"
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"1", 1000},
+		{"100m", 100},
+		{"1.5", 1500},
+		{".5", 500},
+		{"0.0001", 1},
+		{"1k", 1000 * 1000},
+		{"2Gi", 2 << 30 * 1000},
+		{"128Mi", 128 << 20 * 1000},
+		{"1G", 1e9 * 1000},
+		{"1e3", 1000 * 1000},
+		{"1E-3", 1},
+		{"0", 0},
+	}
+	for _, test := range tests {
+		got, err := ParseQuantity(test.s)
+		if err != nil || got != test.want {
+			t.Errorf("%s: got %d, %v, want %d", test.s, got, err, test.want)
+		}
+	}
+
+	for _, s := range []string{"", "1.5.2", "1x", "Gi", "8Ei"} {
+		if got, err := ParseQuantity(s); err == nil {
+			t.Errorf("%s: got %d, want an error", s, got)
+		}
+	}
+}
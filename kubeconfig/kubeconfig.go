@@ -0,0 +1,456 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kubeconfig reads, rewrites and merges kubeconfig files, the files
+// kubectl and other kubernetes clients find API servers and credentials in.
+// Documents are handled generically, so fields unknown to kube-cluster are
+// kept as they are. Files kube-cluster merges into are edited in place: only
+// the entries it adds or removes change, the rest of the file, comments and
+// key order included, is written back as it was read.
+package kubeconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yamlv3 "github.com/gerred/kube-cluster/Godeps/_workspace/src/gopkg.in/yaml.v3"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/yaml"
+)
+
+// fileFields maps the fields of clusters and users referencing files to the
+// fields inlining their content.
+var fileFields = map[string]string{
+	"certificate-authority": "certificate-authority-data",
+	"client-certificate":    "client-certificate-data",
+	"client-key":            "client-key-data",
+}
+
+// Config is a kubeconfig document.
+type Config struct {
+	doc map[string]interface{}
+
+	// node is the document as parsed, nil for new ones, which Marshal
+	// patches with the touched entries of doc unless rewrite is set.
+	node    *yamlv3.Node
+	touched map[string]map[string]bool
+	rewrite bool
+}
+
+// New returns an empty kubeconfig.
+func New() *Config {
+	return &Config{doc: map[string]interface{}{
+		"apiVersion":      "v1",
+		"kind":            "Config",
+		"clusters":        []interface{}{},
+		"users":           []interface{}{},
+		"contexts":        []interface{}{},
+		"current-context": "",
+		"preferences":     map[string]interface{}{},
+	}}
+}
+
+// Parse parses a kubeconfig document.
+func Parse(data []byte) (*Config, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return New(), nil
+	}
+	c := &Config{doc: doc}
+	var node yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &node); err == nil && len(node.Content) == 1 && node.Content[0].Kind == yamlv3.MappingNode {
+		c.node = &node
+	}
+	return c, nil
+}
+
+// Load reads the kubeconfig file at path. A missing file is an empty
+// kubeconfig.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return New(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Marshal returns the YAML encoding of c. Parsed documents are encoded as they
+// were read, but for the entries Merge and Remove changed.
+func (c *Config) Marshal() ([]byte, error) {
+	if c.node == nil || c.rewrite {
+		return yaml.Marshal(c.doc)
+	}
+	if err := c.patch(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Save writes c to path, creating its directory if needed. A file it
+// changes is first copied to path.bak.
+func (c *Config) Save(path string) error {
+	data, err := c.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if old, err := ioutil.ReadFile(path); err == nil && !bytes.Equal(old, data) {
+		if err := ioutil.WriteFile(path+".bak", old, 0600); err != nil {
+			return err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return envstore.WriteFileAtomic(path, data, 0600)
+}
+
+// CurrentContext returns the name of the context in use.
+func (c *Config) CurrentContext() string {
+	s, _ := c.doc["current-context"].(string)
+	return s
+}
+
+// Context returns the cluster and user names of the named context.
+func (c *Config) Context(name string) (cluster, user string, err error) {
+	ctx := c.entry("contexts", "context", name)
+	if ctx == nil {
+		return "", "", fmt.Errorf("context %q not found", name)
+	}
+	cluster, _ = ctx["cluster"].(string)
+	user, _ = ctx["user"].(string)
+	return cluster, user, nil
+}
+
+// Cluster returns the settings of the named cluster, nil if not found.
+func (c *Config) Cluster(name string) map[string]interface{} {
+	return c.entry("clusters", "cluster", name)
+}
+
+// User returns the credentials of the named user, nil if not found.
+func (c *Config) User(name string) map[string]interface{} {
+	return c.entry("users", "user", name)
+}
+
+// Minify drops everything but the current context, and the cluster and user
+// it refers to.
+func (c *Config) Minify() error {
+	name := c.CurrentContext()
+	if name == "" {
+		return fmt.Errorf("no current context")
+	}
+	cluster, user, err := c.Context(name)
+	if err != nil {
+		return err
+	}
+	c.keep("contexts", name)
+	c.keep("clusters", cluster)
+	c.keep("users", user)
+	c.rewrite = true
+	return nil
+}
+
+// Flatten inlines the files clusters and users refer to, relative paths being
+// resolved from dir.
+func (c *Config) Flatten(dir string) error {
+	c.rewrite = true
+	for _, list := range [][2]string{{"clusters", "cluster"}, {"users", "user"}} {
+		for _, item := range c.list(list[0]) {
+			fields, _ := item[list[1]].(map[string]interface{})
+			for fileField, dataField := range fileFields {
+				file, _ := fields[fileField].(string)
+				if file == "" {
+					continue
+				}
+				if !filepath.IsAbs(file) {
+					file = filepath.Join(dir, file)
+				}
+				data, err := ioutil.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("%s %v: %v", list[1], item["name"], err)
+				}
+				delete(fields, fileField)
+				fields[dataField] = base64.StdEncoding.EncodeToString(data)
+			}
+		}
+	}
+	return nil
+}
+
+// Data returns the content of a file field of a cluster or user, either
+// inlined or read from the referenced file, relative to dir.
+func Data(fields map[string]interface{}, fileField, dir string) ([]byte, error) {
+	if data, _ := fields[fileField+"-data"].(string); data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	file, _ := fields[fileField].(string)
+	if file == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// Has reports whether c has a cluster, user or context with the given name.
+func (c *Config) Has(name string) bool {
+	return c.entry("clusters", "cluster", name) != nil ||
+		c.entry("users", "user", name) != nil ||
+		c.entry("contexts", "context", name) != nil
+}
+
+// Merge adds the current context of src to c, naming it, its cluster and its
+// user after name. Entries of c with the same names are replaced.
+func (c *Config) Merge(src *Config, name string) error {
+	cluster, user, err := src.Context(src.CurrentContext())
+	if err != nil {
+		return err
+	}
+	clusterFields := src.Cluster(cluster)
+	if clusterFields == nil {
+		return fmt.Errorf("cluster %q not found", cluster)
+	}
+	userFields := src.User(user)
+	if userFields == nil {
+		return fmt.Errorf("user %q not found", user)
+	}
+
+	ctx := map[string]interface{}{}
+	for k, v := range src.entry("contexts", "context", src.CurrentContext()) {
+		ctx[k] = v
+	}
+	ctx["cluster"], ctx["user"] = name, name
+
+	c.set("clusters", "cluster", name, clusterFields)
+	c.set("users", "user", name, userFields)
+	c.set("contexts", "context", name, ctx)
+	return nil
+}
+
+// Remove removes the named context, and the cluster and user named after it
+// unless other contexts use them. It reports whether the context existed.
+func (c *Config) Remove(name string) bool {
+	if c.entry("contexts", "context", name) == nil {
+		return false
+	}
+	c.drop("contexts", name)
+
+	var clusterUsed, userUsed bool
+	for _, item := range c.list("contexts") {
+		ctx, _ := item["context"].(map[string]interface{})
+		clusterUsed = clusterUsed || ctx["cluster"] == name
+		userUsed = userUsed || ctx["user"] == name
+	}
+	if !clusterUsed {
+		c.drop("clusters", name)
+	}
+	if !userUsed {
+		c.drop("users", name)
+	}
+	if c.CurrentContext() == name {
+		c.doc["current-context"] = ""
+		c.touch("current-context", "")
+	}
+	return true
+}
+
+// DefaultPath returns the kubeconfig file kubectl uses by default: the first
+// one listed by $KUBECONFIG, or ~/.kube/config.
+func DefaultPath() string {
+	if paths := filepath.SplitList(os.Getenv("KUBECONFIG")); len(paths) > 0 && strings.TrimSpace(paths[0]) != "" {
+		return paths[0]
+	}
+	return filepath.Join(os.Getenv("HOME"), ".kube", "config")
+}
+
+// list returns the named list of entries, such as "clusters".
+func (c *Config) list(key string) []map[string]interface{} {
+	items, _ := c.doc[key].([]interface{})
+	var entries []map[string]interface{}
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			entries = append(entries, m)
+		}
+	}
+	return entries
+}
+
+// entry returns the fields of the named entry of a list.
+func (c *Config) entry(key, field, name string) map[string]interface{} {
+	for _, item := range c.list(key) {
+		if item["name"] == name {
+			fields, _ := item[field].(map[string]interface{})
+			if fields == nil {
+				fields = map[string]interface{}{}
+			}
+			return fields
+		}
+	}
+	return nil
+}
+
+// set adds or replaces the named entry of a list.
+func (c *Config) set(key, field, name string, fields map[string]interface{}) {
+	c.drop(key, name)
+	items, _ := c.doc[key].([]interface{})
+	c.doc[key] = append(items, map[string]interface{}{"name": name, field: fields})
+}
+
+// drop removes the named entry of a list.
+func (c *Config) drop(key, name string) {
+	c.filter(key, func(item map[string]interface{}) bool { return item["name"] != name })
+	c.touch(key, name)
+}
+
+// touch records that the named entry of a list changed, the empty name
+// standing for a top-level scalar field.
+func (c *Config) touch(key, name string) {
+	if c.touched == nil {
+		c.touched = make(map[string]map[string]bool)
+	}
+	if c.touched[key] == nil {
+		c.touched[key] = make(map[string]bool)
+	}
+	c.touched[key][name] = true
+}
+
+// patch brings the touched entries of the parsed document up to date with
+// doc, leaving the others alone.
+func (c *Config) patch() error {
+	root := c.node.Content[0]
+	for _, key := range []string{"clusters", "users", "contexts", "current-context"} {
+		names := c.touched[key]
+		if len(names) == 0 {
+			continue
+		}
+		if names[""] {
+			value, _ := c.doc[key].(string)
+			n := mappingValue(root, key)
+			if n == nil {
+				n = &yamlv3.Node{}
+				root.Content = append(root.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: key}, n)
+			}
+			if err := n.Encode(value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		seq := mappingValue(root, key)
+		switch {
+		case seq == nil:
+			seq = &yamlv3.Node{Kind: yamlv3.SequenceNode}
+			root.Content = append(root.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: key}, seq)
+		case seq.Kind == yamlv3.ScalarNode && seq.ShortTag() == "!!null":
+			*seq = yamlv3.Node{Kind: yamlv3.SequenceNode}
+		case seq.Kind != yamlv3.SequenceNode:
+			return fmt.Errorf("%s is not a list", key)
+		}
+		seq.Style &^= yamlv3.FlowStyle
+
+		var sorted []string
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+		for _, name := range sorted {
+			var entry map[string]interface{}
+			for _, item := range c.list(key) {
+				if item["name"] == name {
+					entry = item
+				}
+			}
+			i := 0
+			for ; i < len(seq.Content); i++ {
+				if n := mappingValue(seq.Content[i], "name"); n != nil && n.Value == name {
+					break
+				}
+			}
+
+			if entry == nil {
+				if i < len(seq.Content) {
+					seq.Content = append(seq.Content[:i], seq.Content[i+1:]...)
+				}
+				continue
+			}
+			n := &yamlv3.Node{}
+			if err := n.Encode(entry); err != nil {
+				return err
+			}
+			if i < len(seq.Content) {
+				n.HeadComment, n.LineComment, n.FootComment = seq.Content[i].HeadComment, seq.Content[i].LineComment, seq.Content[i].FootComment
+				seq.Content[i] = n
+			} else {
+				seq.Content = append(seq.Content, n)
+			}
+		}
+	}
+	c.touched = nil
+	return nil
+}
+
+// mappingValue returns the value of key in the mapping node n, or nil.
+func mappingValue(n *yamlv3.Node, key string) *yamlv3.Node {
+	if n.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// keep removes the entries of a list but the named one.
+func (c *Config) keep(key, name string) {
+	c.filter(key, func(item map[string]interface{}) bool { return item["name"] == name })
+}
+
+func (c *Config) filter(key string, keep func(item map[string]interface{}) bool) {
+	items, _ := c.doc[key].([]interface{})
+	kept := []interface{}{}
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); !ok || keep(m) {
+			kept = append(kept, item)
+		}
+	}
+	c.doc[key] = kept
+}
@@ -0,0 +1,278 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeconfig
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// userConfig is a kubeconfig as written by hand.
+const userConfig = `# clusters of the team
+apiVersion: v1
+kind: Config
+current-context: prod
+preferences:
+  colors: true
+clusters:
+- name: prod # the production cluster
+  cluster:
+    server: https://prod.example.com
+users:
+- name: prod
+  user:
+    token: secret
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: prod
+    namespace: "yes"
+`
+
+// envConfig is a kubeconfig written by a driver.
+const envConfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://10.0.0.1:6443
+    certificate-authority: ca.crt
+users:
+- name: admin
+  user:
+    client-certificate-data: Y2VydA==
+    client-key-data: a2V5
+- name: other
+  user:
+    token: other
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+- name: other
+  context:
+    cluster: dev
+    user: other
+`
+
+func parse(t *testing.T, doc string) *Config {
+	c, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMergeKeepsTheRest(t *testing.T) {
+	dst := parse(t, userConfig)
+	if err := dst.Merge(parse(t, envConfig), "stage"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := dst.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		"# clusters of the team\napiVersion: v1\nkind: Config\ncurrent-context: prod\n",
+		"- name: prod # the production cluster\n",
+		"namespace: \"yes\"",
+		"server: https://10.0.0.1:6443",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("merged file does not hold %q:\n%s", want, out)
+		}
+	}
+
+	merged := parse(t, out)
+	cluster, user, err := merged.Context("stage")
+	if err != nil || cluster != "stage" || user != "stage" {
+		t.Errorf("got context stage: %q, %q, %v", cluster, user, err)
+	}
+	if u := merged.User("stage"); u["client-key-data"] != "a2V5" {
+		t.Errorf("got user %v", u)
+	}
+	if u := merged.User("prod"); u["token"] != "secret" {
+		t.Errorf("got user prod %v", u)
+	}
+	if merged.CurrentContext() != "prod" {
+		t.Errorf("current context changed to %q", merged.CurrentContext())
+	}
+
+	// merging again replaces the entries
+	if err := merged.Merge(parse(t, strings.Replace(envConfig, "10.0.0.1", "10.0.0.2", 1)), "stage"); err != nil {
+		t.Fatal(err)
+	}
+	data, err = merged.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "name: stage") != 3 || !strings.Contains(string(data), "10.0.0.2") {
+		t.Errorf("got:\n%s", data)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	c := parse(t, userConfig)
+	if err := c.Merge(parse(t, envConfig), "stage"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = parse(t, string(data))
+	if !c.Remove("stage") {
+		t.Fatal("context stage not found")
+	}
+	if c.Remove("stage") {
+		t.Error("context stage removed twice")
+	}
+	data, err = c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := parse(t, userConfig).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+	if !strings.HasPrefix(string(data), "# clusters of the team\n") {
+		t.Errorf("comments were dropped:\n%s", data)
+	}
+
+	// the current context is unset with it
+	c = parse(t, userConfig)
+	c.Remove("prod")
+	data, err = c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "current-context: \"\"\n") || strings.Contains(string(data), "prod") {
+		t.Errorf("got:\n%s", data)
+	}
+}
+
+func TestRemoveKeepsSharedEntries(t *testing.T) {
+	c := parse(t, envConfig)
+	c.Remove("other")
+	if c.User("other") != nil {
+		t.Error("user other was kept")
+	}
+	if c.Cluster("dev") == nil {
+		t.Error("cluster dev was removed while context dev uses it")
+	}
+}
+
+func TestMinifyFlatten(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.crt"), []byte("ca"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := parse(t, envConfig)
+	if err := c.Minify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flatten(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat := parse(t, string(data))
+	if flat.User("other") != nil || flat.Has("other") {
+		t.Error("minify kept the other context")
+	}
+	ca, err := Data(flat.Cluster("dev"), "certificate-authority", "")
+	if err != nil || string(ca) != "ca" {
+		t.Errorf("got CA %q, %v", ca, err)
+	}
+	if flat.Cluster("dev")["certificate-authority-data"] != base64.StdEncoding.EncodeToString([]byte("ca")) {
+		t.Errorf("got cluster %v", flat.Cluster("dev"))
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".kube", "config")
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Merge(parse(t, envConfig), "dev"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Has("dev") || c.CurrentContext() != "" {
+		t.Errorf("got current context %q, has dev %v", c.CurrentContext(), c.Has("dev"))
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("got %v, %v, want a private file", fi, err)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("got backup of a new file: %v", err)
+	}
+
+	// changes keep a copy of the previous file
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Remove("dev")
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if backup, err := ioutil.ReadFile(path + ".bak"); err != nil || string(backup) != string(before) {
+		t.Errorf("got backup %q, %v, want %q", backup, err, before)
+	}
+}
+
+func TestDefaultPath(t *testing.T) {
+	defer os.Setenv("KUBECONFIG", os.Getenv("KUBECONFIG"))
+	os.Setenv("KUBECONFIG", "/a/config"+string(os.PathListSeparator)+"/b/config")
+	if got := DefaultPath(); got != "/a/config" {
+		t.Errorf("got %q", got)
+	}
+	os.Setenv("KUBECONFIG", "")
+	if got := DefaultPath(); got != filepath.Join(os.Getenv("HOME"), ".kube", "config") {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kubectl locates the kubectl binary forwarded calls are run with.
+// Environments may pin a kubectl version, usually matching their API server.
+// Pinned versions are downloaded once from a release mirror, verified by
+// SHA-256 checksum, and kept in a local cache:
+//
+//	~/.kube-cluster/cache/kubectl/<version>/<os>-<arch>/kubectl
+//
+// Unpinned environments use the kubectl found in PATH.
+package kubectl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+const (
+	// BinaryName is the name of the kubectl executable.
+	BinaryName = "kubectl"
+
+	// MirrorVariable is the environment variable overriding the release
+	// mirror.
+	MirrorVariable = "KUBE_CLUSTER_KUBECTL_MIRROR"
+
+	// DefaultMirror hosts the official kubernetes releases.
+	DefaultMirror = "https://storage.googleapis.com/kubernetes-release/release"
+)
+
+var validVersion = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+([-+][0-9A-Za-z.+-]+)?$`)
+
+// ValidateVersion checks that version is a kubernetes release name, such as
+// v1.1.2.
+func ValidateVersion(version string) error {
+	if !validVersion.MatchString(version) {
+		return fmt.Errorf("invalid kubectl version %q, expected a release name such as v1.1.2", version)
+	}
+	return nil
+}
+
+// Locator finds, and downloads when needed, kubectl binaries.
+type Locator struct {
+	// CacheDir holds the downloaded binaries.
+	CacheDir string
+	// Mirror is the base URL of the release mirror. Binaries are fetched
+	// from <Mirror>/<version>/bin/<os>/<arch>/kubectl, and their checksum
+	// from the same URL with a .sha256 suffix.
+	Mirror string
+	// Client performs the downloads, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// NewLocator instantiates a locator caching binaries in cacheDir. The mirror
+// honours KUBE_CLUSTER_KUBECTL_MIRROR.
+func NewLocator(cacheDir string) *Locator {
+	mirror := os.Getenv(MirrorVariable)
+	if mirror == "" {
+		mirror = DefaultMirror
+	}
+	return &Locator{CacheDir: cacheDir, Mirror: mirror}
+}
+
+// Find returns the path of the kubectl binary of the given version, which is
+// downloaded unless cached. An empty version selects the kubectl found in
+// PATH. If checksum is not empty, it is the expected hex encoded SHA-256 of
+// the binary, otherwise the checksum published by the mirror is used.
+func (l *Locator) Find(version, checksum string) (string, error) {
+	if version == "" {
+		return exec.LookPath(BinaryName)
+	}
+	if err := ValidateVersion(version); err != nil {
+		return "", err
+	}
+
+	binary := filepath.Join(l.CacheDir, version, runtime.GOOS+"-"+runtime.GOARCH, binaryFileName())
+	if data, err := ioutil.ReadFile(binary); err == nil {
+		// cached binaries are verified on download, pinned checksums are
+		// checked again as they may differ from the mirror published one
+		if checksum != "" {
+			if err := verify(data, checksum); err != nil {
+				return "", fmt.Errorf("kubectl %s: cached %s: %v", version, binary, err)
+			}
+		}
+		return binary, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if err := l.download(version, checksum, binary); err != nil {
+		return "", fmt.Errorf("kubectl %s: %v", version, err)
+	}
+	return binary, nil
+}
+
+func (l *Locator) download(version, checksum, binary string) error {
+	url := strings.TrimSuffix(l.Mirror, "/") + "/" + path.Join(version, "bin", runtime.GOOS, runtime.GOARCH, binaryFileName())
+
+	if checksum == "" {
+		sum, err := l.get(url + ".sha256")
+		if err != nil {
+			return fmt.Errorf("fetching checksum: %v", err)
+		}
+		// the checksum file may follow the sha256sum format
+		fields := strings.Fields(string(sum))
+		if len(fields) == 0 {
+			return fmt.Errorf("empty checksum at %s.sha256", url)
+		}
+		checksum = fields[0]
+	}
+
+	data, err := l.get(url)
+	if err != nil {
+		return err
+	}
+
+	if err := verify(data, checksum); err != nil {
+		return fmt.Errorf("%s: %v", url, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(binary), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(binary), "."+BinaryName)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0755)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), binary)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (l *Locator) get(url string) ([]byte, error) {
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// verify checks data against a hex encoded SHA-256 checksum.
+func verify(data []byte, checksum string) error {
+	h := sha256.Sum256(data)
+	if actual := hex.EncodeToString(h[:]); !strings.EqualFold(actual, checksum) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", checksum, actual)
+	}
+	return nil
+}
+
+func binaryFileName() string {
+	if runtime.GOOS == "windows" {
+		return BinaryName + ".exe"
+	}
+	return BinaryName
+}
+
+// ServerVersion queries the API server at url for its release name, such as
+// v1.1.2.
+func ServerVersion(client *http.Client, url string) (string, error) {
+	l := &Locator{Client: client}
+	data, err := l.get(strings.TrimSuffix(url, "/") + "/version")
+	if err != nil {
+		return "", err
+	}
+
+	var info struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", fmt.Errorf("decoding %s/version: %v", url, err)
+	}
+	if err := ValidateVersion(info.GitVersion); err != nil {
+		return "", err
+	}
+	return info.GitVersion, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubectl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mirror serves a kubectl release and its checksum file, counting the
+// downloads.
+type mirror struct {
+	mu        sync.Mutex
+	binary    []byte
+	checksum  string
+	downloads int
+}
+
+func (m *mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	url := "/" + path.Join("v1.1.2", "bin", runtime.GOOS, runtime.GOARCH, binaryFileName())
+	switch r.URL.Path {
+	case url:
+		m.downloads++
+		w.Write(m.binary)
+	case url + ".sha256":
+		fmt.Fprintf(w, "%s  kubectl\n", m.checksum)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newMirror(binary string) *mirror {
+	sum := sha256.Sum256([]byte(binary))
+	return &mirror{binary: []byte(binary), checksum: hex.EncodeToString(sum[:])}
+}
+
+func testLocator(t *testing.T, m *mirror) (*Locator, func()) {
+	dir, err := ioutil.TempDir("", "kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(m)
+	return &Locator{CacheDir: dir, Mirror: srv.URL + "/"}, func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFindDownloadsOnce(t *testing.T) {
+	m := newMirror("#!/bin/sh\necho kubectl\n")
+	l, cleanup := testLocator(t, m)
+	defer cleanup()
+
+	binary, err := l.Find("v1.1.2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(binary, l.CacheDir) {
+		t.Errorf("binary %s is not cached in %s", binary, l.CacheDir)
+	}
+	data, err := ioutil.ReadFile(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(m.binary) {
+		t.Errorf("downloaded %q, want %q", data, m.binary)
+	}
+	if fi, err := os.Stat(binary); err != nil || fi.Mode().Perm()&0100 == 0 {
+		t.Errorf("binary is not executable: %v", err)
+	}
+
+	again, err := l.Find("v1.1.2", m.checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != binary || m.downloads != 1 {
+		t.Errorf("second Find returned %s after %d downloads, want the cached binary", again, m.downloads)
+	}
+}
+
+func TestFindChecksumMismatch(t *testing.T) {
+	m := newMirror("kubectl")
+	l, cleanup := testLocator(t, m)
+	defer cleanup()
+
+	if _, err := l.Find("v1.1.2", strings.Repeat("0", 64)); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Find with a wrong pinned checksum = %v", err)
+	}
+
+	m.checksum = strings.Repeat("0", 64)
+	if _, err := l.Find("v1.1.2", ""); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Find with a wrong published checksum = %v", err)
+	}
+
+	entries, _ := ioutil.ReadDir(l.CacheDir)
+	if len(entries) != 0 {
+		t.Errorf("unverified binary left in the cache: %v", entries)
+	}
+}
+
+func TestFindErrors(t *testing.T) {
+	l, cleanup := testLocator(t, newMirror("kubectl"))
+	defer cleanup()
+
+	for _, version := range []string{"1.1.2", "latest", "v1.1"} {
+		if _, err := l.Find(version, ""); err == nil {
+			t.Errorf("Find(%q) succeeded", version)
+		}
+	}
+	if _, err := l.Find("v9.9.9", ""); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Find of a missing release = %v", err)
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	for version, valid := range map[string]bool{
+		"v1.1.2":        true,
+		"v1.2.0-beta.1": true,
+		"v1.2.0+build":  true,
+		"1.1.2":         false,
+		"v1.1":          false,
+		"v1.1.2/../x":   false,
+		"":              false,
+	} {
+		if err := ValidateVersion(version); (err == nil) != valid {
+			t.Errorf("ValidateVersion(%q) = %v, want valid %v", version, err, valid)
+		}
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"major":"1","minor":"1","gitVersion":"v1.1.2"}`)
+	}))
+	defer srv.Close()
+
+	version, err := ServerVersion(nil, srv.URL+"/")
+	if err != nil || version != "v1.1.2" {
+		t.Errorf("ServerVersion() = %q, %v, want v1.1.2", version, err)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubectlfwd
+
+import (
+	"strings"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/pflag"
+)
+
+// kubectlValueFlags lists the kubectl flags taking a value, by long name and
+// shorthand. Their values must not be mistaken for commands, e.g. in
+// "--namespace kube-system get env".
+var kubectlValueFlags = []string{
+	// global flags
+	"api-version", "certificate-authority", "client-certificate",
+	"client-key", "cluster", "context", "kubeconfig", "log-backtrace-at",
+	"log-dir", "log-flush-frequency", "namespace", "password", "server", "s",
+	"stderrthreshold", "token", "user", "username", "v", "vmodule",
+
+	// command flags
+	"filename", "f", "output", "o", "output-version", "template",
+	"sort-by", "selector", "l", "label-columns", "L", "container", "c",
+	"image", "replicas", "r", "port", "target-port", "type", "name",
+	"generator", "overrides", "labels", "env", "limits", "requests",
+	"restart", "timeout", "grace-period", "resource-version", "patch", "p",
+	"protocol", "external-ip", "load-balancer-ip", "session-affinity",
+	"container-port", "service-account", "hostport", "current-replicas",
+	"update-period", "poll-interval", "deployment-label-key", "rollback",
+	"min", "max", "cpu-percent", "address", "api-prefix", "accept-hosts",
+	"accept-paths", "reject-methods", "reject-paths", "www", "www-prefix",
+	"unix-socket", "pod", "tail", "since", "since-time", "limit-bytes",
+	"to-revision", "revision", "schema-cache-dir", "editor", "n",
+}
+
+// positionalArgs returns the non-flag arguments of args, which must not hold
+// the program name. Flags are recognised in the "--flag value",
+// "--flag=value", "-f value", "-fvalue" and "-f=value" forms, the value being
+// consumed only for the flags listed in valueFlags. Parsing stops at the "--"
+// terminator: what follows belongs to the invoked command, e.g. in
+// "exec POD -- get env".
+func positionalArgs(args []string, valueFlags map[string]bool) []string {
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		v := args[i]
+		switch {
+		case v == "--":
+			return positional
+		case v == "-" || !strings.HasPrefix(v, "-"):
+			// "-" conventionally stands for stdin
+			positional = append(positional, v)
+		case !strings.Contains(v, "=") && takesValue(v, valueFlags):
+			i++
+		}
+	}
+	return positional
+}
+
+// takesValue reports whether flag, given without "=", consumes the next
+// argument as its value. A single shorthand may, anything longer carries its
+// value or combines boolean shorthands.
+func takesValue(flag string, valueFlags map[string]bool) bool {
+	if strings.HasPrefix(flag, "--") {
+		return valueFlags[flag[2:]]
+	}
+	return len(flag) == 2 && valueFlags[flag[1:]]
+}
+
+// valueFlags returns the names and shorthands of the flags taking a value,
+// known to either kubectl or the kube-cluster command tree under root.
+func valueFlags(root *cobra.Command) map[string]bool {
+	flags := make(map[string]bool)
+	for _, name := range kubectlValueFlags {
+		flags[name] = true
+	}
+
+	add := func(f *pflag.Flag) {
+		// flags usable without a value, booleans among them, carry a
+		// default for that case
+		if f.NoOptDefVal != "" {
+			return
+		}
+		flags[f.Name] = true
+		if f.Shorthand != "" {
+			flags[f.Shorthand] = true
+		}
+	}
+
+	var walk func(*cobra.Command)
+	walk = func(c *cobra.Command) {
+		c.Flags().VisitAll(add)
+		c.PersistentFlags().VisitAll(add)
+		for _, child := range c.Commands() {
+			walk(child)
+		}
+	}
+	if root != nil {
+		walk(root)
+	}
+	return flags
+}
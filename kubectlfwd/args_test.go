@@ -0,0 +1,97 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubectlfwd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/cli"
+)
+
+func TestPositionalArgs(t *testing.T) {
+	valueFlags := map[string]bool{"namespace": true, "n": true, "output": true, "o": true}
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"get", "po"}, []string{"get", "po"}},
+		{[]string{"--namespace", "kube-system", "get", "env"}, []string{"get", "env"}},
+		{[]string{"--namespace=kube-system", "get", "env"}, []string{"get", "env"}},
+		{[]string{"-n", "kube-system", "get", "env"}, []string{"get", "env"}},
+		{[]string{"-nkube-system", "get", "env"}, []string{"get", "env"}},
+		{[]string{"-n=kube-system", "get", "env"}, []string{"get", "env"}},
+		{[]string{"get", "po", "-o", "wide"}, []string{"get", "po"}},
+		{[]string{"--all-namespaces", "get", "po"}, []string{"get", "po"}},
+		{[]string{"-aw", "get", "po"}, []string{"get", "po"}},
+		{[]string{"create", "-f", "-"}, []string{"create", "-"}},
+		{[]string{"exec", "nginx", "--", "get", "env"}, []string{"exec", "nginx"}},
+		{[]string{"get", "po", "--namespace"}, []string{"get", "po"}},
+		{nil, nil},
+	}
+	for _, test := range tests {
+		if got := positionalArgs(test.args, valueFlags); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("positionalArgs(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestValueFlags(t *testing.T) {
+	root := &cobra.Command{Use: "kube-cluster"}
+	root.PersistentFlags().String("env", "", "")
+	child := &cobra.Command{Use: "scale", Run: func(*cobra.Command, []string) {}}
+	child.Flags().StringP("pool", "p", "", "")
+	child.Flags().Bool("yes", false, "")
+	root.AddCommand(child)
+
+	flags := valueFlags(root)
+	for _, name := range []string{"env", "pool", "p", "namespace", "n"} {
+		if !flags[name] {
+			t.Errorf("%s is not known to take a value", name)
+		}
+	}
+	if flags["yes"] {
+		t.Error("the boolean yes is known to take a value")
+	}
+}
+
+func TestIsClusterCallWithFlags(t *testing.T) {
+	tests := []struct {
+		args    []string
+		cluster bool
+	}{
+		{[]string{"--namespace", "kube-system", "get", "env"}, true},
+		{[]string{"-n", "kube-system", "get", "po"}, false},
+		{[]string{"--env", "dev", "get", "env"}, true},
+		{[]string{"--env", "get", "get", "po"}, false},
+		{[]string{"--env=dev", "describe", "env"}, true},
+		{[]string{"get", "env", "-o", "json"}, true},
+		{[]string{"get", "-o", "env", "po"}, false},
+		{[]string{"create", "env", "--driver", "fake", "dev"}, true},
+		{[]string{"create", "--driver", "fake", "env", "dev"}, true},
+		{[]string{"scale", "--replicas", "3", "rc", "nginx"}, false},
+		{[]string{"scale", "env", "--nodes", "+2"}, true},
+		{[]string{"exec", "nginx", "--", "kube-cluster", "get", "env"}, false},
+		{[]string{"--kubeconfig", "env", "get", "po"}, false},
+	}
+	for _, test := range tests {
+		f := New(append([]string{"kube-cluster"}, test.args...), nil, cli.Root(), nil, nil, nil, nil)
+		if got := f.isClusterCall(); got != test.cluster {
+			t.Errorf("isClusterCall(%q) = %v, want %v", test.args, got, test.cluster)
+		}
+	}
+}
@@ -13,37 +13,81 @@
 // limitations under the License.
 
 // Package kubectlfwd analyses incoming CLI call, and detects whether it should
-// be hijacked and forwarded, as is, to kubectl. "create", "describe", "env" and
-// "get" commands, if accompanied by an environment name, they are not trapped,
-// and kube-cluster main execution course takes place.
+// be hijacked and forwarded, as is, to kubectl. Calls resolving to a runnable
+// command of the kube-cluster command tree, e.g. "create env" or "env", are not
+// trapped, and kube-cluster main execution course takes place, as are calls
+// without command, e.g. "kube-cluster --help". Anything else, including bare
+// verbs such as "get po", belongs to kubectl.
 //
 // Whenever there is ambiguity when parsing the CLI commands and parameters, it
 // will prefer kube-cluster over kubectl.
+//
+// Forwarded calls target the selected environment, as resolved by
+// envstore.Resolver: kubectl is handed its kubeconfig, unless the call
+// explicitly names one with --kubeconfig. The kube-cluster --env flag is never
+// passed on to kubectl. kubectl itself is only looked up when forwarding, and
+// is the version pinned by the environment, if any.
 package kubectlfwd
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"syscall"
+
+	"github.com/gerred/kube-cluster/Godeps/_workspace/src/github.com/spf13/cobra"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/kubectl"
 )
 
-const clusterObjectName = "env"
+const envFlag = "--env"
+
+// relayedSignals are the signals forwarded to kubectl while it runs.
+var relayedSignals = []os.Signal{
+	os.Interrupt,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+}
+
+// ExitError reports that kubectl exited unsuccessfully. kube-cluster should
+// exit with the same code, without printing anything else.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("kubectl exited with status %d", e.Code)
+}
 
-var acceptableClusterCalls = [...]struct {
-	cmd string
-	obj string
-}{
-	{"create", clusterObjectName},
-	{"describe", clusterObjectName},
-	{"env", clusterObjectName},
-	{"get", clusterObjectName},
+// exitCode returns the exit code of a terminated process, following the shell
+// convention of 128+N for processes killed by signal N.
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok {
+		if status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return status.ExitStatus()
+	}
+	if state.Success() {
+		return 0
+	}
+	return 1
 }
 
 // Fwd holds the CLI environment state which is used to make a decision about
 // forwarding the call to kubectl.
 type Fwd struct {
-	args          []string // args expects pristine os.Args
-	kubectlBinary string   // kubectlBinary is the full path of the detected kubectl
+	args    []string         // args expects pristine os.Args
+	kubectl *kubectl.Locator // kubectl finds the binary calls are forwarded to
+
+	// root is the kube-cluster command tree, deciding which calls are kept
+	root *cobra.Command
+
+	// store resolves the environment forwarded calls are sent to
+	store *envstore.Store
 
 	// stdio for kubectl execution
 	stdin  *os.File
@@ -51,12 +95,15 @@ type Fwd struct {
 	stderr *os.File
 }
 
-// New instantiates a call forwarder (*Fwd). Feed it os.Args, and os.Stdout and
-// os.Stderr.
-func New(args []string, kubectl string, stdin, stdout, stderr *os.File) *Fwd {
+// New instantiates a call forwarder (*Fwd). Feed it os.Args, the kubectl
+// locator, the root of the kube-cluster command tree with all commands
+// attached, the environment store, and os.Stdout and os.Stderr.
+func New(args []string, locator *kubectl.Locator, root *cobra.Command, store *envstore.Store, stdin, stdout, stderr *os.File) *Fwd {
 	return &Fwd{
-		args:          args,
-		kubectlBinary: kubectl,
+		args:    args,
+		kubectl: locator,
+		root:    root,
+		store:   store,
 
 		stdin:  stdin,
 		stdout: stdout,
@@ -66,54 +113,151 @@ func New(args []string, kubectl string, stdin, stdout, stderr *os.File) *Fwd {
 
 // Hijack effectively forwards the CLI call to kubectl, if the combination of
 // command and objects are not targeting manipulation of Kubernetes environments.
+//
+// Forwarding is transparent: signals received meanwhile are relayed to kubectl,
+// and if kubectl fails, the returned error is an *ExitError carrying its exit
+// code. kubectl has already reported the failure by then.
 func (f *Fwd) Hijack() (bool, error) {
 	if f.isClusterCall() {
 		return false, nil
 	}
 
-	cmd := exec.Command(f.kubectlBinary, f.args[1:]...)
+	e, args, err := f.target()
+	if err != nil {
+		return true, err
+	}
+
+	var version, checksum string
+	if e != nil {
+		version, checksum = e.KubectlVersion, e.KubectlChecksum
+	}
+	binary, err := f.kubectl.Find(version, checksum)
+	if err != nil {
+		return true, err
+	}
+
+	cmd := exec.Command(binary, args...)
 	cmd.Stdin = f.stdin
 	cmd.Stdout = f.stdout
 	cmd.Stderr = f.stderr
 
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, relayedSignals...)
+	defer signal.Stop(signals)
+
 	if err := cmd.Start(); err != nil {
 		return true, err
 	}
 
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
 	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return true, &ExitError{Code: exitCode(exitErr.ProcessState)}
+		}
 		return true, err
 	}
 
 	return true, nil
 }
 
-// isClusterCall iterates through command and object pairs looking for
-// non-hijackable calls.
-func (f *Fwd) isClusterCall() bool {
-	args := f.noFlagsArgs()
+// target returns the environment the call is sent to, and the arguments
+// kubectl is run with, pointing it at the kubeconfig of that environment. The
+// environment is nil if the call chooses its own kubeconfig.
+func (f *Fwd) target() (*envstore.Environment, []string, error) {
+	env, args := splitEnvFlag(f.args[1:], valueFlags(f.root))
+	if f.store == nil || hasKubeconfigFlag(args) {
+		return nil, args, nil
+	}
 
-	if len(args) < 3 {
-		return false
+	name, _, err := envstore.NewResolver(f.store, env).Resolve()
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := f.store.Get(name)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	cmd := strings.ToLower(args[1])
-	obj := strings.ToLower(args[2])
-	for _, call := range acceptableClusterCalls {
-		if cmd == call.cmd && obj == call.obj {
-			return true
+	kubeconfig := f.store.KubeconfigPath(name)
+	if _, err := os.Stat(kubeconfig); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("environment %q has no kubeconfig", name)
 		}
+		return nil, nil, err
 	}
 
-	return false
+	return e, append([]string{"--kubeconfig=" + kubeconfig}, args...), nil
+}
+
+// splitEnvFlag extracts the value of the kube-cluster --env flag from args,
+// returning the remaining arguments. The flag is global, so it is only looked
+// for before the kubectl command: past it, --env belongs to kubectl, e.g. in
+// "run nginx --image=nginx --env=FOO=bar". The values of the flags listed in
+// valueFlags are skipped.
+func splitEnvFlag(args []string, valueFlags map[string]bool) (string, []string) {
+	var env string
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		v := args[i]
+		switch {
+		case v == "--" || v == "-" || !strings.HasPrefix(v, "-"):
+			return env, append(rest, args[i:]...)
+		case v == envFlag && i+1 < len(args):
+			env = args[i+1]
+			i++
+		case strings.HasPrefix(v, envFlag+"="):
+			env = v[len(envFlag)+1:]
+		default:
+			rest = append(rest, v)
+			if !strings.Contains(v, "=") && i+1 < len(args) && takesValue(v, valueFlags) {
+				i++
+				rest = append(rest, args[i])
+			}
+		}
+	}
+	return env, rest
 }
 
-func (f *Fwd) noFlagsArgs() []string {
-	var args []string
-	for _, v := range f.args {
-		if "-f" != v && '-' == v[0] {
-			continue
+// hasKubeconfigFlag reports whether the call already chooses a kubeconfig.
+func hasKubeconfigFlag(args []string) bool {
+	for _, v := range args {
+		if v == "--" {
+			break
+		}
+		if v == "--kubeconfig" || strings.HasPrefix(v, "--kubeconfig=") {
+			return true
 		}
-		args = append(args, v)
 	}
-	return args
+	return false
+}
+
+// isClusterCall looks the positional arguments of the call up in the
+// kube-cluster command tree. The call belongs to kube-cluster if they resolve to
+// a runnable command: verbs shared with kubectl, such as "get", only group
+// kube-cluster commands and are not runnable themselves. Calls without
+// positional arguments, such as "kube-cluster --help", are the root command
+// of kube-cluster.
+func (f *Fwd) isClusterCall() bool {
+	args := positionalArgs(f.args[1:], valueFlags(f.root))
+	if len(args) == 0 {
+		return true
+	}
+
+	cmd, _, err := f.root.Find(args)
+	if err != nil || cmd == f.root {
+		return false
+	}
+	return cmd.Runnable()
 }
@@ -0,0 +1,335 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubectlfwd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gerred/kube-cluster/cli"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/kubectl"
+)
+
+// testStore returns a store holding the environment dev, which has a
+// kubeconfig and is the current one, and the environment bare, which has
+// none.
+func testStore(t *testing.T) (*envstore.Store, func()) {
+	root, err := ioutil.TempDir("", "kubectlfwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := envstore.New(root)
+	for _, name := range []string{"dev", "bare"} {
+		if err := store.Create(&envstore.Environment{Name: name, Driver: "fake"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SaveKubeconfig("dev", []byte("apiVersion: v1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetCurrent("dev"); err != nil {
+		t.Fatal(err)
+	}
+
+	variable := os.Getenv(envstore.EnvironmentVariable)
+	os.Unsetenv(envstore.EnvironmentVariable)
+	return store, func() {
+		os.Setenv(envstore.EnvironmentVariable, variable)
+		os.RemoveAll(root)
+	}
+}
+
+// stubKubectl puts a kubectl in PATH which prints its arguments, one per
+// line, and exits with the status in $KUBECTL_STATUS.
+func stubKubectl(t *testing.T, script string) func() {
+	dir, err := ioutil.TempDir("", "kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if script == "" {
+		script = "for arg; do echo \"$arg\"; done\nexit ${KUBECTL_STATUS:-0}\n"
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, kubectl.BinaryName), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+// hijack runs args through a forwarder, returning whether they were forwarded
+// and what kubectl printed.
+func hijack(t *testing.T, store *envstore.Store, args ...string) (bool, string, error) {
+	out, err := ioutil.TempFile("", "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	f := New(append([]string{"kube-cluster"}, args...), kubectl.NewLocator(store.CacheDir()), cli.Root(), store, nil, out, out)
+	fwd, err := f.Hijack()
+	data, readErr := ioutil.ReadFile(out.Name())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return fwd, string(data), err
+}
+
+func TestTarget(t *testing.T) {
+	store, cleanup := testStore(t)
+	defer cleanup()
+	kubeconfig := "--kubeconfig=" + store.KubeconfigPath("dev")
+
+	tests := []struct {
+		args []string
+		env  string
+		want []string
+		err  string
+	}{
+		{
+			args: []string{"get", "po"},
+			env:  "dev",
+			want: []string{kubeconfig, "get", "po"},
+		},
+		{
+			args: []string{"--env", "dev", "get", "po"},
+			env:  "dev",
+			want: []string{kubeconfig, "get", "po"},
+		},
+		{
+			args: []string{"--kubeconfig", "/tmp/config", "get", "po"},
+			want: []string{"--kubeconfig", "/tmp/config", "get", "po"},
+		},
+		{
+			args: []string{"--env=bare", "get", "po"},
+			err:  `environment "bare" has no kubeconfig`,
+		},
+		{
+			args: []string{"--env=missing", "get", "po"},
+			err:  `environment "missing" not found`,
+		},
+	}
+	for _, test := range tests {
+		f := New(append([]string{"kube-cluster"}, test.args...), nil, cli.Root(), store, nil, nil, nil)
+		e, args, err := f.target()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%v: error %v, want %q", test.args, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if (e == nil && test.env != "") || (e != nil && e.Name != test.env) {
+			t.Errorf("%v: environment %+v, want %q", test.args, e, test.env)
+		}
+		if !reflect.DeepEqual(args, test.want) {
+			t.Errorf("%v: kubectl arguments %q, want %q", test.args, args, test.want)
+		}
+	}
+}
+
+func TestHijackForwardsToEnvironment(t *testing.T) {
+	store, cleanup := testStore(t)
+	defer cleanup()
+	defer stubKubectl(t, "")()
+
+	fwd, out, err := hijack(t, store, "get", "po", "--namespace", "kube-system")
+	if err != nil || !fwd {
+		t.Fatalf("Hijack() = %v, %v", fwd, err)
+	}
+	want := []string{"--kubeconfig=" + store.KubeconfigPath("dev"), "get", "po", "--namespace", "kube-system"}
+	if got := strings.Split(strings.TrimSpace(out), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("kubectl ran with %q, want %q", got, want)
+	}
+}
+
+func TestHijackKeepsClusterCalls(t *testing.T) {
+	store, cleanup := testStore(t)
+	defer cleanup()
+	defer stubKubectl(t, "echo kubectl ran\n")()
+
+	fwd, out, err := hijack(t, store, "get", "env")
+	if fwd || err != nil || out != "" {
+		t.Errorf("Hijack() = %v, %v, with kubectl output %q, want a kube-cluster call", fwd, err, out)
+	}
+}
+
+func TestSplitEnvFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		env  string
+		rest []string
+	}{
+		{[]string{"--env", "dev", "get", "po"}, "dev", []string{"get", "po"}},
+		{[]string{"--env=dev", "get", "po"}, "dev", []string{"get", "po"}},
+		{[]string{"-n", "kube-system", "--env", "dev", "get", "po"}, "dev", []string{"-n", "kube-system", "get", "po"}},
+		{[]string{"--namespace", "--env", "get", "po"}, "", []string{"--namespace", "--env", "get", "po"}},
+		{[]string{"get", "po", "--env", "dev"}, "", []string{"get", "po", "--env", "dev"}},
+		{[]string{"run", "nginx", "--image=nginx", "--env=FOO=bar"}, "", []string{"run", "nginx", "--image=nginx", "--env=FOO=bar"}},
+		{[]string{"--env=dev", "run", "nginx", "--env", "FOO=bar"}, "dev", []string{"run", "nginx", "--env", "FOO=bar"}},
+		{[]string{"--", "--env=dev"}, "", []string{"--", "--env=dev"}},
+		{nil, "", []string{}},
+	}
+	for _, test := range tests {
+		env, rest := splitEnvFlag(test.args, valueFlags(cli.Root()))
+		if env != test.env || !reflect.DeepEqual(rest, test.rest) {
+			t.Errorf("splitEnvFlag(%q) = %q, %q, want %q, %q", test.args, env, rest, test.env, test.rest)
+		}
+	}
+}
+
+func TestHijackKeepsKubectlEnvFlag(t *testing.T) {
+	store, cleanup := testStore(t)
+	defer cleanup()
+	defer stubKubectl(t, "")()
+
+	fwd, out, err := hijack(t, store, "run", "nginx", "--image=nginx", "--env=FOO=bar")
+	if err != nil || !fwd {
+		t.Fatalf("Hijack() = %v, %v", fwd, err)
+	}
+	want := []string{"--kubeconfig=" + store.KubeconfigPath("dev"), "run", "nginx", "--image=nginx", "--env=FOO=bar"}
+	if got := strings.Split(strings.TrimSpace(out), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("kubectl ran with %q, want %q", got, want)
+	}
+}
+
+func TestIsClusterCall(t *testing.T) {
+	tests := []struct {
+		args    []string
+		cluster bool
+	}{
+		// the root command
+		{nil, true},
+		{[]string{"--help"}, true},
+		{[]string{"-h"}, true},
+		{[]string{"--env", "dev"}, true},
+
+		// kube-cluster commands
+		{[]string{"create", "env", "dev"}, true},
+		{[]string{"get", "env"}, true},
+		{[]string{"get", "env", "dev", "stage"}, true},
+		{[]string{"describe", "env", "dev"}, true},
+		{[]string{"delete", "env", "dev"}, true},
+		{[]string{"env", "dev"}, true},
+		{[]string{"scale", "env", "dev"}, true},
+		{[]string{"kubeconfig"}, true},
+		{[]string{"certs", "rotate"}, true},
+
+		// kubectl calls, verbs shared with kubectl among them
+		{[]string{"get", "po"}, false},
+		{[]string{"get"}, false},
+		{[]string{"create", "-f", "rc.yaml"}, false},
+		{[]string{"delete", "po", "nginx"}, false},
+		{[]string{"describe", "no"}, false},
+		{[]string{"scale", "rc", "nginx", "--replicas=3"}, false},
+		{[]string{"logs", "nginx"}, false},
+		{[]string{"version"}, false},
+	}
+	for _, test := range tests {
+		f := New(append([]string{"kube-cluster"}, test.args...), nil, cli.Root(), nil, nil, nil, nil)
+		if got := f.isClusterCall(); got != test.cluster {
+			t.Errorf("isClusterCall(%q) = %v, want %v", test.args, got, test.cluster)
+		}
+	}
+}
+
+func TestHijackExitCode(t *testing.T) {
+	store, cleanup := testStore(t)
+	defer cleanup()
+
+	tests := []struct {
+		script string
+		code   int
+	}{
+		{"exit 0\n", 0},
+		{"exit 3\n", 3},
+		{"kill -KILL $$\n", 128 + int(syscall.SIGKILL)},
+	}
+	for _, test := range tests {
+		restore := stubKubectl(t, test.script)
+		fwd, _, err := hijack(t, store, "get", "po")
+		restore()
+
+		if !fwd {
+			t.Errorf("%q: not forwarded", test.script)
+			continue
+		}
+		code := 0
+		if exitErr, ok := err.(*ExitError); ok {
+			code = exitErr.Code
+		} else if err != nil {
+			t.Errorf("%q: %v, want an ExitError", test.script, err)
+			continue
+		}
+		if code != test.code {
+			t.Errorf("%q: exit code %d, want %d", test.script, code, test.code)
+		}
+	}
+}
+
+func TestHijackRelaysSignals(t *testing.T) {
+	store, cleanup := testStore(t)
+	defer cleanup()
+	defer stubKubectl(t, "trap 'exit 42' TERM\necho ready\nwhile :; do sleep 0.05; done\n")()
+
+	out, err := ioutil.TempFile("", "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	f := New([]string{"kube-cluster", "get", "po", "-w"}, kubectl.NewLocator(store.CacheDir()), cli.Root(), store, nil, out, out)
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.Hijack()
+		done <- err
+	}()
+
+	for i := 0; ; i++ {
+		data, _ := ioutil.ReadFile(out.Name())
+		if strings.Contains(string(data), "ready") {
+			break
+		}
+		if i == 100 {
+			t.Fatal("kubectl did not start")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case err := <-done:
+		if exitErr, ok := err.(*ExitError); !ok || exitErr.Code != 42 {
+			t.Errorf("Hijack() = %v, want kubectl to exit with 42 on SIGTERM", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("kubectl did not receive SIGTERM")
+	}
+}
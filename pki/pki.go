@@ -0,0 +1,386 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pki generates the certificates kubernetes environments need: a
+// cluster certificate authority, the API server serving certificate, and the
+// admin client certificate. Certificates and keys are kept as PEM files in a
+// directory of the environment. Everything is done with the standard library,
+// so it works offline.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+// Key algorithms.
+const (
+	RSA   = "rsa"
+	ECDSA = "ecdsa"
+)
+
+// Certificate names.
+const (
+	CA        = "ca"
+	APIServer = "apiserver"
+	Admin     = "admin"
+	// Node is the client certificate of the kubelets and proxies, which
+	// need no more than the rights of the system:nodes group.
+	Node = "node"
+)
+
+// issued are the certificates signed by the certificate authority.
+var issued = []string{APIServer, Admin, Node}
+
+const (
+	rsaBits           = 2048
+	defaultCAValidity = 10 * 365 * 24 * time.Hour
+	defaultValidity   = 365 * 24 * time.Hour
+)
+
+// ValidateAlgorithm checks that a is a supported key algorithm. The empty
+// string stands for RSA.
+func ValidateAlgorithm(a string) error {
+	switch a {
+	case "", RSA, ECDSA:
+		return nil
+	}
+	return fmt.Errorf("unsupported key algorithm %q, use %s or %s", a, RSA, ECDSA)
+}
+
+// Options tells how keys and certificates are generated.
+type Options struct {
+	// Algorithm is RSA (2048 bits) or ECDSA (P-256), RSA if empty.
+	Algorithm string
+	// CAValidity and Validity are the lifetimes of the certificate
+	// authority and of the certificates it issues, 10 and 1 years if zero.
+	CAValidity time.Duration
+	Validity   time.Duration
+}
+
+// SANs are the subject alternative names of the API server certificate.
+type SANs struct {
+	DNSNames []string
+	IPs      []net.IP
+}
+
+// Certificate is a certificate of the PKI.
+type Certificate struct {
+	Name string
+	*x509.Certificate
+}
+
+// PKI manages the certificates of an environment.
+type PKI struct {
+	dir  string
+	opts Options
+}
+
+// New returns the PKI kept in dir.
+func New(dir string, opts Options) *PKI {
+	if opts.CAValidity == 0 {
+		opts.CAValidity = defaultCAValidity
+	}
+	if opts.Validity == 0 {
+		opts.Validity = defaultValidity
+	}
+	return &PKI{dir: dir, opts: opts}
+}
+
+// Dir returns the directory holding the certificates and keys.
+func (p *PKI) Dir() string {
+	return p.dir
+}
+
+// CertPath returns the path of the named PEM encoded certificate.
+func (p *PKI) CertPath(name string) string {
+	return filepath.Join(p.dir, name+".crt")
+}
+
+// KeyPath returns the path of the named PEM encoded private key.
+func (p *PKI) KeyPath(name string) string {
+	return filepath.Join(p.dir, name+".key")
+}
+
+// Exists reports whether the certificate authority was generated.
+func (p *PKI) Exists() bool {
+	_, err := os.Stat(p.CertPath(CA))
+	return err == nil
+}
+
+// Ensure generates the certificate authority of cluster, the API server
+// certificate for sans, and the admin and node certificates, unless they
+// exist.
+func (p *PKI) Ensure(cluster string, sans SANs) error {
+	if err := p.EnsureCA(cluster); err != nil {
+		return err
+	}
+	for _, name := range issued {
+		if _, err := os.Stat(p.CertPath(name)); err == nil {
+			continue
+		}
+		if err := p.Issue(name, sans); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureCA generates the certificate authority of cluster, unless it exists.
+// Drivers needing it before the machines exist, e.g. to hand it to them at
+// boot, call it ahead of Ensure.
+func (p *PKI) EnsureCA(cluster string) error {
+	if p.Exists() {
+		return nil
+	}
+	return p.issueCA(cluster)
+}
+
+// Rotate issues the API server, admin and node certificates again, with new
+// keys. If ca is set, a new certificate authority of cluster is generated
+// first.
+func (p *PKI) Rotate(cluster string, sans SANs, ca bool) error {
+	if ca || !p.Exists() {
+		if err := p.issueCA(cluster); err != nil {
+			return err
+		}
+	}
+	for _, name := range issued {
+		if err := p.Issue(name, sans); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Issue (re)issues the named certificate, APIServer, Admin or Node, signed by
+// the certificate authority. sans only applies to the API server certificate.
+func (p *PKI) Issue(name string, sans SANs) error {
+	caCert, caKey, err := p.Load(CA)
+	if err != nil {
+		return err
+	}
+
+	template, err := p.template(p.opts.Validity)
+	if err != nil {
+		return err
+	}
+	switch name {
+	case APIServer:
+		template.Subject = pkix.Name{CommonName: "kube-apiserver"}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.DNSNames = uniqueStrings(sans.DNSNames)
+		template.IPAddresses = uniqueIPs(sans.IPs)
+	case Admin:
+		template.Subject = pkix.Name{CommonName: "admin", Organization: []string{"system:masters"}}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	case Node:
+		template.Subject = pkix.Name{CommonName: "node", Organization: []string{"system:nodes"}}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	default:
+		return fmt.Errorf("unknown certificate %q", name)
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+
+	key, err := p.generateKey()
+	if err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return err
+	}
+	return p.write(name, der, key)
+}
+
+func (p *PKI) issueCA(cluster string) error {
+	template, err := p.template(p.opts.CAValidity)
+	if err != nil {
+		return err
+	}
+	template.Subject = pkix.Name{CommonName: cluster + "-ca"}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	key, err := p.generateKey()
+	if err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	return p.write(CA, der, key)
+}
+
+// template returns a certificate template valid from now on.
+func (p *PKI) template(validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    now.Add(-5 * time.Minute), // tolerate clock skew
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func (p *PKI) generateKey() (crypto.Signer, error) {
+	if p.opts.Algorithm == ECDSA {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	return rsa.GenerateKey(rand.Reader, rsaBits)
+}
+
+// write stores a certificate and its key, the key being readable by the owner
+// only. Both are written to temporary files first, and only renamed in place
+// once both writes succeeded, so a failed rotation never leaves a truncated
+// file or a key not matching its certificate behind.
+func (p *PKI) write(name string, der []byte, key crypto.Signer) error {
+	var block *pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
+	}
+
+	if err := os.MkdirAll(p.dir, 0700); err != nil {
+		return err
+	}
+	keyTmp, err := envstore.WriteTempFile(p.KeyPath(name), pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyTmp)
+	certTmp, err := envstore.WriteTempFile(p.CertPath(name), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(certTmp)
+
+	if err := os.Rename(keyTmp, p.KeyPath(name)); err != nil {
+		return err
+	}
+	return os.Rename(certTmp, p.CertPath(name))
+}
+
+// Load reads the named certificate and its private key.
+func (p *PKI) Load(name string) (*x509.Certificate, crypto.Signer, error) {
+	cert, err := p.loadCert(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := ioutil.ReadFile(p.KeyPath(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%s: no PEM data", p.KeyPath(name))
+	}
+	var key crypto.Signer
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported key type %q", block.Type)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", p.KeyPath(name), err)
+	}
+	return cert, key, nil
+}
+
+func (p *PKI) loadCert(name string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(p.CertPath(name))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate", p.CertPath(name))
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p.CertPath(name), err)
+	}
+	return cert, nil
+}
+
+// List returns the certificates of the PKI, the certificate authority first.
+func (p *PKI) List() ([]Certificate, error) {
+	var certs []Certificate
+	for _, name := range append([]string{CA}, issued...) {
+		cert, err := p.loadCert(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		certs = append(certs, Certificate{Name: name, Certificate: cert})
+	}
+	return certs, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+func uniqueIPs(ips []net.IP) []net.IP {
+	seen := make(map[string]bool)
+	var unique []net.IP
+	for _, ip := range ips {
+		if ip != nil && !seen[ip.String()] {
+			seen[ip.String()] = true
+			unique = append(unique, ip)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,201 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempPKI(t *testing.T, opts Options) (*PKI, func()) {
+	dir, err := ioutil.TempDir("", "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(filepath.Join(dir, "pki"), opts), func() { os.RemoveAll(dir) }
+}
+
+var sans = SANs{
+	DNSNames: []string{"master", "kubernetes", "master", ""},
+	IPs:      []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.1"), nil},
+}
+
+func TestEnsure(t *testing.T) {
+	for _, algorithm := range []string{"", RSA, ECDSA} {
+		p, cleanup := tempPKI(t, Options{Algorithm: algorithm, Validity: time.Hour})
+		defer cleanup()
+
+		if p.Exists() {
+			t.Errorf("%s: PKI exists before Ensure", algorithm)
+		}
+		if err := p.Ensure("dev", sans); err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		certs, err := p.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(certs) != 4 || certs[0].Name != CA || certs[1].Name != APIServer || certs[2].Name != Admin || certs[3].Name != Node {
+			t.Fatalf("%s: got %v", algorithm, certs)
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(certs[0].Certificate)
+		if certs[0].Subject.CommonName != "dev-ca" || !certs[0].IsCA {
+			t.Errorf("%s: got CA %v", algorithm, certs[0].Subject)
+		}
+		if _, err := certs[1].Verify(x509.VerifyOptions{DNSName: "kubernetes", Roots: roots}); err != nil {
+			t.Errorf("%s: API server certificate: %v", algorithm, err)
+		}
+		if got := certs[1].DNSNames; len(got) != 2 || got[0] != "kubernetes" || got[1] != "master" {
+			t.Errorf("%s: got DNS names %v", algorithm, got)
+		}
+		if got := certs[1].IPAddresses; len(got) != 1 || !got[0].Equal(net.ParseIP("10.0.0.1")) {
+			t.Errorf("%s: got IPs %v", algorithm, got)
+		}
+		if _, err := certs[2].Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+			t.Errorf("%s: admin certificate: %v", algorithm, err)
+		}
+		if o := certs[2].Subject.Organization; len(o) != 1 || o[0] != "system:masters" {
+			t.Errorf("%s: got admin organizations %v", algorithm, o)
+		}
+		if _, err := certs[3].Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+			t.Errorf("%s: node certificate: %v", algorithm, err)
+		}
+		if o := certs[3].Subject.Organization; certs[3].Subject.CommonName != "node" || len(o) != 1 || o[0] != "system:nodes" {
+			t.Errorf("%s: got node subject %v", algorithm, certs[3].Subject)
+		}
+		if left := certs[1].NotAfter.Sub(time.Now()); left > time.Hour || left < 50*time.Minute {
+			t.Errorf("%s: API server certificate valid for %v", algorithm, left)
+		}
+
+		_, key, err := p.Load(Admin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch key.(type) {
+		case *ecdsa.PrivateKey:
+			if algorithm != ECDSA {
+				t.Errorf("%s: got an ECDSA key", algorithm)
+			}
+		case *rsa.PrivateKey:
+			if algorithm == ECDSA {
+				t.Errorf("%s: got an RSA key", algorithm)
+			}
+		}
+		for _, name := range []string{CA, APIServer, Admin, Node} {
+			if fi, err := os.Stat(p.KeyPath(name)); err != nil || fi.Mode().Perm() != 0600 {
+				t.Errorf("%s: key %s: %v, %v", algorithm, name, fi, err)
+			}
+		}
+	}
+}
+
+func TestEnsureKeepsCertificates(t *testing.T) {
+	p, cleanup := tempPKI(t, Options{Algorithm: ECDSA})
+	defer cleanup()
+	if err := p.Ensure("dev", sans); err != nil {
+		t.Fatal(err)
+	}
+	before, err := p.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Ensure("dev", SANs{}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := p.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range before {
+		if before[i].SerialNumber.Cmp(after[i].SerialNumber) != 0 {
+			t.Errorf("%s was reissued", before[i].Name)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	for _, ca := range []bool{false, true} {
+		p, cleanup := tempPKI(t, Options{Algorithm: ECDSA})
+		defer cleanup()
+		if err := p.Ensure("dev", sans); err != nil {
+			t.Fatal(err)
+		}
+		before, err := p.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Rotate("dev", sans, ca); err != nil {
+			t.Fatal(err)
+		}
+		after, err := p.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := before[0].SerialNumber.Cmp(after[0].SerialNumber) != 0; got != ca {
+			t.Errorf("ca %v: CA reissued: %v", ca, got)
+		}
+		for i := 1; i < len(after); i++ {
+			if before[i].SerialNumber.Cmp(after[i].SerialNumber) == 0 {
+				t.Errorf("ca %v: %s was not reissued", ca, after[i].Name)
+			}
+			if err := after[i].CheckSignatureFrom(after[0].Certificate); err != nil {
+				t.Errorf("ca %v: %s: %v", ca, after[i].Name, err)
+			}
+		}
+
+		// no temporary file is left behind
+		files, err := ioutil.ReadDir(p.Dir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 8 {
+			t.Errorf("ca %v: got %d files in the PKI directory", ca, len(files))
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	p, cleanup := tempPKI(t, Options{})
+	defer cleanup()
+
+	if err := p.Issue(Admin, SANs{}); !os.IsNotExist(err) {
+		t.Errorf("got %v issuing without a CA", err)
+	}
+	if err := p.EnsureCA("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Issue("root", SANs{}); err == nil || !strings.Contains(err.Error(), `unknown certificate "root"`) {
+		t.Errorf("got %v", err)
+	}
+	if err := ioutil.WriteFile(p.KeyPath(CA), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.Load(CA); err == nil || !strings.Contains(err.Error(), "no PEM data") {
+		t.Errorf("got %v", err)
+	}
+	if err := ValidateAlgorithm("dsa"); err == nil {
+		t.Error("dsa accepted")
+	}
+}
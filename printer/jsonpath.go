@@ -0,0 +1,374 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package printer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// JSONPath evaluates kubectl style JSONPath templates. Text outside braces is
+// copied as is, and the following are recognised inside braces:
+//
+//	{.field.sub}       fields, from the current object
+//	{$.field}          fields, from the root object
+//	{.list[0]}         list items, negative indexes count from the end
+//	{.list[1:3]}       list slices
+//	{.list[*].field}   all items, .* also selects all fields of an object
+//	{"\n"}             string literals
+//	{range .list[*]}{.field}{end}
+//	                   iteration, the current object being each item
+//
+// Multiple results of a single expression are separated by spaces.
+type JSONPath struct {
+	nodes []node
+}
+
+type node struct {
+	text    string // literal text
+	path    []step // expression path, nil for literal text
+	root    bool   // path starts from the root object
+	isRange bool
+	body    []node // range body
+}
+
+type step struct {
+	field string // field name, "*" selects all fields
+	index bool   // list access, see from, to and all
+	all   bool
+	from  int
+	to    int
+	slice bool
+}
+
+// NewJSONPath parses a JSONPath template.
+func NewJSONPath(template string) (*JSONPath, error) {
+	nodes, rest, err := parseNodes(template, false)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("jsonpath: unexpected {end}")
+	}
+	return &JSONPath{nodes: nodes}, nil
+}
+
+// Print writes the template evaluated against obj, which is first encoded to
+// JSON.
+func (p *JSONPath) Print(w io.Writer, obj interface{}) error {
+	v, err := toGeneric(obj)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := execute(&buf, p.nodes, v, v); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// parseNodes parses template up to its end, or up to an {end} if inRange,
+// returning what follows the {end}.
+func parseNodes(template string, inRange bool) ([]node, string, error) {
+	var nodes []node
+	for template != "" {
+		open := strings.Index(template, "{")
+		if open < 0 {
+			nodes = append(nodes, node{text: template})
+			break
+		}
+		if open > 0 {
+			nodes = append(nodes, node{text: template[:open]})
+		}
+
+		close := closingBrace(template[open:])
+		if close < 0 {
+			return nil, "", fmt.Errorf("jsonpath: unclosed action in %q", template[open:])
+		}
+		action := strings.TrimSpace(template[open+1 : open+close])
+		template = template[open+close+1:]
+
+		switch {
+		case action == "end":
+			if !inRange {
+				return nil, "", fmt.Errorf("jsonpath: unexpected {end}")
+			}
+			return nodes, template, nil
+		case strings.HasPrefix(action, "range "):
+			n, err := parseExpr(strings.TrimSpace(action[len("range "):]))
+			if err != nil {
+				return nil, "", err
+			}
+			body, rest, err := parseNodes(template, true)
+			if err != nil {
+				return nil, "", err
+			}
+			n.isRange = true
+			n.body = body
+			nodes = append(nodes, n)
+			template = rest
+		case strings.HasPrefix(action, `"`):
+			text, err := strconv.Unquote(action)
+			if err != nil {
+				return nil, "", fmt.Errorf("jsonpath: invalid string literal %s", action)
+			}
+			nodes = append(nodes, node{text: text})
+		default:
+			n, err := parseExpr(action)
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, n)
+		}
+	}
+
+	if inRange {
+		return nil, "", fmt.Errorf("jsonpath: {range} without {end}")
+	}
+	return nodes, "", nil
+}
+
+// closingBrace returns the index of the brace closing the action s starts
+// with, skipping braces within string literals.
+func closingBrace(s string) int {
+	inString := false
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case '}':
+			if !inString {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseExpr(expr string) (node, error) {
+	n := node{path: []step{}}
+	switch {
+	case strings.HasPrefix(expr, "$"):
+		n.root = true
+		expr = expr[1:]
+	case strings.HasPrefix(expr, "@"):
+		expr = expr[1:]
+	case !strings.HasPrefix(expr, ".") && !strings.HasPrefix(expr, "["):
+		return n, fmt.Errorf("jsonpath: unrecognized expression %q", expr)
+	}
+
+	for expr != "" {
+		switch expr[0] {
+		case '.':
+			expr = expr[1:]
+			end := strings.IndexAny(expr, ".[")
+			if end < 0 {
+				end = len(expr)
+			}
+			if field := expr[:end]; field != "" {
+				n.path = append(n.path, step{field: field})
+			}
+			expr = expr[end:]
+		case '[':
+			end := strings.Index(expr, "]")
+			if end < 0 {
+				return n, fmt.Errorf("jsonpath: unclosed [ in %q", expr)
+			}
+			s, err := parseIndex(expr[1:end])
+			if err != nil {
+				return n, err
+			}
+			n.path = append(n.path, s)
+			expr = expr[end+1:]
+		default:
+			return n, fmt.Errorf("jsonpath: unexpected %q", expr)
+		}
+	}
+	return n, nil
+}
+
+func parseIndex(index string) (step, error) {
+	index = strings.TrimSpace(index)
+	if index == "*" {
+		return step{index: true, all: true}, nil
+	}
+	if len(index) > 1 && (index[0] == '\'' || index[0] == '"') && index[len(index)-1] == index[0] {
+		return step{field: index[1 : len(index)-1]}, nil
+	}
+
+	s := step{index: true}
+	if parts := strings.SplitN(index, ":", 2); len(parts) == 2 {
+		s.slice = true
+		var err error
+		if parts[0] != "" {
+			if s.from, err = strconv.Atoi(parts[0]); err != nil {
+				return s, fmt.Errorf("jsonpath: invalid slice [%s]", index)
+			}
+		}
+		s.to = -1 << 31
+		if parts[1] != "" {
+			if s.to, err = strconv.Atoi(parts[1]); err != nil {
+				return s, fmt.Errorf("jsonpath: invalid slice [%s]", index)
+			}
+		}
+		return s, nil
+	}
+
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return s, fmt.Errorf("jsonpath: invalid index [%s]", index)
+	}
+	s.from = i
+	return s, nil
+}
+
+func execute(w *bytes.Buffer, nodes []node, root, current interface{}) error {
+	for _, n := range nodes {
+		if n.path == nil {
+			w.WriteString(n.text)
+			continue
+		}
+
+		start := current
+		if n.root {
+			start = root
+		}
+		results, err := walk([]interface{}{start}, n.path)
+		if err != nil {
+			return err
+		}
+
+		if n.isRange {
+			for _, r := range results {
+				if err := execute(w, n.body, root, r); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		for i, r := range results {
+			if i > 0 {
+				w.WriteString(" ")
+			}
+			if err := writeValue(w, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func walk(values []interface{}, path []step) ([]interface{}, error) {
+	for _, s := range path {
+		var next []interface{}
+		for _, v := range values {
+			switch {
+			case !s.index && s.field == "*":
+				if m, ok := v.(map[string]interface{}); ok {
+					for _, k := range sortedKeys(m) {
+						next = append(next, m[k])
+					}
+				}
+			case !s.index:
+				m, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("jsonpath: %s is not found", s.field)
+				}
+				field, ok := m[s.field]
+				if !ok {
+					return nil, fmt.Errorf("jsonpath: %s is not found", s.field)
+				}
+				next = append(next, field)
+			default:
+				l, ok := v.([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("jsonpath: cannot index a non list value")
+				}
+				selected, err := s.selectItems(l)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, selected...)
+			}
+		}
+		values = next
+	}
+	return values, nil
+}
+
+func (s step) selectItems(l []interface{}) ([]interface{}, error) {
+	if s.all {
+		return l, nil
+	}
+
+	bound := func(i int) int {
+		if i < 0 {
+			i += len(l)
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > len(l) {
+			return len(l)
+		}
+		return i
+	}
+
+	if s.slice {
+		from, to := bound(s.from), len(l)
+		if s.to != -1<<31 {
+			to = bound(s.to)
+		}
+		if from >= to {
+			return nil, nil
+		}
+		return l[from:to], nil
+	}
+
+	i := s.from
+	if i < 0 {
+		i += len(l)
+	}
+	if i < 0 || i >= len(l) {
+		return nil, fmt.Errorf("jsonpath: index %d out of range", s.from)
+	}
+	return []interface{}{l[i]}, nil
+}
+
+func writeValue(w *bytes.Buffer, v interface{}) error {
+	if s, ok := v.(string); ok {
+		w.WriteString(s)
+		return nil
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode terminates the value with a newline
+	w.Truncate(w.Len() - 1)
+	return nil
+}
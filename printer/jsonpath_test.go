@@ -0,0 +1,91 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package printer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	obj := map[string]interface{}{
+		"kind": "EnvironmentList",
+		"items": []map[string]interface{}{
+			{"name": "dev", "nodes": 1, "driverOptions": map[string]string{"b": "2", "a": "1"}},
+			{"name": "stage", "nodes": 6},
+			{"name": "live", "nodes": 10},
+		},
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{.kind}", "EnvironmentList"},
+		{"kind: {.kind}!", "kind: EnvironmentList!"},
+		{"{.items[0].name}", "dev"},
+		{"{.items[-1].name}", "live"},
+		{"{.items[1:].name}", "stage live"},
+		{"{.items[:2].nodes}", "1 6"},
+		{"{.items[*].name}", "dev stage live"},
+		{"{.items[0].driverOptions.*}", "1 2"},
+		{"{.items[0]['name']}", "dev"},
+		{`{range .items[*]}{.name}{"\t"}{$.kind}{"\n"}{end}`, "dev\tEnvironmentList\nstage\tEnvironmentList\nlive\tEnvironmentList\n"},
+		{"{.items[0].driverOptions}", `{"a":"1","b":"2"}`},
+		{`{"}"}`, "}"},
+	}
+	for _, test := range tests {
+		p, err := NewJSONPath(test.template)
+		if err != nil {
+			t.Errorf("%s: %v", test.template, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := p.Print(&buf, obj); err != nil {
+			t.Errorf("%s: %v", test.template, err)
+			continue
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s printed %q, want %q", test.template, buf.String(), test.want)
+		}
+	}
+}
+
+func TestJSONPathErrors(t *testing.T) {
+	for _, template := range []string{
+		"{.kind",
+		"{end}",
+		"{range .items[*]}{.name}",
+		"{kind}",
+		"{.items[x]}",
+		"{.items[0}",
+	} {
+		if _, err := NewJSONPath(template); err == nil {
+			t.Errorf("%s: parsed", template)
+		}
+	}
+
+	obj := map[string]interface{}{"items": []interface{}{"a"}}
+	for _, template := range []string{"{.missing}", "{.items[3]}", "{.items.name}", "{.items[0][0]}"} {
+		p, err := NewJSONPath(template)
+		if err != nil {
+			t.Errorf("%s: %v", template, err)
+			continue
+		}
+		if err := p.Print(&bytes.Buffer{}, obj); err == nil {
+			t.Errorf("%s: evaluated", template)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package printer writes objects in the machine readable formats of kubectl's
+// --output flag: json, yaml and jsonpath=TEMPLATE. Human readable formats are
+// up to each command.
+package printer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gerred/kube-cluster/yaml"
+)
+
+// Printer writes objects to w.
+type Printer interface {
+	Print(w io.Writer, obj interface{}) error
+}
+
+// New returns the printer for an --output value. ok is false if format is not
+// a machine readable format, and should be handled by the caller.
+func New(format string) (p Printer, ok bool, err error) {
+	switch {
+	case format == "json":
+		return jsonPrinter{}, true, nil
+	case format == "yaml":
+		return yamlPrinter{}, true, nil
+	case strings.HasPrefix(format, "jsonpath="):
+		p, err := NewJSONPath(format[len("jsonpath="):])
+		return p, true, err
+	case format == "jsonpath":
+		return nil, true, fmt.Errorf("jsonpath output requires a template, e.g. -o jsonpath={.name}")
+	}
+	return nil, false, nil
+}
+
+type jsonPrinter struct{}
+
+func (jsonPrinter) Print(w io.Writer, obj interface{}) error {
+	// values such as <hidden> are printed as is, not HTML escaped
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	return enc.Encode(obj)
+}
+
+type yamlPrinter struct{}
+
+func (yamlPrinter) Print(w io.Writer, obj interface{}) error {
+	return yaml.Fprint(w, obj)
+}
+
+// toGeneric converts obj to the generic values encoding/json decodes to.
+func toGeneric(obj interface{}) (interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	err = dec.Decode(&v)
+	return v, err
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
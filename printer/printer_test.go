@@ -0,0 +1,95 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package printer
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+type object struct {
+	Name    string            `json:"name"`
+	Nodes   int               `json:"nodes"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		format string
+		ok     bool
+		err    bool
+	}{
+		{"json", true, false},
+		{"yaml", true, false},
+		{"jsonpath={.name}", true, false},
+		{"jsonpath", true, true},
+		{"jsonpath={.name", true, true},
+		{"", false, false},
+		{"wide", false, false},
+		{"name", false, false},
+	}
+	for _, test := range tests {
+		p, ok, err := New(test.format)
+		if ok != test.ok || (err != nil) != test.err {
+			t.Errorf("New(%q) = %v, %v, want ok %v and error %v", test.format, ok, err, test.ok, test.err)
+		}
+		if ok && err == nil && p == nil {
+			t.Errorf("New(%q) returned no printer", test.format)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	obj := object{Name: "dev", Nodes: 2, Options: map[string]string{"fake-node-delay": "1s"}}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"json", "{\n    \"name\": \"dev\",\n    \"nodes\": 2,\n    \"options\": {\n        \"fake-node-delay\": \"1s\"\n    }\n}\n"},
+		{"yaml", "name: dev\nnodes: 2\noptions:\n  fake-node-delay: 1s\n"},
+		{"jsonpath={.options.fake-node-delay}", "1s"},
+	}
+	for _, test := range tests {
+		p, _, err := New(test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := p.Print(&buf, obj); err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s printed:\n%s\nwant:\n%s", test.format, buf.String(), test.want)
+		}
+	}
+}
+
+func TestPrintJSONRoundTrip(t *testing.T) {
+	obj := object{Name: "dev", Nodes: 2}
+	var buf bytes.Buffer
+	if err := (jsonPrinter{}).Print(&buf, obj); err != nil {
+		t.Fatal(err)
+	}
+	var got object
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != obj.Name || got.Nodes != obj.Nodes {
+		t.Errorf("decoded %+v, want %+v", got, obj)
+	}
+}
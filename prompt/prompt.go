@@ -0,0 +1,187 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prompt asks typed questions on the terminal. Answers are read line
+// by line, so questions can also be answered non-interactively by piping the
+// answers in, one per line.
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Question is a question expecting a single line answer.
+type Question struct {
+	// Text is the question itself, without trailing punctuation.
+	Text string
+	// Default is the answer used when the line is left empty. It is shown
+	// along the question, unless Secret is set.
+	Default string
+	// Secret answers are read without echo when the input is a terminal.
+	Secret bool
+	// Validate optionally checks the answer. The question is asked again
+	// until it passes.
+	Validate func(answer string) error
+}
+
+// Prompter asks questions, reading answers from in and writing questions to
+// out.
+type Prompter struct {
+	in   *bufio.Reader
+	file *os.File
+	out  io.Writer
+}
+
+// New instantiates a Prompter. Feed it os.Stdin and os.Stdout.
+func New(in *os.File, out io.Writer) *Prompter {
+	return &Prompter{
+		in:   bufio.NewReader(in),
+		file: in,
+		out:  out,
+	}
+}
+
+// IsTerminal reports whether f is a terminal.
+func IsTerminal(f *os.File) bool {
+	return f != nil && isTerminal(f.Fd())
+}
+
+// Ask asks q until it gets a valid answer. It fails if the input ends before.
+func (p *Prompter) Ask(q Question) (string, error) {
+	for {
+		if q.Default != "" && !q.Secret {
+			fmt.Fprintf(p.out, "%s [%s]: ", q.Text, q.Default)
+		} else {
+			fmt.Fprintf(p.out, "%s: ", q.Text)
+		}
+
+		answer, err := p.readLine(q.Secret)
+		if err != nil {
+			return "", fmt.Errorf("no answer to %q: %v", q.Text, err)
+		}
+		if answer == "" {
+			answer = q.Default
+		}
+
+		if q.Validate != nil {
+			if err := q.Validate(answer); err != nil {
+				fmt.Fprintf(p.out, "Invalid answer: %v\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// String asks a free form question.
+func (p *Prompter) String(text, def string) (string, error) {
+	return p.Ask(Question{Text: text, Default: def})
+}
+
+// Int asks for an integer of at least min.
+func (p *Prompter) Int(text string, def, min int) (int, error) {
+	answer, err := p.Ask(Question{
+		Text:    text,
+		Default: strconv.Itoa(def),
+		Validate: func(answer string) error {
+			n, err := strconv.Atoi(answer)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", answer)
+			}
+			if n < min {
+				return fmt.Errorf("must be at least %d", min)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(answer)
+}
+
+// YesNo asks a yes or no question, showing the default as [Y/n] or [y/N].
+func (p *Prompter) YesNo(text string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+
+	var result bool
+	_, err := p.Ask(Question{
+		Text: text + " [" + hint + "]",
+		Validate: func(answer string) error {
+			b, err := ParseYesNo(answer, def)
+			result = b
+			return err
+		},
+	})
+	return result, err
+}
+
+// Choice asks to pick one of choices.
+func (p *Prompter) Choice(text string, choices []string, def string) (string, error) {
+	return p.Ask(Question{
+		Text:    fmt.Sprintf("%s (%s)", text, strings.Join(choices, ", ")),
+		Default: def,
+		Validate: func(answer string) error {
+			for _, c := range choices {
+				if answer == c {
+					return nil
+				}
+			}
+			return fmt.Errorf("%q is not one of %s", answer, strings.Join(choices, ", "))
+		},
+	})
+}
+
+// ParseYesNo interprets a yes or no answer, an empty one standing for def.
+func ParseYesNo(answer string, def bool) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "":
+		return def, nil
+	case "y", "yes", "true":
+		return true, nil
+	case "n", "no", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("answer yes or no, got %q", answer)
+}
+
+func (p *Prompter) readLine(secret bool) (string, error) {
+	if secret && p.file != nil && isTerminal(p.file.Fd()) {
+		restore, err := disableEcho(p.file.Fd())
+		if err == nil {
+			defer func() {
+				restore()
+				// the newline typed was not echoed either
+				fmt.Fprintln(p.out)
+			}()
+		}
+	}
+
+	line, err := p.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
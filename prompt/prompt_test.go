@@ -0,0 +1,139 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prompt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// answering returns a Prompter reading the given answers, and the questions
+// it writes.
+func answering(t *testing.T, answers string) (*Prompter, *bytes.Buffer, func()) {
+	f, err := ioutil.TempFile("", "answers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(answers); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	return New(f, &out), &out, func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func TestAsk(t *testing.T) {
+	p, out, cleanup := answering(t, "\nstage\n")
+	defer cleanup()
+
+	if answer, err := p.String("Name", "dev"); err != nil || answer != "dev" {
+		t.Errorf("empty answer = %q, %v, want the default", answer, err)
+	}
+	if answer, err := p.String("Name", "dev"); err != nil || answer != "stage" {
+		t.Errorf("answer = %q, %v, want stage", answer, err)
+	}
+	if _, err := p.String("Name", "dev"); err == nil {
+		t.Error("answer read past the end of the input")
+	}
+	if want := "Name [dev]: "; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("question %q, want %q", out.String(), want)
+	}
+}
+
+func TestAskSecretHidesDefault(t *testing.T) {
+	p, out, cleanup := answering(t, "\n")
+	defer cleanup()
+
+	answer, err := p.Ask(Question{Text: "Token", Default: "s3cret", Secret: true})
+	if err != nil || answer != "s3cret" {
+		t.Errorf("Ask() = %q, %v", answer, err)
+	}
+	if out.String() != "Token: " {
+		t.Errorf("question %q shows the secret default", out.String())
+	}
+}
+
+func TestInt(t *testing.T) {
+	p, out, cleanup := answering(t, "three\n0\n3\n")
+	defer cleanup()
+
+	n, err := p.Int("How many nodes", 1, 1)
+	if err != nil || n != 3 {
+		t.Errorf("Int() = %d, %v, want 3", n, err)
+	}
+	for _, want := range []string{`"three" is not a number`, "must be at least 1"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not hold %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestYesNo(t *testing.T) {
+	p, out, cleanup := answering(t, "\nmaybe\nY\nno\n")
+	defer cleanup()
+
+	for _, want := range []bool{true, true, false} {
+		b, err := p.YesNo("Autoscale", true)
+		if err != nil || b != want {
+			t.Errorf("YesNo() = %v, %v, want %v", b, err, want)
+		}
+	}
+	if !strings.HasPrefix(out.String(), "Autoscale [Y/n]: ") {
+		t.Errorf("question %q", out.String())
+	}
+}
+
+func TestChoice(t *testing.T) {
+	p, _, cleanup := answering(t, "xen\nkvm\n")
+	defer cleanup()
+
+	choice, err := p.Choice("Driver", []string{"fake", "kvm"}, "fake")
+	if err != nil || choice != "kvm" {
+		t.Errorf("Choice() = %q, %v, want kvm", choice, err)
+	}
+}
+
+func TestParseYesNo(t *testing.T) {
+	tests := []struct {
+		answer string
+		def    bool
+		want   bool
+		ok     bool
+	}{
+		{"", true, true, true},
+		{"", false, false, true},
+		{"y", false, true, true},
+		{" YES ", false, true, true},
+		{"true", false, true, true},
+		{"n", true, false, true},
+		{"No", true, false, true},
+		{"false", true, false, true},
+		{"maybe", true, false, false},
+	}
+	for _, test := range tests {
+		got, err := ParseYesNo(test.answer, test.def)
+		if (err == nil) != test.ok || (test.ok && got != test.want) {
+			t.Errorf("ParseYesNo(%q, %v) = %v, %v", test.answer, test.def, got, err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package prompt
+
+import "errors"
+
+// isTerminal is not implemented on this platform, secrets are read with echo.
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func disableEcho(fd uintptr) (func(), error) {
+	return nil, errors.New("prompt: disabling echo is not supported on this platform")
+}
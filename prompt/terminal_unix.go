@@ -0,0 +1,61 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package prompt
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func getTermios(fd uintptr) (*syscall.Termios, error) {
+	t := &syscall.Termios{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlReadTermios, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return nil, errno
+	}
+	return t, nil
+}
+
+func setTermios(fd uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlWriteTermios, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal reports whether fd refers to a terminal.
+func isTerminal(fd uintptr) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+// disableEcho turns off the echo of the terminal fd, returning the function
+// restoring it.
+func disableEcho(fd uintptr) (func(), error) {
+	old, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	noEcho := *old
+	noEcho.Lflag &^= syscall.ECHO
+	noEcho.Lflag |= syscall.ICANON | syscall.ISIG
+	if err := setTermios(fd, &noEcho); err != nil {
+		return nil, err
+	}
+	return func() { setTermios(fd, old) }, nil
+}
@@ -0,0 +1,386 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+// Action tells what a Change does.
+type Action string
+
+const (
+	Add    Action = "+"
+	Remove Action = "-"
+	Update Action = "~"
+)
+
+// Change is a single difference between an environment and its desired state.
+type Change struct {
+	Action Action
+	// Field locates the change, e.g. nodePools.default.nodes.
+	Field string
+	From  string
+	To    string
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Action == Update:
+		return fmt.Sprintf("%s %s: %s -> %s", c.Action, c.Field, orNone(c.From), orNone(c.To))
+	case c.To != "":
+		return fmt.Sprintf("%s %s: %s", c.Action, c.Field, c.To)
+	case c.From != "":
+		return fmt.Sprintf("%s %s: %s", c.Action, c.Field, c.From)
+	}
+	return fmt.Sprintf("%s %s", c.Action, c.Field)
+}
+
+// Plan lists the changes bringing an environment to its desired state.
+type Plan struct {
+	// Current is the environment as stored, updated with what its driver
+	// reports, nil if it does not exist yet.
+	Current *envstore.Environment
+	// Desired is the environment once the plan is applied.
+	Desired *envstore.Environment
+
+	Changes []Change
+
+	// Start is set if the environment is stopped, and must be started.
+	Start bool
+	// Upgrade is the kubernetes release to upgrade to, if any.
+	Upgrade string
+	// AddonsToInstall and AddonsToRemove list the addon changes, addons
+	// changing version being installed again.
+	AddonsToInstall []envstore.Addon
+	AddonsToRemove  []envstore.Addon
+}
+
+// Empty reports whether the plan changes nothing.
+func (p *Plan) Empty() bool {
+	return p.Current != nil && len(p.Changes) == 0
+}
+
+// Print writes the plan in a human readable form.
+func (p *Plan) Print(w io.Writer) {
+	if p.Current == nil {
+		fmt.Fprintf(w, "Environment %q will be created with %d node(s), driver %s.\n", p.Desired.Name, p.Desired.Nodes, p.Desired.Driver)
+		return
+	}
+	if p.Empty() {
+		fmt.Fprintf(w, "Environment %q is up to date.\n", p.Desired.Name)
+		return
+	}
+
+	fmt.Fprintf(w, "Environment %q:\n", p.Desired.Name)
+	counts := make(map[Action]int)
+	for _, c := range p.Changes {
+		fmt.Fprintf(w, "  %s\n", c)
+		counts[c.Action]++
+	}
+	fmt.Fprintf(w, "Plan: %d to add, %d to change, %d to remove.\n", counts[Add], counts[Update], counts[Remove])
+}
+
+// Observed returns a copy of e, a stored environment, updated with what its
+// driver d reports: the nodes of each pool if d is a driver.NodeLister, and
+// the installed addons if d is a driver.AddonLister. Diffing from it plans
+// the changes made behind kube-cluster's back too, such as a machine deleted
+// by hand. Nodes whose machine is gone are not counted.
+func Observed(e *envstore.Environment, d driver.Driver) (*envstore.Environment, error) {
+	observed := *e
+
+	if l, ok := d.(driver.NodeLister); ok {
+		nodes, err := l.Nodes()
+		if err != nil {
+			return nil, fmt.Errorf("environment %q: cannot list nodes: %v", e.Name, err)
+		}
+		counts := make(map[string]int)
+		observed.Nodes = 0
+		for _, n := range nodes {
+			if n.State == driver.None {
+				continue
+			}
+			pool := n.Pool
+			if pool == "" {
+				pool = envstore.DefaultPoolName
+			}
+			counts[pool]++
+			observed.Nodes++
+		}
+
+		var pools []envstore.NodePool
+		for _, pool := range e.NodePools() {
+			pool.Nodes = counts[pool.Name]
+			delete(counts, pool.Name)
+			pools = append(pools, pool)
+		}
+		var unknown []string
+		for name := range counts {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		for _, name := range unknown {
+			pools = append(pools, envstore.NodePool{Name: name, Nodes: counts[name]})
+		}
+		if len(e.Pools) > 0 || len(unknown) > 0 {
+			observed.Pools = pools
+		}
+	}
+
+	if l, ok := d.(driver.AddonLister); ok {
+		installed, err := l.Addons()
+		if err != nil {
+			return nil, fmt.Errorf("environment %q: cannot list addons: %v", e.Name, err)
+		}
+		var names []string
+		for name := range installed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		observed.Addons = nil
+		for _, name := range names {
+			observed.Addons = append(observed.Addons, envstore.Addon{Name: name, Version: installed[name]})
+		}
+	}
+	return &observed, nil
+}
+
+// Diff plans the changes from current, the stored environment or nil if it
+// does not exist, to desired. current is expected to be Observed, so the plan
+// starts from the actual state of the environment. running tells whether the
+// current environment is running. Changes that cannot be made in place, such
+// as switching drivers, or that drivers do not carry out, such as the machine
+// type of an existing pool or the driver options, are reported as errors.
+func Diff(current, desired *envstore.Environment, running bool) (*Plan, error) {
+	p := &Plan{Current: current, Desired: desired}
+	if current == nil {
+		return p, nil
+	}
+
+	if current.Name != desired.Name {
+		return nil, fmt.Errorf("cannot compare environment %q with %q", current.Name, desired.Name)
+	}
+	if current.Driver != desired.Driver {
+		return nil, fmt.Errorf("environment %q: the driver cannot be changed from %s to %s, delete and create the environment again", current.Name, current.Driver, desired.Driver)
+	}
+	if !reflect.DeepEqual(networking(current), networking(desired)) {
+		return nil, fmt.Errorf("environment %q: networking cannot be changed in place, delete and create the environment again", current.Name)
+	}
+
+	if !running {
+		p.Start = true
+		p.add(Update, "status", "stopped", "running")
+	}
+
+	if err := p.diffPools(current.Name, current.NodePools(), desired.NodePools()); err != nil {
+		return nil, err
+	}
+
+	if current.KubernetesVersion != desired.KubernetesVersion && desired.KubernetesVersion != "" {
+		p.Upgrade = desired.KubernetesVersion
+		p.add(Update, "kubernetesVersion", current.KubernetesVersion, desired.KubernetesVersion)
+	}
+	if current.KubectlVersion != desired.KubectlVersion {
+		p.add(Update, "kubectlVersion", current.KubectlVersion, desired.KubectlVersion)
+	}
+	if current.Autoscale != desired.Autoscale {
+		p.add(Update, "autoscale", fmt.Sprint(current.Autoscale), fmt.Sprint(desired.Autoscale))
+	}
+
+	p.diffAddons(current.Addons, desired.Addons)
+	if err := p.diffOptions(current.Name, desired.Driver, current.DriverOptions, desired.DriverOptions); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Plan) add(action Action, field, from, to string) {
+	p.Changes = append(p.Changes, Change{Action: action, Field: field, From: from, To: to})
+}
+
+// diffPools plans the pools added, removed and resized. The machines of an
+// existing pool are not replaced, so its machine type and labels are kept.
+func (p *Plan) diffPools(env string, current, desired []envstore.NodePool) error {
+	byName := make(map[string]envstore.NodePool)
+	for _, pool := range current {
+		byName[pool.Name] = pool
+	}
+
+	seen := make(map[string]bool)
+	for _, d := range desired {
+		seen[d.Name] = true
+		field := "nodePools." + d.Name
+
+		c, ok := byName[d.Name]
+		if !ok {
+			p.add(Add, field, "", nodes(d.Nodes))
+			continue
+		}
+		if c.Nodes != d.Nodes {
+			p.add(Update, field+".nodes", fmt.Sprint(c.Nodes), fmt.Sprintf("%d (%+d)", d.Nodes, d.Nodes-c.Nodes))
+		}
+		if c.MachineType != d.MachineType {
+			return fmt.Errorf("environment %q: the machine type of node pool %s cannot be changed from %s to %s, add a pool instead",
+				env, d.Name, orNone(c.MachineType), orNone(d.MachineType))
+		}
+		if !reflect.DeepEqual(labels(c.Labels), labels(d.Labels)) {
+			return fmt.Errorf("environment %q: the labels of node pool %s cannot be changed from %s to %s, add a pool instead",
+				env, d.Name, orNone(formatLabels(c.Labels)), orNone(formatLabels(d.Labels)))
+		}
+	}
+
+	for _, c := range current {
+		if !seen[c.Name] {
+			p.add(Remove, "nodePools."+c.Name, nodes(c.Nodes), "")
+		}
+	}
+	return nil
+}
+
+func (p *Plan) diffAddons(current, desired []envstore.Addon) {
+	byName := make(map[string]envstore.Addon)
+	for _, a := range current {
+		byName[a.Name] = a
+	}
+
+	seen := make(map[string]bool)
+	for _, d := range desired {
+		seen[d.Name] = true
+		c, ok := byName[d.Name]
+		switch {
+		case !ok:
+			p.AddonsToInstall = append(p.AddonsToInstall, d)
+			p.add(Add, "addons."+d.Name, "", d.Version)
+		case c.Version != d.Version:
+			p.AddonsToInstall = append(p.AddonsToInstall, d)
+			p.add(Update, "addons."+d.Name+".version", c.Version, d.Version)
+		}
+	}
+
+	for _, c := range current {
+		if !seen[c.Name] {
+			p.AddonsToRemove = append(p.AddonsToRemove, c)
+			p.add(Remove, "addons."+c.Name, c.Version, "")
+		}
+	}
+}
+
+// diffOptions compares driver options, unset ones taking their default value.
+// Drivers are not told about option changes: the options locating or sizing
+// what they created cannot change, only the secret ones, credentials, may.
+// Their values are not shown.
+func (p *Plan) diffOptions(env, driverName string, current, desired map[string]string) error {
+	current = withDefaults(driverName, current)
+	desired = withDefaults(driverName, desired)
+	secret := make(map[string]bool)
+	for _, opt := range driver.Options(driverName) {
+		secret[opt.Name] = opt.Secret
+	}
+
+	var names []string
+	for name := range desired {
+		names = append(names, name)
+	}
+	for name := range current {
+		if _, ok := desired[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		c, inCurrent := current[name]
+		d, inDesired := desired[name]
+		if inCurrent && inDesired && c == d {
+			continue
+		}
+		if !secret[name] {
+			return fmt.Errorf("environment %q: driver option %s cannot be changed from %s to %s, delete and create the environment again",
+				env, name, orNone(c), orNone(d))
+		}
+		switch {
+		case !inCurrent:
+			p.add(Add, "driverOptions."+name, "", "<hidden>")
+		case !inDesired:
+			p.add(Remove, "driverOptions."+name, "<hidden>", "")
+		default:
+			p.add(Update, "driverOptions."+name, "<hidden>", "<hidden>")
+		}
+	}
+	return nil
+}
+
+// withDefaults returns opts completed with the defaults of the driver
+// options, leaving out empty values.
+func withDefaults(driverName string, opts map[string]string) map[string]string {
+	all := make(map[string]string)
+	for _, opt := range driver.Options(driverName) {
+		if opt.Default != "" {
+			all[opt.Name] = opt.Default
+		}
+	}
+	for name, value := range opts {
+		if value == "" {
+			delete(all, name)
+			continue
+		}
+		all[name] = value
+	}
+	return all
+}
+
+func networking(e *envstore.Environment) envstore.Networking {
+	if e.Networking == nil {
+		return envstore.Networking{}
+	}
+	return *e.Networking
+}
+
+func labels(l map[string]string) map[string]string {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+func formatLabels(l map[string]string) string {
+	var pairs []string
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func nodes(n int) string {
+	if n == 1 {
+		return "1 node"
+	}
+	return fmt.Sprintf("%d nodes", n)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
@@ -0,0 +1,221 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+func testEnvironment() *envstore.Environment {
+	return &envstore.Environment{
+		Name:              "stage1",
+		Driver:            "spec-stub",
+		Nodes:             3,
+		KubernetesVersion: "v1.1.2",
+		DriverOptions:     map[string]string{"spec-stub-region": "eu-west-1"},
+		Pools: []envstore.NodePool{
+			{Name: "default", Nodes: 2},
+			{Name: "large", Nodes: 1, MachineType: "m3.large"},
+		},
+		Addons: []envstore.Addon{{Name: "dns", Version: "v1.0"}},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(e *envstore.Environment)
+		running bool
+		want    []string
+	}{
+		{"unchanged", func(e *envstore.Environment) {}, true, nil},
+		{"stopped", func(e *envstore.Environment) {}, false, []string{"~ status: stopped -> running"}},
+		{"pool size", func(e *envstore.Environment) { e.Pools[0].Nodes = 4 }, true, []string{"~ nodePools.default.nodes: 2 -> 4 (+2)"}},
+		{"pool added", func(e *envstore.Environment) {
+			e.Pools = append(e.Pools, envstore.NodePool{Name: "gpu", Nodes: 1})
+		}, true, []string{"+ nodePools.gpu: 1 node"}},
+		{"pool removed", func(e *envstore.Environment) { e.Pools = e.Pools[:1] }, true, []string{"- nodePools.large: 1 node"}},
+		{"upgrade", func(e *envstore.Environment) { e.KubernetesVersion = "v1.2.0" }, true, []string{"~ kubernetesVersion: v1.1.2 -> v1.2.0"}},
+		{"addons", func(e *envstore.Environment) {
+			e.Addons = []envstore.Addon{{Name: "dns", Version: "v1.1"}, {Name: "dashboard", Version: "v1.0"}}
+		}, true, []string{"~ addons.dns.version: v1.0 -> v1.1", "+ addons.dashboard: v1.0"}},
+		{"addon removed", func(e *envstore.Environment) { e.Addons = nil }, true, []string{"- addons.dns: v1.0"}},
+		// options set to their default change nothing
+		{"default option", func(e *envstore.Environment) { e.DriverOptions["spec-stub-mode"] = "0644" }, true, nil},
+		{"secret option", func(e *envstore.Environment) { e.DriverOptions["spec-stub-token"] = "s3cret" }, true, []string{"+ driverOptions.spec-stub-token: <hidden>"}},
+	}
+	for _, test := range tests {
+		desired := testEnvironment()
+		test.change(desired)
+		p, err := Diff(testEnvironment(), desired, test.running)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var got []string
+		for _, c := range p.Changes {
+			got = append(got, c.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got changes %q, want %q", test.name, got, test.want)
+		}
+		if p.Empty() != (len(test.want) == 0) {
+			t.Errorf("%s: plan empty %v with changes %q", test.name, p.Empty(), got)
+		}
+	}
+}
+
+func TestDiffPlanFields(t *testing.T) {
+	desired := testEnvironment()
+	desired.KubernetesVersion = "v1.2.0"
+	desired.Addons = []envstore.Addon{{Name: "dashboard", Version: "v1.0"}}
+	p, err := Diff(testEnvironment(), desired, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Start || p.Upgrade != "v1.2.0" {
+		t.Errorf("got start %v, upgrade %q", p.Start, p.Upgrade)
+	}
+	if want := desired.Addons; !reflect.DeepEqual(p.AddonsToInstall, want) {
+		t.Errorf("got addons to install %v, want %v", p.AddonsToInstall, want)
+	}
+	if want := testEnvironment().Addons; !reflect.DeepEqual(p.AddonsToRemove, want) {
+		t.Errorf("got addons to remove %v, want %v", p.AddonsToRemove, want)
+	}
+
+	var buf bytes.Buffer
+	p.Print(&buf)
+	if !strings.HasSuffix(buf.String(), "Plan: 1 to add, 2 to change, 1 to remove.\n") {
+		t.Errorf("got plan:\n%s", buf.String())
+	}
+}
+
+func TestDiffErrors(t *testing.T) {
+	tests := []func(e *envstore.Environment){
+		func(e *envstore.Environment) { e.Name = "stage2" },
+		func(e *envstore.Environment) { e.Driver = "fake" },
+		func(e *envstore.Environment) { e.Networking = &envstore.Networking{PodCIDR: "10.0.0.0/16"} },
+		// drivers do not replace the machines of a pool
+		func(e *envstore.Environment) { e.Pools[1].MachineType = "m3.xlarge" },
+		func(e *envstore.Environment) { e.Pools[0].Labels = map[string]string{"gpu": "true"} },
+		// nor move what they created
+		func(e *envstore.Environment) { e.DriverOptions["spec-stub-region"] = "us-east-1" },
+		func(e *envstore.Environment) { e.DriverOptions["spec-stub-count"] = "2" },
+	}
+	for i, change := range tests {
+		desired := testEnvironment()
+		change(desired)
+		if _, err := Diff(testEnvironment(), desired, true); err == nil {
+			t.Errorf("%d: got no error", i)
+		}
+	}
+}
+
+func TestDiffNew(t *testing.T) {
+	p, err := Diff(nil, testEnvironment(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	p.Print(&buf)
+	if want := "Environment \"stage1\" will be created with 3 node(s), driver spec-stub.\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+// listingDriver reports nodes and addons.
+type listingDriver struct {
+	driver.Driver
+	nodes  []driver.Node
+	addons map[string]string
+	err    error
+}
+
+func (d *listingDriver) Nodes() ([]driver.Node, error)      { return d.nodes, d.err }
+func (d *listingDriver) Addons() (map[string]string, error) { return d.addons, d.err }
+
+func TestObserved(t *testing.T) {
+	d := &listingDriver{
+		nodes: []driver.Node{
+			{Name: "default-1", Pool: "default", State: driver.Running},
+			{Name: "default-2", Pool: "default", State: driver.None},
+			{Name: "large-1", Pool: "large", State: driver.Running},
+			{Name: "gpu-1", Pool: "gpu", State: driver.Stopped},
+		},
+		addons: map[string]string{"dns": "v1.0", "dashboard": "v0.9"},
+	}
+	current, err := Observed(testEnvironment(), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Diff(current, testEnvironment(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range p.Changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"~ nodePools.default.nodes: 1 -> 2 (+1)",
+		"- nodePools.gpu: 1 node",
+		"- addons.dashboard: v0.9",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %q, want %q", got, want)
+	}
+	if current.Nodes != 3 {
+		t.Errorf("got %d nodes, want 3", current.Nodes)
+	}
+}
+
+func TestObservedDefaultPool(t *testing.T) {
+	e := &envstore.Environment{Name: "dev", Driver: "spec-stub", Nodes: 3}
+	d := &listingDriver{nodes: []driver.Node{{Name: "dev-node-1"}, {Name: "dev-node-2", Pool: envstore.DefaultPoolName}}}
+	current, err := Observed(e, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Nodes != 2 || len(current.Pools) != 0 {
+		t.Errorf("got %d nodes in pools %v, want 2 in the implicit pool", current.Nodes, current.Pools)
+	}
+	if e.Nodes != 3 {
+		t.Error("the stored environment was changed")
+	}
+
+	d.err = errors.New("unreachable")
+	if _, err := Observed(e, d); err == nil {
+		t.Error("got no error from a failing driver")
+	}
+}
+
+func TestObservedNonListing(t *testing.T) {
+	var d struct{ driver.Driver }
+	current, err := Observed(testEnvironment(), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(current, testEnvironment()) {
+		t.Errorf("got %+v, want the stored environment", current)
+	}
+}
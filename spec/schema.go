@@ -0,0 +1,358 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/kubectl"
+	"github.com/gerred/kube-cluster/yaml"
+)
+
+type valueKind int
+
+const (
+	stringValue valueKind = iota
+	intValue
+	boolValue
+	objectValue
+	listValue
+	// stringMapValue is a mapping of strings to scalars, which are
+	// taken as strings, as written.
+	stringMapValue
+)
+
+func (k valueKind) String() string {
+	return [...]string{"a string", "an integer", "a boolean", "a mapping", "a list", "a mapping of strings"}[k]
+}
+
+// schema describes the expected shape of a node.
+type schema struct {
+	kind   valueKind
+	fields []field // objectValue fields
+	items  *schema // listValue items
+	// check further validates a node of the right kind.
+	check func(v *validator, n *yaml.Node, path string)
+}
+
+type field struct {
+	name     string
+	required bool
+	schema   *schema
+}
+
+func (s *schema) field(name string) *field {
+	for i := range s.fields {
+		if s.fields[i].name == name {
+			return &s.fields[i]
+		}
+	}
+	return nil
+}
+
+// schemas holds the schema of each supported apiVersion.
+var schemas = map[string]*schema{
+	APIVersion: v1alpha1,
+}
+
+var v1alpha1 = &schema{kind: objectValue, fields: []field{
+	{"apiVersion", true, &schema{kind: stringValue}},
+	{"kind", true, &schema{kind: stringValue, check: checkKind}},
+	{"metadata", true, &schema{kind: objectValue, fields: []field{
+		{"name", true, &schema{kind: stringValue, check: checkName}},
+	}}},
+	{"spec", true, &schema{kind: objectValue, check: checkSpec, fields: []field{
+		{"driver", true, &schema{kind: stringValue, check: checkDriver}},
+		{"driverOptions", false, &schema{kind: stringMapValue}},
+		{"kubernetesVersion", false, &schema{kind: stringValue, check: checkVersion}},
+		{"kubectlVersion", false, &schema{kind: stringValue, check: checkVersion}},
+		{"autoscale", false, &schema{kind: boolValue}},
+		{"nodePools", true, &schema{kind: listValue, check: checkPools, items: &schema{kind: objectValue, fields: []field{
+			{"name", true, &schema{kind: stringValue, check: checkDNSLabel}},
+			{"nodes", true, &schema{kind: intValue, check: checkNonNegative}},
+			{"machineType", false, &schema{kind: stringValue}},
+			{"labels", false, &schema{kind: stringMapValue}},
+		}}}},
+		{"networking", false, &schema{kind: objectValue, fields: []field{
+			{"podCIDR", false, &schema{kind: stringValue, check: checkCIDR}},
+			{"serviceCIDR", false, &schema{kind: stringValue, check: checkCIDR}},
+			{"dnsDomain", false, &schema{kind: stringValue}},
+		}}},
+		{"addons", false, &schema{kind: listValue, check: checkAddons, items: &schema{kind: objectValue, fields: []field{
+			{"name", true, &schema{kind: stringValue, check: checkDNSLabel}},
+			{"version", false, &schema{kind: stringValue}},
+		}}}},
+	}}},
+}}
+
+// validator checks a document against a schema, collecting all errors.
+type validator struct {
+	file   string
+	errors []*FieldError
+}
+
+func (v *validator) errorf(n *yaml.Node, path, format string, args ...interface{}) {
+	v.errors = append(v.errors, &FieldError{
+		File: v.file,
+		Line: n.Line,
+		Path: path,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validate(root *yaml.Node) {
+	if root.Kind != yaml.MappingNode {
+		v.errorf(root, "", "expected a mapping with apiVersion, kind, metadata and spec")
+		return
+	}
+
+	version := root.Get("apiVersion")
+	if version == nil {
+		v.errorf(root, "apiVersion", "is required, the current version is %s", APIVersion)
+		return
+	}
+	s, ok := schemas[fmt.Sprint(version.Value)]
+	if !ok {
+		v.errorf(version, "apiVersion", "unsupported version %q, supported versions: %s", fmt.Sprint(version.Value), strings.Join(supportedVersions(), ", "))
+		return
+	}
+	v.node(root, s, "")
+}
+
+func (v *validator) node(n *yaml.Node, s *schema, path string) {
+	if !v.kind(n, s, path) {
+		return
+	}
+
+	switch s.kind {
+	case objectValue:
+		for i, key := range n.Keys {
+			f := s.field(key)
+			if f == nil {
+				v.errorf(n.Values[i], join(path, key), "unknown field")
+				continue
+			}
+			v.node(n.Values[i], f.schema, join(path, key))
+		}
+		for _, f := range s.fields {
+			if f.required && n.Get(f.name) == nil {
+				v.errorf(n, join(path, f.name), "is required")
+			}
+		}
+	case listValue:
+		for i, item := range n.Items {
+			v.node(item, s.items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+
+	if s.check != nil {
+		s.check(v, n, path)
+	}
+}
+
+// kind checks the kind of n, turning the scalars of string maps into the
+// strings they are written as.
+func (v *validator) kind(n *yaml.Node, s *schema, path string) bool {
+	ok := false
+	switch s.kind {
+	case stringValue:
+		_, ok = n.Value.(string)
+		ok = ok && n.Kind == yaml.ScalarNode
+	case intValue:
+		if num, isNum := n.Value.(fmt.Stringer); isNum && n.Kind == yaml.ScalarNode {
+			_, err := strconv.Atoi(num.String())
+			ok = err == nil
+		}
+	case boolValue:
+		_, ok = n.Value.(bool)
+	case objectValue:
+		ok = n.Kind == yaml.MappingNode
+	case listValue:
+		ok = n.Kind == yaml.SequenceNode
+	case stringMapValue:
+		if n.Kind != yaml.MappingNode {
+			break
+		}
+		ok = true
+		for i, value := range n.Values {
+			if value.Kind != yaml.ScalarNode || value.Value == nil {
+				v.errorf(value, join(path, n.Keys[i]), "expected a string")
+				continue
+			}
+			// keep the text as written: 0755 or 1.10 are not numbers
+			// here
+			value.Value = value.Text
+		}
+	}
+
+	if !ok {
+		v.errorf(n, path, "expected %s", s.kind)
+	}
+	return ok
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func supportedVersions() []string {
+	var versions []string
+	for v := range schemas {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+func checkKind(v *validator, n *yaml.Node, path string) {
+	if n.Value != Kind {
+		v.errorf(n, path, "must be %s", Kind)
+	}
+}
+
+func checkName(v *validator, n *yaml.Node, path string) {
+	if err := envstore.ValidateName(n.Value.(string)); err != nil {
+		v.errorf(n, path, "%v", err)
+	}
+}
+
+func checkDNSLabel(v *validator, n *yaml.Node, path string) {
+	// environment names follow the DNS label rules as well
+	if err := envstore.ValidateName(n.Value.(string)); err != nil {
+		v.errorf(n, path, "must consist of lower case alphanumeric characters or '-', and start and end with an alphanumeric character")
+	}
+}
+
+func checkVersion(v *validator, n *yaml.Node, path string) {
+	if err := kubectl.ValidateVersion(n.Value.(string)); err != nil {
+		v.errorf(n, path, "invalid version %q, expected a release name such as v1.1.2", n.Value)
+	}
+}
+
+func checkNonNegative(v *validator, n *yaml.Node, path string) {
+	if i, _ := strconv.Atoi(fmt.Sprint(n.Value)); i < 0 {
+		v.errorf(n, path, "must not be negative")
+	}
+}
+
+func checkCIDR(v *validator, n *yaml.Node, path string) {
+	if _, _, err := net.ParseCIDR(n.Value.(string)); err != nil {
+		v.errorf(n, path, "invalid CIDR %q", n.Value)
+	}
+}
+
+func checkDriver(v *validator, n *yaml.Node, path string) {
+	if !isRegistered(n.Value.(string)) {
+		v.errorf(n, path, "unknown driver %q, available drivers: %s", n.Value, strings.Join(driver.Names(), ", "))
+	}
+}
+
+// checkSpec validates the driver options against the driver schema.
+func checkSpec(v *validator, n *yaml.Node, path string) {
+	d, opts := n.Get("driver"), n.Get("driverOptions")
+	if d == nil || opts == nil || opts.Kind != yaml.MappingNode {
+		return
+	}
+	name, ok := d.Value.(string)
+	if !ok || !isRegistered(name) {
+		return
+	}
+
+	known := make(map[string]driver.Option)
+	for _, opt := range driver.Options(name) {
+		known[opt.Name] = opt
+	}
+	for i, key := range opts.Keys {
+		p := join(join(path, "driverOptions"), key)
+		opt, ok := known[key]
+		if !ok {
+			v.errorf(opts.Values[i], p, "unknown option of driver %s", name)
+			continue
+		}
+		if value, ok := opts.Values[i].Value.(string); ok {
+			if err := opt.Check(value); err != nil {
+				v.errorf(opts.Values[i], p, "%v", err)
+			}
+		}
+	}
+	for _, opt := range driver.Options(name) {
+		if opt.Required && opts.Get(opt.Name) == nil {
+			v.errorf(opts, join(join(path, "driverOptions"), opt.Name), "is required by driver %s", name)
+		}
+	}
+}
+
+func checkPools(v *validator, n *yaml.Node, path string) {
+	if len(n.Items) == 0 {
+		v.errorf(n, path, "at least one node pool is required")
+		return
+	}
+
+	seen := make(map[string]bool)
+	total := 0
+	for i, item := range n.Items {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		if name := item.Get("name"); name != nil {
+			key := fmt.Sprint(name.Value)
+			if seen[key] {
+				v.errorf(name, fmt.Sprintf("%s[%d].name", path, i), "duplicate node pool %q", key)
+			}
+			seen[key] = true
+		}
+		if nodes := item.Get("nodes"); nodes != nil {
+			count, _ := strconv.Atoi(fmt.Sprint(nodes.Value))
+			total += count
+		}
+	}
+	if total < 1 {
+		v.errorf(n, path, "at least one node is required")
+	}
+}
+
+func checkAddons(v *validator, n *yaml.Node, path string) {
+	seen := make(map[string]bool)
+	for i, item := range n.Items {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		if name := item.Get("name"); name != nil {
+			key := fmt.Sprint(name.Value)
+			if seen[key] {
+				v.errorf(name, fmt.Sprintf("%s[%d].name", path, i), "duplicate addon %q", key)
+			}
+			seen[key] = true
+		}
+	}
+}
+
+func isRegistered(name string) bool {
+	for _, n := range driver.Names() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spec reads declarative environment specifications, YAML or JSON
+// files describing an environment, so it can be kept in version control
+// rather than in command line flags:
+//
+//	apiVersion: kube-cluster/v1alpha1
+//	kind: Environment
+//	metadata:
+//	  name: stage1
+//	spec:
+//	  driver: aws
+//	  driverOptions:
+//	    aws-region: eu-west-1
+//	  kubernetesVersion: v1.1.2
+//	  nodePools:
+//	  - name: default
+//	    nodes: 3
+//	    machineType: m3.medium
+//	  networking:
+//	    podCIDR: 10.244.0.0/16
+//	  addons:
+//	  - name: dns
+//
+// Specifications are validated against the schema of their apiVersion, and
+// errors point at the offending line.
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/gerred/kube-cluster/envstore"
+	"github.com/gerred/kube-cluster/yaml"
+)
+
+const (
+	// APIVersion is the current version of the specification format.
+	APIVersion = "kube-cluster/v1alpha1"
+	// Kind is the kind of environment specifications.
+	Kind = "Environment"
+)
+
+// Spec is an environment specification.
+type Spec struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   Metadata        `json:"metadata"`
+	Spec       EnvironmentSpec `json:"spec"`
+}
+
+// Metadata identifies the specified environment.
+type Metadata struct {
+	Name string `json:"name"`
+}
+
+// EnvironmentSpec is the desired state of an environment.
+type EnvironmentSpec struct {
+	Driver            string               `json:"driver"`
+	DriverOptions     map[string]string    `json:"driverOptions,omitempty"`
+	KubernetesVersion string               `json:"kubernetesVersion,omitempty"`
+	KubectlVersion    string               `json:"kubectlVersion,omitempty"`
+	Autoscale         bool                 `json:"autoscale,omitempty"`
+	NodePools         []envstore.NodePool  `json:"nodePools"`
+	Networking        *envstore.Networking `json:"networking,omitempty"`
+	Addons            []envstore.Addon     `json:"addons,omitempty"`
+}
+
+// FieldError is a validation failure of a specification field.
+type FieldError struct {
+	File string
+	Line int
+	// Path locates the field, e.g. spec.nodePools[0].nodes.
+	Path string
+	Msg  string
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Path, e.Msg)
+}
+
+// ValidationError lists all the problems found in a specification.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+	return "invalid environment specification:\n" + strings.Join(msgs, "\n")
+}
+
+type byLine []*FieldError
+
+func (b byLine) Len() int           { return len(b) }
+func (b byLine) Less(i, j int) bool { return b[i].Line < b[j].Line }
+func (b byLine) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// Load reads the specification in filename.
+func Load(filename string) (*Spec, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(filename, data)
+}
+
+// Parse parses and validates the specification in data, read from filename.
+func Parse(filename string, data []byte) (*Spec, error) {
+	root, err := yaml.Parse(data)
+	if err != nil {
+		if se, ok := err.(*yaml.SyntaxError); ok {
+			return nil, &ValidationError{Errors: []*FieldError{{File: filename, Line: se.Line, Msg: se.Msg}}}
+		}
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	v := &validator{file: filename}
+	v.validate(root)
+	if len(v.errors) > 0 {
+		sort.Stable(byLine(v.errors))
+		return nil, &ValidationError{Errors: v.errors}
+	}
+
+	// the validator normalised the tree, e.g. turning numbers into strings
+	// where strings are expected, so decode it rather than data
+	b, err := json.Marshal(root.Interface())
+	if err != nil {
+		return nil, err
+	}
+	s := &Spec{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return s, nil
+}
+
+// Environment returns the environment described by s.
+func (s *Spec) Environment() *envstore.Environment {
+	e := &envstore.Environment{
+		Name:              s.Metadata.Name,
+		Driver:            s.Spec.Driver,
+		Autoscale:         s.Spec.Autoscale,
+		KubectlVersion:    s.Spec.KubectlVersion,
+		DriverOptions:     s.Spec.DriverOptions,
+		KubernetesVersion: s.Spec.KubernetesVersion,
+		Pools:             s.Spec.NodePools,
+		Networking:        s.Spec.Networking,
+		Addons:            s.Spec.Addons,
+	}
+	for _, p := range s.Spec.NodePools {
+		e.Nodes += p.Nodes
+	}
+	return e
+}
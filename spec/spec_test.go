@@ -0,0 +1,142 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gerred/kube-cluster/driver"
+	"github.com/gerred/kube-cluster/envstore"
+)
+
+func init() {
+	driver.Register("spec-stub", func(c *driver.Config) (driver.Driver, error) {
+		return nil, errors.New("stub driver")
+	},
+		driver.Option{Name: "spec-stub-mode", Default: "0644"},
+		driver.Option{Name: "spec-stub-count", Default: "1", Type: driver.IntOption},
+		driver.Option{Name: "spec-stub-region", Required: true},
+		driver.Option{Name: "spec-stub-token", Secret: true},
+	)
+}
+
+const validSpec = `apiVersion: kube-cluster/v1alpha1
+kind: Environment
+metadata:
+  name: stage1
+spec:
+  driver: spec-stub
+  driverOptions:
+    spec-stub-mode: 0755
+    spec-stub-region: eu-west-1
+  kubernetesVersion: v1.1.2
+  autoscale: true
+  nodePools:
+  - name: default
+    nodes: 2
+    labels:
+      tier: yes
+      release: 1.10
+  - name: large
+    nodes: 1
+  networking:
+    podCIDR: 10.244.0.0/16
+  addons:
+  - name: dns
+    version: v1.0
+`
+
+func TestParse(t *testing.T) {
+	s, err := Parse("stage1.yaml", []byte(validSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := s.Environment()
+	want := &envstore.Environment{
+		Name:              "stage1",
+		Driver:            "spec-stub",
+		Nodes:             3,
+		Autoscale:         true,
+		KubernetesVersion: "v1.1.2",
+		// string maps keep the scalars as written
+		DriverOptions: map[string]string{"spec-stub-mode": "0755", "spec-stub-region": "eu-west-1"},
+		Pools: []envstore.NodePool{
+			{Name: "default", Nodes: 2, Labels: map[string]string{"tier": "yes", "release": "1.10"}},
+			{Name: "large", Nodes: 1},
+		},
+		Networking: &envstore.Networking{PodCIDR: "10.244.0.0/16"},
+		Addons:     []envstore.Addon{{Name: "dns", Version: "v1.0"}},
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("got %+v,\nwant %+v", e, want)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	doc := `{"apiVersion": "kube-cluster/v1alpha1", "kind": "Environment", "metadata": {"name": "dev"},
+"spec": {"driver": "spec-stub", "driverOptions": {"spec-stub-region": "local"}, "nodePools": [{"name": "default", "nodes": 1}]}}`
+	s, err := Parse("dev.json", []byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Metadata.Name != "dev" || s.Spec.NodePools[0].Nodes != 1 {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name, old, new string
+		want           []string
+	}{
+		{"syntax", "  name: stage1\n", "  name: stage1\n bad: : x\n", []string{"stage1.yaml:4: "}},
+		{"version", "kube-cluster/v1alpha1", "kube-cluster/v9", []string{`stage1.yaml:1: apiVersion: unsupported version "kube-cluster/v9"`}},
+		{"kind", "kind: Environment", "kind: Cluster", []string{"stage1.yaml:2: kind: must be Environment"}},
+		{"name", "name: stage1", "name: Stage_1", []string{"stage1.yaml:4: metadata.name: "}},
+		{"unknown field", "  autoscale: true\n", "  autoscale: true\n  replicas: 3\n", []string{"stage1.yaml:12: spec.replicas: unknown field"}},
+		{"boolean", "autoscale: true", "autoscale: yes", []string{"stage1.yaml:11: spec.autoscale: expected a boolean"}},
+		{"integer", "nodes: 2", "nodes: two", []string{"stage1.yaml:14: spec.nodePools[0].nodes: expected an integer"}},
+		{"negative", "nodes: 2", "nodes: -2", []string{"stage1.yaml:14: spec.nodePools[0].nodes: must not be negative"}},
+		{"duplicate pool", "name: large", "name: default", []string{`spec.nodePools[1].name: duplicate node pool "default"`}},
+		{"version format", "v1.1.2", "latest", []string{`spec.kubernetesVersion: invalid version "latest"`}},
+		{"cidr", "10.244.0.0/16", "10.244.0.0", []string{`spec.networking.podCIDR: invalid CIDR "10.244.0.0"`}},
+		{"driver", "driver: spec-stub", "driver: nope", []string{`spec.driver: unknown driver "nope"`}},
+		{"driver option", "spec-stub-mode: 0755", "spec-stub-speed: 3", []string{"stage1.yaml:8: spec.driverOptions.spec-stub-speed: unknown option of driver spec-stub"}},
+		{"required option", "    spec-stub-region: eu-west-1\n", "", []string{"spec.driverOptions.spec-stub-region: is required by driver spec-stub"}},
+		{"option value", "spec-stub-mode: 0755", "spec-stub-count: many", []string{"spec.driverOptions.spec-stub-count: "}},
+		{"label", "tier: yes", "tier: [a]", []string{"stage1.yaml:16: spec.nodePools[0].labels.tier: expected a string"}},
+		{"missing", "  driver: spec-stub\n", "", []string{"spec.driver: is required"}},
+		{"several", "kind: Environment", "kind: Cluster\nextra: 1", []string{"kind: must be Environment", "extra: unknown field"}},
+	}
+	for _, test := range tests {
+		doc := strings.Replace(validSpec, test.old, test.new, 1)
+		if doc == validSpec {
+			t.Fatalf("%s: %q is not in the specification", test.name, test.old)
+		}
+		_, err := Parse("stage1.yaml", []byte(doc))
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("%s: got %v, want a validation error", test.name, err)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: %q does not hold %q", test.name, err, want)
+			}
+		}
+	}
+}
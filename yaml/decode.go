@@ -0,0 +1,251 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yamlv3 "github.com/gerred/kube-cluster/Godeps/_workspace/src/gopkg.in/yaml.v3"
+)
+
+// Kind is the kind of a Node.
+type Kind int
+
+const (
+	ScalarNode Kind = iota
+	MappingNode
+	SequenceNode
+)
+
+func (k Kind) String() string {
+	switch k {
+	case MappingNode:
+		return "mapping"
+	case SequenceNode:
+		return "sequence"
+	}
+	return "scalar"
+}
+
+// Node is a parsed YAML value, remembering the line it starts on.
+type Node struct {
+	Kind Kind
+	Line int
+
+	// Value holds the value of scalars: nil, a bool, a json.Number or a
+	// string. Quoted scalars are always strings.
+	Value interface{}
+
+	// Text holds scalars as written, without quotes, whatever their value.
+	Text string
+
+	// Keys and Values hold the entries of mappings, in document order.
+	Keys   []string
+	Values []*Node
+
+	// Items holds the entries of sequences.
+	Items []*Node
+}
+
+// Get returns the value of the mapping entry key, or nil.
+func (n *Node) Get(key string) *Node {
+	for i, k := range n.Keys {
+		if k == key {
+			return n.Values[i]
+		}
+	}
+	return nil
+}
+
+// Interface converts n to the generic values encoding/json decodes to:
+// map[string]interface{}, []interface{}, and scalar values.
+func (n *Node) Interface() interface{} {
+	switch n.Kind {
+	case MappingNode:
+		m := make(map[string]interface{}, len(n.Keys))
+		for i, k := range n.Keys {
+			m[k] = n.Values[i].Interface()
+		}
+		return m
+	case SequenceNode:
+		l := make([]interface{}, 0, len(n.Items))
+		for _, item := range n.Items {
+			l = append(l, item.Interface())
+		}
+		return l
+	}
+	return n.Value
+}
+
+// SyntaxError reports a malformed document.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// errorLine matches the errors of the YAML library giving a line.
+var errorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func syntaxError(err error) error {
+	if m := errorLine.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return &SyntaxError{Line: line, Msg: m[2]}
+	}
+	return &SyntaxError{Line: 1, Msg: strings.TrimPrefix(err.Error(), "yaml: ")}
+}
+
+// Unmarshal parses a YAML, or JSON, document and stores the result in v, as
+// encoding/json would, so json struct tags apply.
+func Unmarshal(data []byte, v interface{}) error {
+	n, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(n.Interface())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Parse parses a single YAML 1.2 document. As YAML is a superset of JSON,
+// JSON documents are accepted as well. An empty document parses to a null
+// scalar.
+func Parse(data []byte) (*Node, error) {
+	dec := yamlv3.NewDecoder(bytes.NewReader(data))
+	var doc yamlv3.Node
+	if err := dec.Decode(&doc); err != nil {
+		if err == io.EOF {
+			return &Node{Kind: ScalarNode, Line: 1}, nil
+		}
+		return nil, syntaxError(err)
+	}
+	var next yamlv3.Node
+	switch err := dec.Decode(&next); err {
+	case io.EOF:
+	case nil:
+		return nil, &SyntaxError{Line: next.Line, Msg: "multiple documents are not supported"}
+	default:
+		return nil, syntaxError(err)
+	}
+
+	c := converter{seen: make(map[*yamlv3.Node]bool)}
+	return c.node(&doc)
+}
+
+// converter converts the nodes of the YAML library, expanding aliases.
+type converter struct {
+	// seen holds the nodes being converted, to catch aliases of their
+	// ancestors.
+	seen map[*yamlv3.Node]bool
+}
+
+func (c converter) node(n *yamlv3.Node) (*Node, error) {
+	if c.seen[n] {
+		return nil, &SyntaxError{Line: n.Line, Msg: "recursive alias"}
+	}
+	c.seen[n] = true
+	defer delete(c.seen, n)
+
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		if len(n.Content) == 0 {
+			return &Node{Kind: ScalarNode, Line: n.Line}, nil
+		}
+		return c.node(n.Content[0])
+	case yamlv3.AliasNode:
+		return c.node(n.Alias)
+	case yamlv3.MappingNode:
+		m := &Node{Kind: MappingNode, Line: n.Line}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			if k.Kind != yamlv3.ScalarNode || k.ShortTag() == "!!merge" {
+				return nil, &SyntaxError{Line: k.Line, Msg: "mapping keys must be strings"}
+			}
+			if m.Get(k.Value) != nil {
+				return nil, &SyntaxError{Line: k.Line, Msg: fmt.Sprintf("duplicate key %q", k.Value)}
+			}
+			v, err := c.node(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m.Keys = append(m.Keys, k.Value)
+			m.Values = append(m.Values, v)
+		}
+		return m, nil
+	case yamlv3.SequenceNode:
+		s := &Node{Kind: SequenceNode, Line: n.Line}
+		for _, item := range n.Content {
+			v, err := c.node(item)
+			if err != nil {
+				return nil, err
+			}
+			s.Items = append(s.Items, v)
+		}
+		return s, nil
+	}
+	return scalar(n)
+}
+
+// scalar converts a scalar node to the value encoding/json would give it.
+// Timestamps and binary data are kept as strings.
+func scalar(n *yamlv3.Node) (*Node, error) {
+	s := &Node{Kind: ScalarNode, Line: n.Line, Text: n.Value}
+	tag := n.ShortTag()
+	invalid := &SyntaxError{Line: n.Line, Msg: fmt.Sprintf("%q is not a valid %s", n.Value, strings.TrimPrefix(tag, "!!"))}
+	switch tag {
+	case "!!null":
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return nil, invalid
+		}
+		s.Value = b
+	case "!!int", "!!float":
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return nil, invalid
+		}
+		switch v := v.(type) {
+		case int:
+			s.Value = json.Number(strconv.Itoa(v))
+		case int64:
+			s.Value = json.Number(strconv.FormatInt(v, 10))
+		case uint64:
+			s.Value = json.Number(strconv.FormatUint(v, 10))
+		case float64:
+			if math.IsInf(v, 0) || math.IsNaN(v) {
+				return nil, invalid
+			}
+			s.Value = json.Number(strconv.FormatFloat(v, 'g', -1, 64))
+		default:
+			return nil, invalid
+		}
+	default:
+		s.Value = n.Value
+	}
+	return s, nil
+}
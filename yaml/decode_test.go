@@ -0,0 +1,116 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yaml
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want interface{}
+	}{
+		{"", nil},
+		{"a: 1\nb: 1.5\nc: true\nd: ~\ne: text\n", map[string]interface{}{
+			"a": json.Number("1"), "b": json.Number("1.5"), "c": true, "d": nil, "e": "text",
+		}},
+		// YAML 1.2: only true and false are booleans
+		{"- yes\n- off\n- y\n- True\n", []interface{}{"yes", "off", "y", true}},
+		{"- 0x10\n- '1'\n- \"two\"\n- 2015-11-20\n", []interface{}{json.Number("16"), "1", "two", "2015-11-20"}},
+		{"a: [1, {b: c}]\nd: {}\ne: []\n", map[string]interface{}{
+			"a": []interface{}{json.Number("1"), map[string]interface{}{"b": "c"}},
+			"d": map[string]interface{}{},
+			"e": []interface{}{},
+		}},
+		{"a: &x {b: 1}\nc: *x\n", map[string]interface{}{
+			"a": map[string]interface{}{"b": json.Number("1")},
+			"c": map[string]interface{}{"b": json.Number("1")},
+		}},
+		{`{"a": [1, "b"], "c": null}`, map[string]interface{}{
+			"a": []interface{}{json.Number("1"), "b"}, "c": nil,
+		}},
+		{"---\na: 1\n...\n", map[string]interface{}{"a": json.Number("1")}},
+	}
+	for _, test := range tests {
+		n, err := Parse([]byte(test.doc))
+		if err != nil {
+			t.Errorf("%q: %v", test.doc, err)
+			continue
+		}
+		if got := n.Interface(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %#v, want %#v", test.doc, got, test.want)
+		}
+	}
+}
+
+func TestParseKeepsText(t *testing.T) {
+	n, err := Parse([]byte("labels:\n  a: yes\n  b: 0755\n  c: 1.10\n  d: \"on\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := n.Get("labels")
+	want := map[string]string{"a": "yes", "b": "0755", "c": "1.10", "d": "on"}
+	for key, text := range want {
+		if got := labels.Get(key).Text; got != text {
+			t.Errorf("%s: got text %q, want %q", key, got, text)
+		}
+	}
+	if line := labels.Get("c").Line; line != 4 {
+		t.Errorf("c: got line %d, want 4", line)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		doc  string
+		line int
+	}{
+		{"a: 1\na: 2\n", 2},
+		{"a: 1\n---\nb: 2\n", 2},
+		{"a: 1\n b: : 2\n", 2},
+		{"a: [1, 2\n", 1},
+		{"a: &x [*x]\n", 1},
+		{"a: .inf\n", 1},
+		{"? [a]\n: b\n", 1},
+	}
+	for _, test := range tests {
+		_, err := Parse([]byte(test.doc))
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%q: got %v, want a syntax error", test.doc, err)
+			continue
+		}
+		if se.Line != test.line {
+			t.Errorf("%q: got line %d, want %d (%v)", test.doc, se.Line, test.line, se)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	var v struct {
+		Name  string            `json:"name"`
+		Count int               `json:"count"`
+		Tags  map[string]string `json:"tags"`
+	}
+	if err := Unmarshal([]byte("name: dev\ncount: 3\ntags:\n  tier: \"yes\"\n"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "dev" || v.Count != 3 || v.Tags["tier"] != "yes" {
+		t.Errorf("got %+v", v)
+	}
+}
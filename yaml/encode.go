@@ -0,0 +1,132 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package yaml converts between YAML documents and the values encoding/json
+// works with, so the types of kube-cluster only need json struct tags.
+// Documents are parsed and written by gopkg.in/yaml.v3, following YAML 1.2:
+// "yes" and "off" are strings, not booleans.
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	yamlv3 "github.com/gerred/kube-cluster/Godeps/_workspace/src/gopkg.in/yaml.v3"
+)
+
+// Marshal returns the YAML encoding of v, which is first encoded to JSON, so
+// json struct tags apply. Mapping keys are sorted.
+func Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	n, err := encode(generic)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encode returns the node of v, a value produced by encoding/json decoding
+// into an interface{}.
+func encode(v interface{}) (*yamlv3.Node, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		n := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		if len(v) == 0 {
+			n.Style = yamlv3.FlowStyle
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			value, err := encode(v[k])
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, str(k), value)
+		}
+		return n, nil
+	case []interface{}:
+		n := &yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq"}
+		if len(v) == 0 {
+			n.Style = yamlv3.FlowStyle
+		}
+		for _, item := range v {
+			value, err := encode(item)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, value)
+		}
+		return n, nil
+	case nil:
+		return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	case bool:
+		return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}, nil
+	case json.Number:
+		tag := "!!int"
+		if _, err := v.Int64(); err != nil {
+			tag = "!!float"
+		}
+		return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: tag, Value: v.String()}, nil
+	case string:
+		return str(v), nil
+	}
+	return nil, fmt.Errorf("yaml: unsupported value %T", v)
+}
+
+// str returns the node of the string s, quoted by the YAML library if it
+// would otherwise be read back as something else, including by YAML 1.1
+// readers such as kubectl, to which "yes" is a boolean.
+func str(s string) *yamlv3.Node {
+	n := &yamlv3.Node{}
+	// encoding a string cannot fail
+	n.Encode(s)
+	return n
+}
+
+// Fprint writes the YAML encoding of v to w.
+func Fprint(w io.Writer, v interface{}) error {
+	data, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 The kube-cluster Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yaml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	v := map[string]interface{}{
+		"name":    "dev",
+		"nodes":   2,
+		"ratio":   0.5,
+		"enabled": true,
+		"none":    nil,
+		"pools":   []interface{}{map[string]interface{}{"name": "default", "labels": map[string]interface{}{}}},
+		"empty":   []interface{}{},
+	}
+	want := `empty: []
+enabled: true
+name: dev
+nodes: 2
+none: null
+pools:
+  - labels: {}
+    name: default
+ratio: 0.5
+`
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+// TestMarshalQuotes checks strings are read back as the same strings, by YAML
+// 1.1 readers too.
+func TestMarshalQuotes(t *testing.T) {
+	for _, s := range []string{"", "yes", "off", "y", "true", "null", "~", "12", "1.5", "0x10", "- a", "a: b", "#", " padded", "line\nbreak", "plain"} {
+		data, err := Marshal(map[string]string{"key": s})
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		var got map[string]string
+		if err := Unmarshal(data, &got); err != nil {
+			t.Errorf("%q: cannot read back %q: %v", s, data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, map[string]string{"key": s}) {
+			t.Errorf("%q: read back %q from %q", s, got["key"], data)
+		}
+		switch s {
+		case "yes", "off", "y":
+			if data[len("key: ")] != '"' {
+				t.Errorf("%q: not quoted in %q", s, data)
+			}
+		}
+	}
+}
+
+func TestMarshalJSONTags(t *testing.T) {
+	v := struct {
+		Name    string `json:"name"`
+		Skipped string `json:"skipped,omitempty"`
+	}{Name: "dev"}
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "name: dev\n" {
+		t.Errorf("got %q", data)
+	}
+}